@@ -0,0 +1,204 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+type pendingDelete struct{ min, max uint64 }
+
+func (p pendingDelete) contains(idx uint64) bool {
+	return idx >= p.min && idx <= p.max
+}
+
+// startAsyncDeletes loads ranges left over from a previous run and starts
+// the background worker that removes them.
+func (b *BadgerStore) startAsyncDeletes() error {
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(b.keys.pendingDeletes)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if len(v)%16 != 0 {
+			return fmt.Errorf("malformed pending deletes record of %d bytes", len(v))
+		}
+		for ; len(v) > 0; v = v[16:] {
+			b.pendingDeletes = append(b.pendingDeletes, pendingDelete{
+				min: binary.BigEndian.Uint64(v[:8]),
+				max: binary.BigEndian.Uint64(v[8:16]),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.asyncDeletes = true
+	b.deleteWake = make(chan struct{}, 1)
+	b.deleteStop = make(chan struct{})
+	b.deleteDone = make(chan struct{})
+	go b.deleteWorker()
+	if len(b.pendingDeletes) > 0 {
+		b.wakeDeleteWorker()
+	}
+	return nil
+}
+
+// stopAsyncDeletes stops the background worker, letting it finish the range
+// it is working on. Ranges not yet removed are picked up on the next open.
+func (b *BadgerStore) stopAsyncDeletes() {
+	if !b.asyncDeletes {
+		return
+	}
+	close(b.deleteStop)
+	<-b.deleteDone
+}
+
+func (b *BadgerStore) deleteWorker() {
+	defer close(b.deleteDone)
+	for {
+		select {
+		case <-b.deleteWake:
+			if err := b.flushDeletes(); err != nil {
+				b.metrics.incrCounter([]string{"delete_range", "async_failures"}, 1)
+				b.logger.Warn("asynchronous delete failed", "error", err)
+			}
+		case <-b.deleteStop:
+			return
+		}
+	}
+}
+
+func (b *BadgerStore) wakeDeleteWorker() {
+	select {
+	case b.deleteWake <- struct{}{}:
+	default:
+	}
+}
+
+// deleteRangeAsync records the range so reads stop seeing it and leaves the
+// physical deletion to the background worker.
+func (b *BadgerStore) deleteRangeAsync(min, max uint64) error {
+	b.deletesMu.Lock()
+	pending := append(b.pendingDeletes[:len(b.pendingDeletes):len(b.pendingDeletes)], pendingDelete{min: min, max: max})
+	if err := b.savePendingDeletes(pending); err != nil {
+		b.deletesMu.Unlock()
+		return err
+	}
+	b.pendingDeletes = pending
+	b.deletesMu.Unlock()
+	b.wakeDeleteWorker()
+	return nil
+}
+
+// FlushDeletes physically removes every range DeleteRange has queued when
+// Options.AsyncDeleteRange is set, and returns once they are gone. It is a
+// no-op otherwise.
+func (b *BadgerStore) FlushDeletes() error {
+	return b.run(context.Background(), func(context.Context) error {
+		return b.flushDeletes()
+	})
+}
+
+// flushDeletes is FlushDeletes within an operation already running.
+func (b *BadgerStore) flushDeletes() error {
+	if !b.asyncDeletes {
+		return nil
+	}
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	for {
+		b.deletesMu.RLock()
+		if len(b.pendingDeletes) == 0 {
+			b.deletesMu.RUnlock()
+			return nil
+		}
+		next := b.pendingDeletes[0]
+		b.deletesMu.RUnlock()
+
+		report := b.startCompaction("delete-range", false)
+		var removed uint64
+		err := b.maintenanceRetry.do(b.metrics, b.logger, retryMaintenance, func() error {
+			n, err := b.deleteRange(context.Background(), next.min, next.max)
+			removed += n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		report.EntriesRemoved = removed
+		b.finishCompaction(report)
+
+		// Only FlushDeletes removes ranges and it holds flushMu, so next is
+		// still the first one
+		b.deletesMu.Lock()
+		pending := b.pendingDeletes[1:]
+		if err := b.savePendingDeletes(pending); err != nil {
+			b.deletesMu.Unlock()
+			return err
+		}
+		b.pendingDeletes = pending
+		b.deletesMu.Unlock()
+	}
+}
+
+func (b *BadgerStore) savePendingDeletes(pending []pendingDelete) error {
+	return b.update(func(txn *writeTxn) error {
+		if len(pending) == 0 {
+			return txn.Delete(b.keys.pendingDeletes)
+		}
+		v := make([]byte, 0, 16*len(pending))
+		for _, p := range pending {
+			v = append(v, uint64ToBytes(p.min)...)
+			v = append(v, uint64ToBytes(p.max)...)
+		}
+		return txn.Set(b.keys.pendingDeletes, v)
+	})
+}
+
+// pendingDeleteFor returns the queued range containing idx, if any.
+func (b *BadgerStore) pendingDeleteFor(idx uint64) (pendingDelete, bool) {
+	if !b.asyncDeletes {
+		return pendingDelete{}, false
+	}
+	b.deletesMu.RLock()
+	defer b.deletesMu.RUnlock()
+	for _, p := range b.pendingDeletes {
+		if p.contains(idx) {
+			return p, true
+		}
+	}
+	return pendingDelete{}, false
+}
+
+// isPendingDelete reports whether idx has been deleted but not yet removed.
+func (b *BadgerStore) isPendingDelete(idx uint64) bool {
+	_, ok := b.pendingDeleteFor(idx)
+	return ok
+}
+
+// overlapsPendingDelete reports whether any queued range intersects
+// [min, max].
+func (b *BadgerStore) overlapsPendingDelete(min, max uint64) bool {
+	if !b.asyncDeletes {
+		return false
+	}
+	b.deletesMu.RLock()
+	defer b.deletesMu.RUnlock()
+	for _, p := range b.pendingDeletes {
+		if p.min <= max && min <= p.max {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testAsyncDeleteStore(t *testing.T, dir string) *BadgerStore {
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: dir, BadgerOptions: &badgerOpts, AsyncDeleteRange: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func testStoreFiveLogs(t *testing.T, store *BadgerStore) {
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+		testRaftLog(3, "log3"),
+		testRaftLog(4, "log4"),
+		testRaftLog(5, "log5"),
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func testHasLogKey(t *testing.T, store *BadgerStore, idx uint64) bool {
+	found := false
+	err := store.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(defaultKeys.logKey(idx))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		found = err == nil
+		return err
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return found
+}
+
+func TestBadgerStore_AsyncDeleteRange(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store := testAsyncDeleteStore(t, fh)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	if err := store.DeleteRange(1, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Reads stop seeing the range right away
+	if err := store.GetLog(2, new(raft.Log)); err != raft.ErrLogNotFound {
+		t.Fatalf("expected log not found, got: %v", err)
+	}
+	first, err := store.FirstIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first != 4 {
+		t.Fatalf("expected first index 4, got %d", first)
+	}
+
+	if err := store.FlushDeletes(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for idx := uint64(1); idx <= 3; idx++ {
+		if testHasLogKey(t, store, idx) {
+			t.Fatalf("log %d was not removed", idx)
+		}
+	}
+	if !testHasLogKey(t, store, 4) {
+		t.Fatalf("log 4 should not have been removed")
+	}
+
+	// A suffix truncation followed by a rewrite must keep the new entry
+	if err := store.DeleteRange(4, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last != 0 {
+		t.Fatalf("expected an empty log, got last index %d", last)
+	}
+	if err := store.StoreLog(testRaftLog(4, "rewritten")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.FlushDeletes(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result := new(raft.Log)
+	if err := store.GetLog(4, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "rewritten" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestBadgerStore_AsyncDeleteRangeSurvivesRestart(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	store := testAsyncDeleteStore(t, fh)
+	testStoreFiveLogs(t, store)
+	// Queue the range without the worker getting to it
+	store.stopAsyncDeletes()
+	if err := store.deleteRangeAsync(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// The worker is already stopped
+	store.asyncDeletes = false
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store = testAsyncDeleteStore(t, fh)
+	defer store.Close()
+	if err := store.GetLog(1, new(raft.Log)); err != raft.ErrLogNotFound {
+		t.Fatalf("expected log not found, got: %v", err)
+	}
+	if err := store.FlushDeletes(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if testHasLogKey(t, store, 1) || testHasLogKey(t, store, 2) {
+		t.Fatalf("pending range was not removed after reopening")
+	}
+	err = store.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(defaultKeys.pendingDeletes)
+		return err
+	})
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("expected the pending deletes record to be cleared, got: %v", err)
+	}
+}
+
+func TestBadgerStore_AsyncDeleteRangeDoesNotBlockAppends(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store := testAsyncDeleteStore(t, fh)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	// Hold up the worker, as a huge range would
+	store.flushMu.Lock()
+	if err := store.DeleteRange(1, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- store.StoreLog(testRaftLog(6, "log6"))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("append waited for the deletion")
+	}
+	if first, last := testBounds(t, store); first != 4 || last != 6 {
+		t.Fatalf("bad: %d to %d", first, last)
+	}
+	if !testHasLogKey(t, store, 1) {
+		t.Fatalf("log 1 should not have been removed yet")
+	}
+	store.flushMu.Unlock()
+
+	if err := store.FlushDeletes(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if testHasLogKey(t, store, 1) {
+		t.Fatalf("log 1 was not removed")
+	}
+}
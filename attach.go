@@ -0,0 +1,215 @@
+package raftbadgerdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/protos"
+)
+
+// attachSocketName is the unix socket a store opened with Options.AllowAttach
+// serves read-only attachments on, inside the store's directory.
+const attachSocketName = "attach.sock"
+
+// ErrAttachUnavailable is returned by AttachReadOnly when there is no running
+// store accepting attachments at the path.
+var ErrAttachUnavailable = errors.New("store does not accept attachments")
+
+// attachServer streams a consistent copy of a running store to every
+// process connecting to its attach socket, and appended entries to every
+// process connecting to its follow socket.
+type attachServer struct {
+	b      *BadgerStore
+	ln     net.Listener
+	follow net.Listener
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (b *BadgerStore) startAttachServer() error {
+	ln, err := listenUnix(filepath.Join(b.path, attachSocketName))
+	if err != nil {
+		return err
+	}
+	follow, err := listenUnix(filepath.Join(b.path, followSocketName))
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	s := &attachServer{b: b, ln: ln, follow: follow, stop: make(chan struct{})}
+	b.attach = s
+	s.wg.Add(2)
+	go s.serve(ln, func(conn net.Conn) error { return b.streamLiveEntries(conn) })
+	go s.serve(follow, s.serveFollower)
+	return nil
+}
+
+// listenUnix listens on the unix socket sock. A socket left behind by a
+// crashed process would fail the listen. The Badger directory lock is
+// already held, so nobody else is serving it.
+func listenUnix(sock string) (net.Listener, error) {
+	if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.Listen("unix", sock)
+}
+
+func (s *attachServer) serve(ln net.Listener, handle func(conn net.Conn) error) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// close stops accepting attachments and followers and waits for running
+// streams, which need the database, to finish.
+func (s *attachServer) close() {
+	s.ln.Close()
+	s.follow.Close()
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// streamLiveEntries writes the current version of every live key, as of a
+// single read transaction, in the format badger.DB.Load reads.
+func (b *BadgerStore) streamLiveEntries(w io.Writer) error {
+	return b.streamEntries(w, nil)
+}
+
+// streamEntries is streamLiveEntries limited to the keys keep accepts, or
+// every key if keep is nil. A separate stable store is read in a
+// transaction of its own.
+func (b *BadgerStore) streamEntries(w io.Writer, keep func(key []byte) bool) error {
+	bw := bufio.NewWriter(w)
+	if err := streamDB(bw, b.db, keep); err != nil {
+		return err
+	}
+	if b.separateStable() {
+		if err := streamDB(bw, b.stableDB, keep); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// streamDB writes the entries of db keep accepts to w.
+func streamDB(w io.Writer, db *badger.DB, keep func(key []byte) bool) error {
+	return db.View(func(txn *badger.Txn) error {
+		return streamTxn(w, txn, keep)
+	})
+}
+
+// streamTxn writes the entries keep accepts to w, as txn sees them.
+func streamTxn(w io.Writer, txn *badger.Txn, keep func(key []byte) bool) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if keep != nil && !keep(item.Key()) {
+			continue
+		}
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		kv := &protos.KVPair{
+			Key:       item.Key(),
+			Value:     v,
+			UserMeta:  []byte{item.UserMeta()},
+			Version:   item.Version(),
+			ExpiresAt: item.ExpiresAt(),
+		}
+		if err := writeBackupEntry(w, kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AttachedStore is a read-only copy of a running store, made by
+// AttachReadOnly. Writes fail with ErrReadOnly.
+type AttachedStore struct {
+	*BadgerStore
+
+	dir string
+}
+
+// AttachReadOnly gives another process a consistent, read-only view of the
+// store running at path without touching its lock. The running store must
+// have been opened with Options.AllowAttach; it streams its current contents
+// into a temporary copy, which the returned store reads from. The copy does
+// not follow later writes. options configures the copy, for example with
+// the decryption keys or codec of the running store; its Path and
+// BadgerOptions are ignored.
+func AttachReadOnly(path string, options Options) (*AttachedStore, error) {
+	conn, err := net.Dial("unix", filepath.Join(path, attachSocketName))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAttachUnavailable, err)
+	}
+	defer conn.Close()
+
+	dir, err := ioutil.TempDir("", "raft-badger-attach")
+	if err != nil {
+		return nil, err
+	}
+	if err := loadInto(badgerDir(dir), conn); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	options.Path = dir
+	options.BadgerOptions = nil
+	options.ReadOnly = true
+	store, err := New(options)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &AttachedStore{BadgerStore: store, dir: dir}, nil
+}
+
+// loadInto creates a Badger database in dir from a stream written by
+// streamLiveEntries.
+func loadInto(dir string, r io.Reader) error {
+	if err := createDirSynced(dir); err != nil {
+		return err
+	}
+	opts := badger.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+	db, err := badger.Open(opts)
+	if err != nil {
+		return err
+	}
+	if err := db.Load(r); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// Close closes the copy and deletes it.
+func (a *AttachedStore) Close() error {
+	err := a.BadgerStore.Close()
+	if removeErr := os.RemoveAll(a.dir); err == nil {
+		err = removeErr
+	}
+	return err
+}
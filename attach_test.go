@@ -0,0 +1,83 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestAttachReadOnly(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	live, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, AllowAttach: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer live.Close()
+	testStoreFiveLogs(t, live)
+	if err := live.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := live.SetUint64([]byte("CurrentTerm"), 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	attached, err := AttachReadOnly(fh, Options{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result := new(raft.Log)
+	if err := attached.GetLog(4, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "log4" {
+		t.Fatalf("bad: %#v", result)
+	}
+	// Deleted entries stay deleted in the copy
+	if err := attached.GetLog(1, result); err != raft.ErrLogNotFound {
+		t.Fatalf("expected log not found, got: %v", err)
+	}
+	term, err := attached.GetUint64([]byte("CurrentTerm"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term != 3 {
+		t.Fatalf("bad term: %d", term)
+	}
+	if err := attached.StoreLog(testRaftLog(6, "log6")); err == nil {
+		t.Fatalf("expected writes to the copy to fail")
+	}
+
+	copyDir := attached.Path()
+	if err := attached.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := os.Stat(copyDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the copy to be removed, got: %v", err)
+	}
+
+	// The running store is unaffected
+	if err := live.StoreLog(testRaftLog(6, "log6")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestAttachReadOnly_NotAllowed(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	if _, err := AttachReadOnly(store.path, Options{}); !errors.Is(err, ErrAttachUnavailable) {
+		t.Fatalf("expected attach to be unavailable, got: %v", err)
+	}
+}
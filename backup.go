@@ -0,0 +1,517 @@
+package raftbadgerdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/protos"
+)
+
+// maxBackupEntrySize bounds the size of a single entry read back from a
+// backup, so a corrupt length can't make RestoreScoped allocate gigabytes.
+const maxBackupEntrySize = 1 << 30
+
+// BackupScope selects what BackupScoped writes.
+type BackupScope struct {
+	// Logs includes raft log entries, limited to those from MinIndex to
+	// MaxIndex inclusive. A zero MaxIndex means no upper bound
+	Logs     bool
+	MinIndex uint64
+	MaxIndex uint64
+	// Stable includes the stable store, such as raft's current term and
+	// vote
+	Stable bool
+}
+
+// keep returns whether a key belongs in the backup, given the payloads the
+// log entries in scope reference.
+func (s BackupScope) keep(b *BadgerStore, blobs map[blobHash]bool) func(key []byte) bool {
+	return func(key []byte) bool {
+		switch {
+		case bytes.HasPrefix(key, b.keys.logs):
+			if !s.Logs {
+				return false
+			}
+			idx, err := b.keys.parseLogKey(key)
+			if err != nil {
+				return false
+			}
+			if idx < s.MinIndex || (s.MaxIndex != 0 && idx > s.MaxIndex) {
+				return false
+			}
+			return !b.isPendingDelete(idx)
+		case bytes.HasPrefix(key, b.keys.conf):
+			return s.Stable
+		case bytes.HasPrefix(key, b.keys.blob):
+			// Only the payloads of the entries backed up, without their
+			// reference counts, which RestoreScoped works out again
+			kind, h, err := b.keys.parseBlobKey(key)
+			return err == nil && kind == 'd' && blobs[h]
+		}
+		return false
+	}
+}
+
+// BackupScoped writes the parts of the store selected by scope to w, as of a
+// single point in time, for RestoreScoped to read back. It runs alongside
+// reads and writes. A stable-only backup keeps term and vote while the log
+// is rebuilt; a log-only one archives the log for audits. Store metadata,
+// such as soft-deleted entries and append times, is never included.
+func (b *BadgerStore) BackupScoped(w io.Writer, scope BackupScope) error {
+	if !scope.Logs && !scope.Stable {
+		return errors.New("backup scope selects nothing")
+	}
+	if scope.MaxIndex != 0 && scope.MaxIndex < scope.MinIndex {
+		return fmt.Errorf("invalid backup index range %d-%d", scope.MinIndex, scope.MaxIndex)
+	}
+	return b.run(context.Background(), func(context.Context) error {
+		return b.backupScoped(w, scope)
+	})
+}
+
+func (b *BadgerStore) backupScoped(w io.Writer, scope BackupScope) error {
+	bw := bufio.NewWriter(w)
+	err := b.db.View(func(txn *badger.Txn) error {
+		blobs, err := b.scopedBlobs(txn, scope)
+		if err != nil {
+			return err
+		}
+		return streamTxn(bw, txn, scope.keep(b, blobs))
+	})
+	if err != nil {
+		return err
+	}
+	if b.separateStable() {
+		if err := streamDB(bw, b.stableDB, scope.keep(b, nil)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// scopedBlobs returns the payloads the log entries in scope reference.
+func (b *BadgerStore) scopedBlobs(txn *badger.Txn, scope BackupScope) (map[blobHash]bool, error) {
+	blobs := make(map[blobHash]bool)
+	if !scope.Logs {
+		return blobs, nil
+	}
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(b.keys.logKey(scope.MinIndex)); it.ValidForPrefix(b.keys.logs); it.Next() {
+		idx, err := b.keys.parseLogKey(it.Item().Key())
+		if err != nil || b.isPendingDelete(idx) {
+			continue
+		}
+		if scope.MaxIndex != 0 && idx > scope.MaxIndex {
+			break
+		}
+		v, err := it.Item().Value()
+		if err != nil {
+			return nil, err
+		}
+		h, ok, err := b.blobRef(idx, v)
+		if err != nil {
+			return nil, fmt.Errorf("log %d: %w", idx, err)
+		}
+		if ok {
+			blobs[h] = true
+		}
+	}
+	return blobs, nil
+}
+
+// RestoreScoped writes every entry of a backup made by BackupScoped into the
+// store, replacing entries it already holds under the same keys and leaving
+// the rest alone. Restoring a stable-only backup into an empty store brings
+// back term and vote without any log. Entries are written in as many
+// transactions as they need, so a failed restore can leave some of them
+// written. Log keys must be well formed, and the reference counts of
+// deduplicated payloads are worked out from the entries restored, so
+// restoring an index range keeps them right.
+func (b *BadgerStore) RestoreScoped(r io.Reader) error {
+	if b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	return b.run(context.Background(), func(context.Context) error {
+		return b.restoreScoped(r)
+	})
+}
+
+func (b *BadgerStore) restoreScoped(r io.Reader) error {
+	defer b.cache.invalidate()
+	defer b.bounds.invalidate()
+	br := bufio.NewReader(r)
+	txn, stableTxn := b.newWriteTxn(), b.newStableWriteTxn()
+	defer func() {
+		txn.Discard()
+		stableTxn.Discard()
+	}()
+	// refs counts the payload references changed in txn, and referenced
+	// holds every payload the restored entries need
+	refs := newBlobRefs()
+	referenced := make(map[blobHash]bool)
+	var buf []byte
+	for {
+		kv, raw, err := readBackupEntry(br, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		buf = raw
+		// Backups of stores from before binary keys spell indexes out, and
+		// those from before stableKeyFormat stable store keys
+		if idx, ok := legacyIndex(b.keys.logs, kv.Key); ok {
+			kv.Key = b.keys.logKey(idx)
+		}
+		if k, err := b.keys.parseLegacyConfKey(kv.Key); err == nil {
+			kv.Key = b.keys.confKey(k)
+		}
+		e := &badger.Entry{Key: kv.Key, Value: kv.Value, ExpiresAt: kv.ExpiresAt}
+		if len(kv.UserMeta) > 0 {
+			e.UserMeta = kv.UserMeta[0]
+		}
+		if bytes.HasPrefix(kv.Key, b.keys.conf) {
+			err = stableTxn.SetEntry(e)
+			if err == badger.ErrTxnTooBig {
+				if err := stableTxn.Commit(); err != nil {
+					return err
+				}
+				stableTxn = b.newStableWriteTxn()
+				err = stableTxn.SetEntry(e)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var write func(txn *writeTxn) error
+		switch {
+		case bytes.HasPrefix(kv.Key, b.keys.logs):
+			idx, err := b.keys.parseLogKey(kv.Key)
+			if err != nil {
+				return fmt.Errorf("backup entry: %w", err)
+			}
+			h, ok, err := b.blobRef(idx, kv.Value)
+			if err != nil {
+				return fmt.Errorf("backup entry for log %d: %w", idx, err)
+			}
+			if ok {
+				referenced[h] = true
+			}
+			write = func(txn *writeTxn) error { return b.restoreLog(txn, idx, e, refs) }
+		case bytes.HasPrefix(kv.Key, b.keys.blob):
+			kind, _, err := b.keys.parseBlobKey(kv.Key)
+			if err != nil {
+				return fmt.Errorf("backup entry: %w", err)
+			}
+			if kind == 'r' {
+				// Older backups hold reference counts, which are worked out
+				// again from the entries restored
+				continue
+			}
+			write = func(txn *writeTxn) error { return txn.SetEntry(e) }
+		default:
+			return fmt.Errorf("backup entry %q is neither a log nor a stable store key", kv.Key)
+		}
+		err = write(txn)
+		if err == badger.ErrTxnTooBig {
+			if err := refs.apply(txn); err != nil {
+				return err
+			}
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn, refs = b.newWriteTxn(), newBlobRefs()
+			err = write(txn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := refs.apply(txn); err != nil {
+		return err
+	}
+	for h := range referenced {
+		_, err := txn.Get(b.keys.blobDataKey(h))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("backup references missing deduplicated payload %x", h[:])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	return stableTxn.Commit()
+}
+
+// restoreLog writes the restored log entry e, at index idx, in txn,
+// counting the payload reference it adds, and the one of the entry it
+// replaces, in refs.
+func (b *BadgerStore) restoreLog(txn *writeTxn, idx uint64, e *badger.Entry, refs *blobRefs) error {
+	changed := newBlobRefs()
+	h, ok, err := b.blobRef(idx, e.Value)
+	if err != nil {
+		return err
+	}
+	if ok {
+		changed.deltas[h]++
+	}
+	item, err := txn.Get(e.Key)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if err == nil {
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if err := b.releaseBlob(idx, v, changed); err != nil {
+			return err
+		}
+	}
+	if err := txn.SetEntry(e); err != nil {
+		return err
+	}
+	refs.merge(changed)
+	return nil
+}
+
+// ErrRestoreMirrored is returned by Restore on a store with a mirror, which
+// entries loaded behind the store's transactions would never reach.
+var ErrRestoreMirrored = errors.New("can't restore into a mirrored store")
+
+// Backup writes every entry of the store written at version since or later
+// to w, as of a single point in time, using Badger's backup format, and
+// returns the version to pass as since to the next, incremental, backup. A since of 0
+// backs up everything, including snapshots and the store's own
+// bookkeeping. It runs alongside reads and writes, so a live node can be
+// backed up without copying its directory. Deleted entries are left out of
+// a full backup, and an incremental one records entries deleted since as
+// long expired ones, which hide them once restored. A separate stable
+// store, being tiny, is appended in full every time.
+func (b *BadgerStore) Backup(w io.Writer, since uint64) (version uint64, err error) {
+	err = b.run(context.Background(), func(context.Context) (err error) {
+		version, err = b.backup(w, since)
+		return err
+	})
+	return version, err
+}
+
+func (b *BadgerStore) backup(w io.Writer, since uint64) (uint64, error) {
+	// Queued deletions would otherwise be backed up as live entries
+	if err := b.flushDeletes(); err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(w)
+	version, err := backupDB(bw, b.db, since)
+	if err != nil {
+		return 0, err
+	}
+	if b.separateStable() {
+		if _, err := backupDB(bw, b.stableDB, 0); err != nil {
+			return 0, err
+		}
+	}
+	return version, bw.Flush()
+}
+
+// backupDB writes the latest version of every key of db written at version
+// since or later to w, and returns the version to start the next backup
+// at. Badger's own Backup writes deleted keys as empty values, which a
+// restore would bring back, so they are left out of a full backup and
+// written as tombstones, entries that expired long ago, otherwise.
+func backupDB(w io.Writer, db *badger.DB, since uint64) (uint64, error) {
+	next := since
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.AllVersions = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		var key []byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			// Versions of a key come newest first
+			if key != nil && bytes.Equal(item.Key(), key) {
+				continue
+			}
+			key = item.KeyCopy(key)
+			if item.Version() < since {
+				continue
+			}
+			if item.Version() >= next {
+				next = item.Version() + 1
+			}
+			kv := &protos.KVPair{
+				Key:       key,
+				UserMeta:  []byte{item.UserMeta()},
+				Version:   item.Version(),
+				ExpiresAt: item.ExpiresAt(),
+			}
+			if item.IsDeletedOrExpired() {
+				if since == 0 {
+					continue
+				}
+				kv.UserMeta, kv.ExpiresAt = []byte{0}, 1
+			} else {
+				v, err := item.Value()
+				if err != nil {
+					return err
+				}
+				kv.Value = v
+			}
+			if err := writeBackupEntry(w, kv); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return next, err
+}
+
+// Restore loads a backup written by Backup into the store. Entries keep
+// the versions they had when backed up, so a full backup and the
+// incremental ones following it are restored in the order they were taken,
+// into a store nothing else has written to, usually a freshly created one.
+// Like Badger's Load, it must not run alongside other writes.
+func (b *BadgerStore) Restore(r io.Reader) error {
+	if b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	if b.mirror != nil {
+		return ErrRestoreMirrored
+	}
+	return b.run(context.Background(), func(context.Context) error {
+		defer b.cache.invalidate()
+		defer b.bounds.invalidate()
+		return b.load(r)
+	})
+}
+
+// load loads a backup into the store, handing each database the entries it
+// holds through a pipe. Entries are checked on the way, since Badger's Load
+// panics on some malformed ones.
+func (b *BadgerStore) load(r io.Reader) error {
+	dbs := []*badger.DB{b.db}
+	if b.separateStable() {
+		dbs = append(dbs, b.stableDB)
+	}
+	pipes := make([]*io.PipeWriter, len(dbs))
+	errs := make(chan error, len(dbs))
+	for i, db := range dbs {
+		pr, pw := io.Pipe()
+		pipes[i] = pw
+		go func(db *badger.DB) {
+			err := db.Load(pr)
+			// Unblocks the split if loading stopped early
+			pr.CloseWithError(err)
+			errs <- err
+		}(db)
+	}
+
+	err := splitBackup(r, func(key []byte) io.Writer {
+		if len(pipes) > 1 && bytes.HasPrefix(key, b.keys.conf) {
+			return pipes[1]
+		}
+		return pipes[0]
+	})
+	for _, pw := range pipes {
+		pw.CloseWithError(err)
+	}
+	for range dbs {
+		if loadErr := <-errs; err == nil {
+			err = loadErr
+		}
+	}
+	return err
+}
+
+// splitBackup copies each entry of a backup to the writer route picks for
+// its key. Entries without the user meta byte every backup records are
+// malformed.
+func splitBackup(r io.Reader, route func(key []byte) io.Writer) error {
+	br := bufio.NewReader(r)
+	var buf []byte
+	for {
+		kv, raw, err := readBackupEntry(br, buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		buf = raw
+		if len(kv.UserMeta) != 1 {
+			return fmt.Errorf("malformed backup entry with %d bytes of user meta", len(kv.UserMeta))
+		}
+		w := route(kv.Key)
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(raw))); err != nil {
+			return err
+		}
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+}
+
+// writeBackupEntry writes kv to w in the format badger.DB.Load reads.
+func writeBackupEntry(w io.Writer, kv *protos.KVPair) error {
+	buf, err := kv.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(buf))); err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// readBackupEntry reads the next entry of a backup, reusing buf for its
+// encoding, which it returns along with the entry. It returns io.EOF at
+// the end of the backup.
+func readBackupEntry(br *bufio.Reader, buf []byte) (*protos.KVPair, []byte, error) {
+	var size uint64
+	if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+		return nil, buf, err
+	}
+	if size > maxBackupEntrySize {
+		return nil, buf, fmt.Errorf("malformed backup entry of %d bytes", size)
+	}
+	buf, err := readSized(br, buf, size)
+	if err != nil {
+		return nil, buf, err
+	}
+	kv := new(protos.KVPair)
+	if err := kv.Unmarshal(buf); err != nil {
+		return nil, buf, err
+	}
+	return kv, buf, nil
+}
+
+// readSized reads the next size bytes of r into buf, growing it if needed.
+// The bytes are read before room is made for all of them, so a corrupt size
+// in a short stream doesn't allocate that much memory.
+func readSized(r io.Reader, buf []byte, size uint64) ([]byte, error) {
+	if uint64(cap(buf)) >= size {
+		buf = buf[:size]
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	w := bytes.NewBuffer(buf[:0])
+	n, err := w.ReadFrom(io.LimitReader(r, int64(size)))
+	if err == nil && uint64(n) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	return w.Bytes(), err
+}
@@ -0,0 +1,283 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/protos"
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_BackupScopedStable(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	if err := store.SetUint64([]byte("CurrentTerm"), 7); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.BackupScoped(&buf, BackupScope{Stable: true}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Rebuild the log in a fresh store, keeping term and vote
+	fresh := testBadgerStore(t)
+	defer fresh.Close()
+	defer os.RemoveAll(fresh.path)
+	if err := fresh.RestoreScoped(&buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	term, err := fresh.GetUint64([]byte("CurrentTerm"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term != 7 {
+		t.Fatalf("bad term: %d", term)
+	}
+	last, err := fresh.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last != 0 {
+		t.Fatalf("expected no logs, got last index %d", last)
+	}
+}
+
+func TestBadgerStore_BackupScopedLogRange(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	if err := store.SetUint64([]byte("CurrentTerm"), 7); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.BackupScoped(&buf, BackupScope{Logs: true, MinIndex: 2, MaxIndex: 4}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	fresh := testBadgerStore(t)
+	defer fresh.Close()
+	defer os.RemoveAll(fresh.path)
+	if err := fresh.RestoreScoped(&buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result := new(raft.Log)
+	for idx := uint64(1); idx <= 5; idx++ {
+		err := fresh.GetLog(idx, result)
+		if idx >= 2 && idx <= 4 {
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if result.Index != idx {
+				t.Fatalf("bad: %#v", result)
+			}
+		} else if err != raft.ErrLogNotFound {
+			t.Fatalf("expected log %d not found, got: %v", idx, err)
+		}
+	}
+	if _, err := fresh.GetUint64([]byte("CurrentTerm")); err == nil {
+		t.Fatalf("expected the stable store to be left out")
+	}
+}
+
+func TestBadgerStore_BackupScopedInvalid(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	var buf bytes.Buffer
+	if err := store.BackupScoped(&buf, BackupScope{}); err == nil {
+		t.Fatalf("expected an error for an empty scope")
+	}
+	if err := store.BackupScoped(&buf, BackupScope{Logs: true, MinIndex: 5, MaxIndex: 2}); err == nil {
+		t.Fatalf("expected an error for an inverted range")
+	}
+	if err := store.RestoreScoped(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})); err == nil {
+		t.Fatalf("expected an error for a malformed backup")
+	}
+}
+
+func testBackupStore(t *testing.T, separate bool) *BadgerStore {
+	path, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store, err := New(Options{Path: path, SeparateStableStore: separate})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestBadgerStore_Backup(t *testing.T) {
+	for _, separate := range []bool{false, true} {
+		store := testBackupStore(t, separate)
+		defer store.Close()
+		defer os.RemoveAll(store.path)
+		testStoreFiveLogs(t, store)
+		if err := store.SetUint64([]byte("CurrentTerm"), 7); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		var full, incr bytes.Buffer
+		since, err := store.Backup(&full, 0)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.StoreLog(&raft.Log{Index: 6, Term: 2, Data: []byte("six")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if _, err := store.Backup(&incr, since); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		// The full backup, then the incremental one, rebuild the store
+		fresh := testBackupStore(t, separate)
+		defer fresh.Close()
+		defer os.RemoveAll(fresh.path)
+		if err := fresh.Restore(&full); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if last, err := fresh.LastIndex(); err != nil || last != 5 {
+			t.Fatalf("bad: %d, %v", last, err)
+		}
+		if err := fresh.Restore(&incr); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if last, err := fresh.LastIndex(); err != nil || last != 6 {
+			t.Fatalf("bad: %d, %v", last, err)
+		}
+		if term, err := fresh.GetUint64([]byte("CurrentTerm")); err != nil || term != 7 {
+			t.Fatalf("bad: %d, %v", term, err)
+		}
+		log := new(raft.Log)
+		if err := fresh.GetLog(6, log); err != nil || string(log.Data) != "six" {
+			t.Fatalf("bad: %#v, %v", log, err)
+		}
+	}
+}
+
+func TestBadgerStore_BackupAfterDeleteRange(t *testing.T) {
+	for _, separate := range []bool{false, true} {
+		store := testBackupStore(t, separate)
+		defer store.Close()
+		defer os.RemoveAll(store.path)
+		testStoreFiveLogs(t, store)
+		if err := store.DeleteRange(1, 2); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := store.Backup(&buf, 0); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		fresh := testBackupStore(t, separate)
+		defer fresh.Close()
+		defer os.RemoveAll(fresh.path)
+		if err := fresh.Restore(&buf); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		// Deleted logs stay deleted
+		if first, err := fresh.FirstIndex(); err != nil || first != 3 {
+			t.Fatalf("bad: %d, %v", first, err)
+		}
+		log := new(raft.Log)
+		if err := fresh.GetLog(1, log); err != raft.ErrLogNotFound {
+			t.Fatalf("expected log 1 not found, got: %v", err)
+		}
+		if err := fresh.GetLog(3, log); err != nil || log.Index != 3 {
+			t.Fatalf("bad: %#v, %v", log, err)
+		}
+	}
+}
+
+func TestBadgerStore_RestoreScopedMalformedLogKey(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	var buf bytes.Buffer
+	// A log key with a three byte index
+	kv := &protos.KVPair{Key: []byte("logsabc"), Value: []byte("x"), UserMeta: []byte{0}}
+	if err := writeBackupEntry(&buf, kv); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.RestoreScoped(&buf); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if first, err := store.FirstIndex(); err != nil || first != 0 {
+		t.Fatalf("bad: %d, %v", first, err)
+	}
+}
+
+func TestBadgerStore_BackupScopedDedup(t *testing.T) {
+	open := func() *BadgerStore {
+		fh, err := ioutil.TempDir("", "badger")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		store, err := New(Options{Path: fh, DedupMinSize: 64})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return store
+	}
+	store := open()
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	blob := bytes.Repeat([]byte("config"), 20)
+	other := bytes.Repeat([]byte("retry"), 20)
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Data: blob},
+		{Index: 2, Term: 1, Data: blob},
+		{Index: 3, Term: 1, Data: blob},
+		{Index: 4, Term: 1, Data: other},
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.BackupScoped(&buf, BackupScope{Logs: true, MinIndex: 2, MaxIndex: 3}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	backup := buf.Bytes()
+
+	// Only the payloads the range references come along, counted as often
+	// as the range references them
+	fresh := open()
+	defer fresh.Close()
+	defer os.RemoveAll(fresh.path)
+	if err := fresh.RestoreScoped(bytes.NewReader(backup)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	h := fresh.blobHashOf(blob)
+	if refs := testBlobRefs(t, fresh); len(refs) != 1 || refs[h] != 2 {
+		t.Fatalf("bad: %v", refs)
+	}
+	result := new(raft.Log)
+	if err := fresh.GetLog(3, result); err != nil || !bytes.Equal(result.Data, blob) {
+		t.Fatalf("bad: %#v, %v", result, err)
+	}
+	if err := fresh.DeleteRange(2, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if refs := testBlobRefs(t, fresh); len(refs) != 0 {
+		t.Fatalf("bad: %v", refs)
+	}
+
+	// Restoring over the entries backed up leaves the counts alone
+	if err := store.RestoreScoped(bytes.NewReader(backup)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if refs := testBlobRefs(t, store); len(refs) != 2 || refs[h] != 3 || refs[store.blobHashOf(other)] != 1 {
+		t.Fatalf("bad: %v", refs)
+	}
+}
@@ -2,69 +2,1065 @@ package raftbadgerdb
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
-	"encoding/gob"
 	"errors"
 	"fmt"
-	"log"
 	"math"
-	"strconv"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/dgraph-io/badger"
+	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	// Bucket names we perform transactions in
-	dbLogsPrefix = []byte("logs")
-	dbConfPrefix = []byte("conf")
+// defaultNumVersionsToKeep is the number of versions of a key kept unless
+// configured otherwise.
+const defaultNumVersionsToKeep = 1
+
+const (
+	// defaultValueThreshold is the size up to which values are kept in the
+	// LSM tree unless configured otherwise. It covers the encoded form of
+	// typical raft entries, commands of a few hundred bytes plus the log's
+	// own fields, so reads of them skip the value log; see
+	// BenchmarkBadgerStore_ValueThreshold.
+	defaultValueThreshold = 1 * kib
+	// maxValueThreshold is the largest threshold Badger accepts.
+	maxValueThreshold = math.MaxUint16 - 16
+	// deleteBatchSize is the number of entries DeleteRange removes per
+	// transaction.
+	deleteBatchSize = 10000
+)
 
+var (
 	// ErrKeyNotFound is an error indicating a given key does not exist
 	ErrKeyNotFound = errors.New("not found")
+
+	// ErrUint64Rollback is returned by SetUint64 when a key listed in
+	// Options.MonotonicKeys would move backwards
+	ErrUint64Rollback = errors.New("refusing to decrease monotonic value")
+
+	// ErrNamespaceOverlap is returned by New for an Options.Namespace whose
+	// keys could be mistaken for those of another store
+	ErrNamespaceOverlap = errors.New("namespace overlaps another store's keys")
+
+	// ErrReadOnly is returned by every method that would modify a store
+	// opened with Options.ReadOnly
+	ErrReadOnly = errors.New("store is read-only")
 )
 
+// txnGet reads a single key within a transaction. Tests replace it to
+// inject read failures.
+var txnGet = (*badger.Txn).Get
+
 // BadgerStore provides access to Badger for Raft to store and retrieve
 // log entries. It also provides key/value storage, and can be used as
 // a LogStore and StableStore. See https://godoc.org/github.com/hashicorp/raft#StableStore
 // and https://godoc.org/github.com/hashicorp/raft#LogStore
 type BadgerStore struct {
-	db   *badger.DB
-	path string
+	db     *badger.DB
+	path   string
+	codec  Codec
+	cipher *valueCipher
+	keys   keyPrefixes
+	// compression is applied to entries before they are sealed, see
+	// compress.go
+	compression Compression
+	// minCompressSize is the encoded size below which entries are stored
+	// uncompressed
+	minCompressSize int
+
+	// claimedPath is the canonical path registered with the open guard
+	claimedPath string
+	// sharedDB is set when db belongs to the application, see NewWithDB
+	sharedDB bool
+
+	metrics         *storeMetrics
+	logger          hclog.Logger
+	verifyWrites    bool
+	allowOutOfOrder bool
+	skipChecksums   bool
+	monotonicKeys   map[string]bool
+	onCompaction    func(CompactionReport)
+	hooks           Hooks
+	tracer          trace.Tracer
+	slowOpThreshold time.Duration
+	trashGrace      time.Duration
+	dedupMinSize    int
+	maxEntrySize    int
+	atomicStoreLogs bool
+	// batchBytes and batchInterval configure LogBatch, see logbatch.go
+	batchBytes     int
+	batchInterval  time.Duration
+	cache          *logCache
+	prom           *promMetrics
+	bounds         indexBounds
+	disk           *diskMonitor
+	attach         *attachServer
+	appended       appendSignal
+	mirror         *mirror
+	compactOnClose time.Duration
+	startup        StartupReport
+
+	// ops tracks operations in flight for Close, see lifecycle.go
+	ops          *opTracker
+	closeTimeout time.Duration
+	stopOnce     sync.Once
+
+	stableWrites *stableCoalescer
+	groupCommit  *groupCommitter
+
+	readRetry        RetryPolicy
+	writeRetry       RetryPolicy
+	maintenanceRetry RetryPolicy
+	conflictRetry    RetryPolicy
+
+	// Append time marks and log age metrics, see logage.go
+	appendMu       sync.Mutex
+	lastAppendMark time.Time
+	logAgeStop     chan struct{}
+	logAgeDone     chan struct{}
+
+	// Background trims of old entries, see retention.go
+	retention     retentionPolicy
+	retentionStop chan struct{}
+	retentionDone chan struct{}
+
+	// Encryption key refreshes from Options.KeyProvider, see keyprovider.go
+	keyProvider    KeyProvider
+	keyRefreshStop chan struct{}
+	keyRefreshDone chan struct{}
+
+	// gc runs scheduled value log garbage collection, see gc.go
+	gc *gcScheduler
+
+	// statsMu guards lastGC, see stats.go
+	statsMu sync.Mutex
+	lastGC  time.Time
+
+	// Background syncing for SyncInterval, see sync.go
+	syncStop chan struct{}
+	syncDone chan struct{}
+
+	// Scheduled backups to Options.BackupSink, see sink.go
+	backupStop chan struct{}
+	backupDone chan struct{}
+
+	// badgerOpts are the options Badger was opened with
+	badgerOpts badger.Options
+
+	// stableDB holds the stable store: db itself, or a database of its own
+	// opened with stableBadgerOpts, see stable.go. stableGC collects its
+	// value log
+	stableDB         *badger.DB
+	stableBadgerOpts badger.Options
+	stableGC         *gcScheduler
+
+	// Asynchronous DeleteRange state, see async_delete.go. deletesMu guards
+	// pendingDeletes, flushMu serializes physical deletions
+	asyncDeletes   bool
+	deletesMu      sync.RWMutex
+	pendingDeletes []pendingDelete
+	flushMu        sync.Mutex
+	deleteWake     chan struct{}
+	deleteStop     chan struct{}
+	deleteDone     chan struct{}
 }
 
 // Options contains all the configuration used to open BadgerDB
 type Options struct {
-	// BadgerOptions contains any Badger-specific options
+	// BadgerOptions contains any Badger-specific options. They are copied,
+	// never modified, and default to badger.DefaultOptions. Numeric fields
+	// left at zero take Badger's defaults, apart from NumVersionsToKeep and
+	// ValueThreshold, which take the store's (see the options of the same
+	// name). Dir and ValueDir are always set to a directory inside Path
 	BadgerOptions *badger.Options
 	// Path is the directory
 	Path string
+	// ReadOnly opens Badger read-only, for inspecting the directory of a
+	// stopped node without any risk of changing it; several processes can
+	// do so at once. Every mutating method returns ErrReadOnly. A store
+	// that wasn't closed cleanly needs Badger to replay its value log,
+	// which it can't do read-only, so New fails with badger.ErrReplayNeeded
+	// until it has been opened read-write once. Options that write in the
+	// background can't be combined with it
+	ReadOnly bool
+	// ManualUpgrade makes New fail with ErrUpgradeRequired when the store
+	// is in an older format, instead of upgrading it on the spot, so the
+	// upgrade happens when Upgrade is called
+	ManualUpgrade bool
+	// Codec encodes newly stored logs, defaults to GobCodec. Entries written
+	// with any built-in codec can always be read back
+	Codec Codec
+	// Compression compresses log entries and snapshot chunks before they
+	// are stored, and before they are encrypted. Defaults to
+	// CompressionNone
+	Compression Compression
+	// MinCompressSize, with Compression set, is the encoded size in bytes
+	// below which entries are stored uncompressed, so small entries such as
+	// heartbeats and configuration changes don't spend CPU on it. Zero
+	// compresses entries of every size
+	MinCompressSize int
+	// EncryptionKey, if set, encrypts every newly stored log entry and
+	// snapshot chunk with AES-GCM before it reaches Badger, so payloads
+	// stay protected in backups and exports. StableStore values are not
+	// encrypted
+	EncryptionKey *EncryptionKey
+	// DecryptionKeys are retired keys that entries written earlier may
+	// still be encrypted with
+	DecryptionKeys []EncryptionKey
+	// KeyProvider, if set, supplies the encryption keys instead of
+	// EncryptionKey and DecryptionKeys, which it can't be combined with
+	KeyProvider KeyProvider
+	// KeyRefreshInterval, if set, fetches the keys from KeyProvider again
+	// at this interval and rotates to a new active key with
+	// RotateEncryptionKey as soon as it shows up
+	KeyRefreshInterval time.Duration
+	// VerifyWrites re-reads every StoreLogs batch right after it is
+	// committed and compares checksums with what was written, trading
+	// latency for a guarantee against encode or commit bugs
+	VerifyWrites bool
+	// SkipChecksumVerification stops reads from checking log entries
+	// against the CRC-32C stored with each of them, for users who trust
+	// Badger's own checks. Checksums are written either way
+	SkipChecksumVerification bool
+	// AllowOutOfOrderAppends lets StoreLogs write entries anywhere in the
+	// log, overwriting stored ones or leaving gaps, as it did before the
+	// store implemented raft.MonotonicLogStore. Raft itself never needs it
+	AllowOutOfOrderAppends bool
+	// MonotonicKeys lists stable store keys whose SetUint64 writes go
+	// through SetUint64IfGreater, such as raft's "CurrentTerm" and
+	// "LastVoteTerm", as a safety net against rolling them backwards
+	MonotonicKeys [][]byte
+	// Namespace, if set, is put in front of every key the store writes, so
+	// several stores or applications can share a Badger database without
+	// their keys colliding. It must not make the store's prefixes overlap
+	// those of a store without one
+	Namespace []byte
+	// MetricsPrefix is prepended to the name of every metric the store
+	// emits through go-metrics, defaults to "raft.badgerdb"
+	MetricsPrefix []string
+	// MetricsLabels are constant labels, such as cluster, shard or node id,
+	// attached to every metric the store emits
+	MetricsLabels map[string]string
+	// PrometheusMetrics records operation latencies, bytes and entries
+	// written and Badger's sizes for PrometheusCollector to expose. Metric
+	// names start with MetricsPrefix joined by underscores and carry
+	// MetricsLabels. Stores without it don't pay for the bookkeeping
+	PrometheusMetrics bool
+	// MetricsSink, if set, receives the store's go-metrics instead of
+	// go-metrics' global instance, e.g. to keep them apart from raft's own
+	MetricsSink metrics.MetricSink
+	// TracerProvider, if set, traces StoreLogs, GetLog, DeleteRange, Set
+	// and Get with OpenTelemetry, recording index ranges, batch sizes and
+	// bytes as span attributes
+	TracerProvider trace.TracerProvider
+	// Logger, if set, receives structured logs of slow operations, garbage
+	// collection runs, retried transactions and detected corruption. The
+	// store is silent without one
+	Logger hclog.Logger
+	// SlowOpThreshold is how long a store operation may take before it is
+	// logged as a warning, with the size of its batch and Badger's LSM
+	// tree and compaction backlog, to help tell slow disks apart from other
+	// causes of leadership instability. It is 500ms unless set, and a
+	// negative threshold turns the warnings off
+	SlowOpThreshold time.Duration
+	// OnProgress, if set, receives progress reports during long phases of
+	// opening the store, so a restarting node can be told apart from a hung
+	// one
+	OnProgress ProgressFunc
+	// Hooks are called after log entries are stored, ranges deleted,
+	// stable store keys set, value log garbage collection runs and when
+	// corruption is detected
+	Hooks Hooks
+	// OnCompaction, if set, receives a report after every truncation
+	// (DeleteRange) and value log garbage collection run
+	OnCompaction func(CompactionReport)
+	// AsyncDeleteRange makes DeleteRange return as soon as the range is
+	// recorded as deleted; reads stop seeing it immediately and a background
+	// worker removes the entries, smoothing the latency spike of truncating
+	// after a snapshot. Ranges not yet removed survive restarts
+	AsyncDeleteRange bool
+	// SoftDeleteGracePeriod, if set, makes DeleteRange move entries to a
+	// trash prefix instead of deleting them outright. They can be restored
+	// with Undelete until the grace period runs out, after which Badger
+	// expires them
+	SoftDeleteGracePeriod time.Duration
+	// MinFreeDiskSpace, in bytes, makes New refuse to open the store with
+	// ErrInsufficientDiskSpace when its filesystem has less space available
+	MinFreeDiskSpace uint64
+	// DiskSpaceCheckInterval, if set, publishes the free space of the
+	// store's filesystem as the disk.free_bytes gauge at this interval and
+	// counts disk.low_space whenever it is below MinFreeDiskSpace
+	DiskSpaceCheckInterval time.Duration
+	// OnLowDiskSpace, if set, is called when free space drops below
+	// LowDiskSpaceBytes or the projected days until the filesystem is full
+	// drop below LowDiskSpaceDays, giving the application a chance to
+	// snapshot, truncate or alert before writes fail. It fires once per
+	// crossing. Free space is checked every DiskSpaceCheckInterval, or every
+	// minute if that isn't set
+	OnLowDiskSpace    func(DiskSpaceReport)
+	LowDiskSpaceBytes uint64
+	LowDiskSpaceDays  float64
+	// AllowAttach lets other processes take a read-only copy of the running
+	// store with AttachReadOnly, and follow its appends with FollowLogs,
+	// served over unix sockets in Path
+	AllowAttach bool
+	// MirrorPath, if set, mirrors every write to a second store directory,
+	// for example on another disk. The mirror is seeded from the store when
+	// empty. Writes wait for the mirror unless MirrorAsync is set; either
+	// way a failing mirror only marks it diverged, see MirrorErr and
+	// VerifyMirror
+	MirrorPath  string
+	MirrorAsync bool
+	// CompactOnClose, if set, makes Close garbage collect the value log for
+	// up to this long before closing, so nodes that rarely restart reclaim
+	// space at shutdown. The run is reported through OnCompaction with the
+	// "close" trigger
+	CompactOnClose time.Duration
+	// CloseTimeout is how long Close waits for operations in flight before
+	// giving up with ErrCloseTimeout. It is 30s unless set, and a negative
+	// timeout waits as long as it takes
+	CloseTimeout time.Duration
+	// ValueLogGCInterval, if set, garbage collects the value log in the
+	// background at this interval, rewriting files with at least
+	// ValueLogGCDiscardRatio (0.5 unless set) of stale data until there
+	// are none left. Badger never does this on its own, so without it the
+	// value log of a long-running store only grows. Runs are reported
+	// through OnCompaction with the "scheduled-gc" trigger and can be held
+	// off with PauseValueLogGC
+	ValueLogGCInterval     time.Duration
+	ValueLogGCDiscardRatio float64
+	// VerifyOnOpen selects how thoroughly New checks the store before
+	// returning, see VerifyMode. The outcome is part of StartupReport
+	VerifyOnOpen VerifyMode
+	// MemoryBudget, if set, sizes Badger's memtables, table and value log
+	// loading and compactors to fit the store into about this many bytes,
+	// see TuneForMemory. AutoTuneMemory derives the budget from system
+	// memory instead (a quarter of it, or of the container's limit) on
+	// platforms where it can be read
+	MemoryBudget   uint64
+	AutoTuneMemory bool
+	// NumVersionsToKeep is how many versions of each key Badger keeps
+	// through compaction. Raft never reads an old version, and overwrites
+	// while truncating conflicting entries would otherwise keep the
+	// replaced values on disk, so when neither this nor BadgerOptions sets
+	// it, the store keeps 1
+	NumVersionsToKeep int
+	// ValueThreshold is the size in bytes up to which a stored value lives
+	// in the LSM tree next to its key, rather than in the value log. Inline
+	// values are read without a second lookup but make the LSM tree, and
+	// the memory it needs, grow with them. When neither this nor
+	// BadgerOptions changes it from Badger's default, the store uses 1 KiB,
+	// which keeps typical raft entries inline and still sends snapshots of
+	// state and other large payloads to the value log. Raise it if most
+	// entries are larger; it can't exceed 65519
+	ValueThreshold int
+	// CoalesceStableWrites, if set, merges Set and SetUint64 calls from
+	// concurrent callers into a single transaction and fsync. A call made
+	// while no other is committing commits at once, so raft, which writes
+	// its term and vote one after the other, is never slowed down, but
+	// gains nothing either: it only helps concurrent callers. Calls
+	// arriving while a commit is in flight wait for it, then up to this
+	// window for more calls to join, and commit together. Every call still returns only once its write is durable.
+	// Keys in MonotonicKeys are never coalesced. A window of a millisecond
+	// or two is plenty
+	CoalesceStableWrites time.Duration
+	// GroupCommitWindow, if set, merges StoreLogs calls arriving within
+	// this window into a single transaction, trading up to a window of
+	// latency for far fewer commits when many small appends arrive at
+	// once, as with pipelined replication or several rafts sharing a
+	// store. Every call still returns only once its entries are committed
+	GroupCommitWindow time.Duration
+	// LogAgeInterval, if set, publishes how long ago the oldest and newest
+	// entries were appended as the log.oldest_age_seconds and
+	// log.newest_age_seconds gauges at this interval. A log tail that keeps
+	// getting older usually means snapshots have stopped
+	LogAgeInterval time.Duration
+	// RetentionInterval, if set, trims old log entries in the background at
+	// this interval, so the application needn't call DeleteRange itself.
+	// Only entries up to the index RetentionSnapshotIndex returns, that of
+	// the latest snapshot, are trimmed, keeping RetentionTrailingLogs of
+	// them as raft's TrailingLogs does, and, if RetentionMaxAge is set,
+	// any appended less than that long ago. Trimmed entries are counted as
+	// retention.trimmed_entries and failed runs as retention.failures
+	RetentionInterval      time.Duration
+	RetentionSnapshotIndex func() (uint64, error)
+	RetentionTrailingLogs  uint64
+	RetentionMaxAge        time.Duration
+	// RetentionGroupSnapshotIndex takes the place of RetentionSnapshotIndex
+	// for NewMultiStore, returning the index of the latest snapshot of the
+	// raft group given, so each group is trimmed up to its own snapshot.
+	// Without RetentionInterval groups are only trimmed by TrimRetained
+	RetentionGroupSnapshotIndex func(group string) (uint64, error)
+	// ReadRetry, WriteRetry and MaintenanceRetry retry reads, writes and
+	// background maintenance (deleting queued ranges and value log garbage
+	// collection) that fail with transient errors. By default nothing is
+	// retried, apart from the transaction conflicts ConflictRetries covers.
+	// Retried writes are idempotent: they store the same entries
+	// again or delete the same range again
+	ReadRetry        RetryPolicy
+	WriteRetry       RetryPolicy
+	MaintenanceRetry RetryPolicy
+	// ConflictRetries is how many times StoreLogs, DeleteRange and stable
+	// store writes are retried, after a short backoff, when they conflict
+	// with a concurrent transaction, before WriteRetry gets to see
+	// badger.ErrConflict. It is 3 unless set, and a negative count turns
+	// the retries off
+	ConflictRetries int
+	// exactBadgerOptions makes New use BadgerOptions as they are, see
+	// NewWithOptions
+	exactBadgerOptions bool
+	// skipUpgrade opens a store in an older key format read-only as it is,
+	// for PlanUpgrade to look at
+	skipUpgrade bool
+	// sharedDB is the database to use instead of opening one, see
+	// NewWithDB
+	sharedDB *badger.DB
+	// DedupMinSize, if set, stores log payloads of at least this many bytes
+	// once per distinct content, keyed by SHA-256, or by an HMAC keyed from
+	// EncryptionKey when set, and reference counted, so appending the same
+	// large blob again (a re-pushed configuration, a retried command)
+	// doesn't take more disk. GetLog and every other read put the payload
+	// back transparently, and DeleteRange frees it with its last reference.
+	// Stores with deduplicated entries can be read without the option. It
+	// can't be combined with SoftDeleteGracePeriod
+	DedupMinSize int
+	// MaxEntrySize, if set, makes StoreLogs, LogBatch and StoreTxn reject
+	// batches holding an entry whose Data and Extensions together exceed
+	// this many bytes with ErrEntryTooLarge, so a misbehaving FSM command
+	// fails where it is appended instead of bloating the value log and
+	// every follower's replication
+	MaxEntrySize int
+	// CacheEntries and CacheBytes, if either is set, keep recently appended
+	// entries in memory, up to this many entries and this many bytes of
+	// payload (plus a small per-entry overhead), evicting the least
+	// recently used. GetLog and GetLogs serve cached entries without
+	// touching Badger, which helps a leader replicating the tail of the
+	// log. Payloads returned from the cache are shared and must not be
+	// modified
+	CacheEntries int
+	CacheBytes   int
+	// CacheWarmEntries, if set, makes New load up to this many of the last
+	// entries into the cache before returning, along with the first and
+	// last index, so the first heartbeats and replication after a restart
+	// don't read from disk. It needs CacheEntries or CacheBytes, which
+	// still bound what stays cached
+	CacheWarmEntries int
+	// AtomicStoreLogs makes StoreLogs fail with an error wrapping
+	// badger.ErrTxnTooBig when a batch doesn't fit into one Badger
+	// transaction. By default such batches are committed in several
+	// transactions, in index order, so a failure part way through can leave
+	// a prefix of the batch stored, which raft copes with since it only
+	// relies on entries it was told were stored
+	AtomicStoreLogs bool
+	// SyncPolicy decides when writes are synced to disk, see the policies.
+	// SyncInterval syncs every SyncInterval, once a second unless set. Sync
+	// syncs on demand under any policy
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration
+	// WriteBatchBytes, if set, makes StoreLogs append through a LogBatch
+	// unless AtomicStoreLogs is set: calls with more log data than this
+	// are committed in transactions of about this size, which Badger
+	// writes while the next one is prepared. It is also the batch size of
+	// NewLogBatch, 4 MiB unless set. WriteBatchFlushInterval, if set,
+	// makes a LogBatch commit pending entries that long after the first of
+	// them was appended
+	WriteBatchBytes         int
+	WriteBatchFlushInterval time.Duration
+	// SeparateStableStore keeps the stable store in a Badger database of
+	// its own, inside Badger's directory, so each can be tuned for its
+	// workload: log entries are large, appended and truncated in bulk,
+	// while term and vote are tiny and rewritten all the time.
+	// StableBadgerOptions tunes it like BadgerOptions tunes the log's, and
+	// StableValueLogGCInterval schedules its value log garbage collection,
+	// reported with the "scheduled-stable-gc" trigger. Values already in
+	// the log's database move over on open; a store that has kept them
+	// apart can't go back and fails to open with ErrStableStoreLayout
+	SeparateStableStore      bool
+	StableBadgerOptions      *badger.Options
+	StableValueLogGCInterval time.Duration
+	// BackupSink, if set along with BackupInterval, receives a full backup
+	// of the store at this interval, such as to an S3Sink pushing it
+	// off-box. BackupRetain, if set, removes the oldest backups the store
+	// sent until only this many are left. Failed backups are counted as
+	// backup.failures and logged
+	BackupSink     BackupSink
+	BackupInterval time.Duration
+	BackupRetain   int
+}
+
+// fillBadgerDefaults sets the numeric fields of opts left at zero, which
+// Badger can't open with, to their defaults. Loading modes and flags are
+// left alone since their zero values are valid choices. Badger's default
+// ValueThreshold, being tuned for other workloads, counts as unset.
+func fillBadgerDefaults(opts *badger.Options) {
+	d := badger.DefaultOptions
+	if opts.NumVersionsToKeep == 0 {
+		opts.NumVersionsToKeep = defaultNumVersionsToKeep
+	}
+	if opts.ValueThreshold == 0 || opts.ValueThreshold == d.ValueThreshold {
+		opts.ValueThreshold = defaultValueThreshold
+	}
+	if opts.MaxTableSize == 0 {
+		opts.MaxTableSize = d.MaxTableSize
+	}
+	if opts.LevelSizeMultiplier == 0 {
+		opts.LevelSizeMultiplier = d.LevelSizeMultiplier
+	}
+	if opts.MaxLevels == 0 {
+		opts.MaxLevels = d.MaxLevels
+	}
+	if opts.NumMemtables == 0 {
+		opts.NumMemtables = d.NumMemtables
+	}
+	if opts.NumLevelZeroTables == 0 {
+		opts.NumLevelZeroTables = d.NumLevelZeroTables
+	}
+	if opts.NumLevelZeroTablesStall == 0 {
+		opts.NumLevelZeroTablesStall = d.NumLevelZeroTablesStall
+	}
+	if opts.LevelOneSize == 0 {
+		opts.LevelOneSize = d.LevelOneSize
+	}
+	if opts.ValueLogFileSize == 0 {
+		opts.ValueLogFileSize = d.ValueLogFileSize
+	}
+	if opts.ValueLogMaxEntries == 0 {
+		opts.ValueLogMaxEntries = d.ValueLogMaxEntries
+	}
+	if opts.NumCompactors == 0 {
+		opts.NumCompactors = d.NumCompactors
+	}
 }
 
 // NewBadgerStore takes a file path and returns a connected Raft backend.
 func NewBadgerStore(path string) (*BadgerStore, error) {
-	opts := Options{Path: path, BadgerOptions: &badger.DefaultOptions}
+	opts := Options{Path: path}
 	return New(opts)
 }
 
+// NewWithOptions opens the store at path with exactly the given Badger
+// options, filling in no defaults: only Dir and ValueDir are set, to a
+// directory inside path. Use New to have zero fields defaulted.
+func NewWithOptions(path string, badgerOpts badger.Options) (*BadgerStore, error) {
+	return New(Options{Path: path, BadgerOptions: &badgerOpts, exactBadgerOptions: true})
+}
+
+// NewWithDB layers the store on db, a Badger database the application
+// opened and keeps using for its own state, saving the memory of a second
+// Badger instance. Every key of the store goes under options.Namespace,
+// "raft/" if it isn't set, and stores sharing db must use namespaces that
+// don't overlap. Closing the store leaves db open.
+//
+// The application owns db, so options configuring or managing a database,
+// such as Path, BadgerOptions, SyncPolicy or ValueLogGCInterval, can't be
+// used, and nor can mirroring, attaching or a separate stable store.
+func NewWithDB(db *badger.DB, options Options) (*BadgerStore, error) {
+	if db == nil {
+		return nil, errors.New("NewWithDB needs a database")
+	}
+	for name, set := range map[string]bool{
+		"Path":                   options.Path != "",
+		"BadgerOptions":          options.BadgerOptions != nil,
+		"ReadOnly":               options.ReadOnly,
+		"MinFreeDiskSpace":       options.MinFreeDiskSpace > 0,
+		"DiskSpaceCheckInterval": options.DiskSpaceCheckInterval > 0 || options.OnLowDiskSpace != nil || options.LowDiskSpaceBytes > 0 || options.LowDiskSpaceDays > 0,
+		"AllowAttach":            options.AllowAttach,
+		"MirrorPath":             options.MirrorPath != "",
+		"CompactOnClose":         options.CompactOnClose > 0,
+		"ValueLogGCInterval":     options.ValueLogGCInterval > 0,
+		"MemoryBudget":           options.MemoryBudget > 0 || options.AutoTuneMemory,
+		"NumVersionsToKeep":      options.NumVersionsToKeep > 0,
+		"ValueThreshold":         options.ValueThreshold > 0,
+		"SyncPolicy":             options.SyncPolicy != SyncDefault,
+		"SeparateStableStore":    options.SeparateStableStore,
+		"BackupInterval":         options.BackupInterval > 0,
+	} {
+		if set {
+			return nil, fmt.Errorf("%s can't be used with NewWithDB", name)
+		}
+	}
+	if len(options.Namespace) == 0 {
+		options.Namespace = []byte(defaultSharedNamespace)
+	}
+	options.sharedDB = db
+	return New(options)
+}
+
+// defaultSharedNamespace is the namespace of a store NewWithDB layers on a
+// database without an Options.Namespace.
+const defaultSharedNamespace = "raft/"
+
 // New uses the supplied options to open a badger db and prepare it for use as a raft backend.
 func New(options Options) (*BadgerStore, error) {
-	options.BadgerOptions.Dir = options.Path + "/badger"
-	options.BadgerOptions.ValueDir = options.Path + "/badger"
-	db, err := badger.Open(*options.BadgerOptions)
+	if options.Codec == nil {
+		options.Codec = GobCodec{}
+	}
+	if err := validateCodec(options.Codec); err != nil {
+		return nil, err
+	}
+	if len(options.Namespace) > 0 && newKeyPrefixes(options.Namespace).overlaps(defaultKeys) {
+		return nil, fmt.Errorf("%w: %q", ErrNamespaceOverlap, options.Namespace)
+	}
+	if options.Compression > CompressionZstd {
+		return nil, fmt.Errorf("invalid compression %s", options.Compression)
+	}
+	if options.MinCompressSize < 0 {
+		return nil, fmt.Errorf("invalid MinCompressSize %d", options.MinCompressSize)
+	}
+	if options.NumVersionsToKeep < 0 {
+		return nil, fmt.Errorf("invalid NumVersionsToKeep %d", options.NumVersionsToKeep)
+	}
+	if options.DedupMinSize < 0 {
+		return nil, fmt.Errorf("invalid DedupMinSize %d", options.DedupMinSize)
+	}
+	if options.MaxEntrySize < 0 {
+		return nil, fmt.Errorf("invalid MaxEntrySize %d", options.MaxEntrySize)
+	}
+	if options.DedupMinSize > 0 && options.SoftDeleteGracePeriod > 0 {
+		return nil, errors.New("DedupMinSize and SoftDeleteGracePeriod can't be combined")
+	}
+	if options.ValueLogGCInterval < 0 || options.ValueLogGCDiscardRatio < 0 || options.ValueLogGCDiscardRatio >= 1 {
+		return nil, fmt.Errorf("invalid value log GC interval %s, discard ratio %g", options.ValueLogGCInterval, options.ValueLogGCDiscardRatio)
+	}
+	if options.SyncPolicy < SyncDefault || options.SyncPolicy > SyncNever || options.SyncInterval < 0 {
+		return nil, fmt.Errorf("invalid sync policy %s with interval %s", options.SyncPolicy, options.SyncInterval)
+	}
+	if !options.SeparateStableStore && (options.StableBadgerOptions != nil || options.StableValueLogGCInterval != 0) {
+		return nil, errors.New("StableBadgerOptions and StableValueLogGCInterval need SeparateStableStore")
+	}
+	if options.StableValueLogGCInterval < 0 {
+		return nil, fmt.Errorf("invalid stable value log GC interval %s", options.StableValueLogGCInterval)
+	}
+	if options.SeparateStableStore && options.MirrorPath != "" {
+		return nil, errors.New("SeparateStableStore and MirrorPath can't be combined")
+	}
+	if options.ReadOnly && (options.AsyncDeleteRange || options.ValueLogGCInterval > 0 || options.StableValueLogGCInterval > 0 ||
+		options.CompactOnClose > 0 || options.SyncPolicy == SyncInterval || options.MirrorPath != "" || options.KeyRefreshInterval > 0 ||
+		options.RetentionInterval > 0) {
+		return nil, errors.New("ReadOnly can't be combined with options that write in the background")
+	}
+	if options.BackupInterval < 0 || options.BackupRetain < 0 || options.BackupInterval > 0 && options.BackupSink == nil {
+		return nil, fmt.Errorf("invalid backup interval %s, retaining %d", options.BackupInterval, options.BackupRetain)
+	}
+	if options.WriteBatchBytes < 0 || options.WriteBatchFlushInterval < 0 {
+		return nil, fmt.Errorf("invalid write batch of %d bytes, flush interval %s", options.WriteBatchBytes, options.WriteBatchFlushInterval)
+	}
+	if options.CacheEntries < 0 || options.CacheBytes < 0 {
+		return nil, fmt.Errorf("invalid cache size of %d entries, %d bytes", options.CacheEntries, options.CacheBytes)
+	}
+	if options.CacheWarmEntries < 0 || options.CacheWarmEntries > 0 && options.CacheEntries == 0 && options.CacheBytes == 0 {
+		return nil, fmt.Errorf("invalid cache warm-up of %d entries", options.CacheWarmEntries)
+	}
+	if options.ValueThreshold < 0 || options.ValueThreshold > maxValueThreshold {
+		return nil, fmt.Errorf("invalid ValueThreshold %d", options.ValueThreshold)
+	}
+	if options.RetentionInterval < 0 || options.RetentionMaxAge < 0 || options.RetentionInterval > 0 && options.RetentionSnapshotIndex == nil {
+		return nil, fmt.Errorf("invalid retention interval %s, max age %s", options.RetentionInterval, options.RetentionMaxAge)
+	}
+	if options.RetentionGroupSnapshotIndex != nil {
+		return nil, errors.New("RetentionGroupSnapshotIndex needs NewMultiStore")
+	}
+	if options.KeyProvider != nil && (options.EncryptionKey != nil || len(options.DecryptionKeys) > 0) {
+		return nil, errors.New("KeyProvider can't be combined with EncryptionKey or DecryptionKeys")
+	}
+	if options.KeyRefreshInterval < 0 || options.KeyRefreshInterval > 0 && options.KeyProvider == nil {
+		return nil, fmt.Errorf("invalid key refresh interval %s", options.KeyRefreshInterval)
+	}
+	if options.KeyProvider != nil {
+		active, retired, err := options.KeyProvider.EncryptionKeys()
+		if err != nil {
+			return nil, fmt.Errorf("fetching encryption keys: %w", err)
+		}
+		options.EncryptionKey, options.DecryptionKeys = &active, retired
+	}
+	valueCipher, err := newValueCipher(options.EncryptionKey, options.DecryptionKeys)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+	metricsOut := newStoreMetrics(options.MetricsPrefix, options.MetricsLabels)
+	if options.MetricsSink != nil {
+		if err := metricsOut.useSink(options.MetricsSink); err != nil {
+			return nil, err
+		}
+	}
+	keys := newKeyPrefixes(options.Namespace)
+	var db *badger.DB
+	var badgerOpts badger.Options
+	var claimedPath string
+	openStart := time.Now()
+	if options.sharedDB != nil {
+		if err := claimNamespace(options.sharedDB, keys); err != nil {
+			return nil, err
+		}
+		// The application configures and syncs the database, Sync leaves it
+		// alone
+		db, badgerOpts = options.sharedDB, badger.Options{SyncWrites: true}
+	} else {
+		db, badgerOpts, claimedPath, err = openBadger(options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	monotonicKeys := make(map[string]bool, len(options.MonotonicKeys))
+	for _, k := range options.MonotonicKeys {
+		monotonicKeys[string(k)] = true
 	}
 
 	store := &BadgerStore{
-		db:   db,
-		path: options.Path,
+		db:               db,
+		sharedDB:         options.sharedDB != nil,
+		stableDB:         db,
+		path:             options.Path,
+		codec:            options.Codec,
+		cipher:           valueCipher,
+		compression:      options.Compression,
+		minCompressSize:  options.MinCompressSize,
+		keys:             keys,
+		claimedPath:      claimedPath,
+		metrics:          metricsOut,
+		logger:           logger,
+		verifyWrites:     options.VerifyWrites,
+		allowOutOfOrder:  options.AllowOutOfOrderAppends,
+		skipChecksums:    options.SkipChecksumVerification,
+		monotonicKeys:    monotonicKeys,
+		onCompaction:     options.OnCompaction,
+		hooks:            options.Hooks,
+		slowOpThreshold:  options.SlowOpThreshold,
+		trashGrace:       options.SoftDeleteGracePeriod,
+		dedupMinSize:     options.DedupMinSize,
+		maxEntrySize:     options.MaxEntrySize,
+		atomicStoreLogs:  options.AtomicStoreLogs,
+		batchBytes:       options.WriteBatchBytes,
+		batchInterval:    options.WriteBatchFlushInterval,
+		cache:            newLogCache(options.CacheEntries, options.CacheBytes),
+		compactOnClose:   options.CompactOnClose,
+		ops:              new(opTracker),
+		closeTimeout:     options.CloseTimeout,
+		badgerOpts:       badgerOpts,
+		readRetry:        options.ReadRetry,
+		writeRetry:       options.WriteRetry,
+		maintenanceRetry: options.MaintenanceRetry,
+		conflictRetry:    conflictRetryPolicy(options.ConflictRetries),
+		startup: StartupReport{
+			OpenDuration: time.Since(openStart),
+			Verify:       options.VerifyOnOpen,
+		},
+	}
+	if options.PrometheusMetrics {
+		store.prom = newPromMetrics(store, options.MetricsPrefix, options.MetricsLabels)
+	}
+	if store.slowOpThreshold == 0 {
+		store.slowOpThreshold = defaultSlowOpThreshold
+	}
+	if options.CloseTimeout == 0 {
+		store.closeTimeout = defaultCloseTimeout
+	}
+	if options.TracerProvider != nil {
+		store.tracer = options.TracerProvider.Tracer(tracerName)
+	}
+	if options.CoalesceStableWrites > 0 {
+		store.stableWrites = &stableCoalescer{b: store, window: options.CoalesceStableWrites}
+	}
+	if options.GroupCommitWindow > 0 {
+		store.groupCommit = &groupCommitter{b: store, window: options.GroupCommitWindow}
+	}
+	if !options.skipUpgrade {
+		if err := store.upgradeKeyFormat(options.OnProgress, options.ManualUpgrade); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	// A shared database has no directory of its own for a stable store
+	if options.sharedDB == nil {
+		if err := store.openStableStore(options); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	if options.VerifyOnOpen == VerifyFull {
+		verifyStart := time.Now()
+		verified, err := store.verifyAll(options.OnProgress)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store.startup.EntriesVerified = verified
+		store.startup.VerifyDuration = time.Since(verifyStart)
+	}
+	if options.AsyncDeleteRange {
+		if err := store.startAsyncDeletes(); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	// Legacy keys don't parse as log keys
+	if !options.skipUpgrade {
+		if _, _, err := store.logBounds(); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	if options.CacheWarmEntries > 0 {
+		warmStart := time.Now()
+		warmed, err := store.warmCache(options.CacheWarmEntries)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store.startup.CacheWarmed = warmed
+		store.startup.CacheWarmDuration = time.Since(warmStart)
+	}
+	diskInterval := options.DiskSpaceCheckInterval
+	if diskInterval == 0 && (options.OnLowDiskSpace != nil || options.LowDiskSpaceBytes > 0 || options.LowDiskSpaceDays > 0) {
+		diskInterval = defaultDiskWatchdogInterval
+	}
+	if diskInterval > 0 {
+		store.startDiskMonitor(diskInterval, options)
+	}
+	if options.MirrorPath != "" {
+		if err := store.openMirror(options.MirrorPath, options.MirrorAsync); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	if options.LogAgeInterval > 0 {
+		store.startLogAgeMetrics(options.LogAgeInterval)
+	}
+	if options.RetentionInterval > 0 {
+		store.retention = retentionPolicy{
+			snapshotIndex: options.RetentionSnapshotIndex,
+			trailingLogs:  options.RetentionTrailingLogs,
+			maxAge:        options.RetentionMaxAge,
+		}
+		store.startRetention(options.RetentionInterval)
+	}
+	if options.KeyRefreshInterval > 0 {
+		store.keyProvider = options.KeyProvider
+		store.startKeyRefresh(options.KeyRefreshInterval)
+	}
+	if options.ValueLogGCInterval > 0 {
+		store.gc = store.startValueLogGC(store.db, "scheduled-gc", options.ValueLogGCInterval, options.ValueLogGCDiscardRatio)
+	}
+	if options.StableValueLogGCInterval > 0 {
+		store.stableGC = store.startValueLogGC(store.stableDB, "scheduled-stable-gc", options.StableValueLogGCInterval, options.ValueLogGCDiscardRatio)
+	}
+	if options.SyncPolicy == SyncInterval {
+		interval := options.SyncInterval
+		if interval == 0 {
+			interval = defaultSyncInterval
+		}
+		store.startSyncer(interval)
+	}
+	if options.BackupInterval > 0 {
+		store.startBackups(options.BackupSink, options.BackupInterval, options.BackupRetain)
+	}
+	if options.AllowAttach {
+		if err := store.startAttachServer(); err != nil {
+			store.Close()
+			return nil, err
+		}
 	}
 	return store, nil
 }
 
-// Close is used to gracefully close the DB connection.
+// openBadger opens the Badger database of a store at options.Path and
+// claims the path, returning its canonical form.
+func openBadger(options Options) (*badger.DB, badger.Options, string, error) {
+	claimedPath, err := claimPath(options.Path)
+	if err != nil {
+		return nil, badger.Options{}, "", err
+	}
+	badgerOpts := badger.DefaultOptions
+	if options.BadgerOptions != nil {
+		badgerOpts = *options.BadgerOptions
+	}
+	if options.ReadOnly {
+		badgerOpts.ReadOnly = true
+	}
+	badgerOpts.Dir = badgerDir(options.Path)
+	badgerOpts.ValueDir = badgerDir(options.Path)
+	// A read-only store must already exist, and is opened as it is. Badger
+	// itself doesn't report a missing directory in read-only mode
+	if badgerOpts.ReadOnly {
+		if _, err := os.Stat(badgerOpts.Dir); err != nil {
+			releasePath(claimedPath)
+			return nil, badgerOpts, "", err
+		}
+	} else {
+		if err := recoverReplacement(options.Path); err != nil {
+			releasePath(claimedPath)
+			return nil, badgerOpts, "", err
+		}
+		if err := createDirSynced(badgerOpts.Dir); err != nil {
+			releasePath(claimedPath)
+			return nil, badgerOpts, "", err
+		}
+	}
+	if err := checkDiskSpace(options.Path, options.MinFreeDiskSpace); err != nil {
+		releasePath(claimedPath)
+		return nil, badgerOpts, "", err
+	}
+	budget, err := memoryBudget(options)
+	if err != nil {
+		releasePath(claimedPath)
+		return nil, badgerOpts, "", err
+	}
+	if !options.exactBadgerOptions {
+		fillBadgerDefaults(&badgerOpts)
+	}
+	if budget > 0 {
+		TuneForMemory(&badgerOpts, budget)
+	}
+	if options.NumVersionsToKeep > 0 {
+		badgerOpts.NumVersionsToKeep = options.NumVersionsToKeep
+	}
+	if options.ValueThreshold > 0 {
+		badgerOpts.ValueThreshold = options.ValueThreshold
+	}
+	if options.SyncPolicy != SyncDefault {
+		badgerOpts.SyncWrites = options.SyncPolicy == SyncAlways
+	}
+	var db *badger.DB
+	err = newProgressReporter(options.OnProgress, "open", 1).run(func() (err error) {
+		db, err = badger.Open(badgerOpts)
+		return err
+	})
+	if err != nil {
+		releasePath(claimedPath)
+		return nil, badgerOpts, "", err
+	}
+	return db, badgerOpts, claimedPath, nil
+}
+
+// Close is used to gracefully close the DB connection. It stops background
+// work, then fails new operations with ErrStoreClosed and waits up to
+// Options.CloseTimeout for those in flight before closing Badger. Closing a
+// closed store does nothing.
 func (b *BadgerStore) Close() error {
-	return b.db.Close()
+	b.stopBackground()
+	if closed, err := b.ops.drain(b.closeTimeout); closed || err != nil {
+		return err
+	}
+	if !b.ops.markClosed() {
+		return nil
+	}
+	defer releasePath(b.claimedPath)
+	return b.closeDB(b.compactOnClose)
+}
+
+// stopBackground stops the store's background work, once. Work a worker
+// has begun goes through the store's operations, so it has to stop before
+// they are turned away.
+func (b *BadgerStore) stopBackground() {
+	b.stopOnce.Do(func() {
+		if b.attach != nil {
+			b.attach.close()
+		}
+		if b.disk != nil {
+			b.disk.close()
+		}
+		b.stopBackups()
+		b.stopLogAgeMetrics()
+		b.stopRetention()
+		b.stopKeyRefresh()
+		b.stopValueLogGC()
+		b.stopAsyncDeletes()
+	})
+}
+
+// closeDB stops background work, garbage collects the value log for up to
+// compactBudget and closes Badger, leaving the path claimed.
+func (b *BadgerStore) closeDB(compactBudget time.Duration) error {
+	b.stopBackground()
+	var gcErr error
+	if compactBudget > 0 {
+		_, gcErr = b.runValueLogGC("close", closeGCDiscardRatio, time.Now().Add(compactBudget))
+	}
+	syncErr := b.stopSyncer()
+	if b.mirror != nil {
+		if err := b.mirror.close(); err != nil {
+			b.db.Close()
+			return err
+		}
+	}
+	var stableErr error
+	if b.separateStable() {
+		stableErr = b.stableDB.Close()
+	}
+	if b.sharedDB {
+		releaseNamespace(b.db, b.keys)
+		return syncErr
+	}
+	if err := b.db.Close(); err != nil {
+		return err
+	}
+	if stableErr != nil {
+		return stableErr
+	}
+	if gcErr != nil {
+		return gcErr
+	}
+	return syncErr
+}
+
+// badgerDir returns the directory Badger keeps its files in for a store
+// opened at path.
+func badgerDir(path string) string {
+	return filepath.Join(path, "badger")
+}
+
+// Path returns the directory the store was opened with. Badger's own files
+// live in a subdirectory of it.
+func (b *BadgerStore) Path() string {
+	return b.path
+}
+
+// DB returns the underlying Badger database for custom queries and
+// maintenance.
+//
+// This is an advanced, unsafe escape hatch: the store owns every key under
+// ReservedPrefixes and keeps no guarantees if they are modified behind its
+// back, and the handle must not be closed directly.
+func (b *BadgerStore) DB() *badger.DB {
+	return b.db
+}
+
+// LogsPrefix returns the key prefix raft log entries are stored under.
+func (b *BadgerStore) LogsPrefix() []byte {
+	return append([]byte(nil), b.keys.logs...)
+}
+
+// ConfPrefix returns the key prefix StableStore values are stored under.
+func (b *BadgerStore) ConfPrefix() []byte {
+	return append([]byte(nil), b.keys.conf...)
+}
+
+// ReservedPrefixes returns every key prefix owned by the store.
+func (b *BadgerStore) ReservedPrefixes() [][]byte {
+	return b.keys.reserved()
+}
+
+// IsReservedKey reports whether key falls under one of the store's reserved
+// prefixes.
+func (b *BadgerStore) IsReservedKey(key []byte) bool {
+	for _, prefix := range b.ReservedPrefixes() {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 func bytesToUint64(b []byte) uint64 {
@@ -80,21 +1076,18 @@ func uint64ToBytes(u uint64) []byte {
 
 // FirstIndex returns the first known index from the Raft log.
 func (b *BadgerStore) FirstIndex() (uint64, error) {
-	first := uint64(0)
-	err := b.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		it.Seek(dbLogsPrefix)
-		if it.ValidForPrefix(dbLogsPrefix) {
-			item := it.Item()
-			k := string(item.Key()[len(dbLogsPrefix):])
-			idx, err := strconv.ParseUint(k, 10, 64)
-			if err != nil {
-				return err
-			}
-			first = idx
-		}
-		return nil
+	return b.FirstIndexContext(context.Background())
+}
+
+// FirstIndexContext is like FirstIndex, but gives up once ctx is done.
+func (b *BadgerStore) FirstIndexContext(ctx context.Context) (uint64, error) {
+	defer b.finishOp(opFirstIndex, time.Now(), 0)
+	var first uint64
+	err := b.run(ctx, func(ctx context.Context) error {
+		return b.readRetry.doContext(ctx, b.metrics, b.logger, retryRead, func() (err error) {
+			first, err = b.firstIndex()
+			return err
+		})
 	})
 	if err != nil {
 		return 0, err
@@ -102,156 +1095,718 @@ func (b *BadgerStore) FirstIndex() (uint64, error) {
 	return first, nil
 }
 
+func (b *BadgerStore) firstIndex() (uint64, error) {
+	first, _, err := b.logBounds()
+	return first, err
+}
+
+// logBounds returns the first and last index, seeking them out unless they
+// are known already. An empty log has both at 0.
+func (b *BadgerStore) logBounds() (first, last uint64, err error) {
+	first, last, ok, gen := b.bounds.get()
+	if ok {
+		return first, last, nil
+	}
+	if first, err = b.seekFirstIndex(); err != nil {
+		return 0, 0, err
+	}
+	if last, err = b.seekLastIndex(); err != nil {
+		return 0, 0, err
+	}
+	b.bounds.set(first, last, gen)
+	return first, last, nil
+}
+
+// seekFirstIndex finds the first index with an iterator.
+func (b *BadgerStore) seekFirstIndex() (first uint64, err error) {
+	err = b.db.View(func(txn *badger.Txn) error {
+		first, err = b.firstIndexIn(txn)
+		return err
+	})
+	return first, err
+}
+
+// firstIndexIn finds the first index as txn sees it. In a read-write txn,
+// the key it lands on counts as read for Badger's conflict detection.
+func (b *BadgerStore) firstIndexIn(txn *badger.Txn) (uint64, error) {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(b.keys.logs); it.ValidForPrefix(b.keys.logs); {
+		idx, err := b.keys.parseLogKey(it.Item().Key())
+		if err != nil {
+			return 0, err
+		}
+		if p, ok := b.pendingDeleteFor(idx); ok {
+			it.Seek(b.keys.logKey(p.max + 1))
+			continue
+		}
+		return idx, nil
+	}
+	return 0, nil
+}
+
 // LastIndex returns the last known index from the Raft log.
 func (b *BadgerStore) LastIndex() (uint64, error) {
-	last := uint64(0)
-	if err := b.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Reverse = true
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		// ensure reverse seeking will include the
-		// see https://github.com/dgraph-io/badger/issues/436 and
-		// https://github.com/dgraph-io/badger/issues/347
-		seekKey := append(dbLogsPrefix, 0xFF)
-		it.Seek(seekKey)
-		if it.ValidForPrefix(dbLogsPrefix) {
-			item := it.Item()
-			k := string(item.Key()[len(dbLogsPrefix):])
-			idx, err := strconv.ParseUint(k, 10, 64)
-			if err != nil {
-				return err
-			}
-			last = idx
-		}
-		return nil
-	}); err != nil {
+	return b.LastIndexContext(context.Background())
+}
+
+// LastIndexContext is like LastIndex, but gives up once ctx is done.
+func (b *BadgerStore) LastIndexContext(ctx context.Context) (uint64, error) {
+	defer b.finishOp(opLastIndex, time.Now(), 0)
+	var last uint64
+	err := b.run(ctx, func(ctx context.Context) error {
+		return b.readRetry.doContext(ctx, b.metrics, b.logger, retryRead, func() (err error) {
+			last, err = b.lastIndex()
+			return err
+		})
+	})
+	if err != nil {
 		return 0, err
 	}
 	return last, nil
 }
 
+func (b *BadgerStore) lastIndex() (uint64, error) {
+	_, last, err := b.logBounds()
+	return last, err
+}
+
+// seekLastIndex finds the last index with a reverse iterator.
+func (b *BadgerStore) seekLastIndex() (last uint64, err error) {
+	err = b.db.View(func(txn *badger.Txn) error {
+		last, err = b.lastIndexIn(txn)
+		return err
+	})
+	return last, err
+}
+
+// lastIndexIn finds the last index as txn sees it, like firstIndexIn.
+func (b *BadgerStore) lastIndexIn(txn *badger.Txn) (uint64, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	// Reverse seeking lands on the largest key at or before the seek key,
+	// see https://github.com/dgraph-io/badger/issues/436 and
+	// https://github.com/dgraph-io/badger/issues/347
+	for it.Seek(b.keys.logKey(math.MaxUint64)); it.ValidForPrefix(b.keys.logs); {
+		idx, err := b.keys.parseLogKey(it.Item().Key())
+		if err != nil {
+			return 0, err
+		}
+		if p, ok := b.pendingDeleteFor(idx); ok {
+			if p.min == 0 {
+				break
+			}
+			it.Seek(b.keys.logKey(p.min - 1))
+			continue
+		}
+		return idx, nil
+	}
+	return 0, nil
+}
+
 // GetLog is used to retrieve a log from Badger at a given index.
 func (b *BadgerStore) GetLog(idx uint64, log *raft.Log) error {
+	return b.GetLogContext(context.Background(), idx, log)
+}
+
+// GetLogContext is like GetLog, but gives up once ctx is done, leaving log
+// untouched.
+func (b *BadgerStore) GetLogContext(ctx context.Context, idx uint64, log *raft.Log) error {
+	defer b.metrics.measureSince([]string{"getLog"}, time.Now())
+	defer b.finishOp(opGetLog, time.Now(), 1)
+	span := b.startSpan(ctx, "GetLog", attrIndex.Int64(int64(idx)))
+	dst := log
+	if ctx.Done() != nil {
+		// An abandoned read must not decode into the caller's log
+		dst = new(raft.Log)
+	}
+	err := b.run(ctx, func(ctx context.Context) error {
+		return b.readRetry.doContext(ctx, b.metrics, b.logger, retryRead, func() error {
+			return b.getLog(idx, dst)
+		})
+	})
+	if err == nil {
+		if dst != log {
+			*log = *dst
+		}
+		span.SetAttributes(attrBytes.Int(len(log.Data)))
+	}
+	endSpan(span, err)
+	return err
+}
+
+func (b *BadgerStore) getLog(idx uint64, log *raft.Log) error {
+	if b.isPendingDelete(idx) {
+		return raft.ErrLogNotFound
+	}
+	if b.cache.get(idx, log) {
+		return nil
+	}
 	return b.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(fmt.Sprintf("%s%d", dbLogsPrefix, idx)))
-		if item == nil {
+		item, err := txnGet(txn, b.keys.logKey(idx))
+		if err == badger.ErrKeyNotFound {
 			return raft.ErrLogNotFound
 		}
+		if err != nil {
+			return err
+		}
 		v, err := item.Value()
 		if err != nil {
 			return err
 		}
-		buf := bytes.NewBuffer(v)
-		dec := gob.NewDecoder(buf)
-		return dec.Decode(&log)
+		return b.logDecodeError(idx, b.decodeLog(txn, idx, v, log))
 	})
 }
 
+// GetLogs reads the contiguous range of log entries from min to max,
+// inclusively, into out within a single read transaction, stopping early
+// once out is full. Nil elements of out are allocated. It returns the
+// number of entries read; a missing entry ends the read with an error
+// wrapping raft.ErrLogNotFound.
+func (b *BadgerStore) GetLogs(min, max uint64, out []*raft.Log) (int, error) {
+	return b.GetLogsContext(context.Background(), min, max, out)
+}
+
+// GetLogsContext is like GetLogs, but stops reading once ctx is done. It
+// then returns ctx's error and leaves out untouched.
+func (b *BadgerStore) GetLogsContext(ctx context.Context, min, max uint64, out []*raft.Log) (int, error) {
+	defer b.finishOp(opGetLogs, time.Now(), uint64(len(out)))
+	dst := out
+	copied := ctx.Done() != nil
+	if copied {
+		// An abandoned read must not decode into the caller's logs
+		dst = make([]*raft.Log, len(out))
+	}
+	var n int
+	err := b.run(ctx, func(ctx context.Context) error {
+		return b.readRetry.doContext(ctx, b.metrics, b.logger, retryRead, func() error {
+			var err error
+			n, err = b.getLogs(ctx, min, max, dst)
+			return err
+		})
+	})
+	if err != nil && err == ctx.Err() {
+		return 0, err
+	}
+	if copied {
+		for i := 0; i < n; i++ {
+			if out[i] == nil {
+				out[i] = dst[i]
+			} else {
+				*out[i] = *dst[i]
+			}
+		}
+	}
+	return n, err
+}
+
+func (b *BadgerStore) getLogs(ctx context.Context, min, max uint64, out []*raft.Log) (int, error) {
+	if min > max || len(out) == 0 {
+		return 0, nil
+	}
+	count := len(out)
+	if max-min < uint64(count) {
+		count = int(max-min) + 1
+	}
+	n := 0
+	err := b.db.View(func(txn *badger.Txn) error {
+		for ; n < count; n++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			idx := min + uint64(n)
+			if out[n] == nil {
+				out[n] = new(raft.Log)
+			}
+			if !b.isPendingDelete(idx) && b.cache.get(idx, out[n]) {
+				continue
+			}
+			item, err := txn.Get(b.keys.logKey(idx))
+			if err == nil && b.isPendingDelete(idx) {
+				err = badger.ErrKeyNotFound
+			}
+			if err == badger.ErrKeyNotFound {
+				return fmt.Errorf("log %d: %w", idx, raft.ErrLogNotFound)
+			}
+			if err != nil {
+				return err
+			}
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			if err := b.decodeLog(txn, idx, v, out[n]); err != nil {
+				return b.logDecodeError(idx, err)
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// LogRangeReader is implemented by log stores that read a contiguous range
+// of entries at once, like BadgerStore and the stores wrapping it.
+type LogRangeReader interface {
+	GetLogs(min, max uint64, out []*raft.Log) (int, error)
+}
+
+// ReadLogs reads the entries from min to max into out like
+// BadgerStore.GetLogs, using GetLogs if store implements LogRangeReader
+// and calling GetLog for each entry otherwise.
+func ReadLogs(store raft.LogStore, min, max uint64, out []*raft.Log) (int, error) {
+	if r, ok := store.(LogRangeReader); ok {
+		return r.GetLogs(min, max, out)
+	}
+	n := 0
+	for idx := min; idx <= max && n < len(out); idx++ {
+		if out[n] == nil {
+			out[n] = new(raft.Log)
+		}
+		if err := store.GetLog(idx, out[n]); err != nil {
+			return n, err
+		}
+		n++
+		if idx == math.MaxUint64 {
+			break
+		}
+	}
+	return n, nil
+}
+
 // StoreLog is used to store a single raft log
 func (b *BadgerStore) StoreLog(log *raft.Log) error {
 	return b.StoreLogs([]*raft.Log{log})
 }
 
+// StoreLogContext is like StoreLog, but gives up once ctx is done.
+func (b *BadgerStore) StoreLogContext(ctx context.Context, log *raft.Log) error {
+	return b.StoreLogsContext(ctx, []*raft.Log{log})
+}
+
 // StoreLogs is used to store a set of raft logs
 func (b *BadgerStore) StoreLogs(logs []*raft.Log) error {
-	maxBatchSize := b.db.MaxBatchSize()
-	min := uint64(0)
-	max := uint64(len(logs))
-	ranges := b.generateRanges(min, max, maxBatchSize)
-	for _, r := range ranges {
-		txn := b.db.NewTransaction(true)
-		defer txn.Discard()
-		for index := r.from; index < r.to; index++ {
-			log := logs[index]
-			key := []byte(fmt.Sprintf("%s%d", dbLogsPrefix, log.Index))
-			var out bytes.Buffer
-			enc := gob.NewEncoder(&out)
-			enc.Encode(log)
-			if err := txn.Set(key, out.Bytes()); err != nil {
-				return err
+	return b.StoreLogsContext(context.Background(), logs)
+}
+
+// StoreLogsContext is like StoreLogs, but gives up once ctx is done.
+// Transactions committed by then stay, and the one being committed may
+// still land, so check LastIndex before storing the entries again. The
+// entries must not be modified until they are, or LastIndex shows they
+// weren't.
+func (b *BadgerStore) StoreLogsContext(ctx context.Context, logs []*raft.Log) error {
+	if b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := b.checkEntrySizes(logs); err != nil {
+		return err
+	}
+	start := time.Now()
+	defer b.finishOp(opStoreLogs, start, uint64(len(logs)))
+	span := b.startSpan(ctx, "StoreLogs", b.logsAttributes(logs)...)
+	err := b.run(ctx, func(ctx context.Context) error {
+		return b.doWrite(ctx, func() error {
+			if b.groupCommit != nil {
+				return b.groupCommit.storeLogs(b, logs)
+			}
+			return b.storeLogs(ctx, logs)
+		})
+	})
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	// Named like raft-boltdb's, so dashboards carry over
+	b.metrics.measureSince([]string{"storeLogs"}, start)
+	b.metrics.addSample([]string{"writeCapacity"}, float32(len(logs))/float32(time.Since(start).Seconds()))
+	return nil
+}
+
+func (b *BadgerStore) storeLogs(ctx context.Context, logs []*raft.Log) error {
+	if err := b.awaitPendingDeletes(logs); err != nil {
+		return err
+	}
+	if b.batchBytes > 0 && !b.atomicStoreLogs {
+		lb := b.newLogBatch(0)
+		lb.fail(lb.Append(logs...))
+		return lb.Flush()
+	}
+	limit := len(logs)
+	for len(logs) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := limit
+		if n > len(logs) {
+			n = len(logs)
+		}
+		err := b.storeBatch(logs[:n])
+		// Batches too big for one transaction are committed in halves, in
+		// index order, unless they have to be all or nothing
+		if err == badger.ErrTxnTooBig {
+			if b.atomicStoreLogs || n == 1 {
+				return fmt.Errorf("batch of %d entries: %w", n, err)
 			}
+			limit = n / 2
+			continue
 		}
-		if err := txn.Commit(nil); err != nil {
+		if err != nil {
 			return err
 		}
+		logs = logs[n:]
+	}
+	return nil
+}
+
+// awaitPendingDeletes waits for queued deletions of logs' index range.
+// Rewriting entries a queued DeleteRange still covers, as raft does when
+// it truncates a conflicting suffix, has to wait for the deletion so it
+// neither hides nor removes the new entries.
+func (b *BadgerStore) awaitPendingDeletes(logs []*raft.Log) error {
+	if len(logs) > 0 && b.overlapsPendingDelete(logs[0].Index, logs[len(logs)-1].Index) {
+		return b.flushDeletes()
 	}
 	return nil
 }
 
-type iteratorRange struct{ from, to uint64 }
+// storeBatch writes logs in a single transaction.
+func (b *BadgerStore) storeBatch(logs []*raft.Log) error {
+	txn, stored, err := b.prepareBatch(logs, true)
+	if err != nil {
+		return err
+	}
+	defer txn.Discard()
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	return stored()
+}
+
+// prepareBatch writes logs to a transaction for the caller to commit and
+// returns stored, to be called once it has committed. With check, logs have
+// to follow the last entry, see checkAppend. The transaction is discarded
+// if it fails.
+func (b *BadgerStore) prepareBatch(logs []*raft.Log, check bool) (_ *writeTxn, stored func() error, err error) {
+	txn := b.newWriteTxn()
+	if check {
+		if err := b.checkAppend(txn, logs); err != nil {
+			txn.Discard()
+			return nil, nil, err
+		}
+	}
+	stored, err = b.writeLogs(txn, logs)
+	if err != nil {
+		txn.Discard()
+		return nil, nil, err
+	}
+	return txn, stored, nil
+}
 
-func (b *BadgerStore) generateRanges(min, max uint64, batchSize int64) []iteratorRange {
-	nSegments := int(math.Round(float64((max - min) / uint64(batchSize))))
-	segments := []iteratorRange{}
-	if (max - min) <= uint64(batchSize) {
-		segments = append(segments, iteratorRange{from: min, to: max})
-		return segments
+// writeLogs writes logs to txn and returns stored, to be called once txn
+// has committed.
+func (b *BadgerStore) writeLogs(txn *writeTxn, logs []*raft.Log) (stored func() error, err error) {
+	var written []writtenValue
+	refs := newBlobRefs()
+	if err := b.markAppendTime(txn, logs[0].Index); err != nil {
+		return nil, err
+	}
+	min, max := logs[0].Index, logs[0].Index
+	size := 0
+	for _, log := range logs {
+		key := b.keys.logKey(log.Index)
+		val, err := b.encodeDedupedLog(txn, key, log, refs)
+		if err != nil {
+			return nil, err
+		}
+		if err := txn.Set(key, val); err != nil {
+			return nil, err
+		}
+		size += len(val)
+		b.metrics.addSample([]string{"logSize"}, float32(len(val)))
+		if b.verifyWrites {
+			written = append(written, newWrittenValue(log.Index, key, val))
+		}
+		if log.Index < min {
+			min = log.Index
+		}
+		if log.Index > max {
+			max = log.Index
+		}
 	}
-	for len(segments) < nSegments {
-		nextMin := min + uint64(batchSize)
-		segments = append(segments, iteratorRange{from: min, to: nextMin})
-		min = nextMin + 1
+	if err := refs.apply(txn); err != nil {
+		return nil, err
 	}
-	segments = append(segments, iteratorRange{from: min, to: max})
-	return segments
+	return func() error {
+		b.cache.stored(logs)
+		b.bounds.stored(min, max)
+		b.appended.notify()
+		b.prom.wrote(len(logs), size)
+		b.metrics.addSample([]string{"logsPerBatch"}, float32(len(logs)))
+		b.metrics.addSample([]string{"logBatchSize"}, float32(size))
+		if err := b.verifyWritten(written); err != nil {
+			return err
+		}
+		if b.hooks.LogsStored != nil {
+			b.hooks.LogsStored(logs)
+		}
+		return nil
+	}, nil
 }
 
 // DeleteRange is used to delete logs within a given range inclusively.
 func (b *BadgerStore) DeleteRange(min, max uint64) error {
-	maxBatchSize := b.db.MaxBatchSize()
-	ranges := b.generateRanges(min, max, maxBatchSize)
-	for _, r := range ranges {
-		txn := b.db.NewTransaction(true)
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer txn.Discard()
-
-		it.Rewind()
-		// Get the key to start at
-		minKey := []byte(fmt.Sprintf("%s%d", dbLogsPrefix, r.from))
-		for it.Seek(minKey); it.ValidForPrefix(dbLogsPrefix); it.Next() {
-			item := it.Item()
-			// get the index as a string to convert to uint64
-			k := string(item.Key()[len(dbLogsPrefix):])
-			idx, err := strconv.ParseUint(k, 10, 64)
-			if err != nil {
+	return b.DeleteRangeContext(context.Background(), min, max)
+}
+
+// DeleteRangeContext is like DeleteRange, but stops deleting once ctx is
+// done, discarding the transaction in progress. Entries deleted by then
+// stay deleted; call it again to delete the rest.
+func (b *BadgerStore) DeleteRangeContext(ctx context.Context, min, max uint64) (err error) {
+	if b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer b.finishOp(opDeleteRange, time.Now(), max-min+1)
+	span := b.startSpan(ctx, "DeleteRange", attrFirstIndex.Int64(int64(min)), attrLastIndex.Int64(int64(max)))
+	defer func() { endSpan(span, err) }()
+	// Done again once deleted, in case a read in between cached the log
+	// from before
+	b.cache.removeRange(min, max)
+	b.bounds.deleted(min, max)
+	defer func() {
+		b.cache.removeRange(min, max)
+		b.bounds.deleted(min, max)
+	}()
+	if b.asyncDeletes {
+		err := b.run(ctx, func(context.Context) error {
+			return b.deleteRangeAsync(min, max)
+		})
+		if err != nil {
+			b.deleteFailed(min, max)
+			return err
+		}
+		b.rangeDeleted(min, max)
+		return nil
+	}
+	report := b.startCompaction("delete-range", false)
+	var removed uint64
+	err = b.run(ctx, func(ctx context.Context) error {
+		err := b.doWrite(ctx, func() (err error) {
+			// Entries a failed attempt deleted are gone, so count across
+			// attempts
+			n, err := b.deleteRange(ctx, min, max)
+			removed += n
+			return err
+		})
+		if err != nil {
+			b.deleteFailed(min, max)
+		}
+		return err
+	})
+	if err != nil {
+		// An abandoned delete calls deleteFailed again once it stops
+		b.deleteFailed(min, max)
+		return err
+	}
+	report.EntriesRemoved = removed
+	b.finishCompaction(report)
+	b.rangeDeleted(min, max)
+	return nil
+}
+
+// deleteFailed forgets what DeleteRange assumed about the range from min to
+// max, as part of it may be left and reads may have cached entries deleted
+// since.
+func (b *BadgerStore) deleteFailed(min, max uint64) {
+	b.cache.removeRange(min, max)
+	b.bounds.invalidate()
+}
+
+// rangeDeleted calls the RangeDeleted hook.
+func (b *BadgerStore) rangeDeleted(min, max uint64) {
+	if b.hooks.RangeDeleted != nil {
+		b.hooks.RangeDeleted(min, max)
+	}
+}
+
+func (b *BadgerStore) deleteRange(ctx context.Context, min, max uint64) (uint64, error) {
+	removed := uint64(0)
+	limit := deleteBatchSize
+	next := min
+	for {
+		n, last, done, err := b.deleteBatch(ctx, next, max, limit)
+		// A batch that doesn't fit into a transaction is retried in halves,
+		// since entries kept in the trash take their values along
+		if err == badger.ErrTxnTooBig && limit > 1 {
+			limit /= 2
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+		if done {
+			break
+		}
+		next = last + 1
+	}
+	return removed, b.pruneAppendTimes(min, max)
+}
+
+// deleteBatch deletes up to limit entries with indexes from min to max in
+// one transaction. It returns the number deleted, the index of the last
+// one and whether there are none left in the range.
+func (b *BadgerStore) deleteBatch(ctx context.Context, min, max uint64, limit int) (removed, last uint64, done bool, err error) {
+	txn := b.newWriteTxn()
+	defer txn.Discard()
+	if removed, last, done, err = b.deleteLogs(ctx, txn, min, max, limit); err != nil {
+		return 0, 0, false, err
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, 0, false, err
+	}
+	return removed, last, done, nil
+}
+
+// deleteLogs deletes up to limit entries with indexes from min to max
+// within txn, like deleteBatch, or all of them if limit is 0.
+func (b *BadgerStore) deleteLogs(ctx context.Context, txn *writeTxn, min, max uint64, limit int) (removed, last uint64, done bool, err error) {
+	refs := newBlobRefs()
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = b.trashGrace > 0 || b.dedupMinSize > 0
+	it := txn.NewIterator(opts)
+	done = true
+	for it.Seek(b.keys.logKey(min)); it.ValidForPrefix(b.keys.logs); it.Next() {
+		if err := ctx.Err(); err != nil {
+			it.Close()
+			return 0, 0, false, err
+		}
+		idx, err := b.keys.parseLogKey(it.Item().Key())
+		if err != nil {
+			it.Close()
+			return 0, 0, false, err
+		}
+		if idx > max {
+			break
+		}
+		if limit > 0 && removed == uint64(limit) {
+			done = false
+			break
+		}
+		// Delete in-range index, keeping a copy in the trash when soft
+		// deleting
+		if b.trashGrace > 0 {
+			if err := b.trashEntry(txn, idx, it.Item()); err != nil {
 				it.Close()
-				return err
+				return 0, 0, false, err
 			}
-			// Handle out-of-range index
-			if idx > r.to {
-				break
+		}
+		if b.dedupMinSize > 0 {
+			v, err := it.Item().Value()
+			if err == nil {
+				err = b.releaseBlob(idx, v, refs)
 			}
-			// Delete in-range index
-			delKey := []byte(fmt.Sprintf("%s%d", dbLogsPrefix, idx))
-			if err := txn.Delete(delKey); err != nil {
+			if err != nil {
 				it.Close()
-				return err
+				return 0, 0, false, err
 			}
 		}
-		it.Close()
-		if err := txn.Commit(nil); err != nil {
-			return err
+		if err := txn.Delete(b.keys.logKey(idx)); err != nil {
+			it.Close()
+			return 0, 0, false, err
 		}
+		last = idx
+		removed++
 	}
-	return nil
+	it.Close()
+	if err := refs.apply(txn); err != nil {
+		return 0, 0, false, err
+	}
+	return removed, last, done, nil
 }
 
 // Set is used to set a key/value set outside of the raft log
 func (b *BadgerStore) Set(k, v []byte) error {
-	return b.db.Update(func(txn *badger.Txn) error {
-		key := []byte(fmt.Sprintf("%s%d", dbConfPrefix, k))
-		return txn.Set(key, v)
+	return b.SetContext(context.Background(), k, v)
+}
+
+// SetContext is like Set, but gives up once ctx is done, in which case the
+// value may or may not have been stored.
+func (b *BadgerStore) SetContext(ctx context.Context, k, v []byte) error {
+	if b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	defer b.metrics.measureSince([]string{"set"}, time.Now())
+	defer b.finishOp(opSet, time.Now(), 0)
+	span := b.startSpan(ctx, "Set", attrKey.String(string(k)), attrBytes.Int(len(v)))
+	err := b.run(ctx, func(ctx context.Context) error {
+		return b.doWrite(ctx, func() error {
+			return b.set(k, v)
+		})
 	})
+	endSpan(span, err)
+	if err == nil {
+		b.stableKeySet(k)
+	}
+	return err
+}
+
+// stableKeySet calls the StableKeySet hook.
+func (b *BadgerStore) stableKeySet(k []byte) {
+	if b.hooks.StableKeySet != nil {
+		b.hooks.StableKeySet(k)
+	}
+}
+
+func (b *BadgerStore) set(k, v []byte) error {
+	var err error
+	if b.stableWrites != nil {
+		err = b.stableWrites.set(b.keys.confKey(k), v)
+	} else {
+		err = b.updateStable(func(txn *writeTxn) error {
+			return txn.Set(b.keys.confKey(k), v)
+		})
+	}
+	if err == nil {
+		b.prom.wrote(0, len(v))
+	}
+	return err
 }
 
 // Get is used to retrieve a value from the k/v store by key
 func (b *BadgerStore) Get(k []byte) ([]byte, error) {
-	txn := b.db.NewTransaction(false)
+	return b.GetContext(context.Background(), k)
+}
+
+// GetContext is like Get, but gives up once ctx is done.
+func (b *BadgerStore) GetContext(ctx context.Context, k []byte) ([]byte, error) {
+	defer b.metrics.measureSince([]string{"get"}, time.Now())
+	defer b.finishOp(opGet, time.Now(), 0)
+	span := b.startSpan(ctx, "Get", attrKey.String(string(k)))
+	var v []byte
+	err := b.run(ctx, func(ctx context.Context) error {
+		return b.readRetry.doContext(ctx, b.metrics, b.logger, retryRead, func() (err error) {
+			v, err = b.get(k)
+			return err
+		})
+	})
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attrBytes.Int(len(v)))
+	endSpan(span, nil)
+	return v, nil
+}
+
+func (b *BadgerStore) get(k []byte) ([]byte, error) {
+	txn := b.stableDB.NewTransaction(false)
 	defer txn.Discard()
-	key := []byte(fmt.Sprintf("%s%d", dbConfPrefix, k))
-	item, err := txn.Get(key)
-	if item == nil {
+	item, err := txnGet(txn, b.keys.confKey(k))
+	if err == badger.ErrKeyNotFound {
 		return nil, ErrKeyNotFound
 	}
 	if err != nil {
@@ -267,16 +1822,161 @@ func (b *BadgerStore) Get(k []byte) ([]byte, error) {
 	return append([]byte(nil), v...), nil
 }
 
-// SetUint64 is like Set, but handles uint64 values
+// SetUint64 is like Set, but handles uint64 values. Keys listed in
+// Options.MonotonicKeys refuse to move backwards and return ErrUint64Rollback.
 func (b *BadgerStore) SetUint64(key []byte, val uint64) error {
-	return b.Set(key, uint64ToBytes(val))
+	return b.SetUint64Context(context.Background(), key, val)
+}
+
+// SetUint64Context is like SetUint64, but gives up once ctx is done, in
+// which case the value may or may not have been stored.
+func (b *BadgerStore) SetUint64Context(ctx context.Context, key []byte, val uint64) error {
+	if b.monotonicKeys[string(key)] {
+		_, err := b.setUint64IfGreater(ctx, key, val, true)
+		return err
+	}
+	return b.SetContext(ctx, key, uint64ToBytes(val))
+}
+
+// SetUint64IfGreater atomically stores val under key if the key is unset or
+// currently holds a smaller value, so term-like counters can never be rolled
+// backwards. It reports whether val was written. A concurrent writer of key
+// makes it compare again against what was written, up to
+// Options.ConflictRetries times, after which it fails with
+// badger.ErrConflict.
+func (b *BadgerStore) SetUint64IfGreater(key []byte, val uint64) (bool, error) {
+	return b.setUint64IfGreater(context.Background(), key, val, false)
+}
+
+// setUint64IfGreater implements SetUint64IfGreater. With strict set, an equal
+// value counts as success and a smaller one is an ErrUint64Rollback error.
+func (b *BadgerStore) setUint64IfGreater(ctx context.Context, key []byte, val uint64, strict bool) (bool, error) {
+	if b.badgerOpts.ReadOnly {
+		return false, ErrReadOnly
+	}
+	defer b.metrics.measureSince([]string{"set"}, time.Now())
+	var written bool
+	err := b.run(ctx, func(ctx context.Context) error {
+		// Another writer getting in between the read and the commit makes
+		// it conflict, and the retry compare against what it wrote
+		return b.doWrite(ctx, func() (err error) {
+			written, err = b.trySetUint64IfGreater(key, val, strict)
+			return err
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	if written {
+		b.stableKeySet(key)
+	}
+	return written, nil
+}
+
+func (b *BadgerStore) trySetUint64IfGreater(key []byte, val uint64, strict bool) (bool, error) {
+	written := false
+	err := b.updateStable(func(txn *writeTxn) error {
+		k := b.keys.confKey(key)
+		item, err := txn.Get(k)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			current := bytesToUint64(v)
+			if val == current {
+				return nil
+			}
+			if val < current {
+				if strict {
+					return fmt.Errorf("%w: %q is %d, refusing to set %d", ErrUint64Rollback, key, current, val)
+				}
+				return nil
+			}
+		}
+		written = true
+		return txn.Set(k, uint64ToBytes(val))
+	})
+	return written, err
 }
 
 // GetUint64 is like Get, but handles uint64 values
 func (b *BadgerStore) GetUint64(key []byte) (uint64, error) {
-	val, err := b.Get(key)
+	return b.GetUint64Context(context.Background(), key)
+}
+
+// GetUint64Context is like GetUint64, but gives up once ctx is done.
+func (b *BadgerStore) GetUint64Context(ctx context.Context, key []byte) (uint64, error) {
+	val, err := b.GetContext(ctx, key)
 	if err != nil {
 		return 0, err
 	}
 	return bytesToUint64(val), nil
 }
+
+// StableKeys returns every key set in the stable store, sorted.
+func (b *BadgerStore) StableKeys() ([][]byte, error) {
+	return b.Keys(nil)
+}
+
+// Keys returns the stable store keys starting with prefix, sorted. Only
+// keys are read, not their values.
+func (b *BadgerStore) Keys(prefix []byte) ([][]byte, error) {
+	var keys [][]byte
+	start := b.keys.confKey(prefix)
+	err := b.run(context.Background(), func(context.Context) error {
+		return b.stableDB.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Seek(start); it.ValidForPrefix(start); it.Next() {
+				k, err := b.keys.parseConfKey(it.Item().Key())
+				if err != nil {
+					return err
+				}
+				keys = append(keys, k)
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// ForEach calls fn for every stable store key and its value, in key order,
+// within a single read transaction. The value passed to fn must not be
+// retained after fn returns. Returning ErrStopScan from fn ends the
+// iteration early.
+func (b *BadgerStore) ForEach(fn func(k, v []byte) error) error {
+	err := b.run(context.Background(), func(context.Context) error {
+		return b.forEach(fn)
+	})
+	if err == ErrStopScan {
+		return nil
+	}
+	return err
+}
+
+func (b *BadgerStore) forEach(fn func(k, v []byte) error) error {
+	return b.stableDB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(b.keys.conf); it.ValidForPrefix(b.keys.conf); it.Next() {
+			k, err := b.keys.parseConfKey(it.Item().Key())
+			if err != nil {
+				return err
+			}
+			v, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -2,12 +2,16 @@ package raftbadgerdb
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/dgraph-io/badger"
 	"github.com/hashicorp/raft"
 )
 
@@ -68,6 +72,46 @@ func TestNewBadgerStore(t *testing.T) {
 	}
 }
 
+func TestBadgerStore_DB(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if store.DB() != store.db {
+		t.Fatalf("expected the underlying badger db")
+	}
+	if err := store.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Every key the store wrote is reserved
+	err := store.DB().View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if !store.IsReservedKey(it.Item().Key()) {
+				t.Fatalf("key %q is not reserved", it.Item().Key())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if store.IsReservedKey([]byte("app/state")) {
+		t.Fatalf("application keys should not be reserved")
+	}
+
+	// Callers can't modify the store's prefixes
+	store.LogsPrefix()[0] = 'x'
+	if !bytes.Equal(store.LogsPrefix(), defaultKeys.logs) {
+		t.Fatalf("bad prefix: %q", store.LogsPrefix())
+	}
+}
+
 func TestBadgerStore_FirstIndex(t *testing.T) {
 	store := testBadgerStore(t)
 	defer store.Close()
@@ -169,6 +213,47 @@ func TestBadgerStore_GetLog(t *testing.T) {
 	}
 }
 
+func TestBadgerStore_GetLogs(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.Remove(store.path)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	out := make([]*raft.Log, 3)
+	n, err := store.GetLogs(2, 10, out)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n != 3 {
+		t.Fatalf("bad: %d", n)
+	}
+	for i, log := range out {
+		if log.Index != uint64(i+2) || string(log.Data) != fmt.Sprintf("log%d", i+2) {
+			t.Fatalf("bad: %#v", log)
+		}
+	}
+
+	// Stops at the first missing entry
+	out = make([]*raft.Log, 10)
+	n, err = store.GetLogs(4, 7, out)
+	if !errors.Is(err, raft.ErrLogNotFound) || n != 2 {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+
+	// Falls back to GetLog for stores without range reads
+	inmem := raft.NewInmemStore()
+	if err := inmem.StoreLogs([]*raft.Log{testRaftLog(1, "a"), testRaftLog(2, "b")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out = make([]*raft.Log, 2)
+	if n, err := ReadLogs(inmem, 1, 2, out); err != nil || n != 2 || string(out[1].Data) != "b" {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+	if n, err := ReadLogs(store, 5, 5, out); err != nil || n != 1 || string(out[0].Data) != "log5" {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+}
+
 func TestBadgerStore_SetLog(t *testing.T) {
 	store := testBadgerStore(t)
 	defer store.Close()
@@ -266,6 +351,77 @@ func TestBadgerStore_DeleteRange(t *testing.T) {
 	}
 }
 
+// testFillLogKeys writes n entries with empty values straight to Badger,
+// which is much faster than StoreLogs for building up a large log.
+func testFillLogKeys(t *testing.T, store *BadgerStore, n uint64) {
+	txn := store.db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+	for idx := uint64(1); idx <= n; idx++ {
+		err := txn.Set(defaultKeys.logKey(idx), nil)
+		if err == badger.ErrTxnTooBig {
+			if err := txn.Commit(nil); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			txn = store.db.NewTransaction(true)
+			err = txn.Set(defaultKeys.logKey(idx), nil)
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := txn.Commit(nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.bounds.invalidate()
+}
+
+func TestBadgerStore_DeleteRange_Large(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deletes millions of entries")
+	}
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	const n = 2000000
+	testFillLogKeys(t, store, n)
+	if err := store.DeleteRange(1, n-1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	first, err := store.FirstIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first != n || last != n {
+		t.Fatalf("bad: %d, %d", first, last)
+	}
+}
+
+func TestBadgerStore_DeleteRange_Trash(t *testing.T) {
+	// Kept in the trash along with their values, a full batch of these is
+	// too big for one transaction
+	store := testSmallTxnStore(t, Options{SoftDeleteGracePeriod: time.Hour})
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	logs := testLargeBatch()
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(1, uint64(len(logs))); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last, err := store.LastIndex(); err != nil || last != 0 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+	if n := testCountPrefix(t, store, defaultKeys.trash); n != len(logs) {
+		t.Fatalf("bad: %d entries in the trash", n)
+	}
+}
+
 func TestBadgerStore_Set_Get(t *testing.T) {
 	store := testBadgerStore(t)
 	defer store.Close()
@@ -320,54 +476,510 @@ func TestBadgerStore_SetUint64_GetUint64(t *testing.T) {
 	}
 }
 
-func TestGenerateRanges(t *testing.T) {
+// testSmallTxnStore opens a store with options whose Badger transactions
+// only take about 150 KiB, so tests can exceed them cheaply.
+func testSmallTxnStore(t *testing.T, options Options) *BadgerStore {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	badgerOpts := badger.DefaultOptions
+	badgerOpts.MaxTableSize = 1 << 20
+	options.Path = fh
+	options.BadgerOptions = &badgerOpts
+	store, err := New(options)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+// testLargeBatch returns more entries than fit into one transaction of a
+// testSmallTxnStore.
+func testLargeBatch() []*raft.Log {
+	data := string(bytes.Repeat([]byte("x"), 512))
+	var logs []*raft.Log
+	for idx := uint64(1); idx <= 1000; idx++ {
+		logs = append(logs, testRaftLog(idx, data))
+	}
+	return logs
+}
+
+func TestBadgerStore_StoreLogs_Split(t *testing.T) {
+	logs := testLargeBatch()
+	store := testSmallTxnStore(t, Options{})
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out := make([]*raft.Log, len(logs))
+	if n, err := store.GetLogs(1, uint64(len(logs)), out); err != nil || n != len(logs) {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+	if !reflect.DeepEqual(out, logs) {
+		t.Fatalf("stored logs differ")
+	}
+
+	atomic := testSmallTxnStore(t, Options{AtomicStoreLogs: true})
+	defer os.RemoveAll(atomic.path)
+	defer atomic.Close()
+	if err := atomic.StoreLogs(logs); !errors.Is(err, badger.ErrTxnTooBig) {
+		t.Fatalf("bad: %v", err)
+	}
+	if last, err := atomic.LastIndex(); err != nil || last != 0 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+	if err := atomic.StoreLogs(logs[:10]); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBadgerStore_SetUint64IfGreater(t *testing.T) {
 	store := testBadgerStore(t)
 	defer store.Close()
 	defer os.Remove(store.path)
-	maxSize := store.db.MaxBatchSize()
-	testCases := []struct {
-		desc     string
-		from     int
-		to       int64
-		expected int
-	}{
-		{
-			desc:     "Single batch, at max",
-			from:     0,
-			to:       maxSize,
-			expected: 1,
-		},
-		{
-			desc:     "Single batch, under max",
-			from:     1.0,
-			to:       10.0,
-			expected: 1,
-		},
-		{
-			desc:     "Multi batch, just above max",
-			from:     0.0,
-			to:       maxSize + 1,
-			expected: 2,
-		},
-		{
-			desc:     "Multi batch, well above max",
-			from:     0.0,
-			to:       2*maxSize + 1,
-			expected: 3,
-		},
+
+	k := []byte("CurrentTerm")
+
+	// Unset keys are always written
+	ok, err := store.SetUint64IfGreater(k, 5)
+	if err != nil {
+		t.Fatalf("err: %s", err)
 	}
-	for _, tC := range testCases {
-		t.Run(tC.desc, func(t *testing.T) {
-			ranges := store.generateRanges(uint64(tC.from), uint64(tC.to), maxSize)
-			from := ranges[0].from
-			to := ranges[len(ranges)-1].to
-			if from != uint64(tC.from) || to != uint64(tC.to) {
-				t.Log(ranges)
-				t.Fatalf("err: range not covered. wanted to %v from %v ranges, got to: %v, from %v", tC.from, tC.to, from, to)
-			}
-			if len(ranges) != tC.expected {
-				t.Fatalf("err: wanted %v ranges, got %v", tC.expected, len(ranges))
+	if !ok {
+		t.Fatalf("expected the first write to succeed")
+	}
+
+	// Smaller and equal values are ignored
+	for _, v := range []uint64{4, 5} {
+		ok, err = store.SetUint64IfGreater(k, v)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if ok {
+			t.Fatalf("did not expect %d to be written", v)
+		}
+	}
+
+	ok, err = store.SetUint64IfGreater(k, 6)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !ok {
+		t.Fatalf("expected a greater value to be written")
+	}
+	val, err := store.GetUint64(k)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if val != 6 {
+		t.Fatalf("bad: %v", val)
+	}
+}
+
+func TestBadgerStore_SetUint64IfGreaterConcurrent(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	// Without conflict retries, writers losing a race give up with
+	// badger.ErrConflict instead of retrying forever
+	store, err := New(Options{Path: fh, ConflictRetries: -1})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	k := []byte("CurrentTerm")
+	var mu sync.Mutex
+	var highest uint64
+	var wg sync.WaitGroup
+	errs := make(chan error, 8*50)
+	for w := uint64(0); w < 8; w++ {
+		wg.Add(1)
+		go func(w uint64) {
+			defer wg.Done()
+			for i := uint64(1); i <= 50; i++ {
+				v := i*8 + w
+				ok, err := store.SetUint64IfGreater(k, v)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				mu.Lock()
+				if ok && v > highest {
+					highest = v
+				}
+				mu.Unlock()
 			}
-		})
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != badger.ErrConflict {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if val, err := store.GetUint64(k); err != nil || val != highest {
+		t.Fatalf("bad: %d, %v, expected %d", val, err, highest)
+	}
+}
+
+func TestBadgerStore_MonotonicKeys(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:          fh,
+		BadgerOptions: &badgerOpts,
+		MonotonicKeys: [][]byte{[]byte("CurrentTerm")},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	term := []byte("CurrentTerm")
+	if err := store.SetUint64(term, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Re-setting the same term is fine, going backwards is not
+	if err := store.SetUint64(term, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64(term, 2); !errors.Is(err, ErrUint64Rollback) {
+		t.Fatalf("expected rollback error, got: %v", err)
+	}
+
+	// Other keys behave as before
+	other := []byte("LastVoteCand")
+	if err := store.SetUint64(other, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64(other, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestNew_NumVersionsToKeep(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	// A zero value in the Badger options falls back to a single version
+	badgerOpts := badger.DefaultOptions
+	badgerOpts.NumVersionsToKeep = 0
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := store.badgerOpts.NumVersionsToKeep; n != 1 {
+		t.Fatalf("expected 1 version, got %d", n)
+	}
+	store.Close()
+
+	badgerOpts = badger.DefaultOptions
+	store, err = New(Options{Path: fh, BadgerOptions: &badgerOpts, NumVersionsToKeep: 3})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := store.badgerOpts.NumVersionsToKeep; n != 3 {
+		t.Fatalf("expected 3 versions, got %d", n)
+	}
+	store.Close()
+
+	badgerOpts = badger.DefaultOptions
+	if _, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, NumVersionsToKeep: -1}); err == nil {
+		t.Fatalf("expected an error for a negative version count")
+	}
+}
+
+func TestNew_ValueThreshold(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	// Badger's default is raised so small entries stay in the LSM tree
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := store.badgerOpts.ValueThreshold; n != defaultValueThreshold {
+		t.Fatalf("expected threshold %d, got %d", defaultValueThreshold, n)
+	}
+	store.Close()
+
+	// A threshold set in the Badger options is kept
+	badgerOpts = badger.DefaultOptions
+	badgerOpts.ValueThreshold = 128
+	store, err = New(Options{Path: fh, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := store.badgerOpts.ValueThreshold; n != 128 {
+		t.Fatalf("expected threshold 128, got %d", n)
+	}
+	store.Close()
+
+	badgerOpts = badger.DefaultOptions
+	store, err = New(Options{Path: fh, BadgerOptions: &badgerOpts, ValueThreshold: 4096})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := store.badgerOpts.ValueThreshold; n != 4096 {
+		t.Fatalf("expected threshold 4096, got %d", n)
+	}
+	store.Close()
+
+	for _, n := range []int{-1, maxValueThreshold + 1} {
+		badgerOpts = badger.DefaultOptions
+		if _, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, ValueThreshold: n}); err == nil {
+			t.Fatalf("expected an error for threshold %d", n)
+		}
+	}
+}
+
+func TestNew_BadgerOptions(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	// Zero fields are filled in, set ones are kept, and the caller's
+	// options are left alone
+	badgerOpts := badger.Options{MaxTableSize: 8 << 20, SyncWrites: true}
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if badgerOpts.Dir != "" || badgerOpts.NumMemtables != 0 {
+		t.Fatalf("caller's options were modified: %+v", badgerOpts)
+	}
+	effective := store.badgerOpts
+	if effective.MaxTableSize != 8<<20 || !effective.SyncWrites {
+		t.Fatalf("set options were not kept: %+v", effective)
+	}
+	if effective.NumMemtables != badger.DefaultOptions.NumMemtables || effective.ValueThreshold != defaultValueThreshold {
+		t.Fatalf("zero options were not defaulted: %+v", effective)
+	}
+	store.Close()
+
+	// No Badger options at all
+	store, err = New(Options{Path: fh})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+	if badger.DefaultOptions.Dir != "" {
+		t.Fatalf("badger.DefaultOptions was modified")
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	store, err := NewWithOptions(fh, badgerOpts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Used as given, without the store's own defaults
+	if n := store.badgerOpts.ValueThreshold; n != badgerOpts.ValueThreshold {
+		t.Fatalf("expected threshold %d, got %d", badgerOpts.ValueThreshold, n)
+	}
+	if store.badgerOpts.Dir != badgerDir(fh) {
+		t.Fatalf("bad dir: %s", store.badgerOpts.Dir)
+	}
+	store.Close()
+
+	// Failing to open is an error, not an exit, and releases the path
+	badgerOpts.ValueThreshold = 1 << 20
+	if _, err := NewWithOptions(fh, badgerOpts); err != badger.ErrValueThreshold {
+		t.Fatalf("expected ErrValueThreshold, got: %v", err)
+	}
+	store, err = NewBadgerStore(fh)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+}
+
+func TestNew_ReadOnly(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store, err := New(Options{Path: store.path, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	log := new(raft.Log)
+	if err := store.GetLog(3, log); err != nil || string(log.Data) != "log3" {
+		t.Fatalf("bad: %v, %#v", err, log)
+	}
+	if v, err := store.GetUint64([]byte("CurrentTerm")); err != nil || v != 2 {
+		t.Fatalf("bad: %d, %v", v, err)
+	}
+
+	writes := map[string]func() error{
+		"StoreLog":    func() error { return store.StoreLog(testRaftLog(6, "log6")) },
+		"DeleteRange": func() error { return store.DeleteRange(1, 2) },
+		"Set":         func() error { return store.Set([]byte("k"), []byte("v")) },
+		"SetUint64":   func() error { return store.SetUint64([]byte("CurrentTerm"), 3) },
+		"SetLogMeta":  func() error { return store.SetLogMeta(1, 1) },
+		"Destroy":     func() error { return store.Destroy(store.path) },
+		"RunValueLogGC": func() error {
+			_, err := store.RunValueLogGC(0.5)
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("%s: bad: %v", name, err)
+		}
+	}
+	if last, err := store.LastIndex(); err != nil || last != 5 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+
+	if _, err := New(Options{Path: store.path + "-missing", ReadOnly: true}); err == nil {
+		t.Fatalf("should not create a read-only store")
+	}
+	if _, err := os.Stat(store.path + "-missing"); !os.IsNotExist(err) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestNewWithDB(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	opts := badger.DefaultOptions
+	opts.Dir = fh
+	opts.ValueDir = fh
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+	err = db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("app/state"), []byte("ok"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := NewWithDB(db, Options{Path: fh}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	store, err := NewWithDB(db, Options{ManualUpgrade: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testStoreFiveLogs(t, store)
+	if prefix := store.LogsPrefix(); string(prefix) != "raft/logs" {
+		t.Fatalf("bad: %q", prefix)
+	}
+
+	// Stores on the same database need namespaces of their own
+	if _, err := NewWithDB(db, Options{}); !errors.Is(err, ErrNamespaceOverlap) {
+		t.Fatalf("err: %v", err)
+	}
+	other, err := NewWithDB(db, Options{Namespace: []byte("raft2/")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last, err := other.LastIndex(); err != nil || last != 0 {
+		t.Fatalf("bad: %d %v", last, err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Closing the store leaves the database to the application
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte("app/state"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store, err = NewWithDB(db, Options{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if last, err := store.LastIndex(); err != nil || last != 5 {
+		t.Fatalf("bad: %d %v", last, err)
+	}
+}
+
+func TestBadgerStore_Keys(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	for _, k := range []string{"app/b", "CurrentTerm", "app/a", "LastVoteCand", "apple"} {
+		if err := store.Set([]byte(k), []byte("v-"+k)); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	keys, err := store.Keys([]byte("app/"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(keys, [][]byte{[]byte("app/a"), []byte("app/b")}) {
+		t.Fatalf("bad: %q", keys)
+	}
+	if keys, err = store.Keys(nil); err != nil || len(keys) != 5 {
+		t.Fatalf("bad: %q, %v", keys, err)
+	}
+
+	var seen []string
+	err = store.ForEach(func(k, v []byte) error {
+		if string(v) != "v-"+string(k) {
+			t.Fatalf("bad: %q=%q", k, v)
+		}
+		seen = append(seen, string(k))
+		if len(seen) == 3 {
+			return ErrStopScan
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(seen, []string{"CurrentTerm", "LastVoteCand", "app/a"}) {
+		t.Fatalf("bad: %q", seen)
 	}
 }
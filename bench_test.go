@@ -1,9 +1,13 @@
 package raftbadgerdb
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
 	raftbench "github.com/hashicorp/raft/bench"
 )
 
@@ -31,6 +35,27 @@ func BenchmarkBadgerStore_GetLog(b *testing.B) {
 	raftbench.GetLog(b, store)
 }
 
+func BenchmarkBadgerStore_GetLogs(b *testing.B) {
+	store := testBadgerStore(b)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	logs := make([]*raft.Log, 64)
+	for i := range logs {
+		logs[i] = &raft.Log{Index: uint64(i + 1), Data: []byte("data")}
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		b.Fatalf("err: %s", err)
+	}
+	out := make([]*raft.Log, len(logs))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := store.GetLogs(1, uint64(len(logs)), out); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+	}
+}
+
 func BenchmarkBadgerStore_StoreLog(b *testing.B) {
 	store := testBadgerStore(b)
 	defer store.Close()
@@ -85,3 +110,131 @@ func BenchmarkBadgerStore_GetUint64(b *testing.B) {
 
 	raftbench.GetUint64(b, store)
 }
+
+// BenchmarkBadgerStore_ValueThreshold writes and reads back batches of
+// entries of different sizes with Badger's own threshold, the store's
+// default and one large enough for every entry. Entries below the threshold
+// skip the value log on reads.
+func BenchmarkBadgerStore_ValueThreshold(b *testing.B) {
+	for _, threshold := range []int{32, defaultValueThreshold, 8 * kib} {
+		for _, size := range []int{64, 512, 4 * kib} {
+			b.Run(fmt.Sprintf("threshold=%d/entry=%d", threshold, size), func(b *testing.B) {
+				benchmarkValueThreshold(b, threshold, size)
+			})
+		}
+	}
+}
+
+func benchmarkValueThreshold(b *testing.B, threshold, size int) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, ValueThreshold: threshold})
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	const batch = 64
+	data := make([]byte, size)
+	logs := make([]*raft.Log, batch)
+	result := new(raft.Log)
+	b.SetBytes(int64(batch * size))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := range logs {
+			logs[i] = &raft.Log{Index: uint64(n*batch + i + 1), Data: data}
+		}
+		if err := store.StoreLogs(logs); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+		for _, l := range logs {
+			if err := store.GetLog(l.Index, result); err != nil {
+				b.Fatalf("err: %s", err)
+			}
+		}
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	log := &raft.Log{Index: 123456, Term: 42, Type: raft.LogCommand, Data: make([]byte, 256)}
+	for _, c := range []Codec{GobCodec{}, MsgpackCodec{}, ProtobufCodec{}} {
+		b.Run(fmt.Sprintf("%T", c), func(b *testing.B) {
+			data, err := c.Encode(log)
+			if err != nil {
+				b.Fatalf("err: %s", err)
+			}
+			b.ReportMetric(float64(len(data)), "bytes/value")
+			result := new(raft.Log)
+			for n := 0; n < b.N; n++ {
+				data, err := c.Encode(log)
+				if err != nil {
+					b.Fatalf("err: %s", err)
+				}
+				if err := c.Decode(data, result); err != nil {
+					b.Fatalf("err: %s", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBadgerStore_Compression writes and reads back batches of
+// compressible entries with each compression, reporting the stored size of
+// an entry next to the time taken.
+func BenchmarkBadgerStore_Compression(b *testing.B) {
+	for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+		for _, size := range []int{256, 4 * kib} {
+			b.Run(fmt.Sprintf("%s/entry=%d", c, size), func(b *testing.B) {
+				benchmarkCompression(b, c, size)
+			})
+		}
+	}
+}
+
+func benchmarkCompression(b *testing.B, c Compression, size int) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store, err := New(Options{Path: fh, Compression: c})
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	// Looks like a typical FSM command: repetitive, but not a single byte
+	const command = `{"op": "set", "key": "user/12345", "value": 67890}`
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = command[i%len(command)]
+	}
+	stored, err := store.encodeLog(&raft.Log{Index: 1, Data: data})
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+
+	const batch = 64
+	logs := make([]*raft.Log, batch)
+	result := new(raft.Log)
+	b.SetBytes(int64(batch * size))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := range logs {
+			logs[i] = &raft.Log{Index: uint64(n*batch + i + 1), Data: data}
+		}
+		if err := store.StoreLogs(logs); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+		for _, l := range logs {
+			if err := store.GetLog(l.Index, result); err != nil {
+				b.Fatalf("err: %s", err)
+			}
+		}
+	}
+	b.ReportMetric(float64(len(stored)), "bytes/entry")
+}
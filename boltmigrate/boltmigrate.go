@@ -0,0 +1,234 @@
+// Package boltmigrate moves the logs and stable store values of a
+// raft-boltdb store into a new raft-badger store. It lives apart from
+// raftbadgerdb so only programs migrating pull in BoltDB.
+package boltmigrate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+// batchSize is how many log entries are stored per StoreLogs call.
+const batchSize = 1024
+
+// openTimeout is how long opening the bolt store waits for its lock, which
+// raft-boltdb holds while the node runs.
+const openTimeout = time.Second
+
+// Buckets raft-boltdb keeps log entries and stable store values in.
+var (
+	dbLogs = []byte("logs")
+	dbConf = []byte("conf")
+)
+
+var (
+	// ErrNotEmpty is returned when the store to migrate into already holds
+	// log entries or stable store values
+	ErrNotEmpty = errors.New("target store is not empty")
+	// ErrVerification is returned when the migrated store doesn't hold
+	// what the bolt store does
+	ErrVerification = errors.New("migrated store doesn't match the bolt store")
+)
+
+// Report summarizes a migration.
+type Report struct {
+	// Logs and StableKeys count what was migrated
+	Logs       uint64
+	StableKeys uint64
+	// FirstIndex and LastIndex bound the migrated log, both 0 if empty
+	FirstIndex uint64
+	LastIndex  uint64
+	Duration   time.Duration
+}
+
+// MigrateFromBolt copies every log entry and stable store value of the
+// raft-boltdb store at boltPath into a new BadgerStore opened with options,
+// which must be empty, then checks that both hold the same number of
+// entries and keys and the same first and last index. The bolt store is
+// only read, and the node using it must be stopped. Progress is reported
+// through options.OnProgress with the "migrate-bolt" phase. If migrating
+// fails, the target may hold part of the data and should be removed
+// before trying again.
+func MigrateFromBolt(boltPath string, options raftbadgerdb.Options) (*Report, error) {
+	start := time.Now()
+	db, err := bolt.Open(boltPath, 0600, &bolt.Options{ReadOnly: true, Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt store: %w", err)
+	}
+	defer db.Close()
+	store, err := raftbadgerdb.New(options)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	err = db.View(func(tx *bolt.Tx) error {
+		return migrate(tx, store, options.OnProgress, report)
+	})
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// planPasses is how many passes over the bolt store a migration makes for
+// each pass its plan makes: one copying entries and one verifying them.
+const planPasses = 2
+
+// PlanMigrateFromBolt reports what MigrateFromBolt would do with the same
+// arguments, without changing anything: how many entries and stable store
+// values it would copy, roughly how long it would take and how much disk
+// space the copies need, and the entries that don't decode, or a target
+// that isn't empty, which would stop it.
+func PlanMigrateFromBolt(boltPath string, options raftbadgerdb.Options) (*raftbadgerdb.MigrationPlan, error) {
+	start := time.Now()
+	db, err := bolt.Open(boltPath, 0600, &bolt.Options{ReadOnly: true, Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt store: %w", err)
+	}
+	defer db.Close()
+	plan := &raftbadgerdb.MigrationPlan{Migration: "migrate-bolt"}
+	err = db.View(func(tx *bolt.Tx) error {
+		if logs := tx.Bucket(dbLogs); logs != nil {
+			var undecodable uint64
+			var firstErr error
+			err := logs.ForEach(func(k, v []byte) error {
+				plan.Entries++
+				plan.RequiredDiskSpace += uint64(len(k) + len(v))
+				if err := codec.NewDecoderBytes(v, &codec.MsgpackHandle{}).Decode(new(raft.Log)); err != nil {
+					if undecodable == 0 {
+						firstErr = fmt.Errorf("log entry %d: %v", binary.BigEndian.Uint64(k), err)
+					}
+					undecodable++
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if undecodable > 0 {
+				plan.Anomalies = append(plan.Anomalies, fmt.Sprintf("%d log entries don't decode, first %v", undecodable, firstErr))
+			}
+		}
+		plan.Rewrites = plan.Entries
+		if conf := tx.Bucket(dbConf); conf != nil {
+			return conf.ForEach(func(k, v []byte) error {
+				plan.Rewrites++
+				plan.RequiredDiskSpace += uint64(len(k) + len(v))
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan.EstimatedDuration = planPasses * time.Since(start)
+	if err := plan.CheckTarget(options); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func migrate(tx *bolt.Tx, store *raftbadgerdb.BadgerStore, progress raftbadgerdb.ProgressFunc, report *Report) error {
+	stats, err := store.Stats()
+	if err != nil {
+		return err
+	}
+	if stats.LogEntries > 0 || stats.StableKeys > 0 {
+		return ErrNotEmpty
+	}
+	if err := migrateLogs(tx.Bucket(dbLogs), store, progress, report); err != nil {
+		return err
+	}
+	if conf := tx.Bucket(dbConf); conf != nil {
+		err := conf.ForEach(func(k, v []byte) error {
+			report.StableKeys++
+			return store.Set(k, v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return verify(store, report)
+}
+
+func migrateLogs(logs *bolt.Bucket, store *raftbadgerdb.BadgerStore, progress raftbadgerdb.ProgressFunc, report *Report) error {
+	if logs == nil {
+		return nil
+	}
+	if first, _ := logs.Cursor().First(); first != nil {
+		report.FirstIndex = binary.BigEndian.Uint64(first)
+	}
+	if last, _ := logs.Cursor().Last(); last != nil {
+		report.LastIndex = binary.BigEndian.Uint64(last)
+	}
+	total := uint64(logs.Stats().KeyN)
+	start := time.Now()
+	reportProgress := func() {
+		if progress != nil {
+			progress(raftbadgerdb.Progress{Phase: "migrate-bolt", Done: report.Logs, Total: total, Elapsed: time.Since(start)})
+		}
+	}
+	reportProgress()
+
+	batch := make([]*raft.Log, 0, batchSize)
+	flush := func() error {
+		if err := store.StoreLogs(batch); err != nil {
+			return err
+		}
+		report.Logs += uint64(len(batch))
+		batch = batch[:0]
+		reportProgress()
+		return nil
+	}
+	c := logs.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		log := new(raft.Log)
+		if err := codec.NewDecoderBytes(v, &codec.MsgpackHandle{}).Decode(log); err != nil {
+			return fmt.Errorf("decoding log entry %d: %w", binary.BigEndian.Uint64(k), err)
+		}
+		batch = append(batch, log)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return flush()
+}
+
+// verify checks the migrated store against what was read from bolt.
+func verify(store *raftbadgerdb.BadgerStore, report *Report) error {
+	stats, err := store.Stats()
+	if err != nil {
+		return err
+	}
+	first, err := store.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return err
+	}
+	if stats.LogEntries != report.Logs || stats.StableKeys != report.StableKeys || first != report.FirstIndex || last != report.LastIndex {
+		return fmt.Errorf("%w: %d entries from %d to %d and %d stable keys, expected %d from %d to %d and %d",
+			ErrVerification, stats.LogEntries, first, last, stats.StableKeys,
+			report.Logs, report.FirstIndex, report.LastIndex, report.StableKeys)
+	}
+	return nil
+}
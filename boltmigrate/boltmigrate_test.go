@@ -0,0 +1,149 @@
+package boltmigrate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+// testBoltStore writes a store laid out like raft-boltdb's into dir,
+// holding entries first to last and a term.
+func testBoltStore(t *testing.T, dir string, first, last uint64) string {
+	path := filepath.Join(dir, "raft.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		logs, err := tx.CreateBucket(dbLogs)
+		if err != nil {
+			return err
+		}
+		for idx := first; idx <= last; idx++ {
+			var v []byte
+			log := &raft.Log{Index: idx, Term: 1, Data: []byte("entry")}
+			if err := codec.NewEncoderBytes(&v, &codec.MsgpackHandle{}).Encode(log); err != nil {
+				return err
+			}
+			k := make([]byte, 8)
+			binary.BigEndian.PutUint64(k, idx)
+			if err := logs.Put(k, v); err != nil {
+				return err
+			}
+		}
+		conf, err := tx.CreateBucket(dbConf)
+		if err != nil {
+			return err
+		}
+		term := make([]byte, 8)
+		binary.BigEndian.PutUint64(term, 3)
+		return conf.Put([]byte("CurrentTerm"), term)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return path
+}
+
+func TestMigrateFromBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	boltPath := testBoltStore(t, dir, 10, batchSize+20)
+	target := filepath.Join(dir, "badger")
+
+	var reports []raftbadgerdb.Progress
+	options := raftbadgerdb.Options{Path: target, OnProgress: func(p raftbadgerdb.Progress) {
+		if p.Phase == "migrate-bolt" {
+			reports = append(reports, p)
+		}
+	}}
+	report, err := MigrateFromBolt(boltPath, options)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if report.Logs != batchSize+11 || report.StableKeys != 1 || report.FirstIndex != 10 || report.LastIndex != batchSize+20 {
+		t.Fatalf("bad: %#v", report)
+	}
+	if len(reports) != 3 || reports[2].Done != report.Logs || reports[2].Total != report.Logs {
+		t.Fatalf("bad: %#v", reports)
+	}
+
+	store, err := raftbadgerdb.NewBadgerStore(target)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	log := new(raft.Log)
+	if err := store.GetLog(10, log); err != nil || !bytes.Equal(log.Data, []byte("entry")) {
+		t.Fatalf("bad: %#v, %v", log, err)
+	}
+	if term, err := store.GetUint64([]byte("CurrentTerm")); err != nil || term != 3 {
+		t.Fatalf("bad: %d, %v", term, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Migrating twice would mix two stores
+	if _, err := MigrateFromBolt(boltPath, raftbadgerdb.Options{Path: target}); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestMigrateFromBolt_MissingStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := MigrateFromBolt(filepath.Join(dir, "raft.db"), raftbadgerdb.Options{Path: filepath.Join(dir, "badger")}); err == nil {
+		t.Fatalf("should fail without a bolt store")
+	}
+}
+
+func TestPlanMigrateFromBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	boltPath := testBoltStore(t, dir, 10, 29)
+	target := filepath.Join(dir, "badger")
+
+	plan, err := PlanMigrateFromBolt(boltPath, raftbadgerdb.Options{Path: target})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if plan.Entries != 20 || plan.Rewrites != 21 || plan.RequiredDiskSpace == 0 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+	if err := plan.Err(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("planning created the target: %v", err)
+	}
+
+	if _, err := MigrateFromBolt(boltPath, raftbadgerdb.Options{Path: target}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	plan, err = PlanMigrateFromBolt(boltPath, raftbadgerdb.Options{Path: target})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := plan.Err(); !errors.Is(err, raftbadgerdb.ErrMigrationBlocked) {
+		t.Fatalf("expected ErrMigrationBlocked for a full target, got: %v", err)
+	}
+}
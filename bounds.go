@@ -0,0 +1,78 @@
+package raftbadgerdb
+
+import "sync"
+
+// indexBounds keeps the first and last index of the log in memory, so
+// FirstIndex and LastIndex don't have to seek on every call. Appends and
+// deletes at the ends update them; a delete that moves either end, or any
+// other write to the log, makes them unknown, and the next read finds them
+// again by seeking. gen changes with every update, so bounds found by a
+// seek are only kept if the log didn't change meanwhile.
+type indexBounds struct {
+	mu          sync.Mutex
+	first, last uint64
+	known       bool
+	gen         uint64
+}
+
+// get returns the bounds, if known, and otherwise the generation to pass to
+// set along with the bounds found by seeking.
+func (ib *indexBounds) get() (first, last uint64, ok bool, gen uint64) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if !ib.known {
+		return 0, 0, false, ib.gen
+	}
+	return ib.first, ib.last, true, ib.gen
+}
+
+func (ib *indexBounds) set(first, last, gen uint64) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if ib.gen == gen {
+		ib.first, ib.last, ib.known = first, last, true
+	}
+}
+
+// stored widens the bounds to cover entries from min to max, which have
+// just been committed.
+func (ib *indexBounds) stored(min, max uint64) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	ib.gen++
+	if !ib.known {
+		return
+	}
+	if ib.last == 0 || min < ib.first {
+		ib.first = min
+	}
+	if max > ib.last {
+		ib.last = max
+	}
+}
+
+// deleted accounts for deleting the entries from min to max.
+func (ib *indexBounds) deleted(min, max uint64) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	ib.gen++
+	if !ib.known || ib.last == 0 || max < ib.first || min > ib.last {
+		return
+	}
+	switch {
+	case min <= ib.first && max >= ib.last:
+		ib.first, ib.last = 0, 0
+	case min > ib.first && max < ib.last:
+		// A hole in the middle leaves both ends alone
+	default:
+		ib.known = false
+	}
+}
+
+// invalidate forgets the bounds.
+func (ib *indexBounds) invalidate() {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	ib.gen++
+	ib.known = false
+}
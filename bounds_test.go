@@ -0,0 +1,89 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestIndexBounds(t *testing.T) {
+	cases := []struct {
+		name        string
+		min, max    uint64
+		first, last uint64
+		known       bool
+	}{
+		{"before", 1, 4, 5, 10, true},
+		{"after", 11, 20, 5, 10, true},
+		{"middle", 6, 9, 5, 10, true},
+		{"everything", 1, 20, 0, 0, true},
+		{"head", 1, 7, 0, 0, false},
+		{"tail", 8, 10, 0, 0, false},
+	}
+	for _, c := range cases {
+		var ib indexBounds
+		_, _, _, gen := ib.get()
+		ib.set(5, 10, gen)
+		ib.deleted(c.min, c.max)
+		first, last, known, _ := ib.get()
+		if first != c.first || last != c.last || known != c.known {
+			t.Fatalf("%s: bad: %d, %d, %v", c.name, first, last, known)
+		}
+	}
+
+	// Bounds found before an update are stale
+	var ib indexBounds
+	_, _, _, gen := ib.get()
+	ib.stored(1, 2)
+	ib.set(0, 0, gen)
+	if _, _, known, _ := ib.get(); known {
+		t.Fatalf("should not keep stale bounds")
+	}
+}
+
+func TestBadgerStore_IndexBounds(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	// Removed behind the store's back, the bounds are still served from
+	// memory
+	err := store.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(defaultKeys.logKey(1))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first, err := store.FirstIndex(); err != nil || first != 1 {
+		t.Fatalf("bad: %d, %v", first, err)
+	}
+
+	// Deleting the head makes the store seek again
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first, err := store.FirstIndex(); err != nil || first != 3 {
+		t.Fatalf("bad: %d, %v", first, err)
+	}
+	if err := store.StoreLog(testRaftLog(6, "log6")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last, err := store.LastIndex(); err != nil || last != 6 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+	if err := store.DeleteRange(3, 6); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	first, last, known, _ := store.bounds.get()
+	if !known || first != 0 || last != 0 {
+		t.Fatalf("bad: %d, %d, %v", first, last, known)
+	}
+	if err := store.StoreLog(testRaftLog(7, "log7")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first, err := store.FirstIndex(); err != nil || first != 7 {
+		t.Fatalf("bad: %d, %v", first, err)
+	}
+}
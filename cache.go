@@ -0,0 +1,163 @@
+package raftbadgerdb
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// cachedEntryOverhead approximates the memory a cached entry takes beyond
+// its payload, for CacheBytes accounting.
+const cachedEntryOverhead = 128
+
+// logCache keeps recently appended log entries in memory, evicting the
+// least recently used once it holds more than maxEntries entries or
+// maxBytes bytes. A nil logCache caches nothing.
+type logCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	lru        *list.List
+	entries    map[uint64]*list.Element
+}
+
+func newLogCache(maxEntries, maxBytes int) *logCache {
+	if maxEntries == 0 && maxBytes == 0 {
+		return nil
+	}
+	return &logCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		entries:    make(map[uint64]*list.Element),
+	}
+}
+
+func cachedSize(log *raft.Log) int {
+	return len(log.Data) + len(log.Extensions) + cachedEntryOverhead
+}
+
+// get copies the cached entry at idx into log and reports whether there
+// was one. The payload and extensions are shared with the cache and must
+// not be modified.
+func (c *logCache) get(idx uint64, log *raft.Log) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[idx]
+	if !ok {
+		return false
+	}
+	c.lru.MoveToFront(e)
+	*log = *e.Value.(*raft.Log)
+	return true
+}
+
+// stored adds logs, which have just been committed, to the cache. Their
+// payloads and extensions are copied, as the caller may reuse them.
+func (c *logCache) stored(logs []*raft.Log) {
+	if c == nil || len(logs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, log := range logs {
+		cached := *log
+		cached.Data = append([]byte(nil), log.Data...)
+		cached.Extensions = append([]byte(nil), log.Extensions...)
+		if e, ok := c.entries[log.Index]; ok {
+			c.bytes -= cachedSize(e.Value.(*raft.Log))
+			e.Value = &cached
+			c.lru.MoveToFront(e)
+		} else {
+			c.entries[log.Index] = c.lru.PushFront(&cached)
+		}
+		c.bytes += cachedSize(&cached)
+	}
+	c.evict()
+}
+
+func (c *logCache) evict() {
+	for c.lru.Len() > 0 && ((c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		e := c.lru.Back()
+		log := c.lru.Remove(e).(*raft.Log)
+		delete(c.entries, log.Index)
+		c.bytes -= cachedSize(log)
+	}
+}
+
+// removeRange drops the entries from min to max.
+func (c *logCache) removeRange(min, max uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for idx, e := range c.entries {
+		if idx >= min && idx <= max {
+			c.lru.Remove(e)
+			delete(c.entries, idx)
+			c.bytes -= cachedSize(e.Value.(*raft.Log))
+		}
+	}
+}
+
+// invalidate empties the cache, for writes that change the log other than
+// by appending or deleting a range.
+func (c *logCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[uint64]*list.Element)
+	c.bytes = 0
+}
+
+// warmCache loads up to n of the last log entries into the cache, returning
+// how many it loaded.
+func (b *BadgerStore) warmCache(n int) (int, error) {
+	first, last, err := b.logBounds()
+	if err != nil || last == 0 || n <= 0 || b.cache == nil {
+		return 0, err
+	}
+	min := first
+	if last-first >= uint64(n) {
+		min = last - uint64(n) + 1
+	}
+	var logs []*raft.Log
+	err = b.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(b.keys.logKey(min)); it.ValidForPrefix(b.keys.logs); it.Next() {
+			idx, err := b.keys.parseLogKey(it.Item().Key())
+			if err != nil {
+				return err
+			}
+			if b.isPendingDelete(idx) {
+				continue
+			}
+			v, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+			log := new(raft.Log)
+			if err := b.decodeLog(txn, idx, v, log); err != nil {
+				return b.logDecodeError(idx, err)
+			}
+			logs = append(logs, log)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	b.cache.stored(logs)
+	return len(logs), nil
+}
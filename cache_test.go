@@ -0,0 +1,177 @@
+package raftbadgerdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testCachedStore(t *testing.T, entries, bytes int) *BadgerStore {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store, err := New(Options{Path: fh, CacheEntries: entries, CacheBytes: bytes})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestBadgerStore_Cache(t *testing.T) {
+	store := testCachedStore(t, 3, 0)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	if n := store.cache.lru.Len(); n != 3 {
+		t.Fatalf("bad: %d cached", n)
+	}
+
+	// Remove the entries behind the cache's back: cached ones are still
+	// served, evicted ones are not
+	err := store.db.Update(func(txn *badger.Txn) error {
+		for idx := uint64(1); idx <= 5; idx++ {
+			if err := txn.Delete(defaultKeys.logKey(idx)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	log := new(raft.Log)
+	if err := store.GetLog(5, log); err != nil || string(log.Data) != "log5" {
+		t.Fatalf("bad: %v, %#v", err, log)
+	}
+	if err := store.GetLog(2, log); err != raft.ErrLogNotFound {
+		t.Fatalf("bad: %v", err)
+	}
+	// The bounds still hold the deleted entries
+	store.allowOutOfOrder = true
+	testStoreFiveLogs(t, store)
+	if last, err := store.LastIndex(); err != nil || last != 5 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+	if err := store.StoreLog(testRaftLog(6, "log6")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Truncating the tail drops it from the cache
+	if err := store.DeleteRange(5, 6); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(5, log); err != raft.ErrLogNotFound {
+		t.Fatalf("bad: %v", err)
+	}
+	if last, err := store.LastIndex(); err != nil || last != 4 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+	if err := store.StoreLog(testRaftLog(5, "new5")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(5, log); err != nil || string(log.Data) != "new5" {
+		t.Fatalf("bad: %v, %#v", err, log)
+	}
+	if last, err := store.LastIndex(); err != nil || last != 5 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+}
+
+func TestBadgerStore_CacheBytes(t *testing.T) {
+	store := testCachedStore(t, 0, 2*(cachedEntryOverhead+4))
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	if n := store.cache.lru.Len(); n != 2 {
+		t.Fatalf("bad: %d cached", n)
+	}
+	if store.cache.bytes > store.cache.maxBytes {
+		t.Fatalf("bad: %d bytes", store.cache.bytes)
+	}
+
+	// Cached entries are copies the caller can't change
+	logs := []*raft.Log{testRaftLog(6, "log6")}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	logs[0].Data[0] = 'x'
+	log := new(raft.Log)
+	if err := store.GetLog(6, log); err != nil || string(log.Data) != "log6" {
+		t.Fatalf("bad: %v, %#v", err, log)
+	}
+
+	if _, err := New(Options{Path: store.path, CacheBytes: -1}); err == nil {
+		t.Fatalf("should reject a negative cache size")
+	}
+}
+
+func TestBadgerStore_CacheExtensions(t *testing.T) {
+	store := testCachedStore(t, 3, 0)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+
+	// Extensions are copied along with the payload, so reusing the entry
+	// after StoreLogs doesn't change what the cache serves
+	log := testRaftLog(1, "log1")
+	log.Extensions = []byte("ext1")
+	if err := store.StoreLogs([]*raft.Log{log}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	log.Data[0], log.Extensions[0] = 'x', 'x'
+	result := new(raft.Log)
+	if err := store.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "log1" || string(result.Extensions) != "ext1" {
+		t.Fatalf("bad: %#v", result)
+	}
+	if store.cache.bytes != 2*4+cachedEntryOverhead {
+		t.Fatalf("bad: %d bytes", store.cache.bytes)
+	}
+}
+
+func TestBadgerStore_CacheWarm(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := New(Options{Path: store.path, CacheWarmEntries: 3}); err == nil {
+		t.Fatalf("should reject a warm-up without a cache")
+	}
+	store, err := New(Options{Path: store.path, CacheEntries: 10, CacheWarmEntries: 3})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if n := store.cache.lru.Len(); n != 3 {
+		t.Fatalf("bad: %d cached", n)
+	}
+	if n := store.StartupReport().CacheWarmed; n != 3 {
+		t.Fatalf("bad: %d warmed", n)
+	}
+
+	// The last three entries are served from the cache
+	log := new(raft.Log)
+	for idx := uint64(1); idx <= 5; idx++ {
+		_, cached := store.cache.entries[idx]
+		if cached != (idx >= 3) {
+			t.Fatalf("bad: entry %d cached: %v", idx, cached)
+		}
+		if cached && (!store.cache.get(idx, log) || log.Index != idx || string(log.Data) != fmt.Sprintf("log%d", idx)) {
+			t.Fatalf("bad: %#v", log)
+		}
+	}
+	if first, last := testBounds(t, store); first != 1 || last != 5 {
+		t.Fatalf("bad: %d to %d", first, last)
+	}
+}
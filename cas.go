@@ -0,0 +1,99 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// CompareAndSet atomically stores new under key if the key currently holds
+// old, and reports whether it did. A nil old means the key must be unset.
+// The comparison and the write happen in one transaction, so a concurrent
+// Set of the key either lands before the comparison or makes
+// CompareAndSet compare again against what it wrote.
+func (b *BadgerStore) CompareAndSet(key, old, new []byte) (bool, error) {
+	return b.compareAndSet("CompareAndSet", key, func(current []byte, found bool) (bool, error) {
+		if old == nil {
+			return !found, nil
+		}
+		return found && bytes.Equal(current, old), nil
+	}, new)
+}
+
+// CompareAndSetUint64 is like CompareAndSet, but handles uint64 values. An
+// unset key counts as holding zero, as raft reads it. Keys listed in
+// Options.MonotonicKeys refuse to move backwards and return
+// ErrUint64Rollback.
+func (b *BadgerStore) CompareAndSetUint64(key []byte, old, new uint64) (bool, error) {
+	return b.compareAndSet("CompareAndSetUint64", key, func(current []byte, found bool) (bool, error) {
+		if !found {
+			return old == 0, nil
+		}
+		if bytesToUint64(current) != old {
+			return false, nil
+		}
+		if new < old && b.monotonicKeys[string(key)] {
+			return false, fmt.Errorf("%w: %q is %d, refusing to set %d", ErrUint64Rollback, key, old, new)
+		}
+		return true, nil
+	}, uint64ToBytes(new))
+}
+
+// compareAndSet stores val under key if match accepts the key's current
+// value, found being false for an unset key.
+func (b *BadgerStore) compareAndSet(op string, key []byte, match func(current []byte, found bool) (bool, error), val []byte) (bool, error) {
+	if b.badgerOpts.ReadOnly {
+		return false, ErrReadOnly
+	}
+	defer b.metrics.measureSince([]string{"set"}, time.Now())
+	defer b.finishOp(opSet, time.Now(), 0)
+	span := b.startSpan(context.Background(), op, attrKey.String(string(key)), attrBytes.Int(len(val)))
+	var written bool
+	err := b.run(context.Background(), func(context.Context) error {
+		return b.writeRetry.do(b.metrics, b.logger, retryWrite, func() (err error) {
+			written, err = b.tryCompareAndSet(key, match, val)
+			return err
+		})
+	})
+	endSpan(span, err)
+	if written && err == nil {
+		b.prom.wrote(0, len(val))
+		b.stableKeySet(key)
+	}
+	return written, err
+}
+
+func (b *BadgerStore) tryCompareAndSet(key []byte, match func([]byte, bool) (bool, error), val []byte) (bool, error) {
+	for {
+		written := false
+		err := b.updateStable(func(txn *writeTxn) error {
+			k := b.keys.confKey(key)
+			var current []byte
+			item, err := txn.Get(k)
+			if err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			found := err == nil
+			if found {
+				if current, err = item.Value(); err != nil {
+					return err
+				}
+			}
+			ok, err := match(current, found)
+			if !ok || err != nil {
+				return err
+			}
+			written = true
+			return txn.Set(k, val)
+		})
+		// Another writer got in between the read and the commit, so compare
+		// again against what it wrote
+		if err == badger.ErrConflict {
+			continue
+		}
+		return written, err
+	}
+}
@@ -0,0 +1,77 @@
+package raftbadgerdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// checksumTag marks a value carrying a checksum. The envelope is the tag,
+// the big-endian CRC-32C of the rest and the rest, which is the value as
+// sealed by the encryption envelope, if any, behind dedupTag for a
+// deduplicated entry.
+const checksumTag byte = 0xc5
+
+// checksumEnvelopeSize is the number of bytes the envelope adds to a value.
+const checksumEnvelopeSize = 5
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errChecksumMismatch is returned by unwrapValue for a value whose checksum
+// doesn't match, and turned into an ErrCorruptLog by the callers knowing
+// the entry's index.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrCorruptLog is returned when a log entry doesn't match the checksum
+// stored with it, meaning it was damaged after it was written.
+type ErrCorruptLog struct {
+	// Index is the index of the damaged entry
+	Index uint64
+}
+
+func (e *ErrCorruptLog) Error() string {
+	return fmt.Sprintf("log entry %d is corrupt: %v", e.Index, errChecksumMismatch)
+}
+
+// checksumValue wraps a sealed value in the checksum envelope.
+func checksumValue(v []byte) []byte {
+	out := make([]byte, checksumEnvelopeSize, checksumEnvelopeSize+len(v))
+	out[0] = checksumTag
+	binary.BigEndian.PutUint32(out[1:], crc32.Checksum(v, checksumTable))
+	return append(out, v...)
+}
+
+// openChecksum strips the checksum envelope from v, verifying the checksum
+// unless the store was opened with Options.SkipChecksumVerification.
+func (b *BadgerStore) openChecksum(v []byte) ([]byte, error) {
+	if len(v) < checksumEnvelopeSize {
+		return nil, fmt.Errorf("%w: truncated envelope", errChecksumMismatch)
+	}
+	rest := v[checksumEnvelopeSize:]
+	if !b.skipChecksums && crc32.Checksum(rest, checksumTable) != binary.BigEndian.Uint32(v[1:checksumEnvelopeSize]) {
+		return nil, errChecksumMismatch
+	}
+	return rest, nil
+}
+
+// logDecodeError returns the error decoding entry idx failed with, an
+// ErrCorruptLog for a checksum mismatch, which it reports to the
+// CorruptionDetected hook.
+func (b *BadgerStore) logDecodeError(idx uint64, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		b.corruptionDetected(idx)
+		return &ErrCorruptLog{Index: idx}
+	}
+	return fmt.Errorf("log %d: %w", idx, err)
+}
+
+// corruptionDetected calls the CorruptionDetected hook.
+func (b *BadgerStore) corruptionDetected(idx uint64) {
+	if b.hooks.CorruptionDetected != nil {
+		b.hooks.CorruptionDetected(idx)
+	}
+}
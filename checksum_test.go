@@ -0,0 +1,84 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// testCorruptEntry flips a bit of the payload data of the stored entry idx.
+func testCorruptEntry(t *testing.T, store *BadgerStore, idx uint64, data string) {
+	err := store.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.logKey(idx))
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		at := bytes.Index(v, []byte(data))
+		if at < 0 {
+			t.Fatalf("payload %q not found", data)
+		}
+		v[at] ^= 0x20
+		return txn.Set(defaultKeys.logKey(idx), v)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBadgerStore_Checksums(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	testCorruptEntry(t, store, 3, "log3")
+
+	var corrupt *ErrCorruptLog
+	if err := store.GetLog(3, new(raft.Log)); !errors.As(err, &corrupt) || corrupt.Index != 3 {
+		t.Fatalf("expected ErrCorruptLog for 3, got: %v", err)
+	}
+	if err := store.GetLog(2, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.GetLogs(1, 5, make([]*raft.Log, 5)); !errors.As(err, &corrupt) || corrupt.Index != 3 {
+		t.Fatalf("expected ErrCorruptLog for 3, got: %v", err)
+	}
+	err := store.ScanLogs(LogFilter{}, func(*raft.Log) error { return nil })
+	if !errors.As(err, &corrupt) || corrupt.Index != 3 {
+		t.Fatalf("expected ErrCorruptLog for 3, got: %v", err)
+	}
+}
+
+func TestBadgerStore_SkipChecksumVerification(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store, err := New(Options{Path: fh, SkipChecksumVerification: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if err := store.StoreLog(&raft.Log{Index: 1, Term: 1, Data: []byte("payload")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testCorruptEntry(t, store, 1, "payload")
+
+	// The damage goes unnoticed, as it would without checksums
+	result := new(raft.Log)
+	if err := store.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "Payload" {
+		t.Fatalf("expected the damaged payload, got %q", result.Data)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/hashicorp/raft"
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+func runComposition(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("composition", stdout)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("composition: -path is required")
+	}
+	store, err := raftbadgerdb.New(raftbadgerdb.Options{Path: *path, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	c, err := store.LogComposition()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tENTRIES\tPAYLOAD BYTES\tSTORED BYTES")
+	types := make([]raft.LogType, 0, len(c.ByType))
+	for t := range c.ByType {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, t := range types {
+		printBucket(w, raftbadgerdb.LogTypeName(t), c.ByType[t])
+	}
+	printBucket(w, "total", c.Total)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "TERM\tENTRIES\tPAYLOAD BYTES\tSTORED BYTES")
+	terms := make([]uint64, 0, len(c.ByTerm))
+	for t := range c.ByTerm {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool { return terms[i] < terms[j] })
+	for _, t := range terms {
+		printBucket(w, fmt.Sprint(t), c.ByTerm[t])
+	}
+	return w.Flush()
+}
+
+func printBucket(w io.Writer, name string, b raftbadgerdb.CompositionBucket) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", name, b.Entries, b.PayloadBytes, b.StoredBytes)
+}
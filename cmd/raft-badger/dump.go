@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/raft"
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+// dumpFormats maps the names accepted by dump's -format flag to export
+// formats. Text, the default, isn't one of them.
+var dumpFormats = map[string]raftbadgerdb.ExportFormat{
+	"ndjson": raftbadgerdb.ExportNDJSON,
+	"json":   raftbadgerdb.ExportJSON,
+}
+
+// interrupted returns a channel closed when the process is interrupted, and
+// a function to stop watching for it. Tests replace it.
+var interrupted = func() (<-chan struct{}, func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		select {
+		case <-sig:
+			close(stop)
+		case <-done:
+		}
+	}()
+	return stop, func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+func runDump(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("dump", stdout)
+	from := fs.Uint64("from", 0, "first index to print, the first stored one if 0")
+	to := fs.Uint64("to", 0, "last index to print, the last stored one if 0")
+	format := fs.String("format", "text", "output format: text, ndjson or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("dump: -path is required")
+	}
+	if *to != 0 && *to < *from {
+		return fmt.Errorf("dump: -to %d is before -from %d", *to, *from)
+	}
+	exportFormat, ok := dumpFormats[*format]
+	if !ok && *format != "text" {
+		return fmt.Errorf("dump: unknown format %q", *format)
+	}
+	store, err := raftbadgerdb.New(raftbadgerdb.Options{Path: *path, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	filter := raftbadgerdb.LogFilter{MinIndex: *from, MaxIndex: *to}
+	if ok {
+		return store.Export(stdout, exportFormat, filter)
+	}
+	p, err := newEntryPrinter(stdout, store, *from)
+	if err != nil {
+		return err
+	}
+	return store.ScanLogs(filter, p.print)
+}
+
+func runTail(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("tail", stdout)
+	n := fs.Uint64("n", 10, "number of entries to print")
+	follow := fs.Bool("f", false, "keep printing entries as they are appended, from a running node opened with AllowAttach")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("tail: -path is required")
+	}
+	if *follow {
+		// The running node holds the directory lock, so entries come from
+		// it rather than from the files
+		stop, release := interrupted()
+		defer release()
+		p := &entryPrinter{w: stdout}
+		err := raftbadgerdb.FollowLogs(*path, raftbadgerdb.FollowRequest{Last: *n}, stop, p.print)
+		if errors.Is(err, raftbadgerdb.ErrAttachUnavailable) {
+			return fmt.Errorf("tail: -f needs a running node opened with AllowAttach: %w", err)
+		}
+		return err
+	}
+
+	store, err := raftbadgerdb.New(raftbadgerdb.Options{Path: *path, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	last, err := store.LastIndex()
+	if err != nil {
+		return err
+	}
+	var from uint64
+	if last >= *n {
+		from = last - *n + 1
+	}
+	p, err := newEntryPrinter(stdout, store, from)
+	if err != nil {
+		return err
+	}
+	return store.ScanLogs(raftbadgerdb.LogFilter{MinIndex: from}, p.print)
+}
+
+// entryPrinter prints log entries for humans, configuration entries as the
+// membership changes they make.
+type entryPrinter struct {
+	w io.Writer
+	// prev is the configuration before the next entry, if known
+	prev *raft.Configuration
+}
+
+// newEntryPrinter returns a printer for the entries of store from index
+// from onwards, knowing the configuration in effect before them.
+func newEntryPrinter(w io.Writer, store *raftbadgerdb.BadgerStore, from uint64) (*entryPrinter, error) {
+	p := &entryPrinter{w: w}
+	history, err := store.MembershipHistory()
+	if err != nil {
+		return nil, err
+	}
+	for i := range history {
+		if history[i].Index >= from {
+			break
+		}
+		p.prev = &history[i].Configuration
+	}
+	return p, nil
+}
+
+func (p *entryPrinter) print(log *raft.Log) error {
+	switch log.Type {
+	case raft.LogConfiguration, raft.LogAddPeerDeprecated, raft.LogRemovePeerDeprecated:
+		c, err := raftbadgerdb.DecodeConfiguration(log)
+		if err != nil {
+			return fmt.Errorf("decoding configuration at index %d: %w", log.Index, err)
+		}
+		fmt.Fprintf(p.w, "%d\tterm %d\t%s\n", log.Index, log.Term, raftbadgerdb.LogTypeName(log.Type))
+		printMembershipChange(p.w, p.prev, c)
+		p.prev = &c
+	default:
+		_, err := fmt.Fprintf(p.w, "%d\tterm %d\t%s\t%s\n", log.Index, log.Term, raftbadgerdb.LogTypeName(log.Type), formatData(log.Data))
+		return err
+	}
+	return nil
+}
+
+// printMembershipChange prints how next differs from prev, or every server
+// in next if prev isn't known.
+func printMembershipChange(w io.Writer, prev *raft.Configuration, next raft.Configuration) {
+	if prev == nil {
+		for _, s := range next.Servers {
+			fmt.Fprintf(w, "  = %-8s %s (%s)\n", s.Suffrage, s.ID, s.Address)
+		}
+		return
+	}
+	before := make(map[raft.ServerID]raft.Server, len(prev.Servers))
+	for _, s := range prev.Servers {
+		before[s.ID] = s
+	}
+	changed := false
+	for _, s := range next.Servers {
+		old, ok := before[s.ID]
+		delete(before, s.ID)
+		switch {
+		case !ok:
+			fmt.Fprintf(w, "  + %-8s %s (%s)\n", s.Suffrage, s.ID, s.Address)
+		case old.Suffrage != s.Suffrage:
+			fmt.Fprintf(w, "  ~ %-8s %s (%s), was %s\n", s.Suffrage, s.ID, s.Address, old.Suffrage)
+		case old.Address != s.Address:
+			fmt.Fprintf(w, "  ~ %-8s %s (%s), was at %s\n", s.Suffrage, s.ID, s.Address, old.Address)
+		default:
+			continue
+		}
+		changed = true
+	}
+	for _, s := range prev.Servers {
+		if _, ok := before[s.ID]; ok {
+			fmt.Fprintf(w, "  - %-8s %s (%s)\n", s.Suffrage, s.ID, s.Address)
+			changed = true
+		}
+	}
+	if !changed {
+		fmt.Fprintln(w, "  (no change)")
+	}
+}
+
+// formatData renders an entry payload as text if it is printable, in hex
+// otherwise.
+func formatData(data []byte) string {
+	if utf8.Valid(data) && !strings.ContainsAny(string(data), "\x00") {
+		return fmt.Sprintf("%q", data)
+	}
+	return fmt.Sprintf("0x%x", data)
+}
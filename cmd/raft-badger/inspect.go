@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/hashicorp/raft"
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+// uint64StableKeys are the stable store keys raft keeps numbers under.
+var uint64StableKeys = map[string]bool{"CurrentTerm": true, "LastVoteTerm": true}
+
+func runInspect(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("inspect", stdout)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("inspect: -path is required")
+	}
+	store, err := raftbadgerdb.New(raftbadgerdb.Options{Path: *path, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	stats, err := store.Stats()
+	if err != nil {
+		return err
+	}
+	c, err := store.LogComposition()
+	if err != nil {
+		return err
+	}
+	keys, err := store.StableKeys()
+	if err != nil {
+		return err
+	}
+	sizes, err := diskUsage(*path)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "first index:\t%d\n", stats.FirstIndex)
+	fmt.Fprintf(w, "last index:\t%d\n", stats.LastIndex)
+	fmt.Fprintf(w, "entries:\t%d\n", stats.LogEntries)
+	types := make([]raft.LogType, 0, len(c.ByType))
+	for t := range c.ByType {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, t := range types {
+		fmt.Fprintf(w, "  %s:\t%d\n", raftbadgerdb.LogTypeName(t), c.ByType[t].Entries)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "stable store:")
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	for _, k := range keys {
+		v, err := store.Get(k)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  %s:\t%s\n", k, formatStableValue(k, v))
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(w, "  (empty)")
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "disk usage:")
+	fmt.Fprintf(w, "  lsm tree:\t%d bytes\n", sizes.lsm)
+	fmt.Fprintf(w, "  value log:\t%d bytes\n", sizes.vlog)
+	fmt.Fprintf(w, "  total:\t%d bytes\n", sizes.total)
+	return w.Flush()
+}
+
+// formatStableValue renders a stable store value for humans: raft's terms
+// as numbers, text as text and anything else in hex.
+func formatStableValue(k, v []byte) string {
+	if uint64StableKeys[string(k)] && len(v) == 8 {
+		return fmt.Sprint(binary.BigEndian.Uint64(v))
+	}
+	if utf8.Valid(v) && !strings.ContainsAny(string(v), "\x00\n") {
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprintf("0x%x", v)
+}
+
+type dirSizes struct {
+	lsm, vlog, total int64
+}
+
+// diskUsage adds up the sizes of the files under path, by kind.
+func diskUsage(path string) (dirSizes, error) {
+	var sizes dirSizes
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		switch filepath.Ext(p) {
+		case ".sst":
+			sizes.lsm += info.Size()
+		case ".vlog":
+			sizes.vlog += info.Size()
+		}
+		sizes.total += info.Size()
+		return nil
+	})
+	return sizes, err
+}
@@ -0,0 +1,93 @@
+// Command raft-badger is a toolbox for inspecting and maintaining the data
+// directories of raft-badger stores. Nodes must be stopped before their
+// directory is handed to it, except to follow one with tail -f.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout io.Writer) error
+}
+
+var commands = []command{
+	{"inspect", "summarize the log, stable store and disk usage", runInspect},
+	{"migrate-codec", "re-encode every log entry with another codec", runMigrateCodec},
+	{"migrate-bolt", "copy a raft-boltdb store into a new store", runMigrateBolt},
+	{"dump", "print the log entries in an index range", runDump},
+	{"tail", "print the last log entries, or follow a running node's", runTail},
+	{"truncate", "delete the entries before or after an index", runTruncate},
+	{"repair", "find missing and undecodable entries and drop the damaged tail", runRepair},
+	{"composition", "break the log down by entry type and term", runComposition},
+	{"membership", "show every membership change in the stored log", runMembership},
+}
+
+// codecs maps the names accepted on the command line to codecs.
+var codecs = map[string]raftbadgerdb.Codec{
+	"gob":      raftbadgerdb.GobCodec{},
+	"msgpack":  raftbadgerdb.MsgpackCodec{},
+	"protobuf": raftbadgerdb.ProtobufCodec{},
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "raft-badger:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		usage(stdout)
+		return errors.New("missing command")
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:], stdout)
+		}
+	}
+	usage(stdout)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: raft-badger <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", c.name, c.summary)
+	}
+}
+
+// newFlagSet returns a flag set for a subcommand with the -path flag every
+// subcommand needs.
+func newFlagSet(name string, stdout io.Writer) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	path := fs.String("path", "", "raft-badger data directory")
+	return fs, path
+}
+
+func lookupCodec(name string) (raftbadgerdb.Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		names := make([]string, 0, len(codecs))
+		for n := range codecs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown codec %q, want one of: %s", name, strings.Join(names, ", "))
+	}
+	return c, nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+func testStoreDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raft-badger-cli")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store, err := raftbadgerdb.NewBadgerStore(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Data: []byte("log1")},
+		{Index: 2, Term: 1, Data: []byte("log2")},
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return dir
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	var out bytes.Buffer
+	if err := run([]string{"bogus"}, &out); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.Contains(out.String(), "migrate-codec") {
+		t.Fatalf("expected usage, got: %s", out.String())
+	}
+}
+
+func TestRun_MigrateCodec(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := run([]string{"migrate-codec", "-path", dir, "-to", "gob"}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out.String(), "2/2 entries") {
+		t.Fatalf("expected progress output, got: %s", out.String())
+	}
+
+	out.Reset()
+	if err := run([]string{"migrate-codec", "-path", dir, "-to", "gob", "-dry-run"}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out.String(), "entries:        2") {
+		t.Fatalf("expected a plan, got: %s", out.String())
+	}
+
+	if err := run([]string{"migrate-codec", "-path", dir, "-to", "nope"}, &out); err == nil {
+		t.Fatalf("expected an error for an unknown codec")
+	}
+}
+
+func TestRun_Composition(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := run([]string{"composition", "-path", dir}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, want := range []string{"command", "total", "TERM"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in output, got: %s", want, out.String())
+		}
+	}
+}
+
+func TestRun_Membership(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := run([]string{"membership", "-path", dir}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out.String(), "no membership changes") {
+		t.Fatalf("bad output: %s", out.String())
+	}
+}
+
+func TestRun_Inspect(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := run([]string{"inspect", "-path", dir}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, want := range []string{"last index:   2", "command:", "CurrentTerm:  1", "total:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in output, got: %s", want, out.String())
+		}
+	}
+}
+
+func TestRun_Dump(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := run([]string{"dump", "-path", dir, "-from", "2"}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if out.String() != "2\tterm 1\tcommand\t\"log2\"\n" {
+		t.Fatalf("bad output: %q", out.String())
+	}
+	out.Reset()
+	if err := run([]string{"dump", "-path", dir, "-to", "1", "-format", "ndjson"}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out.String(), `"index":1`) || strings.Contains(out.String(), `"index":2`) {
+		t.Fatalf("bad output: %s", out.String())
+	}
+	if err := run([]string{"dump", "-path", dir, "-format", "xml"}, &out); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
+
+func TestPrintMembershipChange(t *testing.T) {
+	prev := raft.Configuration{Servers: []raft.Server{
+		{Suffrage: raft.Voter, ID: "a", Address: "10.0.0.1:8300"},
+		{Suffrage: raft.Voter, ID: "b", Address: "10.0.0.2:8300"},
+		{Suffrage: raft.Nonvoter, ID: "c", Address: "10.0.0.3:8300"},
+	}}
+	next := raft.Configuration{Servers: []raft.Server{
+		{Suffrage: raft.Voter, ID: "a", Address: "10.0.0.1:8300"},
+		{Suffrage: raft.Voter, ID: "c", Address: "10.0.0.3:8300"},
+		{Suffrage: raft.Nonvoter, ID: "d", Address: "10.0.0.4:8300"},
+	}}
+	var out bytes.Buffer
+	printMembershipChange(&out, &prev, next)
+	expected := "  ~ Voter    c (10.0.0.3:8300), was Nonvoter\n" +
+		"  + Nonvoter d (10.0.0.4:8300)\n" +
+		"  - Voter    b (10.0.0.2:8300)\n"
+	if out.String() != expected {
+		t.Fatalf("bad output: %q", out.String())
+	}
+}
+
+func TestRun_Tail(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := run([]string{"tail", "-path", dir, "-n", "1"}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if out.String() != "2\tterm 1\tcommand\t\"log2\"\n" {
+		t.Fatalf("bad output: %q", out.String())
+	}
+	// Following needs a running node
+	if err := run([]string{"tail", "-path", dir, "-f"}, &out); !errors.Is(err, raftbadgerdb.ErrAttachUnavailable) {
+		t.Fatalf("expected ErrAttachUnavailable, got: %v", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRun_TailFollow(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+	live, err := raftbadgerdb.New(raftbadgerdb.Options{Path: dir, AllowAttach: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer live.Close()
+
+	stop := make(chan struct{})
+	defer func(orig func() (<-chan struct{}, func())) { interrupted = orig }(interrupted)
+	interrupted = func() (<-chan struct{}, func()) { return stop, func() {} }
+
+	var out syncBuffer
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- run([]string{"tail", "-path", dir, "-n", "1", "-f"}, &out)
+	}()
+	waitForOutput(t, &out, "log2")
+	if err := live.StoreLog(&raft.Log{Index: 3, Term: 2, Data: []byte("log3")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	waitForOutput(t, &out, "3\tterm 2\tcommand\t\"log3\"")
+	close(stop)
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.Contains(out.String(), "log1") {
+		t.Fatalf("bad output: %s", out.String())
+	}
+}
+
+func waitForOutput(t *testing.T, out *syncBuffer, want string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q in output, got: %s", want, out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRun_Truncate(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+	store, err := raftbadgerdb.NewBadgerStore(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for idx := uint64(3); idx <= 5; idx++ {
+		if err := store.StoreLog(&raft.Log{Index: idx, Term: 2, Data: []byte("log")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"truncate", "-path", dir, "-before", "2", "-after", "4", "-dry-run"}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, want := range []string{"would delete 1 entries, 1 (term 1) to 1 (term 1)", "would delete 1 entries, 5 (term 2)", "keeping 2 to 4"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in output, got: %s", want, out.String())
+		}
+	}
+	if err := run([]string{"truncate", "-path", dir, "-before", "6"}, &out); err == nil {
+		t.Fatalf("expected an error emptying the log")
+	}
+	if err := run([]string{"truncate", "-path", dir}, &out); err == nil {
+		t.Fatalf("expected an error without -before or -after")
+	}
+
+	if err := run([]string{"truncate", "-path", dir, "-before", "2", "-after", "4"}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store, err = raftbadgerdb.NewBadgerStore(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	first, _ := store.FirstIndex()
+	last, _ := store.LastIndex()
+	if first != 2 || last != 4 {
+		t.Fatalf("bad: %d to %d", first, last)
+	}
+}
+
+func TestRun_Repair(t *testing.T) {
+	dir := testStoreDir(t)
+	defer os.RemoveAll(dir)
+	store, err := raftbadgerdb.NewBadgerStore(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for idx := uint64(3); idx <= 6; idx++ {
+		if err := store.StoreLog(&raft.Log{Index: idx, Term: 1, Data: []byte("log")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	// Lose entry 3 and garble entry 5 behind the store's back
+	err = store.DB().Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(append([]byte("logs"), 0, 0, 0, 0, 0, 0, 0, 3)); err != nil {
+			return err
+		}
+		return txn.Set(append([]byte("logs"), 0, 0, 0, 0, 0, 0, 0, 5), []byte("garbage"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"repair", "-path", dir}, &out); err == nil {
+		t.Fatalf("expected an error for a damaged log")
+	}
+	for _, want := range []string{"gap: 3 to 3 missing", "undecodable at 5:", "intact: 1 to 2"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in output, got: %s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := run([]string{"repair", "-path", dir, "-drop-tail"}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out.String(), "deleted 3 to 6") {
+		t.Fatalf("bad output: %s", out.String())
+	}
+	out.Reset()
+	if err := run([]string{"repair", "-path", dir}, &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(out.String(), "scanned 1 to 2") || !strings.Contains(out.String(), "no damage found") {
+		t.Fatalf("bad output: %s", out.String())
+	}
+}
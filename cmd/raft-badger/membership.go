@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+func runMembership(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("membership", stdout)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("membership: -path is required")
+	}
+	store, err := raftbadgerdb.New(raftbadgerdb.Options{Path: *path, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	history, err := store.MembershipHistory()
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		fmt.Fprintln(stdout, "no membership changes in the stored log")
+		return nil
+	}
+	for _, change := range history {
+		fmt.Fprintf(stdout, "index %d, term %d:\n", change.Index, change.Term)
+		for _, s := range change.Configuration.Servers {
+			fmt.Fprintf(stdout, "  %-8s %s (%s)\n", s.Suffrage, s.ID, s.Address)
+		}
+	}
+	return nil
+}
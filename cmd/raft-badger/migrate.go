@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+	"github.com/markthethomas/raft-badger/boltmigrate"
+)
+
+func runMigrateCodec(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("migrate-codec", stdout)
+	fromName := fs.String("from", "gob", "codec the entries are currently encoded with")
+	toName := fs.String("to", "", "codec to re-encode the entries with")
+	dryRun := fs.Bool("dry-run", false, "report what the migration would do without changing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" || *toName == "" {
+		return errors.New("migrate-codec: -path and -to are required")
+	}
+	from, err := lookupCodec(*fromName)
+	if err != nil {
+		return err
+	}
+	to, err := lookupCodec(*toName)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		plan, err := raftbadgerdb.PlanCodecMigration(raftbadgerdb.Options{Path: *path}, from, to)
+		if err != nil {
+			return err
+		}
+		printPlan(stdout, plan)
+		return plan.Err()
+	}
+
+	err = raftbadgerdb.MigrateCodec(raftbadgerdb.Options{
+		Path: *path,
+		OnProgress: func(p raftbadgerdb.Progress) {
+			if p.Phase == "migrate-codec" {
+				fmt.Fprintf(stdout, "%s: %d/%d entries\n", p.Phase, p.Done, p.Total)
+			}
+		},
+	}, from, to)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "migration complete and verified")
+	return nil
+}
+
+func runMigrateBolt(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("migrate-bolt", stdout)
+	boltPath := fs.String("bolt", "", "raft-boltdb file to copy from")
+	dryRun := fs.Bool("dry-run", false, "report what the migration would do without changing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" || *boltPath == "" {
+		return errors.New("migrate-bolt: -path and -bolt are required")
+	}
+
+	if *dryRun {
+		plan, err := boltmigrate.PlanMigrateFromBolt(*boltPath, raftbadgerdb.Options{Path: *path})
+		if err != nil {
+			return err
+		}
+		printPlan(stdout, plan)
+		return plan.Err()
+	}
+
+	report, err := boltmigrate.MigrateFromBolt(*boltPath, raftbadgerdb.Options{
+		Path: *path,
+		OnProgress: func(p raftbadgerdb.Progress) {
+			if p.Phase == "migrate-bolt" {
+				fmt.Fprintf(stdout, "%s: %d/%d entries\n", p.Phase, p.Done, p.Total)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "migrated %d entries (%d to %d) and %d stable keys in %s, verified\n",
+		report.Logs, report.FirstIndex, report.LastIndex, report.StableKeys, report.Duration.Round(time.Millisecond))
+	return nil
+}
+
+// printPlan writes a migration plan for humans.
+func printPlan(w io.Writer, plan *raftbadgerdb.MigrationPlan) {
+	fmt.Fprintf(w, "%s dry run\n", plan.Migration)
+	fmt.Fprintf(w, "entries:        %d\n", plan.Entries)
+	fmt.Fprintf(w, "to rewrite:     %d\n", plan.Rewrites)
+	fmt.Fprintf(w, "estimated time: %s\n", plan.EstimatedDuration.Round(time.Millisecond))
+	if plan.FreeDiskSpace > 0 {
+		fmt.Fprintf(w, "disk space:     %d bytes needed, %d free\n", plan.RequiredDiskSpace, plan.FreeDiskSpace)
+	} else {
+		fmt.Fprintf(w, "disk space:     %d bytes needed\n", plan.RequiredDiskSpace)
+	}
+	for _, a := range plan.Anomalies {
+		fmt.Fprintf(w, "anomaly:        %s\n", a)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+// maxPrintedAnomalies caps how many anomalies repair lists.
+const maxPrintedAnomalies = 20
+
+func runRepair(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("repair", stdout)
+	dropTail := fs.Bool("drop-tail", false, "delete everything from the first damaged entry on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("repair: -path is required")
+	}
+	store, err := raftbadgerdb.New(raftbadgerdb.Options{Path: *path, ReadOnly: !*dropTail})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	report, err := store.CheckConsistency()
+	if err != nil {
+		return err
+	}
+	if report.Entries == 0 {
+		fmt.Fprintln(stdout, "the log is empty")
+		return nil
+	}
+	fmt.Fprintf(stdout, "scanned %d to %d\n", report.FirstIndex, report.LastIndex)
+	for i, a := range report.Anomalies {
+		if i == maxPrintedAnomalies {
+			fmt.Fprintf(stdout, "and %d more anomalies\n", len(report.Anomalies)-i)
+			break
+		}
+		fmt.Fprintln(stdout, a)
+	}
+	if report.Consistent() {
+		fmt.Fprintln(stdout, "no damage found")
+		return nil
+	}
+
+	var damaged uint64
+	for _, a := range report.Anomalies {
+		if a.Index > 0 && (damaged == 0 || a.Index < damaged) {
+			damaged = a.Index
+		}
+	}
+	if damaged == 0 {
+		return errors.New("repair: the log holds keys that aren't log keys, which dropping the tail doesn't remove")
+	}
+	tail := logRange{damaged, report.LastIndex}
+	if tail.min == report.FirstIndex {
+		return errors.New("repair: the first entry is damaged, nothing of the log is usable; restore the node from a snapshot instead")
+	}
+	fmt.Fprintf(stdout, "intact: %d to %d\n", report.FirstIndex, tail.min-1)
+	if !*dropTail {
+		fmt.Fprintf(stdout, "run with -drop-tail to delete %d to %d, which the leader then replicates again\n", tail.min, tail.max)
+		return errors.New("repair: the log is damaged")
+	}
+	if err := store.DeleteRange(tail.min, tail.max); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "deleted %d to %d\n", tail.min, tail.max)
+	gc, err := store.RunValueLogGC(truncateGCDiscardRatio)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "value log collected in %s\n", gc.Duration.Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftbadgerdb "github.com/markthethomas/raft-badger"
+)
+
+// truncateGCDiscardRatio is the discard ratio truncate collects the value
+// log with afterwards.
+const truncateGCDiscardRatio = 0.5
+
+// logRange is an inclusive range of log indexes.
+type logRange struct {
+	min, max uint64
+}
+
+func runTruncate(args []string, stdout io.Writer) error {
+	fs, path := newFlagSet("truncate", stdout)
+	before := fs.Uint64("before", 0, "delete the entries before this index")
+	after := fs.Uint64("after", 0, "delete the entries after this index")
+	dryRun := fs.Bool("dry-run", false, "print what would be deleted without changing anything")
+	compact := fs.Bool("compact", true, "garbage collect the value log afterwards")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("truncate: -path is required")
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["before"] && !set["after"] {
+		return errors.New("truncate: -before or -after is required")
+	}
+
+	// Opening for writing takes the directory lock, so this fails rather
+	// than truncating the log under a running node
+	store, err := raftbadgerdb.New(raftbadgerdb.Options{Path: *path, ReadOnly: *dryRun})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	first, err := store.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return err
+	}
+	if last == 0 {
+		return errors.New("truncate: the log is empty")
+	}
+
+	keep := logRange{first, last}
+	if set["before"] && *before > keep.min {
+		keep.min = *before
+	}
+	if set["after"] && *after < keep.max {
+		keep.max = *after
+	}
+	// An empty log makes raft start over from its snapshots, or from
+	// nothing; that is a job for Destroy, not for trimming
+	if keep.min > keep.max {
+		return fmt.Errorf("truncate: would delete every entry from %d to %d", first, last)
+	}
+	var deletes []logRange
+	if keep.min > first {
+		deletes = append(deletes, logRange{first, keep.min - 1})
+	}
+	if keep.max < last {
+		deletes = append(deletes, logRange{keep.max + 1, last})
+	}
+	if len(deletes) == 0 {
+		fmt.Fprintf(stdout, "nothing to delete, the log holds %d to %d\n", first, last)
+		return nil
+	}
+
+	for _, r := range deletes {
+		if err := describeRange(stdout, store, r, *dryRun); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(stdout, "keeping %d to %d\n", keep.min, keep.max)
+	if *dryRun {
+		return nil
+	}
+	for _, r := range deletes {
+		if err := store.DeleteRange(r.min, r.max); err != nil {
+			return err
+		}
+	}
+	if *compact {
+		report, err := store.RunValueLogGC(truncateGCDiscardRatio)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "value log collected in %s, %d bytes reclaimed\n",
+			report.Duration.Round(time.Millisecond), report.BytesReclaimed())
+	}
+	return nil
+}
+
+// describeRange prints the entries of r that truncate deletes, or would
+// delete in a dry run, with the terms at either end.
+func describeRange(w io.Writer, store *raftbadgerdb.BadgerStore, r logRange, dryRun bool) error {
+	verb := "deleting"
+	if dryRun {
+		verb = "would delete"
+	}
+	_, err := fmt.Fprintf(w, "%s %d entries, %d (%s) to %d (%s)\n",
+		verb, r.max-r.min+1, r.min, entryTerm(store, r.min), r.max, entryTerm(store, r.max))
+	return err
+}
+
+// entryTerm describes the term of entry idx. A corrupted entry, which may
+// be the reason for truncating, doesn't stop the truncation.
+func entryTerm(store *raftbadgerdb.BadgerStore, idx uint64) string {
+	log := new(raft.Log)
+	if err := store.GetLog(idx, log); err != nil {
+		return fmt.Sprintf("unreadable: %v", err)
+	}
+	return fmt.Sprintf("term %d", log.Term)
+}
@@ -0,0 +1,77 @@
+package raftbadgerdb
+
+import (
+	"sync"
+	"time"
+)
+
+// stableCoalescer merges StableStore writes from concurrent callers into one
+// transaction. A write made while no other is committing commits at once, so
+// a single writer, like raft setting its term and then its vote, never
+// waits. Writes arriving while a commit is in flight queue up and are
+// committed together once it is done, after waiting up to the window for
+// more to join. Every caller still returns only once its write is
+// committed, and writes are applied in arrival order, so a later write to a
+// key wins as it would without coalescing.
+type stableCoalescer struct {
+	b      *BadgerStore
+	window time.Duration
+
+	// commit is held by the writer committing a batch
+	commit sync.Mutex
+
+	mu         sync.Mutex
+	pending    []*stableWrite
+	committing bool
+}
+
+type stableWrite struct {
+	key, val []byte
+	done     chan error
+}
+
+// set queues a write and waits for the transaction carrying it. Whoever
+// takes the commit lock with its write still queued commits everything
+// queued so far.
+func (c *stableCoalescer) set(key, val []byte) error {
+	w := &stableWrite{key: key, val: val, done: make(chan error, 1)}
+	c.mu.Lock()
+	c.pending = append(c.pending, w)
+	contended := c.committing
+	c.mu.Unlock()
+
+	c.commit.Lock()
+	defer c.commit.Unlock()
+	select {
+	case err := <-w.done:
+		// Committed by whoever held the lock before
+		return err
+	default:
+	}
+	if contended && c.window > 0 {
+		// Others are writing too, give them a moment to join
+		time.Sleep(c.window)
+	}
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = nil
+	c.committing = true
+	c.mu.Unlock()
+
+	err := c.b.updateStable(func(txn *writeTxn) error {
+		for _, w := range batch {
+			if err := txn.Set(w.key, w.val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	c.b.metrics.addSample([]string{"stable_store", "coalesced_writes"}, float32(len(batch)))
+	c.mu.Lock()
+	c.committing = false
+	c.mu.Unlock()
+	for _, w := range batch {
+		w.done <- err
+	}
+	return <-w.done
+}
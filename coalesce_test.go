@@ -0,0 +1,135 @@
+package raftbadgerdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestBadgerStore_CoalesceStableWrites(t *testing.T) {
+	sink := testMetricsSink(t)
+
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:                 fh,
+		BadgerOptions:        &badgerOpts,
+		CoalesceStableWrites: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	// Writes arriving while a commit is in flight wait for it, then commit
+	// together
+	c := store.stableWrites
+	c.commit.Lock()
+	c.mu.Lock()
+	c.committing = true
+	c.mu.Unlock()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- store.SetUint64([]byte(fmt.Sprintf("key%d", i)), uint64(i))
+		}(i)
+	}
+	for queued := 0; queued < 10; {
+		time.Sleep(time.Millisecond)
+		c.mu.Lock()
+		queued = len(c.pending)
+		c.mu.Unlock()
+	}
+	c.mu.Lock()
+	c.committing = false
+	c.mu.Unlock()
+	c.commit.Unlock()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// Every write is visible once its call returns
+	for i := 0; i < 10; i++ {
+		v, err := store.GetUint64([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if v != uint64(i) {
+			t.Fatalf("bad value for key%d: %d", i, v)
+		}
+	}
+
+	// Writes in a batch apply in order
+	if err := store.Set([]byte("vote"), []byte("a")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("vote"), []byte("b")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	v, err := store.Get([]byte("vote"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(v) != "b" {
+		t.Fatalf("bad vote: %q", v)
+	}
+
+	sample, ok := sink.Data()[0].Samples["raft.badgerdb.stable_store.coalesced_writes"]
+	if !ok {
+		t.Fatalf("missing sample")
+	}
+	if sample.Count != 3 || sample.Sum != 12 {
+		t.Fatalf("expected concurrent writes to be coalesced, got %d transactions", sample.Count)
+	}
+}
+
+func TestBadgerStore_CoalesceStableWritesSequential(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:                 fh,
+		BadgerOptions:        &badgerOpts,
+		CoalesceStableWrites: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	// Term then vote, one after the other as raft writes them, don't wait
+	// out the window
+	start := time.Now()
+	for i := uint64(1); i <= 3; i++ {
+		if err := store.SetUint64([]byte("CurrentTerm"), i); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.Set([]byte("LastVoteCand"), []byte("node1")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("sequential writes took %s", elapsed)
+	}
+}
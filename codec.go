@@ -0,0 +1,394 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+)
+
+// Every stored log value starts with a one-byte tag naming the codec that
+// produced it, so entries written with different codecs can coexist in one
+// store. Codec tags live in 0x80-0x9f, and 0xa0-0xf7 tag envelopes that wrap
+// a tagged value, such as encryption. A gob stream always starts with a
+// message length, which is either below 0x80 or a negated byte count of 0xf8
+// and up, so values written before tags were introduced are still recognized
+// and decoded as plain gob.
+const (
+	codecTagMin    byte = 0x80
+	codecTagMax    byte = 0x9f
+	envelopeTagMin byte = 0xa0
+	envelopeTagMax byte = 0xf7
+
+	// GobCodecID is the tag written by GobCodec
+	GobCodecID byte = 0x81
+	// MsgpackCodecID is the tag written by MsgpackCodec
+	MsgpackCodecID byte = 0x82
+	// ProtobufCodecID is the tag written by ProtobufCodec
+	ProtobufCodecID byte = 0x83
+)
+
+// ErrUnknownCodec is returned when a stored value carries a codec tag that
+// this store has no codec for.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// ErrCodecDropsFields is returned by New and MigrateCodec for a SchemaCodec
+// that doesn't store every field of raft.Log, as happens when a raft upgrade
+// adds fields the codec predates.
+var ErrCodecDropsFields = errors.New("codec doesn't store every log field")
+
+// Codec encodes and decodes raft logs to and from the bytes stored in Badger.
+type Codec interface {
+	// ID is the tag byte written in front of every value the codec encodes.
+	// It must be unique and fall within 0x80-0x9f.
+	ID() byte
+	// Encode serializes a log.
+	Encode(log *raft.Log) ([]byte, error)
+	// Decode deserializes data produced by Encode into log.
+	Decode(data []byte, log *raft.Log) error
+}
+
+// SchemaCodec is implemented by codecs that store a fixed set of raft.Log
+// fields rather than every field by reflection, like ProtobufCodec. The
+// store refuses to write with one that doesn't list every field of the
+// raft.Log it was built against, so upgrading raft can't silently drop the
+// fields newer versions add. Codecs that don't implement it, like GobCodec
+// and MsgpackCodec, are trusted to store every field.
+type SchemaCodec interface {
+	Codec
+	// LogFields names the raft.Log fields the codec stores.
+	LogFields() []string
+}
+
+// GobCodec encodes logs with encoding/gob. It is the default codec.
+type GobCodec struct{}
+
+// ID implements Codec.
+func (GobCodec) ID() byte { return GobCodecID }
+
+// Encode implements Codec.
+func (GobCodec) Encode(log *raft.Log) ([]byte, error) {
+	var out bytes.Buffer
+	if err := gob.NewEncoder(&out).Encode(log); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decode implements Codec.
+func (GobCodec) Decode(data []byte, log *raft.Log) error {
+	// gob leaves fields holding zero values in the stream untouched
+	*log = raft.Log{}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(log)
+}
+
+// MsgpackCodec encodes logs with MessagePack, as raft-boltdb does. It is
+// faster than gob and its values are smaller, since gob repeats the type
+// description in every value.
+type MsgpackCodec struct{}
+
+// ID implements Codec.
+func (MsgpackCodec) ID() byte { return MsgpackCodecID }
+
+// Encode implements Codec.
+func (MsgpackCodec) Encode(log *raft.Log) ([]byte, error) {
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, &codec.MsgpackHandle{}).Encode(log); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Decode implements Codec.
+func (MsgpackCodec) Decode(data []byte, log *raft.Log) error {
+	*log = raft.Log{}
+	return codec.NewDecoderBytes(data, &codec.MsgpackHandle{}).Decode(log)
+}
+
+// ProtobufCodec encodes logs in the protocol buffers wire format of
+//
+//	message Log {
+//	  uint64 index = 1;
+//	  uint64 term = 2;
+//	  uint32 type = 3;
+//	  bytes data = 4;
+//	  bytes extensions = 5;
+//	  int64 appended_at_unix_nano = 6;
+//	}
+//
+// so other languages can read them with generated code. It produces the
+// smallest values of the built-in codecs.
+type ProtobufCodec struct{}
+
+// Fields of the protobuf Log message.
+const (
+	protoIndexField      = 1
+	protoTermField       = 2
+	protoTypeField       = 3
+	protoDataField       = 4
+	protoExtensionsField = 5
+	protoAppendedAtField = 6
+)
+
+// Protobuf wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// ID implements Codec.
+func (ProtobufCodec) ID() byte { return ProtobufCodecID }
+
+// Encode implements Codec.
+func (ProtobufCodec) Encode(log *raft.Log) ([]byte, error) {
+	out := make([]byte, 0, 6*binary.MaxVarintLen64+len(log.Data)+len(log.Extensions))
+	// Fields holding their zero value are left out, as in proto3
+	if log.Index != 0 {
+		out = binary.AppendUvarint(out, protoIndexField<<3|protoVarint)
+		out = binary.AppendUvarint(out, log.Index)
+	}
+	if log.Term != 0 {
+		out = binary.AppendUvarint(out, protoTermField<<3|protoVarint)
+		out = binary.AppendUvarint(out, log.Term)
+	}
+	if log.Type != 0 {
+		out = binary.AppendUvarint(out, protoTypeField<<3|protoVarint)
+		out = binary.AppendUvarint(out, uint64(log.Type))
+	}
+	if len(log.Data) > 0 {
+		out = binary.AppendUvarint(out, protoDataField<<3|protoBytes)
+		out = binary.AppendUvarint(out, uint64(len(log.Data)))
+		out = append(out, log.Data...)
+	}
+	if len(log.Extensions) > 0 {
+		out = binary.AppendUvarint(out, protoExtensionsField<<3|protoBytes)
+		out = binary.AppendUvarint(out, uint64(len(log.Extensions)))
+		out = append(out, log.Extensions...)
+	}
+	if !log.AppendedAt.IsZero() {
+		out = binary.AppendUvarint(out, protoAppendedAtField<<3|protoVarint)
+		out = binary.AppendUvarint(out, uint64(log.AppendedAt.UnixNano()))
+	}
+	return out, nil
+}
+
+// LogFields implements SchemaCodec.
+func (ProtobufCodec) LogFields() []string {
+	return []string{"Index", "Term", "Type", "Data", "Extensions", "AppendedAt"}
+}
+
+// Decode implements Codec. Unknown fields are skipped.
+func (ProtobufCodec) Decode(data []byte, log *raft.Log) error {
+	*log = raft.Log{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("protobuf: malformed field tag")
+		}
+		data = data[n:]
+		field, wire := tag>>3, tag&7
+		var v uint64
+		var b []byte
+		switch wire {
+		case protoVarint:
+			v, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("protobuf: malformed varint in field %d", field)
+			}
+			data = data[n:]
+		case protoFixed64, protoFixed32:
+			size := 8
+			if wire == protoFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return fmt.Errorf("protobuf: truncated field %d", field)
+			}
+			data = data[size:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return fmt.Errorf("protobuf: truncated field %d", field)
+			}
+			b = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d in field %d", wire, field)
+		}
+		switch {
+		case field == protoIndexField && wire == protoVarint:
+			log.Index = v
+		case field == protoTermField && wire == protoVarint:
+			log.Term = v
+		case field == protoTypeField && wire == protoVarint:
+			log.Type = raft.LogType(v)
+		case field == protoDataField && wire == protoBytes:
+			log.Data = append([]byte(nil), b...)
+		case field == protoExtensionsField && wire == protoBytes:
+			log.Extensions = append([]byte(nil), b...)
+		case field == protoAppendedAtField && wire == protoVarint:
+			log.AppendedAt = time.Unix(0, int64(v))
+		}
+	}
+	return nil
+}
+
+// builtinCodecs are always available for decoding, whichever codec the
+// store writes with.
+var builtinCodecs = map[byte]Codec{
+	GobCodecID:      GobCodec{},
+	MsgpackCodecID:  MsgpackCodec{},
+	ProtobufCodecID: ProtobufCodec{},
+}
+
+func validateCodec(c Codec) error {
+	if id := c.ID(); id < codecTagMin || id > codecTagMax {
+		return fmt.Errorf("codec id %#x outside of reserved range %#x-%#x", id, codecTagMin, codecTagMax)
+	}
+	if sc, ok := c.(SchemaCodec); ok {
+		if missing := missingLogFields(sc.LogFields()); len(missing) > 0 {
+			return fmt.Errorf("%w: codec %#x doesn't store %s", ErrCodecDropsFields, c.ID(), strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
+
+// missingLogFields returns the fields of raft.Log, in declaration order,
+// that aren't among fields.
+func missingLogFields(fields []string) []string {
+	known := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known[f] = true
+	}
+	var missing []string
+	t := reflect.TypeOf(raft.Log{})
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() && !known[f.Name] {
+			missing = append(missing, f.Name)
+		}
+	}
+	return missing
+}
+
+// encodeLog encodes log with the store's codec, prefixes the codec tag and
+// wraps the result in the store's envelopes.
+func (b *BadgerStore) encodeLog(log *raft.Log) ([]byte, error) {
+	return b.encodeLogWith(b.codec, log, false)
+}
+
+// encodeLogWith is encodeLog with codec c. A deduplicated entry, whose
+// payload was swapped for its hash, is marked with dedupTag inside the
+// checksum envelope, so a damaged mark is caught like any other damage.
+func (b *BadgerStore) encodeLogWith(c Codec, log *raft.Log, deduped bool) ([]byte, error) {
+	v, err := encodeWithCodec(c, log)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) >= b.minCompressSize {
+		v = compressValue(b.compression, v)
+	}
+	v, err = b.sealValue(v, logBinding(log.Index))
+	if err != nil {
+		return nil, err
+	}
+	if deduped {
+		v = append([]byte{dedupTag}, v...)
+	}
+	return checksumValue(v), nil
+}
+
+func encodeWithCodec(c Codec, log *raft.Log) ([]byte, error) {
+	data, err := c.Encode(log)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(data)+1)
+	out = append(out, c.ID())
+	return append(out, data...), nil
+}
+
+// decodeLog unwraps the value stored for entry idx and decodes it with
+// whichever codec its tag names. Untagged values are legacy gob. A
+// deduplicated payload is read in txn, the transaction the value was read
+// in, so it can't be released in between.
+func (b *BadgerStore) decodeLog(txn *badger.Txn, idx uint64, v []byte, log *raft.Log) error {
+	v, deduped, err := b.unwrapValue(idx, v)
+	if err != nil {
+		return err
+	}
+	c, data, err := b.codecFor(v)
+	if err != nil {
+		return err
+	}
+	if err := c.Decode(data, log); err != nil {
+		return err
+	}
+	if deduped {
+		log.Data, err = b.loadBlob(txn, log.Data)
+	}
+	return err
+}
+
+// codecFor returns the codec for a stored value along with the value with
+// its tag stripped.
+func (b *BadgerStore) codecFor(v []byte) (Codec, []byte, error) {
+	return lookupCodec(v, b.codec)
+}
+
+// unwrapValue removes every envelope from the value stored for entry idx,
+// leaving the codec-tagged encoding, and reports whether the value is a
+// deduplicated entry.
+func (b *BadgerStore) unwrapValue(idx uint64, v []byte) (_ []byte, deduped bool, err error) {
+	// Entries deduplicated before the mark moved inside the checksum
+	// envelope carry it in front
+	if len(v) > 0 && v[0] == dedupTag {
+		v, deduped = v[1:], true
+	}
+	if len(v) > 0 && v[0] == checksumTag {
+		if v, err = b.openChecksum(v); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(v) > 0 && v[0] == dedupTag {
+		v, deduped = v[1:], true
+	}
+	if isEncrypted(v) {
+		if v, err = b.openValue(v, logBinding(idx)); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(v) > 0 && v[0] == compressedTag {
+		v, err = decompressValue(v)
+	}
+	return v, deduped, err
+}
+
+// lookupCodec finds the codec named by the tag of an unwrapped value among
+// the given codecs and the built-in ones. Untagged values are legacy gob.
+func lookupCodec(v []byte, known ...Codec) (Codec, []byte, error) {
+	if len(v) == 0 || v[0] < codecTagMin || v[0] > envelopeTagMax {
+		return GobCodec{}, v, nil
+	}
+	if v[0] >= envelopeTagMin {
+		return nil, nil, fmt.Errorf("%w: unexpected envelope tag %#x", ErrUnknownCodec, v[0])
+	}
+	for _, c := range known {
+		if c != nil && c.ID() == v[0] {
+			return c, v[1:], nil
+		}
+	}
+	if c, ok := builtinCodecs[v[0]]; ok {
+		return c, v[1:], nil
+	}
+	return nil, nil, fmt.Errorf("%w: tag %#x", ErrUnknownCodec, v[0])
+}
@@ -0,0 +1,248 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+type badIDCodec struct{ GobCodec }
+
+func (badIDCodec) ID() byte { return 0x01 }
+
+// lossyCodec is a SchemaCodec predating AppendedAt.
+type lossyCodec struct{ ProtobufCodec }
+
+func (lossyCodec) LogFields() []string {
+	return []string{"Index", "Term", "Type", "Data", "Extensions"}
+}
+
+func TestBadgerStore_CodecTag(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	log := testRaftLog(1, "log1")
+	if err := store.StoreLog(log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The stored value should carry the gob tag, inside the checksum
+	err := store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.logKey(1))
+		if err != nil {
+			return err
+		}
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if v[0] != checksumTag || v[checksumEnvelopeSize] != GobCodecID {
+			t.Fatalf("expected gob tag, got %#x", v[:checksumEnvelopeSize+1])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result := new(raft.Log)
+	if err := store.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(log, result) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestBadgerStore_LegacyUntaggedValues(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Write a value the way the store did before codec tags existed
+	log := testRaftLog(1, string(bytes.Repeat([]byte("x"), 4096)))
+	var out bytes.Buffer
+	if err := gob.NewEncoder(&out).Encode(log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first := out.Bytes()[0]; first >= codecTagMin && first <= codecTagMax {
+		t.Fatalf("gob stream starts inside the codec tag range: %#x", first)
+	}
+	err := store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(defaultKeys.logKey(1), out.Bytes())
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result := new(raft.Log)
+	if err := store.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(log, result) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestBadgerStore_UnknownCodecTag(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	err := store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(defaultKeys.logKey(1), []byte{0x9f, 1, 2, 3})
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(1, new(raft.Log)); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("expected unknown codec error, got: %v", err)
+	}
+}
+
+func TestNew_InvalidCodec(t *testing.T) {
+	badgerOpts := badger.DefaultOptions
+	_, err := New(Options{Path: os.TempDir(), BadgerOptions: &badgerOpts, Codec: badIDCodec{}})
+	if err == nil {
+		t.Fatalf("expected an error for a codec id outside the reserved range")
+	}
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	logs := []*raft.Log{
+		{},
+		{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("data")},
+		{Index: ^uint64(0), Term: 1 << 40, Type: raft.LogConfiguration, Data: []byte{0, 0xff, 0x81}},
+		{Index: 7, Type: raft.LogNoop},
+	}
+	for _, c := range []Codec{GobCodec{}, MsgpackCodec{}, ProtobufCodec{}} {
+		for _, log := range logs {
+			data, err := c.Encode(log)
+			if err != nil {
+				t.Fatalf("%T: err: %s", c, err)
+			}
+			// Decoding must overwrite whatever was there
+			result := &raft.Log{Index: 99, Data: []byte("stale")}
+			if err := c.Decode(data, result); err != nil {
+				t.Fatalf("%T: err: %s", c, err)
+			}
+			if result.Index != log.Index || result.Term != log.Term || result.Type != log.Type || !bytes.Equal(result.Data, log.Data) {
+				t.Fatalf("%T: expected %#v, got %#v", c, log, result)
+			}
+		}
+	}
+}
+
+func TestCodecs_RoundTripLogFields(t *testing.T) {
+	log := &raft.Log{
+		Index:      3,
+		Term:       2,
+		Type:       raft.LogCommand,
+		Data:       []byte("data"),
+		Extensions: []byte("extensions"),
+		AppendedAt: time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC),
+	}
+	for _, c := range []Codec{GobCodec{}, MsgpackCodec{}, ProtobufCodec{}} {
+		store := testBadgerStore(t)
+		store.codec = c
+		if err := store.StoreLog(log); err != nil {
+			t.Fatalf("%T: err: %s", c, err)
+		}
+		store.cache.invalidate()
+		result := new(raft.Log)
+		if err := store.GetLog(3, result); err != nil {
+			t.Fatalf("%T: err: %s", c, err)
+		}
+		if !bytes.Equal(result.Extensions, log.Extensions) || !result.AppendedAt.Equal(log.AppendedAt) {
+			t.Fatalf("%T: expected %#v, got %#v", c, log, result)
+		}
+		store.Close()
+		os.RemoveAll(store.path)
+	}
+}
+
+func TestNew_CodecDropsFields(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	_, err = New(Options{Path: fh, Codec: lossyCodec{}})
+	if !errors.Is(err, ErrCodecDropsFields) || !strings.Contains(err.Error(), "AppendedAt") {
+		t.Fatalf("expected ErrCodecDropsFields naming AppendedAt, got: %v", err)
+	}
+}
+
+func TestProtobufCodec_Wire(t *testing.T) {
+	log := &raft.Log{Index: 1, Term: 2, Type: raft.LogCommand, Data: []byte("hi")}
+	data, err := ProtobufCodec{}.Encode(log)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Hand-encoded: field 1 = 1, field 2 = 2, field 4 = "hi"; LogCommand is
+	// zero and left out
+	expected := []byte{0x08, 0x01, 0x10, 0x02, 0x22, 0x02, 'h', 'i'}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected %x, got %x", expected, data)
+	}
+
+	// Fields a newer schema adds are skipped
+	extended := append([]byte{0x38, 0x05, 0x42, 0x01, 'x', 0x49, 1, 2, 3, 4, 5, 6, 7, 8}, expected...)
+	result := new(raft.Log)
+	if err := (ProtobufCodec{}).Decode(extended, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Index != 1 || result.Term != 2 || string(result.Data) != "hi" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	for _, bad := range [][]byte{{0x08}, {0x22, 0x05, 'h'}, {0x0b}} {
+		if err := (ProtobufCodec{}).Decode(bad, result); err == nil {
+			t.Fatalf("expected an error decoding %x", bad)
+		}
+	}
+}
+
+func TestBadgerStore_MixedCodecs(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	if err := store.StoreLog(testRaftLog(1, "gob")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// Switching codecs leaves earlier entries readable
+	for i, c := range []Codec{MsgpackCodec{}, ProtobufCodec{}} {
+		badgerOpts := badger.DefaultOptions
+		store, err := New(Options{Path: store.path, BadgerOptions: &badgerOpts, Codec: c})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.StoreLog(testRaftLog(uint64(i+2), "new")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		store.Close()
+	}
+
+	store = testReopen(t, store.path)
+	defer store.Close()
+	result := new(raft.Log)
+	for idx := uint64(1); idx <= 3; idx++ {
+		if err := store.GetLog(idx, result); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if result.Index != idx {
+			t.Fatalf("bad: %#v", result)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// CompactionReport describes the outcome of a truncation or garbage
+// collection run, so operators can verify the store actually shrinks after
+// snapshots.
+type CompactionReport struct {
+	// Trigger names the operation that produced the report: "delete-range",
+	// "value-log-gc", "scheduled-gc", "scheduled-stable-gc" or "close"
+	Trigger string
+	// EntriesRemoved is the number of log entries deleted
+	EntriesRemoved uint64
+	// BytesBefore and BytesAfter are the on-disk sizes of the store directory
+	BytesBefore int64
+	BytesAfter  int64
+	// Duration is how long the operation took
+	Duration time.Duration
+
+	start    time.Time
+	measured bool
+}
+
+// BytesReclaimed returns how many bytes on disk were freed. It is negative
+// when the store grew, which is normal right after a truncation because
+// Badger writes tombstones before compaction reclaims the space.
+func (r CompactionReport) BytesReclaimed() int64 {
+	return r.BytesBefore - r.BytesAfter
+}
+
+// startCompaction begins a report. Disk usage is only measured when asked
+// for or when someone is listening, so stores without an OnCompaction
+// callback pay nothing on DeleteRange.
+func (b *BadgerStore) startCompaction(trigger string, measure bool) *CompactionReport {
+	report := &CompactionReport{
+		Trigger:  trigger,
+		start:    time.Now(),
+		measured: measure || b.onCompaction != nil || b.hooks.GCCompleted != nil && trigger != "delete-range",
+	}
+	if report.measured {
+		report.BytesBefore, _ = dirSize(b.path)
+	}
+	return report
+}
+
+// finishCompaction completes the report and delivers it.
+func (b *BadgerStore) finishCompaction(report *CompactionReport) {
+	report.Duration = time.Since(report.start)
+	if report.measured {
+		report.BytesAfter, _ = dirSize(b.path)
+	}
+	b.metrics.compaction(report)
+	args := []interface{}{"trigger", report.Trigger, "entries_removed", report.EntriesRemoved, "duration", report.Duration}
+	if report.measured {
+		args = append(args, "bytes_reclaimed", report.BytesReclaimed())
+	}
+	b.logger.Debug("compaction finished", args...)
+	if b.onCompaction != nil {
+		b.onCompaction(*report)
+	}
+	if b.hooks.GCCompleted != nil && report.Trigger != "delete-range" {
+		b.hooks.GCCompleted(report.BytesReclaimed())
+	}
+}
+
+// RunValueLogGC runs Badger's value log garbage collection until there is
+// nothing left to rewrite and reports the space reclaimed. discardRatio has
+// the same meaning as in badger.DB.RunValueLogGC.
+func (b *BadgerStore) RunValueLogGC(discardRatio float64) (CompactionReport, error) {
+	if b.badgerOpts.ReadOnly {
+		return CompactionReport{}, ErrReadOnly
+	}
+	var report CompactionReport
+	err := b.run(context.Background(), func(context.Context) (err error) {
+		report, err = b.runValueLogGC("value-log-gc", discardRatio, time.Time{})
+		return err
+	})
+	return report, err
+}
+
+// closeGCDiscardRatio is the discard ratio used by Options.CompactOnClose.
+const closeGCDiscardRatio = 0.5
+
+// runValueLogGC runs value log garbage collection until there is nothing
+// left to rewrite or, if deadline is set, until it passes.
+func (b *BadgerStore) runValueLogGC(trigger string, discardRatio float64, deadline time.Time) (CompactionReport, error) {
+	report := b.startCompaction(trigger, true)
+	err := b.collectValueLog(b.db, discardRatio, func() bool {
+		return deadline.IsZero() || time.Now().Before(deadline)
+	})
+	if err != nil {
+		return *report, err
+	}
+	b.finishCompaction(report)
+	return *report, nil
+}
+
+// collectValueLog runs value log garbage collection of db for as long as
+// there is something left to rewrite and keepGoing returns true.
+func (b *BadgerStore) collectValueLog(db *badger.DB, discardRatio float64, keepGoing func() bool) error {
+	for keepGoing() {
+		err := b.maintenanceRetry.do(b.metrics, b.logger, retryMaintenance, func() error {
+			return db.RunValueLogGC(discardRatio)
+		})
+		if err == badger.ErrNoRewrite {
+			if db == b.db {
+				b.gcDone()
+			}
+			return nil
+		}
+		if err == badger.ErrRejected {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirSize sums the sizes of all regular files below path.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
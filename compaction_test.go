@@ -0,0 +1,111 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_OnCompaction(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	var reports []CompactionReport
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:          fh,
+		BadgerOptions: &badgerOpts,
+		OnCompaction: func(r CompactionReport) {
+			reports = append(reports, r)
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+		testRaftLog(3, "log3"),
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	report := reports[0]
+	if report.Trigger != "delete-range" {
+		t.Fatalf("bad trigger: %q", report.Trigger)
+	}
+	if report.EntriesRemoved != 2 {
+		t.Fatalf("expected 2 entries removed, got %d", report.EntriesRemoved)
+	}
+	if report.BytesBefore == 0 || report.BytesAfter == 0 {
+		t.Fatalf("expected disk usage to be measured: %+v", report)
+	}
+}
+
+func TestBadgerStore_RunValueLogGC(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	report, err := store.RunValueLogGC(0.5)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if report.Trigger != "value-log-gc" {
+		t.Fatalf("bad trigger: %q", report.Trigger)
+	}
+	if report.BytesBefore == 0 {
+		t.Fatalf("expected disk usage to be measured: %+v", report)
+	}
+}
+
+func TestBadgerStore_CompactOnClose(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	var reports []CompactionReport
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:           fh,
+		BadgerOptions:  &badgerOpts,
+		CompactOnClose: time.Second,
+		OnCompaction: func(r CompactionReport) {
+			reports = append(reports, r)
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(reports) != 1 || reports[0].Trigger != "close" {
+		t.Fatalf("expected a close report, got %+v", reports)
+	}
+	if reports[0].Duration > 2*time.Second {
+		t.Fatalf("compaction overran its budget: %v", reports[0].Duration)
+	}
+}
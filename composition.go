@@ -0,0 +1,74 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+// CompositionBucket totals a group of log entries.
+type CompositionBucket struct {
+	Entries uint64
+	// PayloadBytes sums the Data fields, StoredBytes the encoded values
+	// as written to Badger
+	PayloadBytes uint64
+	StoredBytes  uint64
+}
+
+func (c *CompositionBucket) add(log *raft.Log, stored int) {
+	c.Entries++
+	c.PayloadBytes += uint64(len(log.Data))
+	c.StoredBytes += uint64(stored)
+}
+
+// LogComposition breaks the stored log down by entry type and by term, to
+// help diagnose logs dominated by no-ops, barriers or oversized commands.
+type LogComposition struct {
+	Total  CompositionBucket
+	ByType map[raft.LogType]CompositionBucket
+	ByTerm map[uint64]CompositionBucket
+}
+
+// LogComposition scans the whole log and returns its composition.
+func (b *BadgerStore) LogComposition() (*LogComposition, error) {
+	c := &LogComposition{
+		ByType: map[raft.LogType]CompositionBucket{},
+		ByTerm: map[uint64]CompositionBucket{},
+	}
+	err := b.run(context.Background(), func(context.Context) error {
+		return b.scanLogs(LogFilter{}, func(log *raft.Log, item scannedItem) error {
+			c.Total.add(log, item.storedSize)
+			byType := c.ByType[log.Type]
+			byType.add(log, item.storedSize)
+			c.ByType[log.Type] = byType
+			byTerm := c.ByTerm[log.Term]
+			byTerm.add(log, item.storedSize)
+			c.ByTerm[log.Term] = byTerm
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LogTypeName returns a readable name for a raft log type.
+func LogTypeName(t raft.LogType) string {
+	switch t {
+	case raft.LogCommand:
+		return "command"
+	case raft.LogNoop:
+		return "noop"
+	case raft.LogAddPeerDeprecated:
+		return "add-peer"
+	case raft.LogRemovePeerDeprecated:
+		return "remove-peer"
+	case raft.LogBarrier:
+		return "barrier"
+	case raft.LogConfiguration:
+		return "configuration"
+	}
+	return fmt.Sprintf("unknown(%d)", t)
+}
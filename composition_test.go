@@ -0,0 +1,53 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_LogComposition(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogNoop},
+		{Index: 2, Term: 1, Type: raft.LogCommand, Data: []byte("abc")},
+		{Index: 3, Term: 2, Type: raft.LogNoop},
+		{Index: 4, Term: 2, Type: raft.LogCommand, Data: []byte("defgh")},
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	c, err := store.LogComposition()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if c.Total.Entries != 4 || c.Total.PayloadBytes != 8 {
+		t.Fatalf("bad total: %+v", c.Total)
+	}
+	if c.Total.StoredBytes <= c.Total.PayloadBytes {
+		t.Fatalf("stored bytes should include encoding overhead: %+v", c.Total)
+	}
+	if got := c.ByType[raft.LogNoop]; got.Entries != 2 || got.PayloadBytes != 0 {
+		t.Fatalf("bad noop bucket: %+v", got)
+	}
+	if got := c.ByType[raft.LogCommand]; got.Entries != 2 || got.PayloadBytes != 8 {
+		t.Fatalf("bad command bucket: %+v", got)
+	}
+	if got := c.ByTerm[2]; got.Entries != 2 || got.PayloadBytes != 5 {
+		t.Fatalf("bad term bucket: %+v", got)
+	}
+}
+
+func TestLogTypeName(t *testing.T) {
+	if name := LogTypeName(raft.LogConfiguration); name != "configuration" {
+		t.Fatalf("bad: %s", name)
+	}
+	if name := LogTypeName(raft.LogType(42)); name != "unknown(42)" {
+		t.Fatalf("bad: %s", name)
+	}
+}
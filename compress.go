@@ -0,0 +1,105 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedTag marks a compressed value. The envelope is the tag, the
+// Compression it was compressed with and the compressed value, which is the
+// codec-tagged entry. It sits inside the encryption envelope, since
+// encrypted data doesn't compress.
+const compressedTag byte = 0xc1
+
+// Compression selects how log entries are compressed before they are
+// stored. Entries are only stored compressed when that makes them smaller,
+// and every entry records how it was stored, so a store can switch
+// compression at any time and read its older entries as before.
+type Compression byte
+
+const (
+	// CompressionNone stores entries as they are
+	CompressionNone Compression = iota
+	// CompressionSnappy compresses with Snappy, which is very fast and
+	// compresses moderately
+	CompressionSnappy
+	// CompressionZstd compresses with Zstandard at its default level, which
+	// compresses much better for more CPU
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+var errMalformedCompression = errors.New("malformed compressed value")
+
+// zstd encoders and decoders are safe for concurrent use through EncodeAll
+// and DecodeAll, so every store shares one of each.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func initZstd() {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+}
+
+// compressValue returns v in the compression envelope if compressing it
+// with c makes it smaller, and v itself otherwise.
+func compressValue(c Compression, v []byte) []byte {
+	var out []byte
+	header := []byte{compressedTag, byte(c)}
+	switch c {
+	case CompressionSnappy:
+		out = append(header, snappy.Encode(nil, v)...)
+	case CompressionZstd:
+		initZstd()
+		out = zstdEncoder.EncodeAll(v, header)
+	default:
+		return v
+	}
+	if len(out) >= len(v) {
+		return v
+	}
+	return out
+}
+
+// decompressValue strips the compression envelope from v.
+func decompressValue(v []byte) ([]byte, error) {
+	if len(v) < 2 {
+		return nil, fmt.Errorf("%w: truncated envelope", errMalformedCompression)
+	}
+	switch Compression(v[1]) {
+	case CompressionSnappy:
+		out, err := snappy.Decode(nil, v[2:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedCompression, err)
+		}
+		return out, nil
+	case CompressionZstd:
+		initZstd()
+		out, err := zstdDecoder.DecodeAll(v[2:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedCompression, err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%w: unknown compression %d", errMalformedCompression, v[1])
+}
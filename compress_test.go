@@ -0,0 +1,170 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testStoredTag(t *testing.T, store *BadgerStore, idx uint64) byte {
+	var tag byte
+	err := store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.logKey(idx))
+		if err != nil {
+			return err
+		}
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		tag = v[checksumEnvelopeSize]
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return tag
+}
+
+func TestBadgerStore_Compression(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	compressible := bytes.Repeat([]byte("set key=value;"), 100)
+	random := make([]byte, 1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	payloads := map[uint64][]byte{}
+
+	// Each store writes two entries with its compression, and reads every
+	// entry written before it
+	idx := uint64(0)
+	for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd, CompressionNone} {
+		store, err := New(Options{Path: fh, Compression: c})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		for _, data := range [][]byte{compressible, random} {
+			idx++
+			if err := store.StoreLog(&raft.Log{Index: idx, Data: data}); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			payloads[idx] = data
+		}
+		if tag := testStoredTag(t, store, idx-1); (tag == compressedTag) != (c != CompressionNone) {
+			t.Fatalf("%s: bad tag %#x", c, tag)
+		}
+		log := new(raft.Log)
+		for i, data := range payloads {
+			if err := store.GetLog(i, log); err != nil || !bytes.Equal(log.Data, data) {
+				t.Fatalf("%s: bad: %d %v", c, i, err)
+			}
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if _, err := New(Options{Path: fh, Compression: CompressionZstd + 1}); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestCompressValue(t *testing.T) {
+	random := make([]byte, 1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	compressible := bytes.Repeat([]byte("set key=value;"), 100)
+	for _, c := range []Compression{CompressionSnappy, CompressionZstd} {
+		// Compression that doesn't pay off is skipped
+		if v := compressValue(c, random); !bytes.Equal(v, random) {
+			t.Fatalf("%s: random data compressed", c)
+		}
+		v := compressValue(c, compressible)
+		if v[0] != compressedTag || Compression(v[1]) != c || len(v) >= len(compressible) {
+			t.Fatalf("%s: bad: %#x", c, v[:2])
+		}
+		out, err := decompressValue(v)
+		if err != nil || !bytes.Equal(out, compressible) {
+			t.Fatalf("%s: bad: %v", c, err)
+		}
+		if _, err := decompressValue(v[:len(v)/2]); !errors.Is(err, errMalformedCompression) {
+			t.Fatalf("%s: err: %v", c, err)
+		}
+	}
+}
+
+func TestBadgerStore_CompressionEncrypted(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	key := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	store, err := New(Options{Path: fh, Compression: CompressionZstd, EncryptionKey: &key})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	data := bytes.Repeat([]byte("set key=value;"), 100)
+	if err := store.StoreLog(&raft.Log{Index: 1, Data: data}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if tag := testStoredTag(t, store, 1); tag != boundEncryptedTag {
+		t.Fatalf("bad tag %#x", tag)
+	}
+	log := new(raft.Log)
+	if err := store.GetLog(1, log); err != nil || !bytes.Equal(log.Data, data) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestBadgerStore_MinCompressSize(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	if _, err := New(Options{Path: fh, Compression: CompressionSnappy, MinCompressSize: -1}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	store, err := New(Options{Path: fh, Compression: CompressionSnappy, MinCompressSize: 512})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	// Both entries compress, but only the large one is above the threshold
+	small := bytes.Repeat([]byte("hb;"), 20)
+	large := bytes.Repeat([]byte("set key=value;"), 100)
+	for i, data := range [][]byte{small, large} {
+		if err := store.StoreLog(&raft.Log{Index: uint64(i + 1), Data: data}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if tag := testStoredTag(t, store, 1); tag == compressedTag {
+		t.Fatalf("small entry compressed")
+	}
+	if tag := testStoredTag(t, store, 2); tag != compressedTag {
+		t.Fatalf("bad tag %#x", tag)
+	}
+	log := new(raft.Log)
+	for i, data := range [][]byte{small, large} {
+		if err := store.GetLog(uint64(i+1), log); err != nil || !bytes.Equal(log.Data, data) {
+			t.Fatalf("bad: %d %v", i+1, err)
+		}
+	}
+}
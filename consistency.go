@@ -0,0 +1,141 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// AnomalyKind classifies a problem found by CheckConsistency.
+type AnomalyKind int
+
+const (
+	// AnomalyGap is a run of indexes missing between the first and the
+	// last index
+	AnomalyGap AnomalyKind = iota
+	// AnomalyUndecodable is an entry whose value can't be read or decoded,
+	// or a key in the log that isn't a log key
+	AnomalyUndecodable
+	// AnomalyIndexMismatch is an entry stored under an index other than its
+	// own
+	AnomalyIndexMismatch
+	// AnomalyTermRegression is an entry with a lower term than the entry
+	// before it
+	AnomalyTermRegression
+)
+
+func (k AnomalyKind) String() string {
+	switch k {
+	case AnomalyGap:
+		return "gap"
+	case AnomalyUndecodable:
+		return "undecodable"
+	case AnomalyIndexMismatch:
+		return "index mismatch"
+	case AnomalyTermRegression:
+		return "term regression"
+	default:
+		return fmt.Sprintf("AnomalyKind(%d)", int(k))
+	}
+}
+
+// Anomaly is a problem found by CheckConsistency.
+type Anomaly struct {
+	Kind AnomalyKind
+	// Index is the entry concerned, or the first missing index of a gap.
+	// It is 0 for a key that isn't a log key
+	Index uint64
+	// Through is the last missing index of a gap, and Index otherwise
+	Through uint64
+	// Detail describes the problem
+	Detail string
+}
+
+func (a Anomaly) String() string {
+	if a.Kind == AnomalyGap {
+		return fmt.Sprintf("gap: %d to %d missing", a.Index, a.Through)
+	}
+	return fmt.Sprintf("%s at %d: %s", a.Kind, a.Index, a.Detail)
+}
+
+// ConsistencyReport is the outcome of CheckConsistency.
+type ConsistencyReport struct {
+	// FirstIndex and LastIndex are the lowest and highest index stored
+	FirstIndex uint64
+	LastIndex  uint64
+	// Entries is the number of entries found
+	Entries uint64
+	// Anomalies lists every problem found, in index order
+	Anomalies []Anomaly
+}
+
+// Consistent reports whether no anomalies were found.
+func (r *ConsistencyReport) Consistent() bool {
+	return len(r.Anomalies) == 0
+}
+
+// CheckConsistency reads the whole log and checks that its indexes are
+// contiguous from the first to the last, that every entry decodes and is
+// stored under its own index, and that terms never decrease. Problems are
+// listed in the report rather than returned as errors; the error is only
+// set when the log couldn't be read at all. The check runs within a single
+// read transaction, so the store can stay in use meanwhile.
+func (b *BadgerStore) CheckConsistency() (*ConsistencyReport, error) {
+	report := &ConsistencyReport{}
+	err := b.run(context.Background(), func(context.Context) error {
+		return b.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			log := new(raft.Log)
+			var prevIdx, prevTerm uint64
+			for it.Seek(b.keys.logs); it.ValidForPrefix(b.keys.logs); it.Next() {
+				item := it.Item()
+				idx, err := b.keys.parseLogKey(item.Key())
+				if err != nil {
+					report.add(AnomalyUndecodable, 0, err.Error())
+					continue
+				}
+				if b.isPendingDelete(idx) {
+					continue
+				}
+				if report.Entries == 0 {
+					report.FirstIndex = idx
+				} else if idx > prevIdx+1 {
+					report.Anomalies = append(report.Anomalies, Anomaly{Kind: AnomalyGap, Index: prevIdx + 1, Through: idx - 1})
+				}
+				report.Entries++
+				report.LastIndex, prevIdx = idx, idx
+
+				v, err := item.Value()
+				if err == nil {
+					*log = raft.Log{}
+					err = b.decodeLog(txn, idx, v, log)
+				}
+				if err != nil {
+					report.add(AnomalyUndecodable, idx, err.Error())
+					continue
+				}
+				if log.Index != idx {
+					report.add(AnomalyIndexMismatch, idx, fmt.Sprintf("holds entry %d", log.Index))
+				}
+				if log.Term < prevTerm {
+					report.add(AnomalyTermRegression, idx, fmt.Sprintf("term %d follows term %d", log.Term, prevTerm))
+				}
+				prevTerm = log.Term
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (r *ConsistencyReport) add(kind AnomalyKind, idx uint64, detail string) {
+	r.Anomalies = append(r.Anomalies, Anomaly{Kind: kind, Index: idx, Through: idx, Detail: detail})
+}
@@ -0,0 +1,73 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_CheckConsistency(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+
+	report, err := store.CheckConsistency()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !report.Consistent() || report.FirstIndex != 1 || report.LastIndex != 5 || report.Entries != 5 {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	if err := store.StoreLogs([]*raft.Log{
+		{Index: 6, Term: 3, Data: []byte("log6")},
+		{Index: 7, Term: 2, Data: []byte("log7")},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = store.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.logKey(1))
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set(defaultKeys.logKey(2), v); err != nil {
+			return err
+		}
+		if err := txn.Delete(defaultKeys.logKey(3)); err != nil {
+			return err
+		}
+		return txn.Set(defaultKeys.logKey(5), []byte("garbage"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	report, err = store.CheckConsistency()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var kinds []AnomalyKind
+	var indexes []uint64
+	for _, a := range report.Anomalies {
+		kinds = append(kinds, a.Kind)
+		indexes = append(indexes, a.Index)
+	}
+	expectedKinds := []AnomalyKind{AnomalyIndexMismatch, AnomalyGap, AnomalyUndecodable, AnomalyTermRegression}
+	if !reflect.DeepEqual(kinds, expectedKinds) || !reflect.DeepEqual(indexes, []uint64{2, 3, 5, 7}) {
+		t.Fatalf("bad: %v", report.Anomalies)
+	}
+	if report.Consistent() || report.Entries != 6 || report.LastIndex != 7 {
+		t.Fatalf("bad: %#v", report)
+	}
+	if s := report.Anomalies[1].String(); s != "gap: 3 to 3 missing" {
+		t.Fatalf("bad: %s", s)
+	}
+}
@@ -0,0 +1,116 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_ContextVariants(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	logs := []*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2"), testRaftLog(3, "log3")}
+	if err := store.StoreLogsContext(ctx, logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLogContext(ctx, testRaftLog(4, "log4")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first, err := store.FirstIndexContext(ctx); err != nil || first != 1 {
+		t.Fatalf("bad: %d, %v", first, err)
+	}
+	if last, err := store.LastIndexContext(ctx); err != nil || last != 4 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+	log := new(raft.Log)
+	if err := store.GetLogContext(ctx, 2, log); err != nil || !reflect.DeepEqual(log, logs[1]) {
+		t.Fatalf("bad: %#v, %v", log, err)
+	}
+	out := []*raft.Log{log, nil}
+	if n, err := store.GetLogsContext(ctx, 2, 3, out); err != nil || n != 2 {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+	if out[0] != log || !reflect.DeepEqual(out[1], logs[2]) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if err := store.DeleteRangeContext(ctx, 1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetContext(ctx, []byte("k"), []byte("v")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v, err := store.GetContext(ctx, []byte("k")); err != nil || string(v) != "v" {
+		t.Fatalf("bad: %q, %v", v, err)
+	}
+	if err := store.SetUint64Context(ctx, []byte("CurrentTerm"), 7); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v, err := store.GetUint64Context(ctx, []byte("CurrentTerm")); err != nil || v != 7 {
+		t.Fatalf("bad: %d, %v", v, err)
+	}
+
+	// Nothing is read or written under a cancelled context
+	cancel()
+	log = new(raft.Log)
+	if err := store.GetLogContext(ctx, 3, log); err != context.Canceled || log.Index != 0 {
+		t.Fatalf("bad: %#v, %v", log, err)
+	}
+	if err := store.StoreLogsContext(ctx, []*raft.Log{testRaftLog(5, "log5")}); err != context.Canceled {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.DeleteRangeContext(ctx, 3, 4); err != context.Canceled {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.SetContext(ctx, []byte("k"), []byte("w")); err != context.Canceled {
+		t.Fatalf("err: %v", err)
+	}
+	if first, last := testBounds(t, store); first != 3 || last != 4 {
+		t.Fatalf("bad: %d to %d", first, last)
+	}
+	if v, err := store.Get([]byte("k")); err != nil || string(v) != "v" {
+		t.Fatalf("bad: %q, %v", v, err)
+	}
+}
+
+func TestBadgerStore_AbandonOnContext(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	// A blocked operation is abandoned once the deadline passes
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	start := time.Now()
+	err := store.run(ctx, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	if err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+		t.Fatalf("bad: %v after %s", err, time.Since(start))
+	}
+
+	// Backoff between retries ends with the context too
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: time.Hour}
+	attempts := 0
+	err = policy.doContext(ctx, store.metrics, store.logger, retryRead, func() error {
+		attempts++
+		return syscall.EIO
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 1 {
+		t.Fatalf("bad: %v after %d attempts", err, attempts)
+	}
+}
@@ -0,0 +1,175 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// defaultCopyBatchSize is how many entries CopyStore stores per StoreLogs
+// call unless CopyOptions.BatchSize is set.
+const defaultCopyBatchSize = 512
+
+// ErrCopyVerification is returned by CopyStore when the destination
+// doesn't hold what was copied.
+var ErrCopyVerification = errors.New("copied store doesn't match its source")
+
+// RaftStore is a raft log store and stable store in one, as BadgerStore
+// and raft-boltdb's BoltStore are.
+type RaftStore interface {
+	raft.LogStore
+	raft.StableStore
+}
+
+// StableKeyLister is implemented by stores that can list their stable
+// store keys, such as BadgerStore.
+type StableKeyLister interface {
+	StableKeys() ([][]byte, error)
+}
+
+// raftStableKeys are the stable store keys raft itself uses.
+var raftStableKeys = [][]byte{[]byte("CurrentTerm"), []byte("LastVoteTerm"), []byte("LastVoteCand")}
+
+// CopyOptions tunes CopyStore.
+type CopyOptions struct {
+	// StableKeys lists the stable store keys to copy from a source that
+	// isn't a StableKeyLister. It defaults to the keys raft uses, so only
+	// applications keeping their own values in the stable store need it
+	StableKeys [][]byte
+	// BatchSize is how many entries are stored per StoreLogs call, 512
+	// unless set
+	BatchSize int
+	// OnProgress, if set, receives progress reports with the "copy" phase
+	OnProgress ProgressFunc
+}
+
+// CopyReport summarizes a CopyStore run.
+type CopyReport struct {
+	Logs       uint64
+	StableKeys int
+	FirstIndex uint64
+	LastIndex  uint64
+	Duration   time.Duration
+}
+
+// CopyStore copies every log entry and stable store value of src into dst,
+// which can be any raft store implementation, such as raft-boltdb's, so
+// data can move out of a BadgerStore as easily as into one. Afterwards it
+// checks that dst has the same first and last index and stable store
+// values. Neither store may be in use by raft meanwhile. Keys missing from
+// src, for which it returns a "not found" error or no value, are skipped.
+func CopyStore(src, dst RaftStore, options CopyOptions) (*CopyReport, error) {
+	start := time.Now()
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultCopyBatchSize
+	}
+	report := &CopyReport{}
+	if err := copyLogs(src, dst, options, report); err != nil {
+		return nil, err
+	}
+	keys := options.StableKeys
+	lister, listed := src.(StableKeyLister)
+	if listed {
+		var err error
+		if keys, err = lister.StableKeys(); err != nil {
+			return nil, err
+		}
+	} else if keys == nil {
+		keys = raftStableKeys
+	}
+	var copied [][]byte
+	for _, k := range keys {
+		v, err := src.Get(k)
+		// Some stores, raft's InmemStore among them, return no value for
+		// missing keys instead
+		if isNotFound(err) || err == nil && v == nil && !listed {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading stable store key %q: %w", k, err)
+		}
+		if err := dst.Set(k, v); err != nil {
+			return nil, err
+		}
+		copied = append(copied, k)
+	}
+	report.StableKeys = len(copied)
+	if err := verifyCopy(src, dst, copied, report); err != nil {
+		return nil, err
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+func copyLogs(src, dst RaftStore, options CopyOptions, report *CopyReport) error {
+	first, err := src.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := src.LastIndex()
+	if err != nil {
+		return err
+	}
+	report.FirstIndex, report.LastIndex = first, last
+	if last == 0 {
+		return nil
+	}
+	reporter := newProgressReporter(options.OnProgress, "copy", last-first+1)
+	reporter.report(0)
+	batch := make([]*raft.Log, 0, options.BatchSize)
+	for idx := first; idx <= last; idx++ {
+		log := new(raft.Log)
+		if err := src.GetLog(idx, log); err != nil {
+			return fmt.Errorf("reading log entry %d: %w", idx, err)
+		}
+		batch = append(batch, log)
+		if len(batch) < options.BatchSize && idx < last {
+			continue
+		}
+		if err := dst.StoreLogs(batch); err != nil {
+			return err
+		}
+		report.Logs += uint64(len(batch))
+		reporter.report(report.Logs)
+		batch = batch[:0]
+	}
+	return nil
+}
+
+// verifyCopy checks dst against src after CopyStore.
+func verifyCopy(src, dst RaftStore, keys [][]byte, report *CopyReport) error {
+	first, err := dst.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := dst.LastIndex()
+	if err != nil {
+		return err
+	}
+	if first != report.FirstIndex || last != report.LastIndex {
+		return fmt.Errorf("%w: log from %d to %d, expected %d to %d", ErrCopyVerification, first, last, report.FirstIndex, report.LastIndex)
+	}
+	for _, k := range keys {
+		expected, err := src.Get(k)
+		if err != nil {
+			return err
+		}
+		got, err := dst.Get(k)
+		if err != nil {
+			return fmt.Errorf("%w: stable store key %q: %v", ErrCopyVerification, k, err)
+		}
+		if !bytes.Equal(got, expected) {
+			return fmt.Errorf("%w: stable store key %q differs", ErrCopyVerification, k)
+		}
+	}
+	return nil
+}
+
+// isNotFound reports whether a stable store reported a missing key. raft
+// itself recognizes them by their message, which every store shares.
+func isNotFound(err error) bool {
+	return err != nil && (err == ErrKeyNotFound || err.Error() == ErrKeyNotFound.Error())
+}
@@ -0,0 +1,93 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_StableKeys(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	for _, k := range []string{"CurrentTerm", "", "with space"} {
+		if err := store.Set([]byte(k), []byte("v")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	keys, err := store.StableKeys()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	found := map[string]bool{}
+	for _, k := range keys {
+		found[string(k)] = true
+	}
+	if len(keys) != 3 || !found["CurrentTerm"] || !found[""] || !found["with space"] {
+		t.Fatalf("bad: %q", keys)
+	}
+}
+
+func TestCopyStore(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	if err := store.DeleteRange(1, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 4); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("app-key"), []byte("app")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Out of BadgerStore, every stable key comes along
+	inmem := raft.NewInmemStore()
+	var progress []Progress
+	report, err := CopyStore(store, inmem, CopyOptions{BatchSize: 3, OnProgress: func(p Progress) {
+		progress = append(progress, p)
+	}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if report.Logs != 4 || report.FirstIndex != 2 || report.LastIndex != 5 || report.StableKeys != 2 {
+		t.Fatalf("bad: %#v", report)
+	}
+	if len(progress) != 3 || progress[2].Done != 4 {
+		t.Fatalf("bad: %#v", progress)
+	}
+	if v, err := inmem.Get([]byte("app-key")); err != nil || string(v) != "app" {
+		t.Fatalf("bad: %q, %v", v, err)
+	}
+
+	// Back into a fresh BadgerStore, raft's own keys are copied by default
+	fresh := testBadgerStore(t)
+	defer fresh.Close()
+	defer os.RemoveAll(fresh.path)
+	report, err = CopyStore(inmem, fresh, CopyOptions{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if report.StableKeys != 1 {
+		t.Fatalf("bad: %#v", report)
+	}
+	if term, err := fresh.GetUint64([]byte("CurrentTerm")); err != nil || term != 4 {
+		t.Fatalf("bad: %d, %v", term, err)
+	}
+	for idx := uint64(2); idx <= 5; idx++ {
+		expected, got := new(raft.Log), new(raft.Log)
+		if err := store.GetLog(idx, expected); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := fresh.GetLog(idx, got); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if got.Term != expected.Term || !bytes.Equal(got.Data, expected.Data) {
+			t.Fatalf("bad: %#v, expected %#v", got, expected)
+		}
+	}
+}
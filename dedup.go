@@ -0,0 +1,217 @@
+package raftbadgerdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// dedupTag marks a log entry whose payload is stored separately by content
+// hash. The tag goes right inside the checksum envelope, followed by the
+// entry sealed as usual, with the payload replaced by its hash, see
+// blobHashOf. Entries written by earlier versions carry the tag in front of
+// the checksum envelope instead.
+const dedupTag byte = 0xd1
+
+// plainBlobTag starts a payload stored without encryption. Encrypted ones
+// start with boundEncryptedTag, or encryptedTag if written by an earlier
+// version, instead.
+const plainBlobTag byte = 0x00
+
+type blobHash [sha256.Size]byte
+
+// blobRefs collects the reference count changes of one transaction, so
+// entries written and removed together only touch each count once.
+type blobRefs struct {
+	deltas map[blobHash]int64
+	data   map[blobHash][]byte
+}
+
+func newBlobRefs() *blobRefs {
+	return &blobRefs{deltas: make(map[blobHash]int64), data: make(map[blobHash][]byte)}
+}
+
+// dedupLog returns the log to encode in place of log, with a large payload
+// swapped for its hash and counted in refs, and whether it did so.
+func (b *BadgerStore) dedupLog(log *raft.Log, refs *blobRefs) (*raft.Log, bool) {
+	if b.dedupMinSize == 0 || len(log.Data) < b.dedupMinSize {
+		return log, false
+	}
+	h := b.blobHashOf(log.Data)
+	refs.deltas[h]++
+	refs.data[h] = log.Data
+	ref := *log
+	ref.Data = h[:]
+	return &ref, true
+}
+
+// blobHashOf returns the hash naming the payload data: its SHA-256, or,
+// when payloads are encrypted, an HMAC keyed from the active key, so that
+// without the key blob names neither tell which entries share a payload nor
+// confirm a guessed one. Payloads stored before a key rotation keep their
+// names, so identical ones written afterwards are stored again.
+func (b *BadgerStore) blobHashOf(data []byte) blobHash {
+	if b.cipher != nil {
+		if h, ok := b.cipher.blobHash(data); ok {
+			return h
+		}
+	}
+	return sha256.Sum256(data)
+}
+
+// encodeDedupedLog encodes log for storing under key, deduplicating its
+// payload and releasing the payload of the entry it overwrites, if any.
+func (b *BadgerStore) encodeDedupedLog(txn *writeTxn, key []byte, log *raft.Log, refs *blobRefs) ([]byte, error) {
+	if b.dedupMinSize == 0 {
+		return b.encodeLog(log)
+	}
+	item, err := txn.Get(key)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+	if err == nil {
+		v, err := item.Value()
+		if err != nil {
+			return nil, err
+		}
+		if err := b.releaseBlob(log.Index, v, refs); err != nil {
+			return nil, err
+		}
+	}
+	log, deduped := b.dedupLog(log, refs)
+	return b.encodeLogWith(b.codec, log, deduped)
+}
+
+// isDeduped reports whether the stored value v is marked as a deduplicated
+// entry, before checking its checksum.
+func isDeduped(v []byte) bool {
+	if len(v) > 0 && v[0] == dedupTag {
+		return true
+	}
+	return len(v) > checksumEnvelopeSize && v[0] == checksumTag && v[checksumEnvelopeSize] == dedupTag
+}
+
+// releaseBlob counts the removal of the value v stored for entry idx in
+// refs, if it references a payload.
+func (b *BadgerStore) releaseBlob(idx uint64, v []byte, refs *blobRefs) error {
+	h, ok, err := b.blobRef(idx, v)
+	if ok {
+		refs.deltas[h]--
+	}
+	return err
+}
+
+// blobRef returns the hash of the payload the value v stored for entry idx
+// references, if it does.
+func (b *BadgerStore) blobRef(idx uint64, v []byte) (blobHash, bool, error) {
+	var h blobHash
+	if !isDeduped(v) {
+		return h, false, nil
+	}
+	v, _, err := b.unwrapValue(idx, v)
+	if err != nil {
+		return h, false, err
+	}
+	c, data, err := b.codecFor(v)
+	if err != nil {
+		return h, false, err
+	}
+	ref := new(raft.Log)
+	if err := c.Decode(data, ref); err != nil {
+		return h, false, err
+	}
+	if len(ref.Data) != sha256.Size {
+		return h, false, fmt.Errorf("malformed payload reference of %d bytes", len(ref.Data))
+	}
+	copy(h[:], ref.Data)
+	return h, true, nil
+}
+
+// merge adds the reference count changes of o to refs.
+func (refs *blobRefs) merge(o *blobRefs) {
+	for h, delta := range o.deltas {
+		refs.deltas[h] += delta
+	}
+	for h, data := range o.data {
+		refs.data[h] = data
+	}
+}
+
+// apply writes the reference counts changed in refs, storing payloads on
+// their first reference and deleting them with their last. Payloads refs
+// holds no data for, such as those RestoreScoped loads, are expected to be
+// stored already.
+func (refs *blobRefs) apply(txn *writeTxn) error {
+	for h, delta := range refs.deltas {
+		if delta == 0 {
+			continue
+		}
+		count := int64(0)
+		item, err := txn.Get(txn.b.keys.blobRefKey(h))
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			count = int64(binary.BigEndian.Uint64(v))
+		}
+		if count+delta <= 0 {
+			if err := txn.Delete(txn.b.keys.blobDataKey(h)); err != nil {
+				return err
+			}
+			if err := txn.Delete(txn.b.keys.blobRefKey(h)); err != nil {
+				return err
+			}
+			continue
+		}
+		if plain, ok := refs.data[h]; count == 0 && ok {
+			data := append([]byte{plainBlobTag}, plain...)
+			if txn.b.cipher != nil && txn.b.cipher.encrypting() {
+				data, err = txn.b.cipher.seal(plain, blobBinding(h))
+				if err != nil {
+					return err
+				}
+			}
+			if err := txn.Set(txn.b.keys.blobDataKey(h), data); err != nil {
+				return err
+			}
+		}
+		if err := txn.Set(txn.b.keys.blobRefKey(h), uint64ToBytes(uint64(count+delta))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadBlob returns the payload stored under hash as of txn.
+func (b *BadgerStore) loadBlob(txn *badger.Txn, hash []byte) ([]byte, error) {
+	if len(hash) != sha256.Size {
+		return nil, fmt.Errorf("malformed payload reference of %d bytes", len(hash))
+	}
+	var h blobHash
+	copy(h[:], hash)
+	item, err := txn.Get(b.keys.blobDataKey(h))
+	if err == badger.ErrKeyNotFound {
+		return nil, fmt.Errorf("missing deduplicated payload %x", hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(data) {
+		return b.openValue(data, blobBinding(h))
+	}
+	if len(data) == 0 || data[0] != plainBlobTag {
+		return nil, fmt.Errorf("malformed deduplicated payload %x", hash)
+	}
+	return data[1:], nil
+}
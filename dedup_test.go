@@ -0,0 +1,293 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// testBlobRefs returns the reference count of every stored payload.
+func testBlobRefs(t *testing.T, store *BadgerStore) map[blobHash]uint64 {
+	refs := make(map[blobHash]uint64)
+	err := store.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(defaultKeys.blob); it.ValidForPrefix(defaultKeys.blob); it.Next() {
+			key := it.Item().Key()[len(defaultKeys.blob):]
+			var h blobHash
+			copy(h[:], key[1:])
+			if key[0] == 'd' {
+				if _, ok := refs[h]; !ok {
+					refs[h] = 0
+				}
+				continue
+			}
+			v, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+			refs[h] = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return refs
+}
+
+func TestBadgerStore_Dedup(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, DedupMinSize: 64})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	blob := bytes.Repeat([]byte("config"), 20)
+	other := bytes.Repeat([]byte("retry"), 20)
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Data: blob},
+		{Index: 2, Term: 1, Data: blob},
+		{Index: 3, Term: 1, Data: []byte("small")},
+		{Index: 4, Term: 1, Data: blob},
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	refs := testBlobRefs(t, store)
+	if len(refs) != 1 || refs[sha256.Sum256(blob)] != 3 {
+		t.Fatalf("expected one payload with 3 references, got: %v", refs)
+	}
+
+	// Overwriting an entry moves its reference
+	store.allowOutOfOrder = true
+	if err := store.StoreLog(&raft.Log{Index: 4, Term: 2, Data: other}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	refs = testBlobRefs(t, store)
+	if len(refs) != 2 || refs[sha256.Sum256(blob)] != 2 || refs[sha256.Sum256(other)] != 1 {
+		t.Fatalf("bad references after overwrite: %v", refs)
+	}
+
+	result := new(raft.Log)
+	if err := store.GetLog(2, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(result.Data, blob) || result.Index != 2 {
+		t.Fatalf("bad: %#v", result)
+	}
+	if err := store.GetLog(3, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "small" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Deleting the last references frees the payload
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	refs = testBlobRefs(t, store)
+	if len(refs) != 1 || refs[sha256.Sum256(other)] != 1 {
+		t.Fatalf("bad references after delete: %v", refs)
+	}
+	store.Close()
+
+	// Readable without the option
+	badgerOpts = badger.DefaultOptions
+	store, err = New(Options{Path: fh, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if err := store.GetLog(4, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(result.Data, other) || result.Term != 2 {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestBadgerStore_DedupEncrypted(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	key := &EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{0x42}, 32)}
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, DedupMinSize: 8, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	secret := []byte("a secret payload")
+	logs := []*raft.Log{{Index: 1, Data: secret}, {Index: 2, Data: secret}}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Payloads are named by a keyed hash, which can't be checked against a
+	// guess without the key
+	h := store.blobHashOf(secret)
+	if h == sha256.Sum256(secret) {
+		t.Fatalf("payload named by its plain hash")
+	}
+	if refs := testBlobRefs(t, store); len(refs) != 1 || refs[h] != 2 {
+		t.Fatalf("bad: %v", refs)
+	}
+	err = store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.blobDataKey(h))
+		if err != nil {
+			return err
+		}
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(v, secret) {
+			t.Fatalf("payload stored in the clear")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result := new(raft.Log)
+	if err := store.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(result.Data, secret) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestNew_DedupWithSoftDelete(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	badgerOpts := badger.DefaultOptions
+	if _, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, DedupMinSize: 64, SoftDeleteGracePeriod: time.Hour}); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestBadgerStore_DedupReadDuringDelete(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, DedupMinSize: 64})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	blob := bytes.Repeat([]byte("config"), 20)
+	if err := store.StoreLog(&raft.Log{Index: 1, Term: 1, Data: blob}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A read that found the reference before DeleteRange released the
+	// payload still finds the payload
+	txn := store.db.NewTransaction(false)
+	defer txn.Discard()
+	item, err := txn.Get(defaultKeys.logKey(1))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(1, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if refs := testBlobRefs(t, store); len(refs) != 0 {
+		t.Fatalf("bad: %v", refs)
+	}
+	result := new(raft.Log)
+	if err := store.decodeLog(txn, 1, v, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(result.Data, blob) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestBadgerStore_DedupChecksummed(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, DedupMinSize: 64})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	blob := bytes.Repeat([]byte("config"), 20)
+	if err := store.StoreLog(&raft.Log{Index: 1, Term: 1, Data: blob}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var v []byte
+	err = store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.logKey(1))
+		if err != nil {
+			return err
+		}
+		v, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// The reference is marked inside the checksum envelope
+	if v[0] != checksumTag || v[checksumEnvelopeSize] != dedupTag {
+		t.Fatalf("bad: %x", v)
+	}
+	set := func(v []byte) {
+		err := store.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(defaultKeys.logKey(1), v)
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		store.cache.invalidate()
+	}
+
+	// Entries marked in front of the envelope, as earlier versions did,
+	// still read back
+	legacy := append([]byte{dedupTag}, checksumValue(v[checksumEnvelopeSize+1:])...)
+	set(legacy)
+	result := new(raft.Log)
+	if err := store.GetLog(1, result); err != nil || !bytes.Equal(result.Data, blob) {
+		t.Fatalf("bad: %#v, %v", result, err)
+	}
+
+	// A damaged mark fails the checksum
+	damaged := append([]byte(nil), v...)
+	damaged[checksumEnvelopeSize] ^= 0xff
+	set(damaged)
+	var corrupt *ErrCorruptLog
+	if err := store.GetLog(1, result); !errors.As(err, &corrupt) || corrupt.Index != 1 {
+		t.Fatalf("expected a corrupt log, got: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrDestroyNotConfirmed is returned by Destroy when the confirmation does
+// not name the store's directory.
+var ErrDestroyNotConfirmed = errors.New("destroy not confirmed")
+
+// Destroy closes the store and deletes its data for good. As a guard
+// against tearing down the wrong store, confirm must be the path the store
+// was opened with (any spelling of the same directory will do). Badger's
+// directory is removed entirely; the store's own directory is removed too
+// unless something else has been put in it. The store can't be used
+// afterwards.
+func (b *BadgerStore) Destroy(confirm string) error {
+	if b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	canonical, err := canonicalPath(confirm)
+	if err != nil {
+		return err
+	}
+	if canonical != b.claimedPath {
+		return fmt.Errorf("%w: store is at %s, not %s", ErrDestroyNotConfirmed, b.claimedPath, canonical)
+	}
+	b.stopBackground()
+	if closed, err := b.ops.drain(b.closeTimeout); err != nil {
+		return err
+	} else if closed || !b.ops.markClosed() {
+		return ErrStoreClosed
+	}
+	// Keep the path claimed until the files are gone, so the store can't be
+	// reopened halfway through
+	defer releasePath(b.claimedPath)
+	if err := b.closeDB(0); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(badgerDir(b.path)); err != nil {
+		return err
+	}
+	return removeIfEmpty(b.path)
+}
+
+// removeIfEmpty removes the directory at path if it has no entries.
+func removeIfEmpty(path string) error {
+	dir, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = dir.Readdirnames(1)
+	dir.Close()
+	if err != io.EOF {
+		return err
+	}
+	return os.Remove(path)
+}
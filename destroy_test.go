@@ -0,0 +1,65 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBadgerStore_Destroy(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.Path())
+
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Destroy(os.TempDir()); !errors.Is(err, ErrDestroyNotConfirmed) {
+		t.Fatalf("expected destroy to need confirmation, got: %v", err)
+	}
+	// The store is still usable after a refused destroy
+	if _, err := store.LastIndex(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := store.Destroy(store.Path() + "/"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := os.Stat(store.Path()); !os.IsNotExist(err) {
+		t.Fatalf("expected the store directory to be removed, got: %v", err)
+	}
+
+	// The path is released and can be used again
+	reopened, err := NewBadgerStore(store.Path())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer reopened.Close()
+	last, err := reopened.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last != 0 {
+		t.Fatalf("expected an empty store, got last index %d", last)
+	}
+}
+
+func TestBadgerStore_DestroyKeepsForeignFiles(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.Path())
+
+	foreign := filepath.Join(store.Path(), "snapshots")
+	if err := ioutil.WriteFile(foreign, []byte("keep"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Destroy(store.Path()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Path(), "badger")); !os.IsNotExist(err) {
+		t.Fatalf("expected the badger directory to be removed, got: %v", err)
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Fatalf("expected other files to be kept: %v", err)
+	}
+}
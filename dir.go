@@ -0,0 +1,35 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// createDirSynced creates dir and any missing parents. After each directory
+// is created its parent is fsynced, so the creation itself survives a crash
+// right after bootstrap instead of leaving a node whose raft directory has
+// vanished.
+func createDirSynced(dir string) error {
+	dir = filepath.Clean(dir)
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+		if err := syncDir(filepath.Dir(missing[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
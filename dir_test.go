@@ -0,0 +1,47 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateDirSynced(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	dir := filepath.Join(fh, "a", "b", "c")
+	if err := createDirSynced(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Fatalf("expected %s to be created: %v", dir, err)
+	}
+
+	// Creating an existing directory is a no-op
+	if err := createDirSynced(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestNewBadgerStore_CreatesMissingPath(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	path := filepath.Join(fh, "raft", "node1")
+	store, err := NewBadgerStore(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if _, err := os.Stat(filepath.Join(path, "badger")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
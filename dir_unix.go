@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package raftbadgerdb
+
+import "os"
+
+// syncDir fsyncs a directory so changes to its entries are durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package raftbadgerdb
+
+// syncDir is a no-op on Windows, which cannot fsync directories. NTFS
+// journals directory entries itself.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,169 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrInsufficientDiskSpace is returned by New when the store's filesystem
+// has less free space than Options.MinFreeDiskSpace. Badger can corrupt its
+// files when the disk fills up in the middle of a compaction.
+var ErrInsufficientDiskSpace = errors.New("insufficient disk space")
+
+// errDiskSpaceUnsupported is returned by freeDiskSpace on platforms it can't
+// query. Disk space checks are skipped there.
+var errDiskSpaceUnsupported = errors.New("free disk space is not available on this platform")
+
+// FreeDiskSpace returns the number of bytes available to the store on its
+// filesystem.
+func (b *BadgerStore) FreeDiskSpace() (uint64, error) {
+	return freeDiskSpace(b.path)
+}
+
+// checkDiskSpace refuses a store directory with less than min bytes free.
+func checkDiskSpace(path string, min uint64) error {
+	if min == 0 {
+		return nil
+	}
+	free, err := freeDiskSpace(path)
+	if err == errDiskSpaceUnsupported {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if free < min {
+		return fmt.Errorf("%w: %d bytes free in %s, need %d", ErrInsufficientDiskSpace, free, path, min)
+	}
+	return nil
+}
+
+// defaultDiskWatchdogInterval is how often free space is checked when a
+// low disk space watchdog is set up without a DiskSpaceCheckInterval.
+const defaultDiskWatchdogInterval = time.Minute
+
+// diskSampleWindow is the number of free space samples the days-until-full
+// projection is based on.
+const diskSampleWindow = 60
+
+// DiskSpaceReport describes the free space of the store's filesystem when
+// the low disk space watchdog fires.
+type DiskSpaceReport struct {
+	// Free is the number of bytes available to the store
+	Free uint64
+	// BytesPerDay is the rate free space has been shrinking at recently, 0
+	// when it isn't shrinking or there is too little history yet
+	BytesPerDay float64
+	// DaysUntilFull projects when the filesystem fills up at that rate. It
+	// is +Inf when free space isn't shrinking
+	DaysUntilFull float64
+}
+
+// diskSample is one free space measurement.
+type diskSample struct {
+	at   time.Time
+	free uint64
+}
+
+// diskMonitor periodically publishes the free space of the store's
+// filesystem and runs the low disk space watchdog.
+type diskMonitor struct {
+	b   *BadgerStore
+	min uint64
+
+	lowBytes uint64
+	lowDays  float64
+	onLow    func(DiskSpaceReport)
+	low      bool
+	samples  []diskSample
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (b *BadgerStore) startDiskMonitor(interval time.Duration, options Options) {
+	m := &diskMonitor{
+		b:        b,
+		min:      options.MinFreeDiskSpace,
+		lowBytes: options.LowDiskSpaceBytes,
+		lowDays:  options.LowDiskSpaceDays,
+		onLow:    options.OnLowDiskSpace,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	b.disk = m
+	go m.run(interval)
+}
+
+func (m *diskMonitor) run(interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	m.check()
+	for {
+		select {
+		case <-ticker.C:
+			m.check()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *diskMonitor) check() {
+	free, err := m.b.FreeDiskSpace()
+	if err != nil {
+		return
+	}
+	m.observe(time.Now(), free)
+}
+
+// observe records a free space sample, publishes it and fires the watchdog
+// when a threshold is crossed. The callback fires once per crossing, and
+// again only after free space has recovered in between.
+func (m *diskMonitor) observe(at time.Time, free uint64) {
+	m.b.metrics.setGauge([]string{"disk", "free_bytes"}, float32(free))
+	if free < m.min {
+		m.b.metrics.incrCounter([]string{"disk", "low_space"}, 1)
+	}
+
+	m.samples = append(m.samples, diskSample{at: at, free: free})
+	if len(m.samples) > diskSampleWindow {
+		m.samples = m.samples[1:]
+	}
+	report := m.report()
+	if !math.IsInf(report.DaysUntilFull, 1) {
+		m.b.metrics.setGauge([]string{"disk", "days_until_full"}, float32(report.DaysUntilFull))
+	}
+
+	low := (m.lowBytes > 0 && free < m.lowBytes) ||
+		(m.lowDays > 0 && report.DaysUntilFull < m.lowDays)
+	if low && !m.low {
+		m.b.metrics.incrCounter([]string{"disk", "watchdog_triggered"}, 1)
+		if m.onLow != nil {
+			m.onLow(report)
+		}
+	}
+	m.low = low
+}
+
+// report projects the sampled free space forward.
+func (m *diskMonitor) report() DiskSpaceReport {
+	newest := m.samples[len(m.samples)-1]
+	report := DiskSpaceReport{Free: newest.free, DaysUntilFull: math.Inf(1)}
+	oldest := m.samples[0]
+	days := newest.at.Sub(oldest.at).Hours() / 24
+	if days <= 0 || newest.free >= oldest.free {
+		return report
+	}
+	report.BytesPerDay = float64(oldest.free-newest.free) / days
+	report.DaysUntilFull = float64(newest.free) / report.BytesPerDay
+	return report
+}
+
+func (m *diskMonitor) close() {
+	close(m.stop)
+	<-m.done
+}
@@ -0,0 +1,134 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestBadgerStore_FreeDiskSpace(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	free, err := store.FreeDiskSpace()
+	if err == errDiskSpaceUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if free == 0 {
+		t.Fatalf("expected some free space")
+	}
+}
+
+func TestNew_MinFreeDiskSpace(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	if _, err := freeDiskSpace(fh); err == errDiskSpaceUnsupported {
+		t.Skip(err)
+	}
+
+	badgerOpts := badger.DefaultOptions
+	_, err = New(Options{Path: fh, BadgerOptions: &badgerOpts, MinFreeDiskSpace: 1 << 62})
+	if !errors.Is(err, ErrInsufficientDiskSpace) {
+		t.Fatalf("expected insufficient disk space, got: %v", err)
+	}
+
+	// A refused open doesn't hold on to the path
+	badgerOpts = badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, MinFreeDiskSpace: 1})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+}
+
+func TestBadgerStore_DiskSpaceGauge(t *testing.T) {
+	sink := testMetricsSink(t)
+
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	if _, err := freeDiskSpace(fh); err == errDiskSpaceUnsupported {
+		t.Skip(err)
+	}
+
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:                   fh,
+		BadgerOptions:          &badgerOpts,
+		DiskSpaceCheckInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Closing waits for the monitor, which checks once as it starts
+	store.Close()
+
+	gauge, ok := sink.Data()[0].Gauges["raft.badgerdb.disk.free_bytes"]
+	if !ok {
+		t.Fatalf("missing gauge, have: %v", sink.Data()[0].Gauges)
+	}
+	if gauge.Value <= 0 {
+		t.Fatalf("bad free space gauge: %v", gauge.Value)
+	}
+}
+
+func TestDiskMonitor_Watchdog(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	var reports []DiskSpaceReport
+	m := &diskMonitor{
+		b:        store,
+		lowBytes: 100,
+		lowDays:  2,
+		onLow: func(r DiskSpaceReport) {
+			reports = append(reports, r)
+		},
+	}
+	start := time.Now()
+	day := 24 * time.Hour
+
+	// Plenty of space, shrinking slowly
+	m.observe(start, 10000)
+	m.observe(start.Add(day), 9000)
+	if len(reports) != 0 {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+
+	// Shrinking fast enough to fill up within two days
+	m.observe(start.Add(2*day), 3000)
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %+v", reports)
+	}
+	if r := reports[0]; r.Free != 3000 || r.BytesPerDay != 3500 || r.DaysUntilFull >= 1 {
+		t.Fatalf("bad report: %+v", r)
+	}
+
+	// Still low, the watchdog doesn't fire again
+	m.observe(start.Add(3*day), 50)
+	if len(reports) != 1 {
+		t.Fatalf("expected no new report, got %+v", reports)
+	}
+
+	// Recovering and dropping below the byte threshold fires again
+	m.samples = nil
+	m.observe(start.Add(4*day), 100000)
+	m.observe(start.Add(5*day), 99)
+	if len(reports) != 2 {
+		t.Fatalf("expected a second report, got %+v", reports)
+	}
+}
@@ -0,0 +1,462 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// encryptedTag marks a value sealed with AES-GCM. The envelope is laid out as
+// the tag, the big-endian key id, the nonce and the ciphertext. The tag and
+// key id are authenticated along with the payload.
+const encryptedTag byte = 0xe1
+
+// boundEncryptedTag marks a value sealed like one with encryptedTag, whose
+// authenticated data also names what the value is stored as, see
+// logBinding, blobBinding and chunkBinding. A value copied to another index,
+// or anywhere else, then fails authentication there instead of reading back
+// as a valid entry. Values sealed with encryptedTag by earlier versions
+// still open, and RotateEncryptionKey seals them again with this tag.
+const boundEncryptedTag byte = 0xe2
+
+var (
+	// ErrNoDecryptionKey is returned when a log entry is encrypted with a
+	// key the store was not given
+	ErrNoDecryptionKey = errors.New("no decryption key")
+
+	// ErrDecryption is returned when an encrypted log entry fails
+	// authentication, meaning it was corrupted or tampered with
+	ErrDecryption = errors.New("decryption failed")
+)
+
+// EncryptionKey is an AES key used to encrypt log entries independently of
+// Badger, so payloads stay protected in backups and exports too.
+type EncryptionKey struct {
+	// ID is stored in clear with every entry sealed by the key, so the
+	// right key can be picked when reading it back
+	ID uint32
+	// Key must be 16, 24 or 32 bytes long to select AES-128, AES-192 or
+	// AES-256
+	Key []byte
+}
+
+// valueCipher seals new values with the active key and opens values sealed
+// with any known key. mu guards the keys, which RotateEncryptionKey changes
+// while the store is in use.
+type valueCipher struct {
+	mu sync.RWMutex
+	// active is nil when only decryption keys are configured, in which
+	// case new values are written in clear
+	active   cipher.AEAD
+	activeID uint32
+	// dedupKey keys the hashes naming deduplicated payloads, derived from
+	// the active key
+	dedupKey []byte
+	aeads    map[uint32]cipher.AEAD
+	keys     map[uint32][]byte
+}
+
+// newValueCipher returns nil when no keys are configured.
+func newValueCipher(active *EncryptionKey, others []EncryptionKey) (*valueCipher, error) {
+	if active == nil && len(others) == 0 {
+		return nil, nil
+	}
+	c := &valueCipher{aeads: map[uint32]cipher.AEAD{}, keys: map[uint32][]byte{}}
+	keys := others
+	if active != nil {
+		keys = append([]EncryptionKey{*active}, others...)
+	}
+	for _, k := range keys {
+		if _, ok := c.aeads[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key id %d", k.ID)
+		}
+		aead, err := newAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads[k.ID] = aead
+		c.keys[k.ID] = append([]byte(nil), k.Key...)
+	}
+	if active != nil {
+		c.active = c.aeads[active.ID]
+		c.activeID = active.ID
+		c.dedupKey = deriveDedupKey(active.Key)
+	}
+	return c, nil
+}
+
+// deriveDedupKey derives the key naming deduplicated payloads from an
+// encryption key, so the encryption key itself is only ever used by AES.
+func deriveDedupKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("raft-badger dedup"))
+	return mac.Sum(nil)
+}
+
+func newAEAD(k EncryptionKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.Key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %d: %w", k.ID, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %d: %w", k.ID, err)
+	}
+	return aead, nil
+}
+
+// activate makes k the key new values are sealed with, keeping the others
+// for reading. A known ID must come with the same key.
+func (c *valueCipher) activate(k EncryptionKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if known, ok := c.keys[k.ID]; ok && !bytes.Equal(known, k.Key) {
+		return fmt.Errorf("encryption key id %d is already used by another key", k.ID)
+	}
+	aead, err := newAEAD(k)
+	if err != nil {
+		return err
+	}
+	c.aeads[k.ID] = aead
+	c.keys[k.ID] = append([]byte(nil), k.Key...)
+	c.active = aead
+	c.activeID = k.ID
+	c.dedupKey = deriveDedupKey(k.Key)
+	return nil
+}
+
+// addKeys makes keys available for reading. Known IDs must come with the
+// same key.
+func (c *valueCipher) addKeys(keys []EncryptionKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		if known, ok := c.keys[k.ID]; ok {
+			if !bytes.Equal(known, k.Key) {
+				return fmt.Errorf("encryption key id %d is already used by another key", k.ID)
+			}
+			continue
+		}
+		aead, err := newAEAD(k)
+		if err != nil {
+			return err
+		}
+		c.aeads[k.ID] = aead
+		c.keys[k.ID] = append([]byte(nil), k.Key...)
+	}
+	return nil
+}
+
+// isActive reports whether k is the active key.
+func (c *valueCipher) isActive(k EncryptionKey) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.active != nil && c.activeID == k.ID && bytes.Equal(c.keys[k.ID], k.Key)
+}
+
+// encrypting reports whether seal encrypts, which takes an active key.
+func (c *valueCipher) encrypting() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.active != nil
+}
+
+// blobHash returns the HMAC naming a deduplicated payload, keyed from the
+// active key, and false when there is no active key.
+func (c *valueCipher) blobHash(data []byte) (blobHash, bool) {
+	c.mu.RLock()
+	key := c.dedupKey
+	c.mu.RUnlock()
+	var h blobHash
+	if key == nil {
+		return h, false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	copy(h[:], mac.Sum(nil))
+	return h, true
+}
+
+// sealedWithActive reports whether v, a value in the encrypted envelope or
+// not, is sealed with the active key and bound to where it is stored.
+func (c *valueCipher) sealedWithActive(v []byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.active != nil && len(v) >= 5 && v[0] == boundEncryptedTag && binary.BigEndian.Uint32(v[1:5]) == c.activeID
+}
+
+// isEncrypted reports whether v is in the encrypted envelope.
+func isEncrypted(v []byte) bool {
+	return len(v) > 0 && (v[0] == encryptedTag || v[0] == boundEncryptedTag)
+}
+
+// logBinding names a log entry, live or soft-deleted, by its index for
+// sealing. Entries move between keys, to the trash and between key
+// formats, but never to another index.
+func logBinding(idx uint64) []byte {
+	return append([]byte{'l'}, uint64ToBytes(idx)...)
+}
+
+// blobBinding names a deduplicated payload by its hash for sealing.
+func blobBinding(h blobHash) []byte {
+	return append([]byte{'b'}, h[:]...)
+}
+
+// chunkBinding names a snapshot chunk by its snapshot and position for
+// sealing.
+func chunkBinding(id string, n uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], n)
+	return append(append(append([]byte{'s'}, id...), '/'), buf[:]...)
+}
+
+// seal encrypts plain with the active key, authenticating binding, which
+// names what the value is stored as, along with it.
+func (c *valueCipher) seal(plain, binding []byte) ([]byte, error) {
+	c.mu.RLock()
+	aead, id := c.active, c.activeID
+	c.mu.RUnlock()
+	if aead == nil {
+		return plain, nil
+	}
+	header := make([]byte, 5, 5+aead.NonceSize()+len(plain)+aead.Overhead())
+	header[0] = boundEncryptedTag
+	binary.BigEndian.PutUint32(header[1:], id)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, plain, append(header[:5:5], binding...)), nil
+}
+
+// open decrypts v, which must have been sealed with the same binding unless
+// an earlier version sealed it without one.
+func (c *valueCipher) open(v, binding []byte) ([]byte, error) {
+	if len(v) < 5 {
+		return nil, fmt.Errorf("%w: truncated envelope", ErrDecryption)
+	}
+	id := binary.BigEndian.Uint32(v[1:5])
+	c.mu.RLock()
+	aead := c.aeads[id]
+	c.mu.RUnlock()
+	if aead == nil {
+		return nil, fmt.Errorf("%w: key id %d", ErrNoDecryptionKey, id)
+	}
+	if len(v) < 5+aead.NonceSize() {
+		return nil, fmt.Errorf("%w: truncated envelope", ErrDecryption)
+	}
+	nonce := v[5 : 5+aead.NonceSize()]
+	aad := v[:5]
+	if v[0] == boundEncryptedTag {
+		aad = append(v[:5:5], binding...)
+	}
+	plain, err := aead.Open(nil, nonce, v[5+aead.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key id %d", ErrDecryption, id)
+	}
+	return plain, nil
+}
+
+// sealValue encrypts a value, bound to binding, when the store has an
+// active encryption key.
+func (b *BadgerStore) sealValue(v, binding []byte) ([]byte, error) {
+	if b.cipher == nil {
+		return v, nil
+	}
+	return b.cipher.seal(v, binding)
+}
+
+// openValue decrypts a value carrying the encrypted envelope.
+func (b *BadgerStore) openValue(v, binding []byte) ([]byte, error) {
+	if b.cipher == nil {
+		id := uint32(0)
+		if len(v) >= 5 {
+			id = binary.BigEndian.Uint32(v[1:5])
+		}
+		return nil, fmt.Errorf("%w: key id %d", ErrNoDecryptionKey, id)
+	}
+	return b.cipher.open(v, binding)
+}
+
+// RotateEncryptionKey makes key the key log entries are encrypted with and
+// re-encrypts every stored entry, soft-deleted entry, deduplicated payload
+// and snapshot chunk sealed with another key, or written in clear, with it.
+// Chunks of snapshots written before chunks were sealed stay as they were,
+// in clear, since their records say how to read them. It returns
+// the number of values rewritten. The store stays usable throughout; once
+// it returns, the keys it replaced are no longer needed and can be dropped
+// from Options.DecryptionKeys. The store must have been opened with
+// Options.EncryptionKey or Options.DecryptionKeys.
+func (b *BadgerStore) RotateEncryptionKey(key EncryptionKey) (rewritten uint64, err error) {
+	if b.badgerOpts.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	err = b.run(context.Background(), func(context.Context) (err error) {
+		rewritten, err = b.rotateEncryptionKey(key)
+		return err
+	})
+	return rewritten, err
+}
+
+func (b *BadgerStore) rotateEncryptionKey(key EncryptionKey) (uint64, error) {
+	if b.cipher == nil {
+		return 0, errors.New("store was opened without encryption keys")
+	}
+	if err := b.cipher.activate(key); err != nil {
+		return 0, err
+	}
+	b.logger.Info("rotating encryption key", "key-id", key.ID)
+	rewritten := uint64(0)
+	chunks := append(append([]byte(nil), b.keys.snap...), 'c')
+	for _, prefix := range [][]byte{b.keys.logs, b.keys.trash, append(append([]byte(nil), b.keys.blob...), 'd'), chunks} {
+		next := prefix
+		for next != nil {
+			var n uint64
+			err := b.writeRetry.do(b.metrics, b.logger, retryWrite, func() error {
+				var err error
+				n, next, err = b.reencryptBatch(prefix, next)
+				return err
+			})
+			if err != nil {
+				return rewritten, err
+			}
+			rewritten += n
+		}
+	}
+	return rewritten, nil
+}
+
+// reencryptBatch re-encrypts up to migrateBatchSize values under prefix,
+// starting at from, reading and writing them in the same transaction so a
+// concurrent write to any of them makes it conflict rather than be undone.
+// It returns how many it rewrote and the key to continue from, nil at the
+// end of the prefix.
+func (b *BadgerStore) reencryptBatch(prefix, from []byte) (uint64, []byte, error) {
+	var next []byte
+	rewritten := uint64(0)
+	err := b.update(func(txn *writeTxn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		scanned := 0
+		for it.Seek(from); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if scanned == migrateBatchSize {
+				next = item.KeyCopy(nil)
+				return nil
+			}
+			scanned++
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var val []byte
+			var idx uint64
+			switch {
+			case bytes.HasPrefix(prefix, b.keys.blob):
+				val, err = b.reencryptBlob(item.Key(), v)
+			case bytes.HasPrefix(prefix, b.keys.snap):
+				val, err = b.reencryptChunk(txn.Txn, item.Key(), v)
+			case bytes.HasPrefix(prefix, b.keys.trash):
+				if idx, err = b.keys.parseTrashKey(item.Key()); err == nil {
+					val, err = b.reencryptLog(v, logBinding(idx))
+				}
+			default:
+				if idx, err = b.keys.parseLogKey(item.Key()); err == nil {
+					val, err = b.reencryptLog(v, logBinding(idx))
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("key %q: %w", item.Key(), err)
+			}
+			if val == nil {
+				continue
+			}
+			err = txn.SetEntry(&badger.Entry{
+				Key:       item.KeyCopy(nil),
+				Value:     val,
+				UserMeta:  item.UserMeta(),
+				ExpiresAt: item.ExpiresAt(),
+			})
+			if err != nil {
+				return err
+			}
+			rewritten++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, from, err
+	}
+	return rewritten, next, nil
+}
+
+// reencryptLog returns a log entry value sealed with the active key and
+// bound to binding, or nil if it already is.
+func (b *BadgerStore) reencryptLog(v, binding []byte) ([]byte, error) {
+	deduped := len(v) > 0 && v[0] == dedupTag
+	if deduped {
+		v = v[1:]
+	}
+	if len(v) > 0 && v[0] == checksumTag {
+		var err error
+		if v, err = b.openChecksum(v); err != nil {
+			return nil, err
+		}
+	}
+	if len(v) > 0 && v[0] == dedupTag {
+		v, deduped = v[1:], true
+	}
+	if b.cipher.sealedWithActive(v) {
+		return nil, nil
+	}
+	if isEncrypted(v) {
+		var err error
+		if v, err = b.cipher.open(v, binding); err != nil {
+			return nil, err
+		}
+	}
+	sealed, err := b.cipher.seal(v, binding)
+	if err != nil {
+		return nil, err
+	}
+	if deduped {
+		sealed = append([]byte{dedupTag}, sealed...)
+	}
+	return checksumValue(sealed), nil
+}
+
+// reencryptBlob returns the deduplicated payload v stored under key sealed
+// with the active key, or nil if it already is.
+func (b *BadgerStore) reencryptBlob(key, v []byte) ([]byte, error) {
+	if b.cipher.sealedWithActive(v) {
+		return nil, nil
+	}
+	_, h, err := b.keys.parseBlobKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var plain []byte
+	switch {
+	case isEncrypted(v):
+		if plain, err = b.cipher.open(v, blobBinding(h)); err != nil {
+			return nil, err
+		}
+	case len(v) > 0 && v[0] == plainBlobTag:
+		plain = v[1:]
+	default:
+		return nil, errors.New("malformed deduplicated payload")
+	}
+	return b.cipher.seal(plain, blobBinding(h))
+}
@@ -0,0 +1,273 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testEncryptedStore(t *testing.T, path string, active *EncryptionKey, others ...EncryptionKey) *BadgerStore {
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:           path,
+		BadgerOptions:  &badgerOpts,
+		EncryptionKey:  active,
+		DecryptionKeys: others,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestBadgerStore_Encryption(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	key1 := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	key2 := EncryptionKey{ID: 2, Key: bytes.Repeat([]byte{2}, 16)}
+
+	store := testEncryptedStore(t, fh, &key1)
+	log1 := testRaftLog(1, "top secret")
+	if err := store.StoreLog(log1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The payload must not be visible in Badger
+	err = store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.logKey(1))
+		if err != nil {
+			return err
+		}
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if v[0] != checksumTag || v[checksumEnvelopeSize] != boundEncryptedTag {
+			t.Fatalf("expected encrypted envelope, got %#x", v[:checksumEnvelopeSize+1])
+		}
+		if bytes.Contains(v, []byte("top secret")) {
+			t.Fatalf("payload stored in clear")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result := new(raft.Log)
+	if err := store.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(log1, result) {
+		t.Fatalf("bad: %#v", result)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// After rotating, old entries remain readable through the retired key
+	store = testEncryptedStore(t, fh, &key2, key1)
+	log2 := testRaftLog(2, "also secret")
+	if err := store.StoreLog(log2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, want := range []*raft.Log{log1, log2} {
+		result := new(raft.Log)
+		if err := store.GetLog(want.Index, result); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(want, result) {
+			t.Fatalf("bad: %#v", result)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Without the key the entry can't be read
+	store = testEncryptedStore(t, fh, &key2)
+	defer store.Close()
+	if err := store.GetLog(1, new(raft.Log)); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Fatalf("expected missing key error, got: %v", err)
+	}
+}
+
+func TestValueCipher_Tampering(t *testing.T) {
+	c, err := newValueCipher(&EncryptionKey{ID: 7, Key: bytes.Repeat([]byte{7}, 32)}, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	sealed, err := c.seal([]byte("payload"), logBinding(1))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Bound to the index it was sealed for
+	if plain, err := c.open(sealed, logBinding(1)); err != nil || string(plain) != "payload" {
+		t.Fatalf("bad: %q, %v", plain, err)
+	}
+	if _, err := c.open(sealed, logBinding(2)); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("expected decryption error, got: %v", err)
+	}
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := c.open(sealed, logBinding(1)); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("expected decryption error, got: %v", err)
+	}
+	if _, err := c.open(sealed[:3], logBinding(1)); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("expected decryption error, got: %v", err)
+	}
+}
+
+// testSealUnbound seals plain with key the way earlier versions did,
+// authenticating only the envelope header.
+func testSealUnbound(t *testing.T, key EncryptionKey, plain []byte) []byte {
+	aead, err := newAEAD(key)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	header := []byte{encryptedTag, 0, 0, 0, byte(key.ID)}
+	nonce := bytes.Repeat([]byte{9}, aead.NonceSize())
+	return aead.Seal(append(append([]byte(nil), header...), nonce...), nonce, plain, header)
+}
+
+func TestBadgerStore_EncryptionBinding(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	key := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	store := testEncryptedStore(t, fh, &key)
+	defer store.Close()
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	set := func(idx uint64, v []byte) {
+		err := store.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(defaultKeys.logKey(idx), v)
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// An entry copied to another index doesn't read back there
+	var v []byte
+	err = store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.logKey(1))
+		if err != nil {
+			return err
+		}
+		v, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	set(2, v)
+	if err := store.GetLog(2, new(raft.Log)); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("expected decryption error, got: %v", err)
+	}
+
+	// Entries sealed by earlier versions, without the binding, still read
+	// back, and rotating seals them again with it
+	encoded, err := encodeWithCodec(store.codec, testRaftLog(2, "legacy"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	set(2, checksumValue(testSealUnbound(t, key, encoded)))
+	result := new(raft.Log)
+	if err := store.GetLog(2, result); err != nil || string(result.Data) != "legacy" {
+		t.Fatalf("bad: %#v, %v", result, err)
+	}
+	if n, err := store.RotateEncryptionKey(key); err != nil || n != 1 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if tag := testStoredTag(t, store, 2); tag != boundEncryptedTag {
+		t.Fatalf("bad tag %#x", tag)
+	}
+	if err := store.GetLog(2, result); err != nil || string(result.Data) != "legacy" {
+		t.Fatalf("bad: %#v, %v", result, err)
+	}
+}
+
+func TestNewValueCipher_InvalidKeys(t *testing.T) {
+	if _, err := newValueCipher(&EncryptionKey{ID: 1, Key: []byte("short")}, nil); err == nil {
+		t.Fatalf("expected an error for an invalid key length")
+	}
+	key := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	if _, err := newValueCipher(&key, []EncryptionKey{key}); err == nil {
+		t.Fatalf("expected an error for duplicate key ids")
+	}
+	c, err := newValueCipher(nil, nil)
+	if err != nil || c != nil {
+		t.Fatalf("expected no cipher without keys, got %v, %v", c, err)
+	}
+}
+
+func TestBadgerStore_RotateEncryptionKey(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	key1 := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	key2 := EncryptionKey{ID: 2, Key: bytes.Repeat([]byte{2}, 32)}
+	payload := string(bytes.Repeat([]byte("x"), 64))
+
+	// Without an active key entries are written in clear
+	store, err := New(Options{Path: fh, DecryptionKeys: []EncryptionKey{key1}, DedupMinSize: 32})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, payload)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n, err := store.RotateEncryptionKey(key1); err != nil || n != 3 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if err := store.StoreLog(testRaftLog(3, "log3")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n, err := store.RotateEncryptionKey(key2); err != nil || n != 4 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if n, err := store.RotateEncryptionKey(key2); err != nil || n != 0 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if _, err := store.RotateEncryptionKey(EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{3}, 32)}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// key1 is no longer needed
+	store, err = New(Options{Path: fh, EncryptionKey: &key2, DedupMinSize: 32})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	log := new(raft.Log)
+	for idx, data := range map[uint64]string{1: "log1", 2: payload, 3: "log3"} {
+		if err := store.GetLog(idx, log); err != nil || string(log.Data) != data {
+			t.Fatalf("bad: %d %v %#v", idx, err, log)
+		}
+	}
+
+	plain := testBadgerStore(t)
+	defer plain.Close()
+	defer os.RemoveAll(plain.path)
+	if _, err := plain.RotateEncryptionKey(key1); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
@@ -0,0 +1,34 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrEntryTooLarge is returned by StoreLogs and the other appends for
+// entries whose payload exceeds Options.MaxEntrySize. Nothing of the batch
+// is stored.
+var ErrEntryTooLarge = errors.New("log entry too large")
+
+// entrySize is the payload of log counted against Options.MaxEntrySize.
+func entrySize(log *raft.Log) int {
+	return len(log.Data) + len(log.Extensions)
+}
+
+// checkEntrySizes fails with ErrEntryTooLarge if any of logs exceeds the
+// store's MaxEntrySize, counting the rejection in the log.oversized metric.
+func (b *BadgerStore) checkEntrySizes(logs []*raft.Log) error {
+	if b.maxEntrySize == 0 {
+		return nil
+	}
+	for _, log := range logs {
+		if size := entrySize(log); size > b.maxEntrySize {
+			b.metrics.incrCounter([]string{"log", "oversized"}, 1)
+			b.logger.Warn("rejected oversized log entry", "index", log.Index, "size", size, "limit", b.maxEntrySize)
+			return fmt.Errorf("%w: entry %d has %d bytes, limit is %d", ErrEntryTooLarge, log.Index, size, b.maxEntrySize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_MaxEntrySize(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	if _, err := New(Options{Path: fh, MaxEntrySize: -1}); err == nil {
+		t.Fatalf("should reject a negative MaxEntrySize")
+	}
+	store, err := New(Options{Path: fh, MaxEntrySize: 8})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	// An oversized entry fails the whole batch
+	logs := []*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, strings.Repeat("x", 9))}
+	if err := store.StoreLogs(logs); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge, got: %v", err)
+	}
+	if last, err := store.LastIndex(); err != nil || last != 0 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+
+	// Extensions count towards the limit
+	log := testRaftLog(1, "log1")
+	log.Extensions = []byte("12345")
+	if err := store.StoreLog(log); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge, got: %v", err)
+	}
+	err = store.Update(func(tx *StoreTxn) error {
+		return tx.StoreLogs([]*raft.Log{log})
+	})
+	if !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge, got: %v", err)
+	}
+	batch, err := store.NewLogBatch()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := batch.Append(log); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge, got: %v", err)
+	}
+
+	// Entries up to the limit are stored
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, strings.Repeat("x", 8))}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last, err := store.LastIndex(); err != nil || last != 2 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+}
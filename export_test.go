@@ -0,0 +1,95 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_Export(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	appendedAt := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := store.StoreLog(&raft.Log{Index: 6, Term: 2, Type: raft.LogNoop, Extensions: []byte("ext"), AppendedAt: appendedAt}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, format := range []ExportFormat{ExportNDJSON, ExportJSON} {
+		var buf bytes.Buffer
+		if err := store.Export(&buf, format, LogFilter{}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if format == ExportNDJSON {
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			var entry ExportedLog
+			if err := json.Unmarshal([]byte(lines[5]), &entry); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if len(lines) != 6 || entry.Index != 6 || entry.Type != "noop" {
+				t.Fatalf("bad: %q", lines)
+			}
+		} else {
+			var entries []ExportedLog
+			if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if len(entries) != 6 {
+				t.Fatalf("bad: %#v", entries)
+			}
+		}
+
+		fresh := testBadgerStore(t)
+		if err := fresh.Import(&buf); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		for idx := uint64(1); idx <= 6; idx++ {
+			expected, got := new(raft.Log), new(raft.Log)
+			if err := store.GetLog(idx, expected); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if err := fresh.GetLog(idx, got); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if got.Term != expected.Term || got.Type != expected.Type || !bytes.Equal(got.Data, expected.Data) ||
+				!bytes.Equal(got.Extensions, expected.Extensions) || !got.AppendedAt.Equal(expected.AppendedAt) {
+				t.Fatalf("bad: %#v, expected %#v", got, expected)
+			}
+		}
+		fresh.Close()
+		os.RemoveAll(fresh.path)
+	}
+
+	// A range of the log
+	var buf bytes.Buffer
+	if err := store.Export(&buf, ExportNDJSON, LogFilter{MinIndex: 2, MaxIndex: 3}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Fatalf("bad: %d entries exported", n)
+	}
+}
+
+func TestBadgerStore_ImportMalformed(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	for _, export := range []string{
+		`{"index":1,"term":1,"type":"command","data":null}` + "\n{",
+		`[{"index":1,"term":1,"type":"command","data":null}`,
+		`{"index":1,"term":1,"type":"bogus","data":null}`,
+	} {
+		if err := store.Import(strings.NewReader(export)); err == nil {
+			t.Fatalf("should reject %q", export)
+		}
+	}
+	if err := store.Import(strings.NewReader(" \n")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
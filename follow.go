@@ -0,0 +1,184 @@
+package raftbadgerdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// followSocketName is the unix socket a store opened with
+// Options.AllowAttach streams newly appended entries on, next to the
+// attach socket.
+const followSocketName = "follow.sock"
+
+// appendSignal wakes goroutines waiting for log entries to be appended.
+// The zero value is ready to use.
+type appendSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel closed by the next notify.
+func (s *appendSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *appendSignal) notify() {
+	s.mu.Lock()
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+	s.mu.Unlock()
+}
+
+// WatchLogs calls fn for every stored log entry from index from onwards,
+// in index order, then waits for entries to be appended and calls fn for
+// those too, until stop is closed or fn returns an error. Returning
+// ErrStopScan from fn stops watching without an error. An entry replaced,
+// as a follower does with conflicting entries, after fn saw it isn't
+// passed to fn again. Watching must stop before the store is closed.
+func (b *BadgerStore) WatchLogs(from uint64, stop <-chan struct{}, fn func(log *raft.Log) error) error {
+	next := from
+	for {
+		// Taken before reading, so an append in between isn't missed
+		appended := b.appended.wait()
+		var fnErr error
+		err := b.ScanLogs(LogFilter{MinIndex: next}, func(log *raft.Log) error {
+			if fnErr = fn(log); fnErr != nil {
+				return fnErr
+			}
+			next = log.Index + 1
+			return nil
+		})
+		if fnErr == ErrStopScan {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-appended:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// FollowRequest is what FollowLogs asks a running store for.
+type FollowRequest struct {
+	// From is the index of the first entry to send
+	From uint64 `json:"from"`
+	// Last, if set, starts with the last this many entries instead
+	Last uint64 `json:"last"`
+}
+
+// FollowLogs streams the entries of the store running at path, which must
+// have been opened with Options.AllowAttach, to fn as they are appended,
+// starting with those req asks for, until stop is closed or fn returns an
+// error. Returning ErrStopScan from fn stops following without an error.
+// Entries arrive decoded and decrypted, as Export writes them.
+func FollowLogs(path string, req FollowRequest, stop <-chan struct{}, fn func(log *raft.Log) error) error {
+	conn, err := net.Dial("unix", filepath.Join(path, followSocketName))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAttachUnavailable, err)
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var entry ExportedLog
+		if err := dec.Decode(&entry); err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			if err == io.EOF {
+				return fmt.Errorf("%w: store closed", ErrAttachUnavailable)
+			}
+			return err
+		}
+		log, err := entry.log()
+		if err != nil {
+			return err
+		}
+		err = fn(log)
+		if err == ErrStopScan {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// serveFollower streams entries to a FollowLogs client until it goes away
+// or the server stops.
+func (s *attachServer) serveFollower(conn net.Conn) error {
+	var req FollowRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return err
+	}
+	from := req.From
+	if req.Last > 0 {
+		last, err := s.b.LastIndex()
+		if err != nil {
+			return err
+		}
+		if last >= req.Last {
+			from = last - req.Last + 1
+		}
+	}
+	// A client hanging up ends the stream; there is nothing else to read
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-gone:
+		case <-s.stop:
+		}
+		close(stop)
+	}()
+	bw := bufio.NewWriter(conn)
+	enc := json.NewEncoder(bw)
+	pending := 0
+	return s.b.WatchLogs(from, stop, func(log *raft.Log) error {
+		if err := enc.Encode(exportLog(log)); err != nil {
+			return err
+		}
+		// Flushed in batches while catching up, and at once when following
+		last, err := s.b.LastIndex()
+		if pending++; pending >= 64 || err != nil || log.Index >= last {
+			pending = 0
+			return bw.Flush()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,112 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_WatchLogs(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+
+	seen := make(chan uint64, 10)
+	stop := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- store.WatchLogs(4, stop, func(log *raft.Log) error {
+			seen <- log.Index
+			return nil
+		})
+	}()
+	for _, expected := range []uint64{4, 5} {
+		if idx := <-seen; idx != expected {
+			t.Fatalf("expected %d, got %d", expected, idx)
+		}
+	}
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(6, "log6"), testRaftLog(7, "log7")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, expected := range []uint64{6, 7} {
+		select {
+		case idx := <-seen:
+			if idx != expected {
+				t.Fatalf("expected %d, got %d", expected, idx)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("appended entry %d not seen", expected)
+		}
+	}
+	close(stop)
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// ErrStopScan ends watching cleanly
+	err := store.WatchLogs(1, nil, func(log *raft.Log) error {
+		return ErrStopScan
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	live, err := New(Options{Path: fh, AllowAttach: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer live.Close()
+	testStoreFiveLogs(t, live)
+
+	seen := make(chan *raft.Log, 10)
+	stop := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- FollowLogs(fh, FollowRequest{Last: 2}, stop, func(log *raft.Log) error {
+			seen <- log
+			return nil
+		})
+	}()
+	for _, expected := range []uint64{4, 5} {
+		if log := <-seen; log.Index != expected {
+			t.Fatalf("expected %d, got %#v", expected, log)
+		}
+	}
+	if err := live.StoreLog(testRaftLog(6, "log6")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	select {
+	case log := <-seen:
+		if log.Index != 6 || string(log.Data) != "log6" || log.Type != raft.LogCommand {
+			t.Fatalf("bad: %#v", log)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("appended entry not seen")
+	}
+	close(stop)
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestFollowLogs_NotAllowed(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	err := FollowLogs(store.path, FollowRequest{}, nil, func(*raft.Log) error { return nil })
+	if !errors.Is(err, ErrAttachUnavailable) {
+		t.Fatalf("expected ErrAttachUnavailable, got: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func FuzzParseLogKey(f *testing.F) {
+	f.Add(defaultKeys.logKey(0))
+	f.Add(defaultKeys.logKey(1))
+	f.Add(defaultKeys.logKey(^uint64(0)))
+	f.Add([]byte("logs"))
+	f.Add([]byte("conf42"))
+	f.Fuzz(func(t *testing.T, key []byte) {
+		idx, err := defaultKeys.parseLogKey(key)
+		if err != nil {
+			return
+		}
+		// Whatever parses must survive a round trip through logKey
+		back, err := defaultKeys.parseLogKey(defaultKeys.logKey(idx))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if back != idx {
+			t.Fatalf("round trip changed index %d to %d", idx, back)
+		}
+	})
+}
+
+func FuzzDecodeLog(f *testing.F) {
+	log := &raft.Log{Index: 7, Term: 2, Type: raft.LogCommand, Data: []byte("data")}
+	tagged, err := encodeWithCodec(GobCodec{}, log)
+	if err != nil {
+		f.Fatalf("err: %s", err)
+	}
+	var legacy bytes.Buffer
+	if err := gob.NewEncoder(&legacy).Encode(log); err != nil {
+		f.Fatalf("err: %s", err)
+	}
+	f.Add(tagged)
+	f.Add(legacy.Bytes())
+	f.Add([]byte{})
+	f.Add([]byte{GobCodecID})
+	f.Add([]byte{codecTagMax, 0xff})
+	f.Add([]byte{encryptedTag, 0, 0, 0, 1})
+	f.Add([]byte{boundEncryptedTag, 0, 0, 0, 1})
+
+	store := testBadgerStore(f)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	txn := store.db.NewTransaction(false)
+	defer txn.Discard()
+	f.Fuzz(func(t *testing.T, v []byte) {
+		// Corrupt values must produce errors, never panics
+		store.decodeLog(txn, 1, v, new(raft.Log))
+	})
+}
+
+// testFuzzSeeds returns a store holding five entries and a term, to write
+// well-formed seeds from.
+func testFuzzSeeds(f *testing.F) *BadgerStore {
+	store := testBadgerStore(f)
+	var logs []*raft.Log
+	for i := uint64(1); i <= 5; i++ {
+		logs = append(logs, testRaftLog(i, "log"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		f.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		f.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+// addTruncated adds seed and a prefix of it cut off in the middle.
+func addTruncated(f *testing.F, seed []byte) {
+	f.Add(seed)
+	f.Add(seed[:len(seed)/2])
+}
+
+func FuzzRestore(f *testing.F) {
+	seeds := testFuzzSeeds(f)
+	defer os.RemoveAll(seeds.path)
+	var buf bytes.Buffer
+	if _, err := seeds.Backup(&buf, 0); err != nil {
+		f.Fatalf("err: %s", err)
+	}
+	seeds.Close()
+	addTruncated(f, buf.Bytes())
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	store := testBadgerStore(f)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	f.Fuzz(func(t *testing.T, backup []byte) {
+		// Corrupt backups must produce errors, never panics
+		store.Restore(bytes.NewReader(backup))
+	})
+}
+
+func FuzzImport(f *testing.F) {
+	seeds := testFuzzSeeds(f)
+	defer os.RemoveAll(seeds.path)
+	for _, format := range []ExportFormat{ExportNDJSON, ExportJSON} {
+		var buf bytes.Buffer
+		if err := seeds.Export(&buf, format, LogFilter{}); err != nil {
+			f.Fatalf("err: %s", err)
+		}
+		addTruncated(f, buf.Bytes())
+	}
+	seeds.Close()
+	f.Add([]byte("[]"))
+	f.Add([]byte(`{"index":"1"}`))
+	f.Add([]byte(`[{"index":1,"type":"nope"}`))
+
+	store := testBadgerStore(f)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	f.Fuzz(func(t *testing.T, export []byte) {
+		// Corrupt exports must produce errors, never panics
+		store.Import(bytes.NewReader(export))
+	})
+}
+
+func FuzzReceiveLogs(f *testing.F) {
+	seeds := testFuzzSeeds(f)
+	defer os.RemoveAll(seeds.path)
+	var buf bytes.Buffer
+	if _, err := seeds.SendLogs(&buf, 1, 5); err != nil {
+		f.Fatalf("err: %s", err)
+	}
+	seeds.Close()
+	stream := buf.Bytes()
+	addTruncated(f, stream)
+	f.Add(stream[:len(logStreamMagic)+1])
+	f.Add(append(append([]byte(nil), stream[:len(logStreamMagic)+1]...), 0xff, 0xff, 0xff, 0xff, 0x0f))
+
+	store := testBadgerStore(f)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		// Corrupt streams must produce errors, never panics
+		store.ReceiveLogs(bytes.NewReader(stream))
+	})
+}
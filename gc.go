@@ -0,0 +1,145 @@
+package raftbadgerdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// defaultGCDiscardRatio is the discard ratio scheduled value log garbage
+// collection uses unless Options.ValueLogGCDiscardRatio is set.
+const defaultGCDiscardRatio = 0.5
+
+// gcScheduler runs value log garbage collection of db in the background,
+// reporting runs with trigger. Runs hold mu, so pausing can wait for one in
+// progress to stop; stateMu guards paused.
+type gcScheduler struct {
+	b            *BadgerStore
+	db           *badger.DB
+	trigger      string
+	discardRatio float64
+
+	mu      sync.Mutex
+	stateMu sync.Mutex
+	paused  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startValueLogGC collects the value log of db every interval until the
+// store closes.
+func (b *BadgerStore) startValueLogGC(db *badger.DB, trigger string, interval time.Duration, discardRatio float64) *gcScheduler {
+	if discardRatio == 0 {
+		discardRatio = defaultGCDiscardRatio
+	}
+	s := &gcScheduler{
+		b:            b,
+		db:           db,
+		trigger:      trigger,
+		discardRatio: discardRatio,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.run()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// run collects the value log unless paused, stopping early if paused or
+// stopped meanwhile.
+func (s *gcScheduler) run() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isPaused() {
+		return
+	}
+	report := s.b.startCompaction(s.trigger, false)
+	err := s.b.collectValueLog(s.db, s.discardRatio, func() bool {
+		select {
+		case <-s.stop:
+			return false
+		default:
+			return !s.isPaused()
+		}
+	})
+	if err != nil {
+		s.b.metrics.incrCounter([]string{"value_log_gc", "failures"}, 1)
+		s.b.logger.Warn("scheduled value log garbage collection failed", "trigger", s.trigger, "error", err)
+		return
+	}
+	s.b.finishCompaction(report)
+}
+
+func (s *gcScheduler) isPaused() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.paused
+}
+
+func (s *gcScheduler) setPaused(paused bool) {
+	s.stateMu.Lock()
+	s.paused = paused
+	s.stateMu.Unlock()
+}
+
+// pause pauses s, waiting for a run in progress to stop. A nil scheduler
+// does nothing, as do the other methods.
+func (s *gcScheduler) pause() {
+	if s == nil {
+		return
+	}
+	s.setPaused(true)
+	s.mu.Lock()
+	s.mu.Unlock()
+}
+
+func (s *gcScheduler) resume() {
+	if s == nil {
+		return
+	}
+	s.setPaused(false)
+}
+
+// close stops s, interrupting a run in progress after its current rewrite.
+func (s *gcScheduler) close() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// PauseValueLogGC stops scheduled value log garbage collection, waiting for
+// a run in progress to finish the rewrite it is in the middle of. It does
+// nothing unless Options.ValueLogGCInterval or StableValueLogGCInterval is
+// set.
+func (b *BadgerStore) PauseValueLogGC() {
+	b.gc.pause()
+	b.stableGC.pause()
+}
+
+// ResumeValueLogGC resumes scheduled value log garbage collection after
+// PauseValueLogGC.
+func (b *BadgerStore) ResumeValueLogGC() {
+	b.gc.resume()
+	b.stableGC.resume()
+}
+
+// stopValueLogGC stops the schedulers.
+func (b *BadgerStore) stopValueLogGC() {
+	b.gc.close()
+	b.stableGC.close()
+}
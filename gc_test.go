@@ -0,0 +1,94 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBadgerStore_ValueLogGC(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	var mu sync.Mutex
+	runs := 0
+	store, err := New(Options{
+		Path:               fh,
+		ValueLogGCInterval: 10 * time.Millisecond,
+		OnCompaction: func(r CompactionReport) {
+			if r.Trigger != "scheduled-gc" {
+				t.Errorf("bad trigger: %q", r.Trigger)
+			}
+			mu.Lock()
+			runs++
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	countRuns := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return runs
+	}
+	waitForRun := func() {
+		start := countRuns()
+		deadline := time.Now().Add(5 * time.Second)
+		for countRuns() == start {
+			if time.Now().After(deadline) {
+				t.Fatalf("no scheduled run")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitForRun()
+
+	store.PauseValueLogGC()
+	paused := countRuns()
+	time.Sleep(50 * time.Millisecond)
+	if n := countRuns(); n != paused {
+		t.Fatalf("ran %d times while paused", n-paused)
+	}
+	store.ResumeValueLogGC()
+	waitForRun()
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	closed := countRuns()
+	time.Sleep(50 * time.Millisecond)
+	if n := countRuns(); n != closed {
+		t.Fatalf("ran %d times after closing", n-closed)
+	}
+}
+
+func TestNew_ValueLogGCOptions(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	for _, ratio := range []float64{-0.1, 1} {
+		if _, err := New(Options{Path: fh, ValueLogGCInterval: time.Minute, ValueLogGCDiscardRatio: ratio}); err == nil {
+			t.Fatalf("should reject discard ratio %g", ratio)
+		}
+	}
+
+	// Pausing is harmless without a schedule
+	store, err := New(Options{Path: fh})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.PauseValueLogGC()
+	store.ResumeValueLogGC()
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,128 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// groupCommitter merges StoreLogs calls arriving within a short window into
+// one transaction, as pipelined replication and several rafts sharing a
+// store issue many small appends at once. Every caller still returns only
+// once its entries are committed, with the outcome of the transaction that
+// carried them. Calls are applied in arrival order, so a later call
+// rewriting an index wins as it would without grouping. The groups of a
+// MultiStore share the committer of the database, so appends to different
+// groups are committed together too.
+type groupCommitter struct {
+	b      *BadgerStore
+	window time.Duration
+
+	mu      sync.Mutex
+	pending []*groupAppend
+}
+
+type groupAppend struct {
+	// b is the store appended to, one of the groups of a MultiStore
+	b    *BadgerStore
+	logs []*raft.Log
+	done chan error
+}
+
+// storeLogs queues logs for b and waits for the transaction carrying them.
+// The first caller into an empty group waits out the window and commits
+// for everyone who joined in the meantime.
+func (g *groupCommitter) storeLogs(b *BadgerStore, logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	a := &groupAppend{b: b, logs: logs, done: make(chan error, 1)}
+	g.mu.Lock()
+	g.pending = append(g.pending, a)
+	leader := len(g.pending) == 1
+	g.mu.Unlock()
+
+	if leader {
+		time.Sleep(g.window)
+		g.mu.Lock()
+		group := g.pending
+		g.pending = nil
+		g.mu.Unlock()
+		g.commit(group)
+	}
+	return <-a.done
+}
+
+// commit stores the group's entries in one transaction. A group too big for
+// one is committed call by call instead, each call split as StoreLogs
+// would.
+func (g *groupCommitter) commit(group []*groupAppend) {
+	g.b.metrics.addSample([]string{"store_logs", "grouped_calls"}, float32(len(group)))
+	if len(group) == 1 {
+		group[0].done <- group[0].b.storeLogs(context.Background(), group[0].logs)
+		return
+	}
+	for _, a := range group {
+		if err := a.b.awaitPendingDeletes(a.logs); err != nil {
+			g.finish(group, err)
+			return
+		}
+	}
+	err := g.storeTogether(group)
+	if err == nil {
+		return
+	}
+	if err != badger.ErrTxnTooBig {
+		g.finish(group, err)
+		return
+	}
+	for _, a := range group {
+		a.done <- a.b.storeLogs(context.Background(), a.logs)
+	}
+}
+
+// storeTogether writes the entries of every call in one transaction and,
+// once it is committed, hands each call the outcome of its own bookkeeping.
+// A call whose entries don't follow the log as the calls before it left it
+// is left out and fails with ErrOutOfOrderAppend. It returns an error, and
+// leaves the calls waiting, if nothing was committed.
+func (g *groupCommitter) storeTogether(group []*groupAppend) error {
+	txn := g.b.newWriteTxn()
+	defer txn.Discard()
+	stored := make([]func() error, len(group))
+	rejected := make([]error, len(group))
+	for i, a := range group {
+		err := a.b.checkAppend(txn, a.logs)
+		if errors.Is(err, ErrOutOfOrderAppend) {
+			rejected[i] = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if stored[i], err = a.b.writeLogs(txn, a.logs); err != nil {
+			return err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	for i, a := range group {
+		if rejected[i] != nil {
+			a.done <- rejected[i]
+			continue
+		}
+		a.done <- stored[i]()
+	}
+	return nil
+}
+
+func (g *groupCommitter) finish(group []*groupAppend, err error) {
+	for _, a := range group {
+		a.done <- err
+	}
+}
@@ -0,0 +1,110 @@
+package raftbadgerdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_GroupCommit(t *testing.T) {
+	sink := testMetricsSink(t)
+
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	// The calls reach the store in any order
+	store, err := New(Options{Path: fh, GroupCommitWindow: 50 * time.Millisecond, AllowOutOfOrderAppends: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			idx := uint64(2*i + 1)
+			errs <- store.StoreLogs([]*raft.Log{
+				testRaftLog(idx, fmt.Sprintf("log%d", idx)),
+				testRaftLog(idx+1, fmt.Sprintf("log%d", idx+1)),
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// Every entry is visible once its call returns
+	log := new(raft.Log)
+	for idx := uint64(1); idx <= 20; idx++ {
+		if err := store.GetLog(idx, log); err != nil || string(log.Data) != fmt.Sprintf("log%d", idx) {
+			t.Fatalf("bad: %d: %v, %#v", idx, err, log)
+		}
+	}
+	if first, last := testBounds(t, store); first != 1 || last != 20 {
+		t.Fatalf("bad: %d, %d", first, last)
+	}
+
+	sample, ok := sink.Data()[0].Samples["raft.badgerdb.store_logs.grouped_calls"]
+	if !ok {
+		t.Fatalf("missing sample")
+	}
+	if sample.Count >= 10 {
+		t.Fatalf("expected concurrent appends to be grouped, got %d transactions", sample.Count)
+	}
+}
+
+func TestBadgerStore_GroupCommitTooBig(t *testing.T) {
+	store := testSmallTxnStore(t, Options{GroupCommitWindow: 50 * time.Millisecond, AllowOutOfOrderAppends: true})
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+
+	// The group doesn't fit into one transaction, so each call is committed
+	// on its own
+	logs := testLargeBatch()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, part := range [][]*raft.Log{logs[:500], logs[500:]} {
+		wg.Add(1)
+		go func(part []*raft.Log) {
+			defer wg.Done()
+			errs <- store.StoreLogs(part)
+		}(part)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	out := make([]*raft.Log, len(logs))
+	if n, err := store.GetLogs(1, uint64(len(logs)), out); err != nil || n != len(logs) {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+}
+
+func testBounds(t *testing.T, store *BadgerStore) (uint64, uint64) {
+	first, err := store.FirstIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return first, last
+}
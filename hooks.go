@@ -0,0 +1,28 @@
+package raftbadgerdb
+
+import (
+	"github.com/hashicorp/raft"
+)
+
+// Hooks are callbacks the store makes as things happen to it, so
+// applications can build auditing and alerting without wrapping every
+// method. Each runs synchronously, on the goroutine that caused the event,
+// and should return quickly. Hooks left nil are skipped.
+type Hooks struct {
+	// LogsStored is called with every batch of entries once it is
+	// committed, which for a large StoreLogs call or a LogBatch can be a
+	// part of what was appended. The entries must not be modified
+	LogsStored func(logs []*raft.Log)
+	// RangeDeleted is called after DeleteRange removed, or with
+	// AsyncDeleteRange queued, the entries from min to max
+	RangeDeleted func(min, max uint64)
+	// StableKeySet is called after Set, SetUint64 or SetUint64IfGreater
+	// wrote key
+	StableKeySet func(key []byte)
+	// GCCompleted is called after every value log garbage collection run
+	// with the bytes it freed on disk, see CompactionReport.BytesReclaimed
+	GCCompleted func(bytesReclaimed int64)
+	// CorruptionDetected is called when a read finds the entry at index
+	// doesn't match its checksum, before ErrCorruptLog is returned
+	CorruptionDetected func(index uint64)
+}
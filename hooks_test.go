@@ -0,0 +1,61 @@
+package raftbadgerdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_Hooks(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	var events []string
+	record := func(format string, args ...interface{}) {
+		events = append(events, fmt.Sprintf(format, args...))
+	}
+	store, err := New(Options{Path: fh, Hooks: Hooks{
+		LogsStored: func(logs []*raft.Log) {
+			record("stored %d-%d", logs[0].Index, logs[len(logs)-1].Index)
+		},
+		RangeDeleted:       func(min, max uint64) { record("deleted %d-%d", min, max) },
+		StableKeySet:       func(key []byte) { record("set %s", key) },
+		GCCompleted:        func(int64) { record("gc") },
+		CorruptionDetected: func(idx uint64) { record("corrupt %d", idx) },
+	}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	testStoreFiveLogs(t, store)
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Nothing is written, so nothing is reported
+	if _, err := store.SetUint64IfGreater([]byte("CurrentTerm"), 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.RunValueLogGC(0.5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testCorruptEntry(t, store, 4, "log4")
+	if err := store.GetLog(4, new(raft.Log)); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	expected := []string{"stored 1-5", "deleted 1-2", "set CurrentTerm", "gc", "corrupt 4"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("bad: %q", events)
+	}
+}
@@ -0,0 +1,176 @@
+package raftbadgerdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// backupManifestName is the file in a backup directory listing its
+// backups.
+const backupManifestName = "MANIFEST.json"
+
+// ErrBackupMissing is returned by RestoreIncremental when a backup the
+// manifest lists is missing or has been changed.
+var ErrBackupMissing = errors.New("backup listed in the manifest is missing")
+
+// BackupManifest lists the backups in a backup directory, in the order they
+// were taken. The first is a full backup, every later one holds what
+// changed since the one before.
+type BackupManifest struct {
+	Backups []BackupFile `json:"backups"`
+}
+
+// BackupFile describes one backup in a backup directory.
+type BackupFile struct {
+	// Name of the file within the backup directory
+	Name string `json:"name"`
+	// Since is the version the backup starts at, 0 for a full backup
+	Since uint64 `json:"since"`
+	// Version to start the next backup at
+	Version uint64 `json:"version"`
+	// Size of the file in bytes
+	Size int64 `json:"size"`
+	// Created is when the backup was taken
+	Created time.Time `json:"created"`
+}
+
+// LastVersion returns the version the next backup starts at, 0 if there
+// are no backups yet.
+func (m *BackupManifest) LastVersion() uint64 {
+	if len(m.Backups) == 0 {
+		return 0
+	}
+	return m.Backups[len(m.Backups)-1].Version
+}
+
+// ReadBackupManifest reads the manifest of the backup directory dir. A
+// directory without one has no backups yet.
+func ReadBackupManifest(dir string) (*BackupManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupManifestName))
+	if os.IsNotExist(err) {
+		return &BackupManifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(BackupManifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("malformed backup manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// BackupIncremental adds a backup of the store to the backup directory dir,
+// creating it if needed. The first backup in a directory is a full one;
+// each later one only holds what changed since the last backup the
+// manifest lists, including the entries deleted since, so they stay
+// deleted once restored. The manifest is only updated once the new backup
+// is safely on disk, so an interrupted backup leaves the directory as it
+// was.
+func (b *BadgerStore) BackupIncremental(dir string) (BackupFile, error) {
+	if err := createDirSynced(dir); err != nil {
+		return BackupFile{}, err
+	}
+	manifest, err := ReadBackupManifest(dir)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	file := BackupFile{
+		Name:    fmt.Sprintf("backup-%06d.bak", len(manifest.Backups)+1),
+		Since:   manifest.LastVersion(),
+		Created: time.Now().UTC(),
+	}
+	err = writeFileSynced(filepath.Join(dir, file.Name), func(w io.Writer) error {
+		file.Version, err = b.Backup(w, file.Since)
+		return err
+	})
+	if err != nil {
+		return BackupFile{}, err
+	}
+	info, err := os.Stat(filepath.Join(dir, file.Name))
+	if err != nil {
+		return BackupFile{}, err
+	}
+	file.Size = info.Size()
+
+	manifest.Backups = append(manifest.Backups, file)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return BackupFile{}, err
+	}
+	err = writeFileSynced(filepath.Join(dir, backupManifestName), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return BackupFile{}, err
+	}
+	return file, nil
+}
+
+// RestoreIncremental restores every backup the manifest of the backup
+// directory dir lists, in order, into the store, which like with Restore
+// should be freshly created. Every file is checked against the manifest
+// before anything is restored.
+func (b *BadgerStore) RestoreIncremental(dir string) error {
+	manifest, err := ReadBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range manifest.Backups {
+		info, err := os.Stat(filepath.Join(dir, file.Name))
+		if os.IsNotExist(err) || err == nil && info.Size() != file.Size {
+			return fmt.Errorf("%w: %s", ErrBackupMissing, file.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, file := range manifest.Backups {
+		if err := b.restoreFile(filepath.Join(dir, file.Name)); err != nil {
+			return fmt.Errorf("restoring %s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+func (b *BadgerStore) restoreFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return b.Restore(f)
+}
+
+// writeFileSynced writes path through a temporary file that is synced and
+// renamed into place, so path either keeps its old contents or has all of
+// the new ones.
+func writeFileSynced(path string, write func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,126 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_BackupIncremental(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testStoreFiveLogs(t, store)
+	full, err := store.BackupIncremental(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if full.Since != 0 || full.Name != "backup-000001.bak" {
+		t.Fatalf("bad: %#v", full)
+	}
+	if err := store.StoreLog(&raft.Log{Index: 6, Term: 2, Data: []byte("six")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	incr, err := store.BackupIncremental(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if incr.Since != full.Version || incr.Size >= full.Size {
+		t.Fatalf("bad: %#v after %#v", incr, full)
+	}
+	manifest, err := ReadBackupManifest(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(manifest.Backups) != 2 || manifest.LastVersion() != incr.Version {
+		t.Fatalf("bad: %#v", manifest)
+	}
+
+	fresh := testBadgerStore(t)
+	defer fresh.Close()
+	defer os.RemoveAll(fresh.path)
+	if err := fresh.RestoreIncremental(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last, err := fresh.LastIndex(); err != nil || last != 6 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+	if term, err := fresh.GetUint64([]byte("CurrentTerm")); err != nil || term != 2 {
+		t.Fatalf("bad: %d, %v", term, err)
+	}
+
+	// A missing increment is caught before anything is restored
+	if err := os.Remove(filepath.Join(dir, incr.Name)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	other := testBadgerStore(t)
+	defer other.Close()
+	defer os.RemoveAll(other.path)
+	if err := other.RestoreIncremental(dir); !errors.Is(err, ErrBackupMissing) {
+		t.Fatalf("bad: %v", err)
+	}
+	if last, err := other.LastIndex(); err != nil || last != 0 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+}
+
+func TestBadgerStore_BackupIncrementalDeleteRange(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testStoreFiveLogs(t, store)
+	if _, err := store.BackupIncremental(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Compact the head and replace the tail, as raft does after a conflict
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(5, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(&raft.Log{Index: 5, Term: 2, Data: []byte("five")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.BackupIncremental(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	fresh := testBadgerStore(t)
+	defer fresh.Close()
+	defer os.RemoveAll(fresh.path)
+	if err := fresh.RestoreIncremental(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first, err := fresh.FirstIndex(); err != nil || first != 3 {
+		t.Fatalf("bad: %d, %v", first, err)
+	}
+	log := new(raft.Log)
+	for _, idx := range []uint64{1, 2} {
+		if err := fresh.GetLog(idx, log); err != raft.ErrLogNotFound {
+			t.Fatalf("expected log %d not found, got: %v", idx, err)
+		}
+	}
+	if err := fresh.GetLog(5, log); err != nil || log.Term != 2 || string(log.Data) != "five" {
+		t.Fatalf("bad: %#v, %v", log, err)
+	}
+}
@@ -0,0 +1,159 @@
+package raftbadgerdb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// stagingDirName is where a replacement store is built, inside the
+	// directory of the store it replaces, so swapping it in is a rename on
+	// the same file system.
+	stagingDirName = "staging"
+	// replacedSuffix is appended to Badger's directory while a replacement
+	// is swapped in.
+	replacedSuffix = ".replaced"
+)
+
+// stagedStore is a replacement for the store at target, built on the side
+// and swapped in by install once it verifies. Until then the store at
+// target is untouched, and a crash leaves at most a staging directory the
+// next stageStore removes.
+type stagedStore struct {
+	*BadgerStore
+	target string
+}
+
+// stageStore opens an empty staging store for replacing the store at path.
+// Options that affect how keys and values are read and written, the codec,
+// compression, encryption keys and namespace, carry over to it, as do the
+// options Badger is opened with, since its directory becomes the store's.
+// Only Badger's directories, set from the staging path, differ.
+func stageStore(path string, options Options) (*stagedStore, error) {
+	staging := filepath.Join(path, stagingDirName)
+	// Left behind by an earlier replacement that never finished
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	badgerOpts := badger.DefaultOptions
+	if options.BadgerOptions != nil {
+		badgerOpts = *options.BadgerOptions
+	}
+	badgerOpts.ReadOnly = false
+	store, err := New(Options{
+		Path:               staging,
+		BadgerOptions:      &badgerOpts,
+		exactBadgerOptions: options.exactBadgerOptions,
+		MemoryBudget:       options.MemoryBudget,
+		AutoTuneMemory:     options.AutoTuneMemory,
+		NumVersionsToKeep:  options.NumVersionsToKeep,
+		ValueThreshold:     options.ValueThreshold,
+		SyncPolicy:         options.SyncPolicy,
+		SyncInterval:       options.SyncInterval,
+		Codec:              options.Codec,
+		Compression:        options.Compression,
+		MinCompressSize:    options.MinCompressSize,
+		EncryptionKey:      options.EncryptionKey,
+		DecryptionKeys:     options.DecryptionKeys,
+		KeyProvider:        options.KeyProvider,
+		Namespace:          options.Namespace,
+	})
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	return &stagedStore{BadgerStore: store, target: path}, nil
+}
+
+// install verifies every entry of the staged store, closes it and swaps it
+// in for the store at its target, which must not be open. The staged store
+// is discarded if verification fails.
+func (s *stagedStore) install(progress ProgressFunc) error {
+	if _, err := s.verifyAll(progress); err != nil {
+		s.discard()
+		return err
+	}
+	if err := s.Close(); err != nil {
+		os.RemoveAll(s.path)
+		return err
+	}
+	claimed, err := claimPath(s.target)
+	if err != nil {
+		os.RemoveAll(s.path)
+		return err
+	}
+	defer releasePath(claimed)
+	if err := recoverReplacement(s.target); err != nil {
+		return err
+	}
+
+	// A crash between the renames leaves only the replaced directory, which
+	// recoverReplacement moves back on the next open
+	dir := badgerDir(s.target)
+	replaced := dir + replacedSuffix
+	if err := os.Rename(dir, replaced); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := syncDir(s.target); err != nil {
+		return err
+	}
+	if err := os.Rename(badgerDir(s.path), dir); err != nil {
+		return err
+	}
+	if err := syncDir(s.target); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(replaced); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.path)
+}
+
+// discard closes the staged store and deletes it.
+func (s *stagedStore) discard() {
+	s.Close()
+	os.RemoveAll(s.path)
+}
+
+// recoverReplacement finishes a swap that a crash interrupted. If the new
+// directory made it into place the replaced one is deleted, otherwise the
+// replaced one is moved back.
+func recoverReplacement(path string) error {
+	dir := badgerDir(path)
+	replaced := dir + replacedSuffix
+	if _, err := os.Stat(replaced); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return os.RemoveAll(replaced)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(replaced, dir); err != nil {
+		return err
+	}
+	return syncDir(path)
+}
+
+// RestoreBackup replaces the store at path with the contents of a backup
+// written by BackupScoped. The backup is loaded into a staging directory and
+// verified first, and only then swapped in, so a failed or interrupted
+// restore leaves the existing store as it was. options supplies the codec
+// and encryption keys the backed up entries need. The store must not be
+// open.
+func RestoreBackup(path string, r io.Reader, options Options) error {
+	staged, err := stageStore(path, options)
+	if err != nil {
+		return err
+	}
+	if err := staged.RestoreScoped(r); err != nil {
+		staged.discard()
+		return err
+	}
+	return staged.install(options.OnProgress)
+}
@@ -0,0 +1,150 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testReopen(t *testing.T, path string) *BadgerStore {
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: path, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestRestoreBackup(t *testing.T) {
+	source := testBadgerStore(t)
+	defer source.Close()
+	defer os.RemoveAll(source.path)
+	testStoreFiveLogs(t, source)
+	if err := source.SetUint64([]byte("CurrentTerm"), 7); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := source.BackupScoped(&buf, BackupScope{Logs: true, Stable: true, MinIndex: 2}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	target := testBadgerStore(t)
+	defer os.RemoveAll(target.path)
+	if err := target.StoreLog(testRaftLog(1, "stale")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Restoring over an open store is refused
+	if err := RestoreBackup(target.path, bytes.NewReader(buf.Bytes()), Options{}); !errors.Is(err, ErrAlreadyOpen) {
+		t.Fatalf("expected ErrAlreadyOpen, got: %v", err)
+	}
+	target.Close()
+
+	if err := RestoreBackup(target.path, &buf, Options{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, name := range []string{stagingDirName, "badger" + replacedSuffix} {
+		if _, err := os.Stat(filepath.Join(target.path, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be gone, got: %v", name, err)
+		}
+	}
+
+	target = testReopen(t, target.path)
+	defer target.Close()
+	if err := target.GetLog(1, new(raft.Log)); err != raft.ErrLogNotFound {
+		t.Fatalf("expected the old store to be replaced, got: %v", err)
+	}
+	result := new(raft.Log)
+	if err := target.GetLog(5, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "log5" {
+		t.Fatalf("bad: %#v", result)
+	}
+	if term, err := target.GetUint64([]byte("CurrentTerm")); err != nil || term != 7 {
+		t.Fatalf("bad term %d: %v", term, err)
+	}
+}
+
+func TestRestoreBackup_Failed(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	store.Close()
+
+	truncated := []byte{0x10, 0, 0, 0, 0, 0, 0, 0, 0x01}
+	if err := RestoreBackup(store.path, bytes.NewReader(truncated), Options{}); err == nil {
+		t.Fatalf("expected an error for a truncated backup")
+	}
+	if _, err := os.Stat(filepath.Join(store.path, stagingDirName)); !os.IsNotExist(err) {
+		t.Fatalf("expected the staging directory to be gone, got: %v", err)
+	}
+
+	store = testReopen(t, store.path)
+	defer store.Close()
+	if err := store.GetLog(3, new(raft.Log)); err != nil {
+		t.Fatalf("expected the store to be untouched, got: %v", err)
+	}
+}
+
+func TestStageStore_BadgerOptions(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	store.Close()
+
+	// The staging store is opened as the caller configured Badger, but in
+	// its own directory
+	badgerOpts := badger.DefaultOptions
+	badgerOpts.ValueLogFileSize = 32 << 20
+	badgerOpts.NumMemtables = 3
+	badgerOpts.Dir = "/elsewhere"
+	badgerOpts.ValueDir = "/elsewhere"
+	staged, err := stageStore(store.path, Options{BadgerOptions: &badgerOpts, ValueThreshold: 64})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer staged.Close()
+	opts := staged.badgerOpts
+	if opts.ValueLogFileSize != 32<<20 || opts.NumMemtables != 3 || opts.ValueThreshold != 64 {
+		t.Fatalf("caller's options not applied: %+v", opts)
+	}
+	if dir := badgerDir(filepath.Join(store.path, stagingDirName)); opts.Dir != dir || opts.ValueDir != dir {
+		t.Fatalf("bad directories %q, %q", opts.Dir, opts.ValueDir)
+	}
+}
+
+func TestNew_RecoversInterruptedReplacement(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	store.Close()
+
+	// A crash after moving the old directory aside but before the new one
+	// was renamed in
+	dir := badgerDir(store.path)
+	if err := os.Rename(dir, dir+replacedSuffix); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store = testReopen(t, store.path)
+	if err := store.GetLog(3, new(raft.Log)); err != nil {
+		t.Fatalf("expected the old directory to be moved back, got: %v", err)
+	}
+	store.Close()
+
+	// A crash after the new directory was renamed in
+	if err := os.Mkdir(dir+replacedSuffix, 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store = testReopen(t, store.path)
+	defer store.Close()
+	if _, err := os.Stat(dir + replacedSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected the replaced directory to be removed, got: %v", err)
+	}
+	if err := store.GetLog(3, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
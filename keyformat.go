@@ -0,0 +1,339 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Key formats recorded under b.keys.formatVersion. Stores without the key
+// predate it and use the legacy format.
+const (
+	// legacyKeyFormat formats log indexes in decimal, so keys don't sort in
+	// index order
+	legacyKeyFormat uint64 = 1
+	// binaryKeyFormat stores log indexes big-endian, see logKey
+	binaryKeyFormat uint64 = 2
+	// stableKeyFormat stores stable store keys as they are, see confKey,
+	// rather than their bytes spelled out in decimal
+	stableKeyFormat uint64 = 3
+
+	currentKeyFormat = stableKeyFormat
+)
+
+var (
+	// ErrUpgradeRequired is returned when a store in an older format is
+	// opened read-only, or with Options.ManualUpgrade, and so isn't
+	// upgraded
+	ErrUpgradeRequired = errors.New("store must be upgraded to the current format")
+	// ErrMixedKeyFormats is returned when a store recorded as being in the
+	// current format still holds keys in an older one, which it would
+	// misread
+	ErrMixedKeyFormats = errors.New("store holds keys in more than one format")
+)
+
+// Upgrade opens the store described by options, upgrading it to the
+// current format if it is in an older one, and closes it again. It is how
+// stores opened with Options.ManualUpgrade are upgraded, at a time of the
+// operator's choosing; progress is reported through options.OnProgress
+// with the "upgrade-keys" phase.
+func Upgrade(options Options) error {
+	if options.ReadOnly {
+		return ErrReadOnly
+	}
+	options.ManualUpgrade = false
+	store, err := New(options)
+	if err != nil {
+		return err
+	}
+	return store.Close()
+}
+
+// FormatVersion returns the key format the store is written in.
+func (b *BadgerStore) FormatVersion() (format uint64, err error) {
+	err = b.run(context.Background(), func(context.Context) (err error) {
+		format, err = b.keyFormat()
+		return err
+	})
+	return format, err
+}
+
+// keyFormat returns the key format the store is written in.
+func (b *BadgerStore) keyFormat() (uint64, error) {
+	format := legacyKeyFormat
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(b.keys.formatVersion)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if len(v) != 8 {
+			return fmt.Errorf("malformed format version of %d bytes", len(v))
+		}
+		format = bytesToUint64(v)
+		return nil
+	})
+	return format, err
+}
+
+// upgradeKeyFormat rewrites the keys of a store in an older format and
+// records the current format, unless manual is set. Every entry moves to
+// its new key in a single transaction, so an interrupted upgrade leaves
+// each entry under exactly one key and simply continues on the next open.
+// A store already in the current format is checked for leftover keys in an
+// older one. The keys of a separate stable store are rewritten as it is
+// opened.
+func (b *BadgerStore) upgradeKeyFormat(progress ProgressFunc, manual bool) error {
+	format, err := b.keyFormat()
+	if err != nil {
+		return err
+	}
+	if format == currentKeyFormat {
+		return b.checkKeyFormat()
+	}
+	if format > currentKeyFormat {
+		return fmt.Errorf("store uses key format %d, this version only reads up to %d", format, currentKeyFormat)
+	}
+	empty, err := b.ownsNoKeys()
+	if err != nil {
+		return err
+	}
+	if !empty && (b.badgerOpts.ReadOnly || manual) {
+		return ErrUpgradeRequired
+	}
+	if b.badgerOpts.ReadOnly {
+		return nil
+	}
+	if empty {
+		return b.setKeyFormat()
+	}
+	b.logger.Info("upgrading key format", "from", format, "to", currentKeyFormat)
+	if format < binaryKeyFormat {
+		if err := b.rewriteLegacyLogKeys(progress); err != nil {
+			return err
+		}
+	}
+	if err := b.rewriteLegacyConfKeys(b.db, b.newWriteTxn); err != nil {
+		return err
+	}
+	return b.setKeyFormat()
+}
+
+// rewriteLegacyLogKeys moves log entries and soft-deleted ones from their
+// decimal keys to binary ones.
+func (b *BadgerStore) rewriteLegacyLogKeys(progress ProgressFunc) error {
+	total, err := b.countLogs()
+	if err != nil {
+		return err
+	}
+	reporter := newProgressReporter(progress, "upgrade-keys", total)
+	reporter.report(0)
+	done := uint64(0)
+	for _, prefix := range [][]byte{b.keys.logs, b.keys.trash} {
+		n, err := b.rewriteLegacyKeys(prefix, func(moved uint64) {
+			if bytes.Equal(prefix, b.keys.logs) {
+				reporter.report(done + moved)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(prefix, b.keys.logs) {
+			done += n
+		}
+	}
+	reporter.report(total)
+	return nil
+}
+
+// rewriteLegacyConfKeys moves the stable store values of db from legacy
+// keys to their confKey. A read-only store can't and fails with
+// ErrUpgradeRequired if there are any. Stable stores are small, so they
+// are moved in one go.
+func (b *BadgerStore) rewriteLegacyConfKeys(db *badger.DB, newTxn func() *writeTxn) error {
+	var legacy [][]byte
+	var entries []badger.Entry
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(b.keys.legacyConf); it.ValidForPrefix(b.keys.legacyConf); it.Next() {
+			item := it.Item()
+			k, err := b.keys.parseLegacyConfKey(item.Key())
+			if err != nil {
+				return err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, badger.Entry{Key: b.keys.confKey(k), Value: v, UserMeta: item.UserMeta()})
+			legacy = append(legacy, item.KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	if b.badgerOpts.ReadOnly {
+		return ErrUpgradeRequired
+	}
+	b.logger.Info("rewriting stable store keys", "keys", len(entries))
+	return b.moveEntries(newTxn, legacy, entries)
+}
+
+// checkKeyFormat makes sure no legacy keys are left under the prefixes
+// whose keys hold indexes. Legacy keys spell indexes out in ASCII digits,
+// so they sort after the binary key of any index below 3.4e18 and the last
+// key under each prefix gives them away. Legacy stable store keys have a
+// prefix of their own.
+func (b *BadgerStore) checkKeyFormat() error {
+	return b.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for _, prefix := range [][]byte{b.keys.logs, b.keys.trash} {
+			it.Seek(append(append([]byte(nil), prefix...), bytes.Repeat([]byte{0xff}, 9)...))
+			if !it.ValidForPrefix(prefix) {
+				continue
+			}
+			if key := it.Item().Key(); len(key) != len(prefix)+8 {
+				return fmt.Errorf("%w: found %q", ErrMixedKeyFormats, key)
+			}
+		}
+		if it.Seek(append(append([]byte(nil), b.keys.legacyConf...), 0xff)); it.ValidForPrefix(b.keys.legacyConf) {
+			return fmt.Errorf("%w: found %q", ErrMixedKeyFormats, it.Item().Key())
+		}
+		return nil
+	})
+}
+
+// ownsNoKeys reports whether the store's database holds none of its keys,
+// ignoring keys others keep in a shared database.
+func (b *BadgerStore) ownsNoKeys() (bool, error) {
+	empty := true
+	err := b.db.View(func(txn *badger.Txn) error {
+		for _, prefix := range b.keys.reserved() {
+			if countPrefix(txn, prefix) > 0 {
+				empty = false
+				return nil
+			}
+		}
+		return nil
+	})
+	return empty, err
+}
+
+func (b *BadgerStore) setKeyFormat() error {
+	return b.update(func(txn *writeTxn) error {
+		return txn.Set(b.keys.formatVersion, uint64ToBytes(currentKeyFormat))
+	})
+}
+
+// legacyIndex returns the index of a legacy key under prefix, whose index is
+// spelled out in decimal. A binary key could only be mistaken for one if
+// every byte of its index were an ASCII digit, which takes an index above
+// 3.4e18.
+func legacyIndex(prefix, key []byte) (uint64, bool) {
+	if !bytes.HasPrefix(key, prefix) {
+		return 0, false
+	}
+	digits := key[len(prefix):]
+	if len(digits) == 0 {
+		return 0, false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	idx, err := strconv.ParseUint(string(digits), 10, 64)
+	return idx, err == nil
+}
+
+// rewriteLegacyKeys moves every entry under prefix with a legacy key to its
+// binary key, keeping its value, user meta and expiry, and returns how many
+// it moved. report is called after each batch.
+func (b *BadgerStore) rewriteLegacyKeys(prefix []byte, report func(moved uint64)) (uint64, error) {
+	moved := uint64(0)
+	next := prefix
+	for {
+		var batch []badger.Entry
+		var legacy [][]byte
+		err := b.db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer it.Close()
+			for it.Seek(next); it.ValidForPrefix(prefix) && len(batch) < migrateBatchSize; it.Next() {
+				item := it.Item()
+				next = append(item.KeyCopy(nil), 0)
+				idx, ok := legacyIndex(prefix, item.Key())
+				if !ok {
+					continue
+				}
+				v, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				batch = append(batch, badger.Entry{
+					Key:       indexKey(prefix, idx),
+					Value:     v,
+					UserMeta:  item.UserMeta(),
+					ExpiresAt: item.ExpiresAt(),
+				})
+				legacy = append(legacy, item.KeyCopy(nil))
+			}
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+		if len(batch) == 0 {
+			return moved, nil
+		}
+		if err := b.moveEntries(b.newWriteTxn, legacy, batch); err != nil {
+			return moved, err
+		}
+		moved += uint64(len(batch))
+		report(moved)
+	}
+}
+
+// moveEntries deletes each of the old keys and writes the matching entry,
+// both in the same transaction started by newTxn, splitting the batch
+// across transactions if it doesn't fit into one.
+func (b *BadgerStore) moveEntries(newTxn func() *writeTxn, old [][]byte, entries []badger.Entry) error {
+	txn := newTxn()
+	defer func() { txn.Discard() }()
+	for i := range entries {
+		// Written before the delete, so a transaction cut short in between
+		// holds the entry twice rather than not at all
+		err := txn.SetEntry(&entries[i])
+		if err == nil {
+			err = txn.Delete(old[i])
+		}
+		if err == badger.ErrTxnTooBig {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = newTxn()
+			if err = txn.SetEntry(&entries[i]); err == nil {
+				err = txn.Delete(old[i])
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
@@ -0,0 +1,291 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_IndexOrder(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 20; i++ {
+		logs = append(logs, testRaftLog(i, fmt.Sprintf("log%d", i)))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	first, err := store.FirstIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first != 1 || last != 20 {
+		t.Fatalf("expected 1-20, got %d-%d", first, last)
+	}
+
+	// Deleting 1-9 must not take 10-15 along with it, or leave them behind
+	if err := store.DeleteRange(1, 15); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	first, err = store.FirstIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first != 16 {
+		t.Fatalf("expected first index 16, got %d", first)
+	}
+}
+
+// testLegacyStore creates a store at a temporary path holding logs 1 to n
+// under the legacy decimal keys, as written before binary keys.
+func testLegacyStore(t *testing.T, n uint64) string {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dir := badgerDir(fh)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	opts := badger.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+	err = db.Update(func(txn *badger.Txn) error {
+		for i := uint64(1); i <= n; i++ {
+			v, err := encodeWithCodec(GobCodec{}, testRaftLog(i, fmt.Sprintf("log%d", i)))
+			if err != nil {
+				return err
+			}
+			if err := txn.SetWithMeta([]byte(fmt.Sprintf("logs%d", i)), v, byte(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return fh
+}
+
+func TestNew_UpgradesLegacyKeys(t *testing.T) {
+	fh := testLegacyStore(t, 12)
+	defer os.RemoveAll(fh)
+
+	var progress []Progress
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, OnProgress: func(p Progress) {
+		if p.Phase == "upgrade-keys" {
+			progress = append(progress, p)
+		}
+	}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if len(progress) == 0 || progress[len(progress)-1].Done != 12 {
+		t.Fatalf("bad progress: %+v", progress)
+	}
+
+	format, err := store.keyFormat()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if format != currentKeyFormat {
+		t.Fatalf("expected format %d, got %d", currentKeyFormat, format)
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last != 12 {
+		t.Fatalf("expected last index 12, got %d", last)
+	}
+	result := new(raft.Log)
+	if err := store.GetLog(10, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "log10" {
+		t.Fatalf("bad: %#v", result)
+	}
+	meta, err := store.GetLogMeta(10)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if meta != 10 {
+		t.Fatalf("expected user meta to be kept, got %d", meta)
+	}
+	if n, err := store.countLogs(); err != nil || n != 12 {
+		t.Fatalf("expected 12 keys, got %d: %v", n, err)
+	}
+}
+
+func TestNew_LegacyKeysReadOnly(t *testing.T) {
+	fh := testLegacyStore(t, 3)
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	badgerOpts.ReadOnly = true
+	if _, err := New(Options{Path: fh, BadgerOptions: &badgerOpts}); err != ErrUpgradeRequired {
+		t.Fatalf("expected ErrUpgradeRequired, got: %v", err)
+	}
+}
+
+func TestNew_ManualUpgrade(t *testing.T) {
+	fh := testLegacyStore(t, 3)
+	defer os.RemoveAll(fh)
+
+	if _, err := New(Options{Path: fh, ManualUpgrade: true}); err != ErrUpgradeRequired {
+		t.Fatalf("expected ErrUpgradeRequired, got: %v", err)
+	}
+	if err := Upgrade(Options{Path: fh, ManualUpgrade: true}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store, err := New(Options{Path: fh, ManualUpgrade: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if format, err := store.FormatVersion(); err != nil || format != currentKeyFormat {
+		t.Fatalf("bad: %d, %v", format, err)
+	}
+	if last, err := store.LastIndex(); err != nil || last != 3 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+}
+
+func TestNew_MixedKeyFormats(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	// A legacy key written behind the store's back, as by an older version
+	// sharing the directory
+	err := store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("logs6"), []byte("x"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := NewBadgerStore(store.path); !errors.Is(err, ErrMixedKeyFormats) {
+		t.Fatalf("expected ErrMixedKeyFormats, got: %v", err)
+	}
+}
+
+// testLegacyStableStore creates a store at a temporary path in
+// binaryKeyFormat, holding CurrentTerm and key under legacy stable store
+// keys, in a separate stable store if separate is set.
+func testLegacyStableStore(t *testing.T, separate bool) string {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store, err := New(Options{Path: fh, SeparateStableStore: separate})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(defaultKeys.formatVersion, uint64ToBytes(binaryKeyFormat))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = store.stableDB.Update(func(txn *badger.Txn) error {
+		for k, v := range map[string][]byte{"CurrentTerm": uint64ToBytes(7), "key": []byte("value")} {
+			if err := txn.Set([]byte(fmt.Sprintf("conf%d", []byte(k))), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return fh
+}
+
+func TestNew_UpgradesLegacyStableKeys(t *testing.T) {
+	for _, separate := range []bool{false, true} {
+		fh := testLegacyStableStore(t, separate)
+		defer os.RemoveAll(fh)
+
+		badgerOpts := badger.DefaultOptions
+		badgerOpts.ReadOnly = true
+		if _, err := New(Options{Path: fh, SeparateStableStore: separate, BadgerOptions: &badgerOpts}); err != ErrUpgradeRequired {
+			t.Fatalf("expected ErrUpgradeRequired, got: %v", err)
+		}
+
+		store, err := New(Options{Path: fh, SeparateStableStore: separate})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if format, err := store.FormatVersion(); err != nil || format != currentKeyFormat {
+			t.Fatalf("bad: %d, %v", format, err)
+		}
+		if term, err := store.GetUint64([]byte("CurrentTerm")); err != nil || term != 7 {
+			t.Fatalf("bad: %d, %v", term, err)
+		}
+		if v, err := store.Get([]byte("key")); err != nil || string(v) != "value" {
+			t.Fatalf("bad: %q, %v", v, err)
+		}
+		keys, err := store.StableKeys()
+		if err != nil || len(keys) != 2 {
+			t.Fatalf("bad: %q, %v", keys, err)
+		}
+		// Keys are stored as they are, readable by any tool
+		err = store.stableDB.View(func(txn *badger.Txn) error {
+			_, err := txn.Get([]byte("conf/CurrentTerm"))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+}
+
+func TestKeyPrefixes_ConfKey(t *testing.T) {
+	for _, k := range [][]byte{[]byte("CurrentTerm"), {}, {0, 0xff}, []byte("[1 2]")} {
+		back, err := defaultKeys.parseConfKey(defaultKeys.confKey(k))
+		if err != nil || !bytes.Equal(back, k) {
+			t.Fatalf("bad: %q %v", back, err)
+		}
+		// A legacy key never passes for a current one, nor the other way
+		legacy := []byte(fmt.Sprintf("conf%d", k))
+		if _, err := defaultKeys.parseConfKey(legacy); err == nil {
+			t.Fatalf("%q parsed as a current key", legacy)
+		}
+		if _, err := defaultKeys.parseLegacyConfKey(defaultKeys.confKey(k)); err == nil {
+			t.Fatalf("%q parsed as a legacy key", k)
+		}
+		back, err = defaultKeys.parseLegacyConfKey(legacy)
+		if err != nil || !bytes.Equal(back, k) {
+			t.Fatalf("bad: %q %v", back, err)
+		}
+	}
+}
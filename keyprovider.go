@@ -0,0 +1,140 @@
+package raftbadgerdb
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyProvider supplies the encryption keys of a store from outside its
+// Options, such as a key file or a secrets manager.
+type KeyProvider interface {
+	// EncryptionKeys returns the key to encrypt new log entries with and
+	// the retired keys stored entries may still be encrypted with
+	EncryptionKeys() (active EncryptionKey, retired []EncryptionKey, err error)
+}
+
+// KeyProviderFunc adapts a function to KeyProvider, for instance one
+// decrypting a data key with AWS KMS or reading it from Vault.
+type KeyProviderFunc func() (EncryptionKey, []EncryptionKey, error)
+
+// EncryptionKeys implements KeyProvider.
+func (f KeyProviderFunc) EncryptionKeys() (EncryptionKey, []EncryptionKey, error) {
+	return f()
+}
+
+// EnvKeyProvider reads keys from the environment variable Name, in the
+// format ParseEncryptionKeys accepts.
+type EnvKeyProvider struct {
+	Name string
+}
+
+// EncryptionKeys implements KeyProvider.
+func (p EnvKeyProvider) EncryptionKeys() (EncryptionKey, []EncryptionKey, error) {
+	spec, ok := os.LookupEnv(p.Name)
+	if !ok {
+		return EncryptionKey{}, nil, fmt.Errorf("environment variable %s is not set", p.Name)
+	}
+	return ParseEncryptionKeys(spec)
+}
+
+// FileKeyProvider reads keys from the file at Path, in the format
+// ParseEncryptionKeys accepts. The file is read again on every refresh, so
+// replacing it rotates the key.
+type FileKeyProvider struct {
+	Path string
+}
+
+// EncryptionKeys implements KeyProvider.
+func (p FileKeyProvider) EncryptionKeys() (EncryptionKey, []EncryptionKey, error) {
+	spec, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return EncryptionKey{}, nil, err
+	}
+	return ParseEncryptionKeys(string(spec))
+}
+
+// ParseEncryptionKeys parses keys written as the key ID, a colon and the
+// base64-encoded key, separated by commas or white space, such as
+// "2:q83v...,1:3q2+...". The first key is the active one and the others are
+// retired.
+func ParseEncryptionKeys(spec string) (EncryptionKey, []EncryptionKey, error) {
+	fields := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) == 0 {
+		return EncryptionKey{}, nil, errors.New("no encryption key given")
+	}
+	keys := make([]EncryptionKey, len(fields))
+	for i, f := range fields {
+		parts := strings.SplitN(f, ":", 2)
+		if len(parts) != 2 {
+			return EncryptionKey{}, nil, fmt.Errorf("encryption key %d: want id:base64", i+1)
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return EncryptionKey{}, nil, fmt.Errorf("encryption key %d: bad id: %w", i+1, err)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return EncryptionKey{}, nil, fmt.Errorf("encryption key %d: %w", id, err)
+		}
+		keys[i] = EncryptionKey{ID: uint32(id), Key: key}
+	}
+	return keys[0], keys[1:], nil
+}
+
+// refreshKeys fetches the keys from the store's KeyProvider and rotates to
+// the active one if it changed.
+func (b *BadgerStore) refreshKeys() error {
+	active, retired, err := b.keyProvider.EncryptionKeys()
+	if err != nil {
+		return err
+	}
+	if err := b.cipher.addKeys(retired); err != nil {
+		return err
+	}
+	if b.cipher.isActive(active) {
+		return nil
+	}
+	n, err := b.RotateEncryptionKey(active)
+	if err != nil {
+		return err
+	}
+	b.logger.Info("rotated to a new encryption key", "key-id", active.ID, "rewritten", n)
+	return nil
+}
+
+func (b *BadgerStore) startKeyRefresh(interval time.Duration) {
+	b.keyRefreshStop = make(chan struct{})
+	b.keyRefreshDone = make(chan struct{})
+	go func() {
+		defer close(b.keyRefreshDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-b.keyRefreshStop:
+				return
+			}
+			if err := b.refreshKeys(); err != nil {
+				b.metrics.incrCounter([]string{"encryption", "refresh_failed"}, 1)
+				b.logger.Warn("refreshing encryption keys failed", "error", err)
+			}
+		}
+	}()
+}
+
+func (b *BadgerStore) stopKeyRefresh() {
+	if b.keyRefreshStop == nil {
+		return
+	}
+	close(b.keyRefreshStop)
+	<-b.keyRefreshDone
+}
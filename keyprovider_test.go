@@ -0,0 +1,132 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testKeySpec(keys ...EncryptionKey) string {
+	var spec string
+	for i, k := range keys {
+		if i > 0 {
+			spec += ","
+		}
+		spec += fmt.Sprintf("%d:%s", k.ID, base64.StdEncoding.EncodeToString(k.Key))
+	}
+	return spec
+}
+
+func TestParseEncryptionKeys(t *testing.T) {
+	key1 := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	key2 := EncryptionKey{ID: 2, Key: bytes.Repeat([]byte{2}, 16)}
+
+	active, retired, err := ParseEncryptionKeys(testKeySpec(key2, key1) + "\n")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(active, key2) || !reflect.DeepEqual(retired, []EncryptionKey{key1}) {
+		t.Fatalf("bad: %v %v", active, retired)
+	}
+	for _, spec := range []string{"", " \n", "1", "x:AAAA", "1:not base64!"} {
+		if _, _, err := ParseEncryptionKeys(spec); err == nil {
+			t.Fatalf("%q: expected an error", spec)
+		}
+	}
+
+	os.Setenv("RAFT_BADGER_TEST_KEYS", testKeySpec(key1))
+	defer os.Unsetenv("RAFT_BADGER_TEST_KEYS")
+	if active, _, err := (EnvKeyProvider{Name: "RAFT_BADGER_TEST_KEYS"}).EncryptionKeys(); err != nil || !reflect.DeepEqual(active, key1) {
+		t.Fatalf("bad: %v %v", active, err)
+	}
+	if _, _, err := (EnvKeyProvider{Name: "RAFT_BADGER_TEST_UNSET"}).EncryptionKeys(); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestNew_KeyProvider(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	key1 := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	key2 := EncryptionKey{ID: 2, Key: bytes.Repeat([]byte{2}, 32)}
+	keyFile := filepath.Join(fh, "keys")
+	if err := ioutil.WriteFile(keyFile, []byte(testKeySpec(key1)), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	provider := FileKeyProvider{Path: keyFile}
+
+	if _, err := New(Options{Path: fh, KeyProvider: provider, EncryptionKey: &key1}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if _, err := New(Options{Path: fh, KeyRefreshInterval: time.Second}); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	store, err := New(Options{Path: fh, KeyProvider: provider, KeyRefreshInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Replacing the key file rotates the store to the new key
+	if err := ioutil.WriteFile(keyFile, []byte(testKeySpec(key2, key1)), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var id uint32
+		err := store.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(defaultKeys.logKey(1))
+			if err != nil {
+				return err
+			}
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			id = binary.BigEndian.Uint32(v[checksumEnvelopeSize+1:])
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if id == key2.ID {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entry still encrypted with key %d", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store, err = New(Options{Path: fh, KeyProvider: KeyProviderFunc(func() (EncryptionKey, []EncryptionKey, error) {
+		return key2, nil, nil
+	})})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	log := new(raft.Log)
+	if err := store.GetLog(1, log); err != nil || string(log.Data) != "log1" {
+		t.Fatalf("bad: %v %#v", err, log)
+	}
+}
@@ -0,0 +1,209 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keyPrefixes are the prefixes of every key a store owns. A store on a
+// Badger database of its own uses defaultKeys; stores sharing one, such as
+// the groups of a MultiStore, put a namespace in front of all of them.
+type keyPrefixes struct {
+	// namespace is put in front of every other prefix
+	namespace []byte
+
+	// logs holds log entries and conf StableStore values under their keys
+	// as given. legacyConf holds those of stores from before
+	// stableKeyFormat, whose keys spelled the key's bytes out in decimal,
+	// like "conf[67 117 ...]"
+	logs       []byte
+	conf       []byte
+	legacyConf []byte
+	// meta holds the store's own bookkeeping, such as migration
+	// checkpoints
+	meta []byte
+	// trash holds log entries DeleteRange removed while
+	// Options.SoftDeleteGracePeriod is set, until they expire
+	trash []byte
+	// blob holds deduplicated payloads under "blob" "d" hash and their
+	// reference counts under "blob" "r" hash
+	blob []byte
+	// snap holds snapshot metadata under "snap" "m" id and snapshot data
+	// under "snap" "c" id "/" chunk number
+	snap []byte
+
+	// pendingDeletes holds the ranges DeleteRange has hidden but not yet
+	// physically removed when Options.AsyncDeleteRange is set
+	pendingDeletes []byte
+	// formatVersion records the key format the store is written in
+	formatVersion []byte
+	// appendTimes holds append time marks: the key is the big-endian index
+	// of the first entry of a batch and the value the time it was stored,
+	// in Unix nanoseconds
+	appendTimes []byte
+	// codecMigration records how far an interrupted codec migration got
+	codecMigration []byte
+	// groups records the raft groups of a MultiStore, one key per group,
+	// and groupKeys holds their keys under the group ID and a slash
+	groups    []byte
+	groupKeys []byte
+}
+
+// defaultKeys are the key prefixes of a store without a namespace.
+var defaultKeys = newKeyPrefixes(nil)
+
+func newKeyPrefixes(namespace []byte) keyPrefixes {
+	prefix := func(parts ...string) []byte {
+		return []byte(string(namespace) + strings.Join(parts, ""))
+	}
+	return keyPrefixes{
+		namespace:      append([]byte(nil), namespace...),
+		logs:           prefix("logs"),
+		conf:           prefix("conf/"),
+		legacyConf:     prefix("conf["),
+		meta:           prefix("meta"),
+		trash:          prefix("trash"),
+		blob:           prefix("blob"),
+		snap:           prefix("snap"),
+		pendingDeletes: prefix("meta", "pending-deletes"),
+		formatVersion:  prefix("meta", "format-version"),
+		appendTimes:    prefix("meta", "appended-at/"),
+		codecMigration: prefix("meta", "codec-migration"),
+		groups:         prefix("meta", "groups/"),
+		groupKeys:      prefix("g/"),
+	}
+}
+
+// reserved returns every prefix of k, copied.
+func (k keyPrefixes) reserved() [][]byte {
+	var out [][]byte
+	for _, prefix := range [][]byte{k.logs, k.conf, k.legacyConf, k.meta, k.trash, k.blob, k.snap} {
+		out = append(out, append([]byte(nil), prefix...))
+	}
+	return out
+}
+
+// overlaps reports whether a key could fall under a prefix of both k and o,
+// counting the keys of MultiStore groups.
+func (k keyPrefixes) overlaps(o keyPrefixes) bool {
+	for _, p := range append(k.reserved(), k.groupKeys) {
+		for _, q := range append(o.reserved(), o.groupKeys) {
+			if bytes.HasPrefix(p, q) || bytes.HasPrefix(q, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// logKey returns the key a log entry is stored under: the logs prefix
+// followed by the big-endian index, so keys sort in index order
+func (k keyPrefixes) logKey(idx uint64) []byte {
+	return indexKey(k.logs, idx)
+}
+
+// parseLogKey returns the index of the log entry stored under key
+func (k keyPrefixes) parseLogKey(key []byte) (uint64, error) {
+	if !bytes.HasPrefix(key, k.logs) || len(key) != len(k.logs)+8 {
+		return 0, fmt.Errorf("not a log key: %q", key)
+	}
+	return bytesToUint64(key[len(k.logs):]), nil
+}
+
+func indexKey(prefix []byte, idx uint64) []byte {
+	key := make([]byte, len(prefix)+8)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], idx)
+	return key
+}
+
+// confKey returns the key a stable store value is stored under: the conf
+// prefix followed by the key as it is
+func (k keyPrefixes) confKey(key []byte) []byte {
+	return append(append([]byte(nil), k.conf...), key...)
+}
+
+// parseConfKey returns the stable store key stored under key.
+func (k keyPrefixes) parseConfKey(key []byte) ([]byte, error) {
+	if !bytes.HasPrefix(key, k.conf) {
+		return nil, fmt.Errorf("not a stable store key: %q", key)
+	}
+	return append([]byte(nil), key[len(k.conf):]...), nil
+}
+
+// parseLegacyConfKey returns the stable store key a store from before
+// stableKeyFormat stored under key, which spells its bytes out in decimal.
+func (k keyPrefixes) parseLegacyConfKey(key []byte) ([]byte, error) {
+	spelled := string(bytes.TrimPrefix(key, k.legacyConf))
+	if len(spelled) == len(key) || !strings.HasSuffix(spelled, "]") {
+		return nil, fmt.Errorf("not a legacy stable store key: %q", key)
+	}
+	fields := strings.Fields(spelled[:len(spelled)-1])
+	out := make([]byte, len(fields))
+	for i, f := range fields {
+		c, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("not a legacy stable store key: %q", key)
+		}
+		out[i] = byte(c)
+	}
+	return out, nil
+}
+
+// trashKey returns the key a soft-deleted log entry is kept under, laid out
+// like logKey
+func (k keyPrefixes) trashKey(idx uint64) []byte {
+	return indexKey(k.trash, idx)
+}
+
+// parseTrashKey returns the index of the log entry kept under key
+func (k keyPrefixes) parseTrashKey(key []byte) (uint64, error) {
+	if !bytes.HasPrefix(key, k.trash) || len(key) != len(k.trash)+8 {
+		return 0, fmt.Errorf("not a trash key: %q", key)
+	}
+	return bytesToUint64(key[len(k.trash):]), nil
+}
+
+// parseBlobKey returns whether key holds a payload, 'd', or its reference
+// count, 'r', and the payload's hash.
+func (k keyPrefixes) parseBlobKey(key []byte) (byte, blobHash, error) {
+	var h blobHash
+	if !bytes.HasPrefix(key, k.blob) || len(key) != len(k.blob)+1+len(h) {
+		return 0, h, fmt.Errorf("not a payload key: %q", key)
+	}
+	kind := key[len(k.blob)]
+	if kind != 'd' && kind != 'r' {
+		return 0, h, fmt.Errorf("not a payload key: %q", key)
+	}
+	copy(h[:], key[len(k.blob)+1:])
+	return kind, h, nil
+}
+
+func (k keyPrefixes) blobDataKey(h blobHash) []byte {
+	return append(append(append([]byte(nil), k.blob...), 'd'), h[:]...)
+}
+
+func (k keyPrefixes) blobRefKey(h blobHash) []byte {
+	return append(append(append([]byte(nil), k.blob...), 'r'), h[:]...)
+}
+
+func (k keyPrefixes) snapMetaKey(id string) []byte {
+	return append(append(append([]byte(nil), k.snap...), 'm'), id...)
+}
+
+func (k keyPrefixes) snapChunkPrefix(id string) []byte {
+	return append(append(append(append([]byte(nil), k.snap...), 'c'), id...), '/')
+}
+
+func (k keyPrefixes) snapChunkKey(id string, n uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], n)
+	return append(k.snapChunkPrefix(id), buf[:]...)
+}
+
+func (k keyPrefixes) appendTimeKey(idx uint64) []byte {
+	return append(append([]byte(nil), k.appendTimes...), uint64ToBytes(idx)...)
+}
@@ -0,0 +1,102 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestKeyPrefixes_Overlaps(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		overlaps bool
+	}{
+		{"", "", true},
+		{"app1/", "", false},
+		{"app1/", "app2/", false},
+		{"app1/", "app1/", true},
+		{"logs", "", true},
+		{"log", "", true},
+		{"g/a/", "", true},
+		{"a", "alogs", true},
+		{"a/", "a/b/", false},
+	}
+	for _, c := range cases {
+		if got := newKeyPrefixes([]byte(c.a)).overlaps(newKeyPrefixes([]byte(c.b))); got != c.overlaps {
+			t.Fatalf("%q and %q: got %v", c.a, c.b, got)
+		}
+	}
+}
+
+func TestNew_Namespace(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	for _, ns := range []string{"logs", "log", "snap/", "g/a/"} {
+		if _, err := New(Options{Path: fh, Namespace: []byte(ns)}); !errors.Is(err, ErrNamespaceOverlap) {
+			t.Fatalf("%q: %v", ns, err)
+		}
+	}
+
+	store, err := New(Options{Path: fh, Namespace: []byte("app1/")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if prefix := store.LogsPrefix(); string(prefix) != "app1/logs" {
+		t.Fatalf("bad: %q", prefix)
+	}
+	err = store.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if !bytes.HasPrefix(it.Item().Key(), []byte("app1/")) {
+				t.Fatalf("key outside the namespace: %q", it.Item().Key())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A store without the namespace doesn't see the entries
+	other, err := New(Options{Path: fh})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last, err := other.LastIndex(); err != nil || last != 0 {
+		t.Fatalf("bad: %d %v", last, err)
+	}
+	if _, err := other.GetUint64([]byte("CurrentTerm")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("err: %v", err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store, err = New(Options{Path: fh, Namespace: []byte("app1/")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if last, err := store.LastIndex(); err != nil || last != 2 {
+		t.Fatalf("bad: %d %v", last, err)
+	}
+}
@@ -0,0 +1,148 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultCloseTimeout is how long Close waits for operations in flight
+// unless Options.CloseTimeout says otherwise.
+const defaultCloseTimeout = 30 * time.Second
+
+var (
+	// ErrStoreClosed is returned by operations started once Close has
+	// begun
+	ErrStoreClosed = errors.New("store closed")
+
+	// ErrCloseTimeout is returned by Close when operations in flight don't
+	// finish within Options.CloseTimeout. The store rejects new operations
+	// and has stopped its background work, but stays open, and Close can be
+	// called again to keep waiting.
+	ErrCloseTimeout = errors.New("timed out waiting for operations to finish")
+)
+
+// opTracker counts the operations in flight, so Close can wait for them
+// before closing Badger under their feet, and turns away new ones once
+// Close has begun. Stores of a MultiStore's groups share the tracker of the
+// database.
+type opTracker struct {
+	mu       sync.Mutex
+	closing  bool
+	closed   bool
+	inflight int
+	// drained is closed once the last operation in flight ends while
+	// closing
+	drained chan struct{}
+}
+
+// begin registers an operation, failing with ErrStoreClosed once closing.
+func (t *opTracker) begin() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return ErrStoreClosed
+	}
+	t.inflight++
+	return nil
+}
+
+// end unregisters an operation begun with begin.
+func (t *opTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inflight--
+	if t.inflight == 0 && t.drained != nil {
+		close(t.drained)
+		t.drained = nil
+	}
+}
+
+// drain turns away new operations and waits up to timeout, or without
+// limit if it is negative, for those in flight. It reports whether the
+// store was closed already.
+func (t *opTracker) drain(timeout time.Duration) (closed bool, err error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return true, nil
+	}
+	t.closing = true
+	if t.inflight == 0 {
+		t.mu.Unlock()
+		return false, nil
+	}
+	if t.drained == nil {
+		t.drained = make(chan struct{})
+	}
+	drained := t.drained
+	t.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-drained:
+		return false, nil
+	case <-expired:
+		t.mu.Lock()
+		n := t.inflight
+		t.mu.Unlock()
+		return false, fmt.Errorf("%w: %d still running after %s", ErrCloseTimeout, n, timeout)
+	}
+}
+
+// markClosed records that the store is closed and reports whether it
+// wasn't already, so of concurrent calls to Close only one closes Badger.
+func (t *opTracker) markClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.closed = true
+	return true
+}
+
+// run runs fn as an operation of the store: it fails with ErrStoreClosed
+// once Close has begun, and Close waits for it to end. Once ctx is done,
+// run returns ctx's error right away, even if fn is blocked, on a wedged
+// disk say. fn then keeps running in the background, still counted as in
+// flight, until it notices ctx is done, or Badger returns, and discards its
+// transaction; a write it was committing may still land. So fn must not
+// write to memory the caller can see before run returns nil, and callers
+// only read what fn produced on success.
+func (b *BadgerStore) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := b.ops.begin(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		defer b.ops.end()
+		return fn(ctx)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer b.ops.end()
+		done <- fn(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Don't report an operation that finished as abandoned
+		select {
+		case err := <-done:
+			return err
+		default:
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_CloseDrains(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+
+	// Close waits for the operation in flight and turns new ones away
+	started, release := make(chan struct{}), make(chan struct{})
+	go store.run(context.Background(), func(context.Context) error {
+		close(started)
+		<-release
+		return store.StoreLog(testRaftLog(1, "log1"))
+	})
+	<-started
+	closed := make(chan error, 1)
+	go func() {
+		closed <- store.Close()
+	}()
+	for {
+		if _, err := store.LastIndex(); err == ErrStoreClosed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("closed with an operation in flight: %v", err)
+	default:
+	}
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := store.StoreLog(testRaftLog(2, "log2")); err != ErrStoreClosed {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := store.Get([]byte("k")); err != ErrStoreClosed {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBadgerStore_CloseTimeout(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store, err := New(Options{Path: fh, CloseTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The store stays open, turning operations away, until the one in
+	// flight ends and Close is called again
+	started, release := make(chan struct{}), make(chan struct{})
+	go store.run(context.Background(), func(context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	if err := store.Close(); !errors.Is(err, ErrCloseTimeout) {
+		t.Fatalf("expected timeout, got: %v", err)
+	}
+	if err := store.GetLog(1, new(raft.Log)); err != ErrStoreClosed {
+		t.Fatalf("err: %v", err)
+	}
+	close(release)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBadgerStore_CloseConcurrentWrites(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				k := []byte{byte(w)}
+				if err := store.SetUint64(k, uint64(i)); err != nil {
+					if err != ErrStoreClosed {
+						t.Errorf("err: %s", err)
+					}
+					return
+				}
+			}
+		}(w)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	wg.Wait()
+}
+
+func TestBadgerStore_UseAfterClose(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	snaps, err := NewSnapshotStore(store, 1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	batch, err := store.NewLogBatch()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	it, err := store.ReplayLogs(0, 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer it.Close()
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	calls := map[string]func() error{
+		"FirstIndex":       func() error { _, err := store.FirstIndex(); return err },
+		"GetLog":           func() error { return store.GetLog(1, new(raft.Log)) },
+		"StoreLog":         func() error { return store.StoreLog(testRaftLog(6, "log6")) },
+		"DeleteRange":      func() error { return store.DeleteRange(1, 2) },
+		"Set":              func() error { return store.Set([]byte("k"), []byte("v")) },
+		"Get":              func() error { _, err := store.Get([]byte("k")); return err },
+		"LogComposition":   func() error { _, err := store.LogComposition(); return err },
+		"CheckConsistency": func() error { _, err := store.CheckConsistency(); return err },
+		"SetLogMeta":       func() error { return store.SetLogMeta(1, 1) },
+		"GetLogMeta":       func() error { _, err := store.GetLogMeta(1); return err },
+		"Undelete":         func() error { return store.Undelete(1, 2) },
+		"Backup":           func() error { _, err := store.Backup(&buf, 0); return err },
+		"BackupScoped":     func() error { return store.BackupScoped(&buf, BackupScope{Logs: true}) },
+		"Restore":          func() error { return store.Restore(&buf) },
+		"RestoreScoped":    func() error { return store.RestoreScoped(&buf) },
+		"LogBatch.Append":  func() error { return batch.Append(testRaftLog(6, "log6")) },
+		"LogBatch.Flush":   func() error { return batch.Flush() },
+		"RunValueLogGC":    func() error { _, err := store.RunValueLogGC(0.5); return err },
+		"Sync":             func() error { return store.Sync() },
+		"ReplayLogs":       func() error { _, err := store.ReplayLogs(0, 0); return err },
+		"LogIterator.Next": func() error { it.Next(); return it.Err() },
+		"FlushDeletes":     func() error { return store.FlushDeletes() },
+		"FormatVersion":    func() error { _, err := store.FormatVersion(); return err },
+		"LogAge":           func() error { _, _, err := store.LogAge(); return err },
+		"Stats":            func() error { _, err := store.Stats(); return err },
+		"ScanLogs":         func() error { return store.ScanLogs(LogFilter{}, func(*raft.Log) error { return nil }) },
+		"Export":           func() error { return store.Export(&buf, ExportNDJSON, LogFilter{}) },
+		"Update":           func() error { return store.Update(func(*StoreTxn) error { return nil }) },
+		"Snapshots.List":   func() error { _, err := snaps.List(); return err },
+		"Snapshots.Open":   func() error { _, _, err := snaps.Open("1-1-1"); return err },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrStoreClosed) {
+			t.Fatalf("%s: expected ErrStoreClosed, got: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// appendTimeResolution is how often StoreLogs records when entries were
+// appended. Log ages are accurate to about this much.
+const appendTimeResolution = time.Minute
+
+// markAppendTime records the receive time of the entry at idx within txn,
+// unless a mark was made less than appendTimeResolution ago.
+func (b *BadgerStore) markAppendTime(txn *writeTxn, idx uint64) error {
+	now := time.Now()
+	b.appendMu.Lock()
+	due := now.Sub(b.lastAppendMark) >= appendTimeResolution
+	if due {
+		b.lastAppendMark = now
+	}
+	b.appendMu.Unlock()
+	if !due {
+		return nil
+	}
+	return txn.Set(b.keys.appendTimeKey(idx), uint64ToBytes(uint64(now.UnixNano())))
+}
+
+// pruneAppendTimes drops the marks of deleted entries. The last mark inside
+// the range is kept, since it still dates the entries following it.
+func (b *BadgerStore) pruneAppendTimes(min, max uint64) error {
+	return b.update(func(txn *writeTxn) error {
+		return b.pruneAppendTimesTxn(txn, min, max)
+	})
+}
+
+// pruneAppendTimesTxn is pruneAppendTimes within txn.
+func (b *BadgerStore) pruneAppendTimesTxn(txn *writeTxn, min, max uint64) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	var marks [][]byte
+	end := b.keys.appendTimeKey(max)
+	for it.Seek(b.keys.appendTimeKey(min)); it.ValidForPrefix(b.keys.appendTimes); it.Next() {
+		if bytes.Compare(it.Item().Key(), end) > 0 {
+			break
+		}
+		marks = append(marks, it.Item().KeyCopy(nil))
+	}
+	for i := 0; i < len(marks)-1; i++ {
+		if err := txn.Delete(marks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogAge returns when the oldest and newest stored entries were appended,
+// as recorded by this store to within about a minute. raft.Log carries no
+// timestamps of its own in the raft version this store is built against.
+// Both are zero for an empty log or entries written before append times
+// were recorded.
+func (b *BadgerStore) LogAge() (oldest, newest time.Time, err error) {
+	err = b.run(context.Background(), func(context.Context) (err error) {
+		oldest, newest, err = b.logAge()
+		return err
+	})
+	return oldest, newest, err
+}
+
+func (b *BadgerStore) logAge() (oldest, newest time.Time, err error) {
+	first, _, err := b.logBounds()
+	if err != nil || first == 0 {
+		return oldest, newest, err
+	}
+	err = b.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		// The newest mark dates the newest entries
+		it.Seek(append(append([]byte(nil), b.keys.appendTimes...), 0xff))
+		if !it.ValidForPrefix(b.keys.appendTimes) {
+			return nil
+		}
+		v, err := it.Item().Value()
+		if err != nil {
+			return err
+		}
+		newest = time.Unix(0, int64(bytesToUint64(v)))
+
+		// The last mark at or before the first entry dates the oldest one,
+		// failing that the first mark after it does
+		it.Seek(b.keys.appendTimeKey(first))
+		if !it.ValidForPrefix(b.keys.appendTimes) {
+			fwd := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer fwd.Close()
+			fwd.Seek(b.keys.appendTimes)
+			it = fwd
+		}
+		v, err = it.Item().Value()
+		if err != nil {
+			return err
+		}
+		oldest = time.Unix(0, int64(bytesToUint64(v)))
+		return nil
+	})
+	return oldest, newest, err
+}
+
+// publishLogAge sets the log age gauges.
+func (b *BadgerStore) publishLogAge() {
+	oldest, newest, err := b.LogAge()
+	if err != nil || oldest.IsZero() {
+		return
+	}
+	b.metrics.setGauge([]string{"log", "oldest_age_seconds"}, float32(time.Since(oldest).Seconds()))
+	b.metrics.setGauge([]string{"log", "newest_age_seconds"}, float32(time.Since(newest).Seconds()))
+}
+
+// startLogAgeMetrics publishes the log age gauges every interval until the
+// store closes.
+func (b *BadgerStore) startLogAgeMetrics(interval time.Duration) {
+	b.logAgeStop = make(chan struct{})
+	b.logAgeDone = make(chan struct{})
+	go func() {
+		defer close(b.logAgeDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			b.publishLogAge()
+			select {
+			case <-ticker.C:
+			case <-b.logAgeStop:
+				return
+			}
+		}
+	}()
+}
+
+func (b *BadgerStore) stopLogAgeMetrics() {
+	if b.logAgeStop == nil {
+		return
+	}
+	close(b.logAgeStop)
+	<-b.logAgeDone
+}
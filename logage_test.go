@@ -0,0 +1,98 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_LogAge(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	oldest, newest, err := store.LogAge()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !oldest.IsZero() || !newest.IsZero() {
+		t.Fatalf("expected no age for an empty log")
+	}
+
+	before := time.Now()
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Pretend the next batch arrives much later
+	store.lastAppendMark = time.Time{}
+	time.Sleep(10 * time.Millisecond)
+	middle := time.Now()
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(3, "log3"), testRaftLog(4, "log4")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Within the resolution, no new mark
+	if err := store.StoreLog(testRaftLog(5, "log5")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	oldest, newest, err = store.LogAge()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if oldest.Before(before) || !oldest.Before(middle) {
+		t.Fatalf("bad oldest time %v, expected between %v and %v", oldest, before, middle)
+	}
+	if newest.Before(middle) {
+		t.Fatalf("bad newest time %v, expected after %v", newest, middle)
+	}
+
+	// Truncating the first batch makes the second one the oldest
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	oldest, _, err = store.LogAge()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if oldest.Before(middle) {
+		t.Fatalf("bad oldest time after truncation %v, expected after %v", oldest, middle)
+	}
+}
+
+func TestBadgerStore_LogAgeGauges(t *testing.T) {
+	sink := testMetricsSink(t)
+
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testStoreFiveLogs(t, store)
+	store.Close()
+
+	badgerOpts = badger.DefaultOptions
+	store, err = New(Options{Path: fh, BadgerOptions: &badgerOpts, LogAgeInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Closing waits for the publisher, which publishes once as it starts
+	store.Close()
+
+	gauges := sink.Data()[0].Gauges
+	if _, ok := gauges["raft.badgerdb.log.oldest_age_seconds"]; !ok {
+		t.Fatalf("missing gauge, have: %v", gauges)
+	}
+	if _, ok := gauges["raft.badgerdb.log.newest_age_seconds"]; !ok {
+		t.Fatalf("missing gauge, have: %v", gauges)
+	}
+}
@@ -0,0 +1,233 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// defaultWriteBatchBytes is how much log data a LogBatch gathers into
+	// a transaction unless Options.WriteBatchBytes is set.
+	defaultWriteBatchBytes = 4 * mib
+
+	// maxInflightBatches bounds the transactions a LogBatch has committed
+	// but Badger hasn't written yet, and so the memory they hold.
+	maxInflightBatches = 4
+)
+
+// LogBatch appends log entries at a high rate. Entries are gathered until
+// Options.WriteBatchBytes of log data are pending, or
+// Options.WriteBatchFlushInterval has passed since the first of them, and
+// committed in one transaction that Badger writes while the next one
+// fills. Appended entries aren't durable, nor necessarily visible, until
+// Flush returns. A failed transaction can leave entries after it stored,
+// so after an error the range appended since the last Flush should be
+// written again. A LogBatch is safe for concurrent use; entries are
+// committed in the order they were appended.
+type LogBatch struct {
+	b        *BadgerStore
+	maxBytes int
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending []*raft.Log
+	size    int
+	timer   *time.Timer
+	// last is the index of the last entry committed since the last Flush,
+	// which the store's LastIndex may not reflect yet
+	last uint64
+
+	inflight sync.WaitGroup
+	slots    chan struct{}
+
+	errMu sync.Mutex
+	err   error
+}
+
+// NewLogBatch returns a LogBatch appending to the store.
+func (b *BadgerStore) NewLogBatch() (*LogBatch, error) {
+	if b.badgerOpts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	return b.newLogBatch(b.batchInterval), nil
+}
+
+func (b *BadgerStore) newLogBatch(interval time.Duration) *LogBatch {
+	maxBytes := b.batchBytes
+	if maxBytes == 0 {
+		maxBytes = defaultWriteBatchBytes
+	}
+	return &LogBatch{
+		b:        b,
+		maxBytes: maxBytes,
+		interval: interval,
+		slots:    make(chan struct{}, maxInflightBatches),
+	}
+}
+
+// Append adds logs to the batch, committing the pending entries if they
+// reach the batch size. It returns the error of an earlier commit, if one
+// failed.
+func (lb *LogBatch) Append(logs ...*raft.Log) error {
+	if err := lb.failed(); err != nil {
+		return err
+	}
+	return lb.b.run(context.Background(), func(context.Context) error {
+		return lb.append(logs)
+	})
+}
+
+func (lb *LogBatch) append(logs []*raft.Log) error {
+	if err := lb.b.checkEntrySizes(logs); err != nil {
+		return err
+	}
+	if err := lb.b.awaitPendingDeletes(logs); err != nil {
+		return err
+	}
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if err := lb.checkAppend(logs); err != nil {
+		return err
+	}
+	for _, log := range logs {
+		lb.pending = append(lb.pending, log)
+		lb.size += len(log.Data)
+		if lb.size >= lb.maxBytes {
+			if err := lb.commitPending(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(lb.pending) > 0 && lb.interval > 0 && lb.timer == nil {
+		lb.timer = time.AfterFunc(lb.interval, func() {
+			lb.mu.Lock()
+			defer lb.mu.Unlock()
+			lb.timer = nil
+			lb.fail(lb.b.run(context.Background(), func(context.Context) error {
+				return lb.commitPending()
+			}))
+		})
+	}
+	return nil
+}
+
+// Flush commits the pending entries and waits until Badger has written
+// everything appended so far. It returns the first error since the last
+// Flush.
+func (lb *LogBatch) Flush() error {
+	err := lb.b.run(context.Background(), func(context.Context) error {
+		lb.mu.Lock()
+		if lb.timer != nil {
+			lb.timer.Stop()
+			lb.timer = nil
+		}
+		lb.fail(lb.commitPending())
+		lb.inflight.Wait()
+		lb.last = 0
+		lb.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	lb.errMu.Lock()
+	defer lb.errMu.Unlock()
+	err = lb.err
+	lb.err = nil
+	return err
+}
+
+// checkAppend rejects logs up front unless they follow the entries appended
+// to the batch so far. The first transaction since the last Flush checks
+// again against the stored entries, in the transaction. mu must be held.
+func (lb *LogBatch) checkAppend(logs []*raft.Log) error {
+	if lb.b.allowOutOfOrder || len(logs) == 0 {
+		return nil
+	}
+	last := lb.last
+	if len(lb.pending) > 0 {
+		last = lb.pending[len(lb.pending)-1].Index
+	} else if last == 0 {
+		_, stored, err := lb.b.logBounds()
+		if err != nil {
+			return err
+		}
+		last = stored
+	}
+	return lb.b.checkAppendAfter(logs, last)
+}
+
+// commitPending commits the pending entries, in halves if they are too big
+// for one transaction, without waiting for Badger to write them. mu must
+// be held, so transactions commit in the order their entries were
+// appended.
+func (lb *LogBatch) commitPending() error {
+	logs := lb.pending
+	lb.pending, lb.size = nil, 0
+	limit := len(logs)
+	for len(logs) > 0 {
+		n := limit
+		if n > len(logs) {
+			n = len(logs)
+		}
+		// The first transaction since the last Flush has to follow the
+		// stored entries, the others follow it
+		txn, stored, err := lb.b.prepareBatch(logs[:n], lb.last == 0)
+		if err == badger.ErrTxnTooBig && n > 1 {
+			limit = n / 2
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("batch of %d entries: %w", n, err)
+		}
+		// Close waits for Badger to write the transaction too
+		if err := lb.b.ops.begin(); err != nil {
+			txn.Discard()
+			return err
+		}
+		lb.slots <- struct{}{}
+		lb.inflight.Add(1)
+		err = txn.commitAsync(func(err error) {
+			if err == nil {
+				err = stored()
+			}
+			lb.fail(err)
+			<-lb.slots
+			lb.inflight.Done()
+			lb.b.ops.end()
+		})
+		if err != nil {
+			<-lb.slots
+			lb.inflight.Done()
+			lb.b.ops.end()
+			return err
+		}
+		lb.last = logs[n-1].Index
+		logs = logs[n:]
+	}
+	return nil
+}
+
+// fail records err unless an earlier error is already recorded.
+func (lb *LogBatch) fail(err error) {
+	if err == nil {
+		return
+	}
+	lb.errMu.Lock()
+	if lb.err == nil {
+		lb.err = err
+	}
+	lb.errMu.Unlock()
+}
+
+func (lb *LogBatch) failed() error {
+	lb.errMu.Lock()
+	defer lb.errMu.Unlock()
+	return lb.err
+}
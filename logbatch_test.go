@@ -0,0 +1,146 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_LogBatch(t *testing.T) {
+	store := testSmallTxnStore(t, Options{WriteBatchBytes: 16 << 10})
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+
+	batch, err := store.NewLogBatch()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	logs := testLargeBatch()
+	for i := 0; i < len(logs); i += 10 {
+		if err := batch.Append(logs[i : i+10]...); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out := make([]*raft.Log, len(logs))
+	if n, err := store.GetLogs(1, uint64(len(logs)), out); err != nil || n != len(logs) {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+	if last, err := store.LastIndex(); err != nil || last != uint64(len(logs)) {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+
+	// StoreLogs pipelines large calls through a batch
+	if err := store.DeleteRange(1, uint64(len(logs))); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n, err := store.GetLogs(1, uint64(len(logs)), out); err != nil || n != len(logs) {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+}
+
+func TestBadgerStore_LogBatchFlushInterval(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store, err := New(Options{Path: fh, WriteBatchFlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	batch, err := store.NewLogBatch()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := batch.Append(testRaftLog(1, "log1"), testRaftLog(2, "log2")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Committed without a Flush once the interval has passed
+	log := new(raft.Log)
+	deadline := time.Now().Add(5 * time.Second)
+	for store.GetLog(2, log) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("pending entries were never committed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBadgerStore_LogBatchOutOfOrder(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	// With a batch size of 1 every entry is committed right away, so
+	// appends follow entries Badger may not have written yet rather than
+	// pending ones
+	for _, batchBytes := range []int{1 << 20, 1} {
+		store, err := New(Options{Path: fh, WriteBatchBytes: batchBytes})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.DeleteRange(1, 10); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		batch, err := store.NewLogBatch()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := batch.Append(testRaftLog(10, "log10")); !errors.Is(err, ErrOutOfOrderAppend) {
+			t.Fatalf("expected ErrOutOfOrderAppend, got: %v", err)
+		}
+		if err := batch.Append(testRaftLog(3, "log3"), testRaftLog(5, "log5")); !errors.Is(err, ErrOutOfOrderAppend) {
+			t.Fatalf("expected ErrOutOfOrderAppend, got: %v", err)
+		}
+		if err := batch.Append(testRaftLog(3, "log3"), testRaftLog(4, "log4")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := batch.Append(testRaftLog(7, "log7")); !errors.Is(err, ErrOutOfOrderAppend) {
+			t.Fatalf("expected ErrOutOfOrderAppend, got: %v", err)
+		}
+		if err := batch.Append(testRaftLog(5, "log5")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := batch.Flush(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := batch.Append(testRaftLog(5, "log5")); !errors.Is(err, ErrOutOfOrderAppend) {
+			t.Fatalf("expected ErrOutOfOrderAppend, got: %v", err)
+		}
+		if err := batch.Append(testRaftLog(6, "log6")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := batch.Flush(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		report, err := store.CheckConsistency()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !report.Consistent() || report.LastIndex != 6 {
+			t.Fatalf("bad: %#v", report)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+}
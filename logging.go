@@ -0,0 +1,33 @@
+package raftbadgerdb
+
+import "time"
+
+// defaultSlowOpThreshold is how long an operation may take before it is
+// logged as slow, unless Options.SlowOpThreshold is set.
+const defaultSlowOpThreshold = 500 * time.Millisecond
+
+// finishOp records the duration of op, which started at start and covered
+// entries log entries, and logs it if it was slow.
+func (b *BadgerStore) finishOp(op string, start time.Time, entries uint64) {
+	elapsed := time.Since(start)
+	if b.prom != nil {
+		b.prom.opDuration.WithLabelValues(op).Observe(elapsed.Seconds())
+	}
+	if b.slowOpThreshold > 0 && elapsed >= b.slowOpThreshold {
+		b.logSlowOp(op, elapsed, entries)
+	}
+}
+
+// logSlowOp warns about a slow operation, along with the state of Badger's
+// LSM tree: a backlog of level 0 tables means compaction can't keep up and
+// writes are about to stall.
+func (b *BadgerStore) logSlowOp(op string, elapsed time.Duration, entries uint64) {
+	args := []interface{}{"op", op, "duration", elapsed}
+	if entries > 0 {
+		args = append(args, "entries", entries)
+	}
+	levels, pending := levelTables(b.db)
+	lsm, vlog := b.db.Size()
+	args = append(args, "pending_compactions", pending, "level_tables", levels, "lsm_size", lsm, "vlog_size", vlog)
+	b.logger.Warn("slow operation", args...)
+}
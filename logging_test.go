@@ -0,0 +1,85 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+func TestBadgerStore_Logger(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf, Level: hclog.Debug})
+	store, err := New(Options{Path: fh, Logger: logger})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	if err := store.DeleteRange(1, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(buf.String(), "compaction finished: trigger=delete-range entries_removed=3") {
+		t.Fatalf("bad: %q", buf.String())
+	}
+
+	store.finishOp(opGetLog, time.Now().Add(-defaultSlowOpThreshold), 1)
+	if !strings.Contains(buf.String(), "slow operation: op=get_log") {
+		t.Fatalf("bad: %q", buf.String())
+	}
+
+	policy := RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+	policy.do(store.metrics, store.logger, retryWrite, func() error {
+		return syscall.ETIMEDOUT
+	})
+	for _, msg := range []string{"retrying after transient error: class=write attempt=1", "giving up after transient errors: class=write attempts=2"} {
+		if !strings.Contains(buf.String(), msg) {
+			t.Fatalf("missing %q in %q", msg, buf.String())
+		}
+	}
+}
+
+func TestBadgerStore_SlowOpThreshold(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf})
+	store, err := New(Options{Path: fh, Logger: logger, SlowOpThreshold: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	store.finishOp(opGetLog, time.Now(), 1)
+	if buf.Len() != 0 {
+		t.Fatalf("bad: %q", buf.String())
+	}
+	store.finishOp(opStoreLogs, time.Now().Add(-20*time.Millisecond), 64)
+	for _, field := range []string{"op=store_logs", "entries=64", "pending_compactions=", "level_tables=", "lsm_size=", "vlog_size="} {
+		if !strings.Contains(buf.String(), field) {
+			t.Fatalf("missing %q in %q", field, buf.String())
+		}
+	}
+
+	// A negative threshold turns the warnings off
+	buf.Reset()
+	store.slowOpThreshold = -1
+	store.finishOp(opStoreLogs, time.Now().Add(-time.Hour), 64)
+	if buf.Len() != 0 {
+		t.Fatalf("bad: %q", buf.String())
+	}
+}
@@ -0,0 +1,51 @@
+package raftbadgerdb
+
+import (
+	"context"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// SetLogMeta sets Badger's user meta byte on the log entry at idx. The store
+// never interprets the byte; it is free for applications and tooling to mark
+// entries, for example as archived or redacted, without touching the
+// payload. Entries are stored with a meta byte of 0.
+func (b *BadgerStore) SetLogMeta(idx uint64, meta byte) error {
+	return b.run(context.Background(), func(context.Context) error {
+		return b.update(func(txn *writeTxn) error {
+			key := b.keys.logKey(idx)
+			item, err := txn.Get(key)
+			if err == badger.ErrKeyNotFound {
+				return raft.ErrLogNotFound
+			}
+			if err != nil {
+				return err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			return txn.SetWithMeta(key, v, meta)
+		})
+	})
+}
+
+// GetLogMeta returns the user meta byte of the log entry at idx.
+func (b *BadgerStore) GetLogMeta(idx uint64) (byte, error) {
+	var meta byte
+	err := b.run(context.Background(), func(context.Context) error {
+		return b.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(b.keys.logKey(idx))
+			if err == badger.ErrKeyNotFound {
+				return raft.ErrLogNotFound
+			}
+			if err != nil {
+				return err
+			}
+			meta = item.UserMeta()
+			return nil
+		})
+	})
+	return meta, err
+}
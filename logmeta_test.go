@@ -0,0 +1,57 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_LogMeta(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Missing entries report not found
+	if err := store.SetLogMeta(1, 0x01); err != raft.ErrLogNotFound {
+		t.Fatalf("expected raft log not found error, got: %v", err)
+	}
+	if _, err := store.GetLogMeta(1); err != raft.ErrLogNotFound {
+		t.Fatalf("expected raft log not found error, got: %v", err)
+	}
+
+	log := testRaftLog(1, "log1")
+	if err := store.StoreLog(log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// New entries start out with no meta
+	meta, err := store.GetLogMeta(1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if meta != 0 {
+		t.Fatalf("bad: %#x", meta)
+	}
+
+	if err := store.SetLogMeta(1, 0x42); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	meta, err = store.GetLogMeta(1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if meta != 0x42 {
+		t.Fatalf("bad: %#x", meta)
+	}
+
+	// The payload is untouched
+	result := new(raft.Log)
+	if err := store.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(log, result) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
@@ -0,0 +1,163 @@
+package raftbadgerdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/hashicorp/raft"
+)
+
+// logStreamMagic starts every stream SendLogs writes, followed by the
+// stream format version. Each entry then follows as a uvarint length, the
+// codec-tagged encoding and its CRC32C, and a zero length ends the stream,
+// followed by the number of entries sent.
+var logStreamMagic = []byte("RBLOGS")
+
+const logStreamVersion byte = 1
+
+// maxLogStreamFrame bounds the length ReceiveLogs accepts for one entry,
+// so a corrupted length can't make it allocate without limit.
+const maxLogStreamFrame = 1 << 30
+
+// ErrMalformedLogStream is returned by ReceiveLogs for a stream that
+// wasn't written by SendLogs, or was truncated or corrupted on the way.
+var ErrMalformedLogStream = errors.New("malformed log stream")
+
+// SendLogs writes the log entries from index from to index to, inclusively,
+// to w, as of a single point in time, for ReceiveLogs to store on another
+// node over any transport. Entries are decoded, and decrypted, and sent
+// encoded with the store's codec, so the receiving store can use its own
+// keys and compression. It fails with raft.ErrLogNotFound if an entry in
+// the range is missing, and returns the number of entries sent.
+func (b *BadgerStore) SendLogs(w io.Writer, from, to uint64) (uint64, error) {
+	if from == 0 || to < from {
+		return 0, fmt.Errorf("invalid log range %d to %d", from, to)
+	}
+	bw := bufio.NewWriter(w)
+	bw.Write(logStreamMagic)
+	bw.WriteByte(logStreamVersion)
+	var sum [4]byte
+	next := from
+	err := b.ScanLogs(LogFilter{MinIndex: from, MaxIndex: to}, func(log *raft.Log) error {
+		if log.Index != next {
+			return fmt.Errorf("%w: %d", raft.ErrLogNotFound, next)
+		}
+		v, err := encodeWithCodec(b.codec, log)
+		if err != nil {
+			return err
+		}
+		writeUvarint(bw, uint64(len(v)))
+		bw.Write(v)
+		binary.BigEndian.PutUint32(sum[:], crc32.Checksum(v, checksumTable))
+		if _, err := bw.Write(sum[:]); err != nil {
+			return err
+		}
+		next++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if next != to+1 {
+		return 0, fmt.Errorf("%w: %d", raft.ErrLogNotFound, next)
+	}
+	sent := to - from + 1
+	writeUvarint(bw, 0)
+	writeUvarint(bw, sent)
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return sent, nil
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+// ReceiveLogs stores the log entries of a stream written by SendLogs,
+// typically to rebuild a follower's log from a healthy peer without a
+// snapshot install. Entries are stored in batches as they arrive, so a
+// stream that breaks off leaves the entries before the problem stored. It
+// returns the number of entries stored.
+func (b *BadgerStore) ReceiveLogs(r io.Reader) (uint64, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(logStreamMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrMalformedLogStream, err)
+	}
+	if !bytes.Equal(header[:len(logStreamMagic)], logStreamMagic) {
+		return 0, fmt.Errorf("%w: bad header", ErrMalformedLogStream)
+	}
+	if v := header[len(logStreamMagic)]; v != logStreamVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrMalformedLogStream, v)
+	}
+
+	var stored, prev uint64
+	batch := make([]*raft.Log, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := b.StoreLogs(batch); err != nil {
+			return err
+		}
+		stored += uint64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return stored, fmt.Errorf("%w: after %d entries: %v", ErrMalformedLogStream, stored+uint64(len(batch)), err)
+		}
+		if n == 0 {
+			break
+		}
+		if n > maxLogStreamFrame {
+			return stored, fmt.Errorf("%w: entry of %d bytes", ErrMalformedLogStream, n)
+		}
+		frame, err := readSized(br, nil, n+4)
+		if err != nil {
+			return stored, fmt.Errorf("%w: after %d entries: %v", ErrMalformedLogStream, stored+uint64(len(batch)), err)
+		}
+		v := frame[:n]
+		if crc32.Checksum(v, checksumTable) != binary.BigEndian.Uint32(frame[n:]) {
+			return stored, fmt.Errorf("%w: checksum mismatch after %d entries", ErrMalformedLogStream, stored+uint64(len(batch)))
+		}
+		c, data, err := lookupCodec(v, b.codec)
+		if err != nil {
+			return stored, err
+		}
+		log := new(raft.Log)
+		if err := c.Decode(data, log); err != nil {
+			return stored, fmt.Errorf("%w: %v", ErrMalformedLogStream, err)
+		}
+		if prev != 0 && log.Index != prev+1 {
+			return stored, fmt.Errorf("%w: entry %d follows %d", ErrMalformedLogStream, log.Index, prev)
+		}
+		prev = log.Index
+		batch = append(batch, log)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return stored, err
+			}
+		}
+	}
+	sent, err := binary.ReadUvarint(br)
+	if err != nil {
+		return stored, fmt.Errorf("%w: truncated trailer: %v", ErrMalformedLogStream, err)
+	}
+	if sent != stored+uint64(len(batch)) {
+		return stored, fmt.Errorf("%w: %d entries sent, %d received", ErrMalformedLogStream, sent, stored+uint64(len(batch)))
+	}
+	if err := flush(); err != nil {
+		return stored, err
+	}
+	return stored, nil
+}
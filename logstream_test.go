@@ -0,0 +1,103 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_SendLogs(t *testing.T) {
+	src := testBadgerStore(t)
+	defer src.Close()
+	defer os.Remove(src.path)
+	for i := uint64(1); i <= 2000; i++ {
+		if err := src.StoreLog(testRaftLog(i, fmt.Sprintf("log%d", i))); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// The receiving store uses its own codec, key and compression
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	key := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	dst, err := New(Options{Path: fh, Codec: MsgpackCodec{}, EncryptionKey: &key, Compression: CompressionSnappy})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer dst.Close()
+
+	// Stream through a pipe, as over a network connection
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := src.SendLogs(pw, 5, 1500)
+		pw.CloseWithError(err)
+	}()
+	n, err := dst.ReceiveLogs(pr)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n != 1496 {
+		t.Fatalf("bad: %d", n)
+	}
+	first, _ := dst.FirstIndex()
+	last, _ := dst.LastIndex()
+	if first != 5 || last != 1500 {
+		t.Fatalf("bad: %d %d", first, last)
+	}
+	log := new(raft.Log)
+	if err := dst.GetLog(1234, log); err != nil || string(log.Data) != "log1234" {
+		t.Fatalf("bad: %v %#v", err, log)
+	}
+
+	if _, err := src.SendLogs(ioutil.Discard, 1500, 2001); !errors.Is(err, raft.ErrLogNotFound) {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := src.SendLogs(ioutil.Discard, 3, 2); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestBadgerStore_ReceiveLogsMalformed(t *testing.T) {
+	src := testBadgerStore(t)
+	defer src.Close()
+	defer os.Remove(src.path)
+	for i := uint64(1); i <= 3; i++ {
+		if err := src.StoreLog(testRaftLog(i, fmt.Sprintf("log%d", i))); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := src.SendLogs(&buf, 1, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	stream := buf.Bytes()
+	corrupt := append([]byte(nil), stream...)
+	corrupt[len(logStreamMagic)+5] ^= 0xff
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"header":    []byte("not a log stream"),
+		"truncated": stream[:len(stream)-3],
+		"corrupt":   corrupt,
+	} {
+		dst := testBadgerStore(t)
+		if _, err := dst.ReceiveLogs(bytes.NewReader(data)); !errors.Is(err, ErrMalformedLogStream) {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		// Nothing is stored from a stream that breaks off within a batch
+		if last, _ := dst.LastIndex(); last != 0 {
+			t.Fatalf("%s: bad: %d", name, last)
+		}
+		dst.Close()
+		os.Remove(dst.path)
+	}
+}
@@ -0,0 +1,76 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+)
+
+// DecodeConfiguration decodes the cluster membership recorded in a
+// LogConfiguration entry. Entries of the deprecated LogAddPeer and
+// LogRemovePeer types are decoded too, assuming the peers were encoded as
+// plain addresses like raft's network and in-memory transports do; every
+// peer in them is a voter.
+func DecodeConfiguration(log *raft.Log) (raft.Configuration, error) {
+	var configuration raft.Configuration
+	switch log.Type {
+	case raft.LogConfiguration:
+		if err := decodeMsgPack(log.Data, &configuration); err != nil {
+			return configuration, fmt.Errorf("log %d: failed to decode configuration: %w", log.Index, err)
+		}
+	case raft.LogAddPeerDeprecated, raft.LogRemovePeerDeprecated:
+		var peers [][]byte
+		if err := decodeMsgPack(log.Data, &peers); err != nil {
+			return configuration, fmt.Errorf("log %d: failed to decode peers: %w", log.Index, err)
+		}
+		for _, p := range peers {
+			configuration.Servers = append(configuration.Servers, raft.Server{
+				Suffrage: raft.Voter,
+				ID:       raft.ServerID(p),
+				Address:  raft.ServerAddress(p),
+			})
+		}
+	default:
+		return configuration, fmt.Errorf("log %d is a %s entry, not a membership change", log.Index, LogTypeName(log.Type))
+	}
+	return configuration, nil
+}
+
+// MembershipChange is a cluster configuration recorded in the log.
+type MembershipChange struct {
+	Index         uint64
+	Term          uint64
+	Configuration raft.Configuration
+}
+
+// MembershipHistory returns every membership change in the stored log, in
+// log order.
+func (b *BadgerStore) MembershipHistory() ([]MembershipChange, error) {
+	var history []MembershipChange
+	filter := LogFilter{Types: []raft.LogType{
+		raft.LogConfiguration,
+		raft.LogAddPeerDeprecated,
+		raft.LogRemovePeerDeprecated,
+	}}
+	err := b.ScanLogs(filter, func(log *raft.Log) error {
+		configuration, err := DecodeConfiguration(log)
+		if err != nil {
+			return err
+		}
+		history = append(history, MembershipChange{
+			Index:         log.Index,
+			Term:          log.Term,
+			Configuration: configuration,
+		})
+		return nil
+	})
+	return history, err
+}
+
+// decodeMsgPack decodes buf the same way raft encodes its own structures.
+func decodeMsgPack(buf []byte, out interface{}) error {
+	hd := codec.MsgpackHandle{}
+	return codec.NewDecoder(bytes.NewReader(buf), &hd).Decode(out)
+}
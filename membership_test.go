@@ -0,0 +1,70 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+)
+
+func encodeMsgPackForTest(t *testing.T, in interface{}) []byte {
+	var buf bytes.Buffer
+	hd := codec.MsgpackHandle{}
+	if err := codec.NewEncoder(&buf, &hd).Encode(in); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBadgerStore_MembershipHistory(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	first := raft.Configuration{Servers: []raft.Server{
+		{Suffrage: raft.Voter, ID: "a", Address: "10.0.0.1:8300"},
+	}}
+	second := raft.Configuration{Servers: []raft.Server{
+		{Suffrage: raft.Voter, ID: "a", Address: "10.0.0.1:8300"},
+		{Suffrage: raft.Nonvoter, ID: "b", Address: "10.0.0.2:8300"},
+	}}
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogConfiguration, Data: encodeMsgPackForTest(t, first)},
+		{Index: 2, Term: 1, Type: raft.LogCommand, Data: []byte("cmd")},
+		{Index: 3, Term: 2, Type: raft.LogConfiguration, Data: encodeMsgPackForTest(t, second)},
+		{Index: 4, Term: 2, Type: raft.LogAddPeerDeprecated, Data: encodeMsgPackForTest(t, [][]byte{[]byte("10.0.0.3:8300")})},
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	history, err := store.MembershipHistory()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 changes, got %d", len(history))
+	}
+	if history[0].Index != 1 || !reflect.DeepEqual(history[0].Configuration, first) {
+		t.Fatalf("bad first change: %#v", history[0])
+	}
+	if history[1].Index != 3 || history[1].Term != 2 || !reflect.DeepEqual(history[1].Configuration, second) {
+		t.Fatalf("bad second change: %#v", history[1])
+	}
+	legacy := history[2].Configuration.Servers
+	if len(legacy) != 1 || legacy[0].Address != "10.0.0.3:8300" || legacy[0].Suffrage != raft.Voter {
+		t.Fatalf("bad legacy change: %#v", history[2])
+	}
+}
+
+func TestDecodeConfiguration_Errors(t *testing.T) {
+	if _, err := DecodeConfiguration(&raft.Log{Type: raft.LogCommand}); err == nil {
+		t.Fatalf("expected an error for a command entry")
+	}
+	if _, err := DecodeConfiguration(&raft.Log{Type: raft.LogConfiguration, Data: []byte{0xc1}}); err == nil {
+		t.Fatalf("expected an error for garbage data")
+	}
+}
@@ -0,0 +1,109 @@
+package raftbadgerdb
+
+import (
+	"sort"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// defaultMetricsPrefix is prepended to every metric name unless
+// Options.MetricsPrefix says otherwise.
+var defaultMetricsPrefix = []string{"raft", "badgerdb"}
+
+// storeMetrics names and emits the store's metrics through go-metrics. Every
+// metric carries the configured prefix and constant labels, so hundreds of
+// stores can report into one telemetry pipeline.
+type storeMetrics struct {
+	prefix []string
+	labels []metrics.Label
+	// sink, if set, receives the metrics instead of go-metrics' global
+	// instance
+	sink *metrics.Metrics
+}
+
+func newStoreMetrics(prefix []string, labels map[string]string) *storeMetrics {
+	if prefix == nil {
+		prefix = defaultMetricsPrefix
+	}
+	m := &storeMetrics{prefix: append([]string(nil), prefix...)}
+	// Sort the labels so every metric carries them in the same order
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.labels = append(m.labels, metrics.Label{Name: name, Value: labels[name]})
+	}
+	return m
+}
+
+// useSink sends the metrics to sink instead of go-metrics' global instance.
+func (m *storeMetrics) useSink(sink metrics.MetricSink) error {
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	instance, err := metrics.New(conf, sink)
+	if err != nil {
+		return err
+	}
+	m.sink = instance
+	return nil
+}
+
+func (m *storeMetrics) key(parts ...string) []string {
+	key := make([]string, 0, len(m.prefix)+len(parts))
+	return append(append(key, m.prefix...), parts...)
+}
+
+func (m *storeMetrics) withLabels(extra ...metrics.Label) []metrics.Label {
+	if len(extra) == 0 {
+		return m.labels
+	}
+	labels := make([]metrics.Label, 0, len(m.labels)+len(extra))
+	return append(append(labels, m.labels...), extra...)
+}
+
+func (m *storeMetrics) incrCounter(name []string, val float32, extra ...metrics.Label) {
+	if m.sink != nil {
+		m.sink.IncrCounterWithLabels(m.key(name...), val, m.withLabels(extra...))
+		return
+	}
+	metrics.IncrCounterWithLabels(m.key(name...), val, m.withLabels(extra...))
+}
+
+func (m *storeMetrics) addSample(name []string, val float32, extra ...metrics.Label) {
+	if m.sink != nil {
+		m.sink.AddSampleWithLabels(m.key(name...), val, m.withLabels(extra...))
+		return
+	}
+	metrics.AddSampleWithLabels(m.key(name...), val, m.withLabels(extra...))
+}
+
+func (m *storeMetrics) setGauge(name []string, val float32, extra ...metrics.Label) {
+	if m.sink != nil {
+		m.sink.SetGaugeWithLabels(m.key(name...), val, m.withLabels(extra...))
+		return
+	}
+	metrics.SetGaugeWithLabels(m.key(name...), val, m.withLabels(extra...))
+}
+
+func (m *storeMetrics) measureSince(name []string, start time.Time, extra ...metrics.Label) {
+	if m.sink != nil {
+		m.sink.MeasureSinceWithLabels(m.key(name...), start, m.withLabels(extra...))
+		return
+	}
+	metrics.MeasureSinceWithLabels(m.key(name...), start, m.withLabels(extra...))
+}
+
+// compaction emits the outcome of a truncation or garbage collection run.
+func (m *storeMetrics) compaction(report *CompactionReport) {
+	trigger := metrics.Label{Name: "trigger", Value: report.Trigger}
+	m.incrCounter([]string{"compaction", "entries_removed"}, float32(report.EntriesRemoved), trigger)
+	m.measureSince([]string{"compaction", "duration"}, report.start, trigger)
+	if report.measured {
+		m.addSample([]string{"compaction", "bytes_reclaimed"}, float32(report.BytesReclaimed()), trigger)
+		m.setGauge([]string{"disk", "bytes"}, float32(report.BytesAfter))
+	}
+}
@@ -0,0 +1,108 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// testMetricsSink routes the global go-metrics instance to an in-memory sink.
+func testMetricsSink(t *testing.T) *metrics.InmemSink {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return sink
+}
+
+func TestNewStoreMetrics(t *testing.T) {
+	m := newStoreMetrics(nil, map[string]string{"shard": "7", "cluster": "east"})
+	if got := m.key("a", "b"); len(got) != 4 || got[0] != "raft" || got[1] != "badgerdb" || got[3] != "b" {
+		t.Fatalf("bad key: %v", got)
+	}
+	// Labels come out sorted by name
+	if len(m.labels) != 2 || m.labels[0].Name != "cluster" || m.labels[1].Name != "shard" {
+		t.Fatalf("bad labels: %v", m.labels)
+	}
+}
+
+func TestBadgerStore_MetricsPrefixAndLabels(t *testing.T) {
+	sink := testMetricsSink(t)
+
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:          fh,
+		BadgerOptions: &badgerOpts,
+		MetricsPrefix: []string{"myapp", "raft"},
+		MetricsLabels: map[string]string{"node": "n1"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	counters := sink.Data()[0].Counters
+	c, ok := counters["myapp.raft.compaction.entries_removed;node=n1;trigger=delete-range"]
+	if !ok {
+		t.Fatalf("missing counter, have: %v", counters)
+	}
+	if c.Sum != 2 {
+		t.Fatalf("expected 2 entries removed, got %v", c.Sum)
+	}
+}
+
+func TestBadgerStore_MetricsSink(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	store, err := New(Options{Path: fh, MetricsSink: sink})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	testStoreFiveLogs(t, store)
+	if err := store.GetLog(1, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.GetUint64([]byte("CurrentTerm")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	samples := sink.Data()[0].Samples
+	for _, name := range []string{"getLog", "storeLogs", "writeCapacity", "logsPerBatch", "logBatchSize", "logSize", "set", "get"} {
+		if _, ok := samples["raft.badgerdb."+name]; !ok {
+			t.Fatalf("missing raft.badgerdb.%s in %v", name, samples)
+		}
+	}
+	if s := samples["raft.badgerdb.logsPerBatch"]; s.Count != 1 || s.Sum != 5 {
+		t.Fatalf("bad: %+v", s)
+	}
+}
@@ -0,0 +1,261 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// migrateBatchSize is the number of entries re-encoded per transaction.
+const migrateBatchSize = 512
+
+// ErrMigrationVerification is returned when a migrated store fails the
+// post-migration check.
+var ErrMigrationVerification = errors.New("migration verification failed")
+
+// MigrateCodec re-encodes every log entry in the store options describe with
+// the to codec. Entries tagged with from (or untagged legacy entries when
+// from is GobCodec) are decoded with from, entries already written by to
+// are left alone. The store is opened with options, so its encryption keys,
+// compression and namespace apply, and rewritten entries are compressed
+// and encrypted as the store's own writes would be. The migration runs on
+// a copy of the store, which replaces it only once every entry has been
+// converted and verified to decode with to, so an interrupted or failed
+// run leaves the store as it was. Progress is reported through
+// options.OnProgress with the "migrate-codec" phase. The store must not be
+// open elsewhere, and can't have a separate stable store.
+func MigrateCodec(options Options, from, to Codec) error {
+	if err := validateCodec(to); err != nil {
+		return err
+	}
+	if options.SeparateStableStore {
+		return errors.New("SeparateStableStore can't be used with MigrateCodec")
+	}
+	options.Codec = to
+	store, err := New(options)
+	if err != nil {
+		return err
+	}
+	staged, err := stageStore(options.Path, options)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	err = copyLiveEntries(store, staged.db)
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = staged.migrateCodec(from, to, options.OnProgress)
+	}
+	if err != nil {
+		staged.discard()
+		return err
+	}
+	return staged.install(nil)
+}
+
+func (b *BadgerStore) migrateCodec(from, to Codec, progress ProgressFunc) error {
+	next, done, err := b.codecMigrationCheckpoint(from, to)
+	if err != nil {
+		return err
+	}
+	total, err := b.countLogs()
+	if err != nil {
+		return err
+	}
+	reporter := newProgressReporter(progress, "migrate-codec", total)
+	reporter.report(done)
+
+	for {
+		batch, last, scanned, err := b.readMigrationBatch(next, from, to)
+		if err != nil {
+			return err
+		}
+		if scanned == 0 {
+			break
+		}
+		checkpoint := append([]byte{from.ID(), to.ID()}, last...)
+		if err := b.writeMigrationBatch(batch, checkpoint); err != nil {
+			return err
+		}
+		done += scanned
+		reporter.report(done)
+		next = append(last, 0)
+	}
+
+	if err := b.update(func(txn *writeTxn) error {
+		return txn.Delete(b.keys.codecMigration)
+	}); err != nil {
+		return err
+	}
+	return b.verifyCodec(to)
+}
+
+// codecMigrationCheckpoint returns the key to resume a migration from and the
+// number of entries already behind it. Checkpoints left by a migration
+// between other codecs are ignored; since entries are selected by their tag,
+// starting over is always safe.
+func (b *BadgerStore) codecMigrationCheckpoint(from, to Codec) ([]byte, uint64, error) {
+	next := b.keys.logs
+	done := uint64(0)
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(b.keys.codecMigration)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if len(v) < 2 || v[0] != from.ID() || v[1] != to.ID() {
+			return nil
+		}
+		next = append(v[2:], 0)
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(b.keys.logs); it.ValidForPrefix(b.keys.logs); it.Next() {
+			if bytes.Compare(it.Item().Key(), next) >= 0 {
+				break
+			}
+			done++
+		}
+		return nil
+	})
+	return next, done, err
+}
+
+type migrationEntry struct {
+	key, val []byte
+	meta     byte
+}
+
+// readMigrationBatch re-encodes up to migrateBatchSize entries starting at
+// next. It returns the entries that changed, the last key looked at and how
+// many entries were looked at.
+func (b *BadgerStore) readMigrationBatch(next []byte, from, to Codec) ([]migrationEntry, []byte, uint64, error) {
+	var batch []migrationEntry
+	var last []byte
+	scanned := uint64(0)
+	err := b.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(next); it.ValidForPrefix(b.keys.logs) && scanned < migrateBatchSize; it.Next() {
+			item := it.Item()
+			last = item.KeyCopy(nil)
+			scanned++
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			idx, err := b.keys.parseLogKey(last)
+			if err != nil {
+				return err
+			}
+			// The payload reference is re-encoded like any other, and the
+			// payload itself stays where it is
+			v, deduped, err := b.unwrapValue(idx, v)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", last, err)
+			}
+			if len(v) > 0 && v[0] == to.ID() {
+				continue
+			}
+			c, data, err := lookupCodec(v, from)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", last, err)
+			}
+			log := new(raft.Log)
+			if err := c.Decode(data, log); err != nil {
+				return fmt.Errorf("key %q: %w", last, err)
+			}
+			val, err := b.encodeLogWith(to, log, deduped)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, migrationEntry{key: last, val: val, meta: item.UserMeta()})
+		}
+		return nil
+	})
+	return batch, last, scanned, err
+}
+
+// writeMigrationBatch writes a batch along with its checkpoint, splitting it
+// across transactions if it does not fit into one. The checkpoint is only
+// advanced in the final transaction.
+func (b *BadgerStore) writeMigrationBatch(batch []migrationEntry, checkpoint []byte) error {
+	txn := b.newWriteTxn()
+	defer func() { txn.Discard() }()
+	for _, e := range batch {
+		err := txn.SetWithMeta(e.key, e.val, e.meta)
+		if err == badger.ErrTxnTooBig {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = b.newWriteTxn()
+			err = txn.SetWithMeta(e.key, e.val, e.meta)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := txn.Set(b.keys.codecMigration, checkpoint); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// verifyCodec checks that every log entry is tagged with and decodes with c.
+func (b *BadgerStore) verifyCodec(c Codec) error {
+	return b.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(b.keys.logs); it.ValidForPrefix(b.keys.logs); it.Next() {
+			item := it.Item()
+			idx, err := b.keys.parseLogKey(item.Key())
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrMigrationVerification, err)
+			}
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			v, _, err = b.unwrapValue(idx, v)
+			if err != nil {
+				return fmt.Errorf("%w: key %q: %v", ErrMigrationVerification, item.Key(), err)
+			}
+			if len(v) == 0 || v[0] != c.ID() {
+				return fmt.Errorf("%w: key %q is not encoded with codec %#x", ErrMigrationVerification, item.Key(), c.ID())
+			}
+			if err := c.Decode(v[1:], new(raft.Log)); err != nil {
+				return fmt.Errorf("%w: key %q: %v", ErrMigrationVerification, item.Key(), err)
+			}
+		}
+		return nil
+	})
+}
+
+// countLogs returns the number of stored log entries.
+func (b *BadgerStore) countLogs() (uint64, error) {
+	count := uint64(0)
+	err := b.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(b.keys.logs); it.ValidForPrefix(b.keys.logs); it.Next() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
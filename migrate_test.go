@@ -0,0 +1,178 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// altCodec is gob under a different tag, standing in for a second codec.
+type altCodec struct{ GobCodec }
+
+func (altCodec) ID() byte { return 0x9e }
+
+func TestMigrateCodec(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 1200; i++ {
+		logs = append(logs, testRaftLog(i, "log"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetLogMeta(700, 0x07); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var last Progress
+	err := MigrateCodec(Options{Path: store.path, OnProgress: func(p Progress) {
+		if p.Phase == "migrate-codec" {
+			last = p
+		}
+	}}, GobCodec{}, altCodec{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last.Done != 1200 || last.Total != 1200 {
+		t.Fatalf("bad final progress: %+v", last)
+	}
+
+	// Every entry should now carry the new tag and read back unchanged
+	badgerOpts := badger.DefaultOptions
+	store, err = New(Options{Path: store.path, BadgerOptions: &badgerOpts, Codec: altCodec{}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if err := store.verifyCodec(altCodec{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result := new(raft.Log)
+	if err := store.GetLog(700, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(logs[699], result) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// User meta survives the rewrite
+	meta, err := store.GetLogMeta(700)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if meta != 0x07 {
+		t.Fatalf("bad meta: %#x", meta)
+	}
+}
+
+func TestMigrateCodec_Resume(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 10; i++ {
+		logs = append(logs, testRaftLog(i, "log"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Pretend an earlier run got through the first three keys
+	first, _, _, err := store.readMigrationBatch(defaultKeys.logs, GobCodec{}, altCodec{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkpoint := append([]byte{GobCodecID, altCodec{}.ID()}, first[2].key...)
+	if err := store.writeMigrationBatch(first[:3], checkpoint); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var progress []Progress
+	if err := store.migrateCodec(GobCodec{}, altCodec{}, func(p Progress) { progress = append(progress, p) }); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if progress[0].Done != 3 {
+		t.Fatalf("expected to resume after 3 entries, got %+v", progress[0])
+	}
+	if err := store.verifyCodec(altCodec{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestMigrateCodec_KeepsEnvelopes(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	key := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	options := Options{Path: fh, Compression: CompressionZstd, EncryptionKey: &key, Namespace: []byte("ns/")}
+	store, err := New(options)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	data := bytes.Repeat([]byte("set key=value;"), 100)
+	if err := store.StoreLogs([]*raft.Log{{Index: 1, Data: data}, {Index: 2, Data: data}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := MigrateCodec(options, GobCodec{}, altCodec{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	options.Codec = altCodec{}
+	store, err = New(options)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if err := store.verifyCodec(altCodec{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	log := new(raft.Log)
+	if err := store.GetLog(2, log); err != nil || !bytes.Equal(log.Data, data) {
+		t.Fatalf("bad: %v", err)
+	}
+	// Rewritten entries are still compressed, then encrypted
+	err = store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(store.keys.logKey(2))
+		if err != nil {
+			return err
+		}
+		v, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if v, err = store.openChecksum(v); err != nil {
+			return err
+		}
+		if v[0] != boundEncryptedTag {
+			t.Fatalf("bad tag %#x", v[0])
+		}
+		if v, err = store.openValue(v, logBinding(2)); err != nil {
+			return err
+		}
+		if v[0] != compressedTag {
+			t.Fatalf("bad tag %#x", v[0])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,328 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// mirrorQueueSize is the number of committed transactions an asynchronous
+// mirror may lag behind before writes wait for it.
+const mirrorQueueSize = 1024
+
+// ErrMirrorDiverged is returned when the mirror no longer holds the same
+// data as the store.
+var ErrMirrorDiverged = errors.New("mirror diverged")
+
+// writeTxn is a read-write transaction that records its mutations, so they
+// can be replayed on the mirror once the transaction commits. Every write
+// the store makes goes through one.
+type writeTxn struct {
+	*badger.Txn
+	b   *BadgerStore
+	ops []mirrorOp
+}
+
+// mirrorOp is a single recorded mutation.
+type mirrorOp struct {
+	entry  badger.Entry
+	delete bool
+}
+
+// newWriteTxn starts a read-write transaction.
+func (b *BadgerStore) newWriteTxn() *writeTxn {
+	return &writeTxn{Txn: b.db.NewTransaction(true), b: b}
+}
+
+// update runs fn in a read-write transaction and commits it, like
+// badger.DB.Update.
+func (b *BadgerStore) update(fn func(txn *writeTxn) error) error {
+	txn := b.newWriteTxn()
+	defer txn.Discard()
+	if err := fn(txn); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// Set implements badger.Txn.Set.
+func (t *writeTxn) Set(key, val []byte) error {
+	return t.SetEntry(&badger.Entry{Key: key, Value: val})
+}
+
+// SetWithMeta implements badger.Txn.SetWithMeta.
+func (t *writeTxn) SetWithMeta(key, val []byte, meta byte) error {
+	return t.SetEntry(&badger.Entry{Key: key, Value: val, UserMeta: meta})
+}
+
+// SetEntry implements badger.Txn.SetEntry.
+func (t *writeTxn) SetEntry(e *badger.Entry) error {
+	if err := t.Txn.SetEntry(e); err != nil {
+		return writeErr(err)
+	}
+	if t.b.mirror != nil {
+		t.ops = append(t.ops, mirrorOp{entry: *e})
+	}
+	return nil
+}
+
+// Delete implements badger.Txn.Delete.
+func (t *writeTxn) Delete(key []byte) error {
+	if err := t.Txn.Delete(key); err != nil {
+		return writeErr(err)
+	}
+	if t.b.mirror != nil {
+		t.ops = append(t.ops, mirrorOp{entry: badger.Entry{Key: key}, delete: true})
+	}
+	return nil
+}
+
+// writeErr reports Badger refusing a write in a read-only database, whose
+// transactions are all read-only, as ErrReadOnly.
+func writeErr(err error) error {
+	if err == badger.ErrReadOnlyTxn {
+		return ErrReadOnly
+	}
+	return err
+}
+
+// Commit commits the transaction and hands its mutations to the mirror.
+func (t *writeTxn) Commit() error {
+	m := t.b.mirror
+	if m == nil || len(t.ops) == 0 {
+		return t.Txn.Commit(nil)
+	}
+	// Mirror in commit order, or concurrent writes to a key could land on
+	// the mirror the other way round
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := t.Txn.Commit(nil); err != nil {
+		return err
+	}
+	m.submit(t.ops)
+	return nil
+}
+
+// commitAsync commits the transaction without waiting for Badger to write
+// it, and calls done once Badger has, unless it fails right away. With a
+// mirror it commits synchronously, so mutations reach the mirror in commit
+// order.
+func (t *writeTxn) commitAsync(done func(error)) error {
+	if t.b.mirror != nil {
+		if err := t.Commit(); err != nil {
+			return err
+		}
+		done(nil)
+		return nil
+	}
+	return t.Txn.Commit(done)
+}
+
+// mirror replays the store's committed writes on a second Badger database,
+// typically on another disk. A failing mirror never fails the store's own
+// writes; it is marked diverged instead, see MirrorErr.
+type mirror struct {
+	b     *BadgerStore
+	db    *badger.DB
+	async bool
+
+	// mu orders commits on the store with their submission to the mirror
+	mu sync.Mutex
+
+	queue chan []mirrorOp
+	done  chan struct{}
+
+	errMu sync.Mutex
+	err   error
+}
+
+// openMirror opens the mirror at path, seeding it with the store's contents
+// if it is empty.
+func (b *BadgerStore) openMirror(path string, async bool) error {
+	dir := badgerDir(path)
+	if err := createDirSynced(dir); err != nil {
+		return err
+	}
+	opts := badger.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+	db, err := badger.Open(opts)
+	if err != nil {
+		return err
+	}
+	m := &mirror{b: b, db: db, async: async}
+	empty, err := isEmpty(db)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if empty {
+		if err := copyLiveEntries(b, db); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	if async {
+		m.queue = make(chan []mirrorOp, mirrorQueueSize)
+		m.done = make(chan struct{})
+		go m.run()
+	}
+	b.mirror = m
+	return nil
+}
+
+// isEmpty reports whether db holds no live keys.
+func isEmpty(db *badger.DB) (bool, error) {
+	empty := true
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	return empty, err
+}
+
+// copyLiveEntries copies the store's current contents into an empty
+// database.
+func copyLiveEntries(b *BadgerStore, db *badger.DB) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(b.streamLiveEntries(w))
+	}()
+	err := db.Load(r)
+	r.Close()
+	return err
+}
+
+func (m *mirror) submit(ops []mirrorOp) {
+	if m.async {
+		m.queue <- ops
+		return
+	}
+	m.apply(ops)
+}
+
+func (m *mirror) run() {
+	defer close(m.done)
+	for ops := range m.queue {
+		m.apply(ops)
+	}
+}
+
+// apply replays one committed transaction. Once the mirror has diverged it
+// is no longer written to.
+func (m *mirror) apply(ops []mirrorOp) {
+	if m.Err() != nil {
+		return
+	}
+	err := m.db.Update(func(txn *badger.Txn) error {
+		for i := range ops {
+			op := &ops[i]
+			var err error
+			if op.delete {
+				err = txn.Delete(op.entry.Key)
+			} else {
+				err = txn.SetEntry(&op.entry)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		m.fail(fmt.Errorf("%w: %v", ErrMirrorDiverged, err))
+	}
+}
+
+func (m *mirror) fail(err error) {
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+	if m.err == nil {
+		m.err = err
+		m.b.metrics.incrCounter([]string{"mirror", "diverged"}, 1)
+		m.b.logger.Error("mirror diverged", "error", err)
+	}
+}
+
+// Err returns why the mirror diverged, if it did.
+func (m *mirror) Err() error {
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+	return m.err
+}
+
+// close waits for queued writes and closes the mirror.
+func (m *mirror) close() error {
+	if m.async {
+		close(m.queue)
+		<-m.done
+	}
+	return m.db.Close()
+}
+
+// MirrorErr returns the error that made the mirror diverge from the store,
+// or nil if it is in sync or there is no mirror. A diverged mirror is no
+// longer written to.
+func (b *BadgerStore) MirrorErr() error {
+	if b.mirror == nil {
+		return nil
+	}
+	return b.mirror.Err()
+}
+
+// VerifyMirror compares every live key and value in the store against the
+// mirror and returns an error wrapping ErrMirrorDiverged at the first
+// difference. An asynchronous mirror may lag behind writes made while the
+// comparison runs.
+func (b *BadgerStore) VerifyMirror() error {
+	if b.mirror == nil {
+		return errors.New("store has no mirror")
+	}
+	if err := b.mirror.Err(); err != nil {
+		return err
+	}
+	return b.run(context.Background(), func(context.Context) error {
+		return b.db.View(func(txn *badger.Txn) error {
+			return b.mirror.db.View(func(mtxn *badger.Txn) error {
+				it := txn.NewIterator(badger.DefaultIteratorOptions)
+				defer it.Close()
+				mit := mtxn.NewIterator(badger.DefaultIteratorOptions)
+				defer mit.Close()
+				it.Rewind()
+				mit.Rewind()
+				for ; it.Valid(); it.Next() {
+					item := it.Item()
+					if !mit.Valid() || !bytes.Equal(item.Key(), mit.Item().Key()) {
+						return fmt.Errorf("%w: key %q differs", ErrMirrorDiverged, item.Key())
+					}
+					v, err := item.Value()
+					if err != nil {
+						return err
+					}
+					mv, err := mit.Item().Value()
+					if err != nil {
+						return err
+					}
+					if !bytes.Equal(v, mv) || item.UserMeta() != mit.Item().UserMeta() {
+						return fmt.Errorf("%w: value of key %q differs", ErrMirrorDiverged, item.Key())
+					}
+					mit.Next()
+				}
+				if mit.Valid() {
+					return fmt.Errorf("%w: key %q only exists in the mirror", ErrMirrorDiverged, mit.Item().Key())
+				}
+				return nil
+			})
+		})
+	})
+}
@@ -0,0 +1,118 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testMirroredStore(t *testing.T, dir, mirrorDir string, async bool) *BadgerStore {
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:          dir,
+		BadgerOptions: &badgerOpts,
+		MirrorPath:    mirrorDir,
+		MirrorAsync:   async,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func testMirrorDirs(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	mirrorDir, err := ioutil.TempDir("", "badger-mirror")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return dir, mirrorDir
+}
+
+func TestBadgerStore_Mirror(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		dir, mirrorDir := testMirrorDirs(t)
+		defer os.RemoveAll(dir)
+		defer os.RemoveAll(mirrorDir)
+
+		store := testMirroredStore(t, dir, mirrorDir, async)
+		testStoreFiveLogs(t, store)
+		if err := store.DeleteRange(1, 2); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		// The mirror works as a store of its own
+		mirrored, err := NewBadgerStore(mirrorDir)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		result := new(raft.Log)
+		if err := mirrored.GetLog(4, result); err != nil {
+			t.Fatalf("async=%v err: %s", async, err)
+		}
+		if err := mirrored.GetLog(1, result); err != raft.ErrLogNotFound {
+			t.Fatalf("async=%v expected the deletion to be mirrored, got: %v", async, err)
+		}
+		term, err := mirrored.GetUint64([]byte("CurrentTerm"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if term != 2 {
+			t.Fatalf("bad term: %d", term)
+		}
+		mirrored.Close()
+	}
+}
+
+func TestBadgerStore_MirrorSeedAndVerify(t *testing.T) {
+	dir, mirrorDir := testMirrorDirs(t)
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(mirrorDir)
+
+	// A store with data gets an empty mirror attached
+	store, err := NewBadgerStore(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testStoreFiveLogs(t, store)
+	store.Close()
+
+	store = testMirroredStore(t, dir, mirrorDir, false)
+	defer store.Close()
+	if err := store.VerifyMirror(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(6, "log6")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.VerifyMirror(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A write behind the store's back is detected
+	err = store.mirror.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(defaultKeys.logKey(3), []byte("tampered"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.VerifyMirror(); !errors.Is(err, ErrMirrorDiverged) {
+		t.Fatalf("expected divergence, got: %v", err)
+	}
+	if err := store.MirrorErr(); err != nil {
+		t.Fatalf("unexpected mirror error: %s", err)
+	}
+}
@@ -0,0 +1,57 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// ErrOutOfOrderAppend is returned by StoreLogs for entries that don't
+// directly follow the last stored entry, or each other, unless the store
+// was opened with Options.AllowOutOfOrderAppends.
+var ErrOutOfOrderAppend = errors.New("out of order append")
+
+// IsMonotonic implements raft.MonotonicLogStore. Unless the store was
+// opened with Options.AllowOutOfOrderAppends, StoreLogs only appends right
+// after the last entry, so raft clears the log rather than leaving gaps when
+// it restores a snapshot.
+func (b *BadgerStore) IsMonotonic() bool {
+	return !b.allowOutOfOrder
+}
+
+// checkAppend fails with ErrOutOfOrderAppend unless logs are consecutive
+// and follow the last entry as of txn, which they are about to be written
+// to. Any index starts an empty log. The last entry is read in txn, along
+// with the key after it, so an append committed concurrently makes txn
+// conflict instead of both landing.
+func (b *BadgerStore) checkAppend(txn *writeTxn, logs []*raft.Log) error {
+	if b.allowOutOfOrder || len(logs) == 0 {
+		return nil
+	}
+	last, err := b.lastIndexIn(txn.Txn)
+	if err != nil {
+		return err
+	}
+	if _, err := txn.Get(b.keys.logKey(last + 1)); err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	return b.checkAppendAfter(logs, last)
+}
+
+// checkAppendAfter is checkAppend for a log whose last entry is last.
+func (b *BadgerStore) checkAppendAfter(logs []*raft.Log, last uint64) error {
+	if b.allowOutOfOrder || len(logs) == 0 {
+		return nil
+	}
+	for i := 1; i < len(logs); i++ {
+		if logs[i].Index != logs[i-1].Index+1 {
+			return fmt.Errorf("%w: entry %d follows entry %d in the batch", ErrOutOfOrderAppend, logs[i].Index, logs[i-1].Index)
+		}
+	}
+	if last != 0 && logs[0].Index != last+1 {
+		return fmt.Errorf("%w: entry %d after last entry %d", ErrOutOfOrderAppend, logs[0].Index, last)
+	}
+	return nil
+}
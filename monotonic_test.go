@@ -0,0 +1,118 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_IsMonotonic(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	var _ raft.MonotonicLogStore = store
+	var _ raft.MonotonicLogStore = store.ForReplay()
+	if !store.IsMonotonic() {
+		t.Fatalf("expected a monotonic store")
+	}
+
+	// An empty log starts anywhere, as after a snapshot restore
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(10, "log10"), testRaftLog(11, "log11")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, logs := range [][]*raft.Log{
+		{testRaftLog(11, "again")},
+		{testRaftLog(13, "gap")},
+		{testRaftLog(12, "log12"), testRaftLog(14, "gap")},
+	} {
+		if err := store.StoreLogs(logs); !errors.Is(err, ErrOutOfOrderAppend) {
+			t.Fatalf("expected ErrOutOfOrderAppend, got: %v", err)
+		}
+	}
+	if last, err := store.LastIndex(); err != nil || last != 11 {
+		t.Fatalf("bad: %d, %v", last, err)
+	}
+
+	// Raft deletes a conflicting suffix before writing over it
+	if err := store.DeleteRange(11, 11); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(11, "replaced"), testRaftLog(12, "log12")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBadgerStore_AllowOutOfOrderAppends(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store, err := New(Options{Path: fh, AllowOutOfOrderAppends: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if store.IsMonotonic() {
+		t.Fatalf("expected a store that isn't monotonic")
+	}
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(3, "log3"), testRaftLog(1, "log1")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBadgerStore_ConcurrentAppend(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(10, "log10"), testRaftLog(11, "log11")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// An append committed while another was being written makes the other
+	// conflict rather than overwrite it
+	txn, _, err := store.prepareBatch([]*raft.Log{testRaftLog(12, "first")}, true)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(12, "second")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := txn.Commit(); err != badger.ErrConflict {
+		t.Fatalf("expected a conflict, got: %v", err)
+	}
+	txn.Discard()
+	// Retried, it no longer follows the last entry
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(12, "first")}); !errors.Is(err, ErrOutOfOrderAppend) {
+		t.Fatalf("expected ErrOutOfOrderAppend, got: %v", err)
+	}
+	result := new(raft.Log)
+	if err := store.GetLog(12, result); err != nil || string(result.Data) != "second" {
+		t.Fatalf("bad: %#v, %v", result, err)
+	}
+
+	// Calls grouped into one transaction are checked against the calls
+	// before them, and only the one out of order fails
+	g := &groupCommitter{b: store}
+	group := []*groupAppend{
+		{b: store, logs: []*raft.Log{testRaftLog(13, "log13")}, done: make(chan error, 1)},
+		{b: store, logs: []*raft.Log{testRaftLog(13, "again")}, done: make(chan error, 1)},
+	}
+	if err := g.storeTogether(group); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := <-group[0].done; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := <-group[1].done; !errors.Is(err, ErrOutOfOrderAppend) {
+		t.Fatalf("expected ErrOutOfOrderAppend, got: %v", err)
+	}
+	if err := store.GetLog(13, result); err != nil || string(result.Data) != "log13" {
+		t.Fatalf("bad: %#v, %v", result, err)
+	}
+}
@@ -0,0 +1,481 @@
+package raftbadgerdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// ErrInvalidGroupID is returned for a raft group ID that is empty or
+// contains a slash.
+var ErrInvalidGroupID = errors.New("invalid group ID")
+
+// MultiStore keeps the logs and stable stores of many raft groups in a
+// single Badger database, each group's keys under a prefix of its own. It
+// saves running a Badger instance, with its memtables, value log and
+// compactions, per group.
+type MultiStore struct {
+	root    *BadgerStore
+	options Options
+
+	mu     sync.Mutex
+	groups map[string]*GroupStore
+
+	retentionStop chan struct{}
+	retentionDone chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewMultiStore opens the Badger database at options.Path for use by many
+// raft groups. Options apply to every group alike; MirrorPath, AllowAttach,
+// AsyncDeleteRange and SeparateStableStore aren't supported, and retention
+// takes RetentionGroupSnapshotIndex in place of RetentionSnapshotIndex.
+func NewMultiStore(options Options) (*MultiStore, error) {
+	for name, set := range map[string]bool{
+		"MirrorPath":             options.MirrorPath != "",
+		"AllowAttach":            options.AllowAttach,
+		"AsyncDeleteRange":       options.AsyncDeleteRange,
+		"SeparateStableStore":    options.SeparateStableStore,
+		"RetentionSnapshotIndex": options.RetentionSnapshotIndex != nil,
+	} {
+		if set {
+			return nil, fmt.Errorf("%s can't be used with NewMultiStore", name)
+		}
+	}
+	if options.RetentionInterval < 0 || options.RetentionMaxAge < 0 || options.RetentionInterval > 0 && options.RetentionGroupSnapshotIndex == nil {
+		return nil, fmt.Errorf("invalid retention interval %s, max age %s", options.RetentionInterval, options.RetentionMaxAge)
+	}
+	if options.ReadOnly && options.RetentionInterval > 0 {
+		return nil, errors.New("ReadOnly can't be combined with options that write in the background")
+	}
+	// Retention runs per group, not over the root store
+	rootOptions := options
+	rootOptions.RetentionInterval = 0
+	rootOptions.RetentionGroupSnapshotIndex = nil
+	root, err := New(rootOptions)
+	if err != nil {
+		return nil, err
+	}
+	m := &MultiStore{
+		root:    root,
+		options: options,
+		groups:  make(map[string]*GroupStore),
+	}
+	if options.RetentionInterval > 0 {
+		m.startRetention(options.RetentionInterval)
+	}
+	return m, nil
+}
+
+// Group returns the store of the raft group id, creating the group if it
+// doesn't exist yet. The returned store implements raft.LogStore and
+// raft.StableStore and stays valid until the MultiStore is closed or the
+// group is dropped.
+func (m *MultiStore) Group(id string) (*GroupStore, error) {
+	if id == "" || strings.Contains(id, "/") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidGroupID, id)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if g, ok := m.groups[id]; ok {
+		return g, nil
+	}
+	var g *GroupStore
+	err := m.root.run(context.Background(), func(context.Context) (err error) {
+		g, err = m.openGroup(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.groups[id] = g
+	return g, nil
+}
+
+// openGroup creates the group id if needed and returns its store.
+func (m *MultiStore) openGroup(id string) (*GroupStore, error) {
+	if !m.root.badgerOpts.ReadOnly {
+		err := m.root.update(func(txn *writeTxn) error {
+			return txn.Set(m.groupKey(id), nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	g := &GroupStore{id: id, b: m.root.groupView(newKeyPrefixes(m.groupNamespace(id)), m.options)}
+	if snapshotIndex := m.options.RetentionGroupSnapshotIndex; snapshotIndex != nil {
+		g.b.retention = retentionPolicy{
+			snapshotIndex: func() (uint64, error) { return snapshotIndex(id) },
+			trailingLogs:  m.options.RetentionTrailingLogs,
+			maxAge:        m.options.RetentionMaxAge,
+		}
+	}
+	if err := g.b.rewriteLegacyConfKeys(g.b.db, g.b.newWriteTxn); err != nil {
+		return nil, err
+	}
+	if _, _, err := g.b.logBounds(); err != nil {
+		return nil, err
+	}
+	if _, err := g.b.warmCache(m.options.CacheWarmEntries); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// groupNamespace returns the prefix of every key of the raft group id.
+func (m *MultiStore) groupNamespace(id string) []byte {
+	return append(append(append([]byte(nil), m.root.keys.groupKeys...), id...), '/')
+}
+
+// groupKey returns the key recording that the raft group id exists.
+func (m *MultiStore) groupKey(id string) []byte {
+	return append(append([]byte(nil), m.root.keys.groups...), id...)
+}
+
+// Groups returns the IDs of the raft groups in the store, sorted.
+func (m *MultiStore) Groups() ([]string, error) {
+	var ids []string
+	prefix := m.root.keys.groups
+	err := m.root.run(context.Background(), func(context.Context) error {
+		return m.root.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				ids = append(ids, string(it.Item().Key()[len(prefix):]))
+			}
+			return nil
+		})
+	})
+	sort.Strings(ids)
+	return ids, err
+}
+
+// DropGroup deletes the raft group id and everything it stored. Stores
+// returned by Group for it must not be used afterwards.
+func (m *MultiStore) DropGroup(id string) error {
+	if id == "" || strings.Contains(id, "/") {
+		return fmt.Errorf("%w: %q", ErrInvalidGroupID, id)
+	}
+	if m.root.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.root.run(context.Background(), func(context.Context) error {
+		if err := m.root.deletePrefix(m.groupNamespace(id)); err != nil {
+			return err
+		}
+		return m.root.update(func(txn *writeTxn) error {
+			return txn.Delete(m.groupKey(id))
+		})
+	})
+	if err != nil {
+		return err
+	}
+	delete(m.groups, id)
+	return nil
+}
+
+// openGroups returns the groups opened through Group, sorted by ID.
+func (m *MultiStore) openGroups() []*GroupStore {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	groups := make([]*GroupStore, 0, len(m.groups))
+	for _, g := range m.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].id < groups[j].id })
+	return groups
+}
+
+// TrimRetained trims every group opened through Group as its TrimRetained
+// does and returns how many entries were deleted in all. Groups no one has
+// opened are left alone, since no raft is taking snapshots of them.
+func (m *MultiStore) TrimRetained() (uint64, error) {
+	var total uint64
+	for _, g := range m.openGroups() {
+		n, err := g.TrimRetained()
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("group %q: %w", g.id, err)
+		}
+	}
+	return total, nil
+}
+
+// startRetention trims the open groups every interval until the store
+// closes. A group failing to trim doesn't hold up the others.
+func (m *MultiStore) startRetention(interval time.Duration) {
+	m.retentionStop = make(chan struct{})
+	m.retentionDone = make(chan struct{})
+	go func() {
+		defer close(m.retentionDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-m.retentionStop:
+				return
+			}
+			for _, g := range m.openGroups() {
+				n, err := g.TrimRetained()
+				if err != nil {
+					m.root.metrics.incrCounter([]string{"retention", "failures"}, 1)
+					m.root.logger.Warn("trimming old log entries failed", "group", g.id, "error", err)
+					continue
+				}
+				if n > 0 {
+					m.root.metrics.incrCounter([]string{"retention", "trimmed_entries"}, float32(n))
+					m.root.logger.Debug("trimmed old log entries", "group", g.id, "entries", n)
+				}
+			}
+		}
+	}()
+}
+
+// Close closes the database shared by all groups.
+func (m *MultiStore) Close() error {
+	m.stopOnce.Do(func() {
+		if m.retentionStop != nil {
+			close(m.retentionStop)
+			<-m.retentionDone
+		}
+	})
+	return m.root.Close()
+}
+
+// groupView returns a store over the keys in keys, sharing b's databases
+// and settings. It runs no background work of its own and must not be
+// closed.
+func (b *BadgerStore) groupView(keys keyPrefixes, options Options) *BadgerStore {
+	g := &BadgerStore{
+		db:               b.db,
+		stableDB:         b.stableDB,
+		path:             b.path,
+		codec:            b.codec,
+		cipher:           b.cipher,
+		compression:      b.compression,
+		minCompressSize:  b.minCompressSize,
+		keys:             keys,
+		metrics:          b.metrics,
+		logger:           b.logger,
+		hooks:            b.hooks,
+		onCompaction:     b.onCompaction,
+		tracer:           b.tracer,
+		slowOpThreshold:  b.slowOpThreshold,
+		ops:              b.ops,
+		verifyWrites:     b.verifyWrites,
+		allowOutOfOrder:  b.allowOutOfOrder,
+		skipChecksums:    b.skipChecksums,
+		monotonicKeys:    b.monotonicKeys,
+		trashGrace:       b.trashGrace,
+		dedupMinSize:     b.dedupMinSize,
+		maxEntrySize:     b.maxEntrySize,
+		atomicStoreLogs:  b.atomicStoreLogs,
+		batchBytes:       b.batchBytes,
+		batchInterval:    b.batchInterval,
+		cache:            newLogCache(options.CacheEntries, options.CacheBytes),
+		prom:             b.prom,
+		badgerOpts:       b.badgerOpts,
+		readRetry:        b.readRetry,
+		writeRetry:       b.writeRetry,
+		maintenanceRetry: b.maintenanceRetry,
+		conflictRetry:    b.conflictRetry,
+	}
+	if options.CoalesceStableWrites > 0 {
+		g.stableWrites = &stableCoalescer{b: g, window: options.CoalesceStableWrites}
+	}
+	// Appends to all groups are committed together
+	g.groupCommit = b.groupCommit
+	return g
+}
+
+// GroupStore is the log and stable store of one raft group in a
+// MultiStore.
+type GroupStore struct {
+	id string
+	b  *BadgerStore
+}
+
+// GroupStats describes the contents of one raft group.
+type GroupStats struct {
+	// LogEntries is the number of stored log entries, FirstIndex and
+	// LastIndex their bounds, both zero for an empty log
+	LogEntries uint64
+	FirstIndex uint64
+	LastIndex  uint64
+	// StableKeys is the number of keys set through the StableStore
+	// interface
+	StableKeys uint64
+}
+
+// ID returns the ID of the raft group.
+func (g *GroupStore) ID() string {
+	return g.id
+}
+
+// FirstIndex implements the raft.LogStore interface.
+func (g *GroupStore) FirstIndex() (uint64, error) {
+	return g.b.FirstIndex()
+}
+
+// LastIndex implements the raft.LogStore interface.
+func (g *GroupStore) LastIndex() (uint64, error) {
+	return g.b.LastIndex()
+}
+
+// GetLog implements the raft.LogStore interface.
+func (g *GroupStore) GetLog(idx uint64, log *raft.Log) error {
+	return g.b.GetLog(idx, log)
+}
+
+// StoreLog implements the raft.LogStore interface.
+func (g *GroupStore) StoreLog(log *raft.Log) error {
+	return g.b.StoreLog(log)
+}
+
+// StoreLogs implements the raft.LogStore interface.
+func (g *GroupStore) StoreLogs(logs []*raft.Log) error {
+	return g.b.StoreLogs(logs)
+}
+
+// DeleteRange implements the raft.LogStore interface. It only deletes the
+// group's own entries.
+func (g *GroupStore) DeleteRange(min, max uint64) error {
+	return g.b.DeleteRange(min, max)
+}
+
+// IsMonotonic implements the raft.MonotonicLogStore interface.
+func (g *GroupStore) IsMonotonic() bool {
+	return g.b.IsMonotonic()
+}
+
+// Set implements the raft.StableStore interface.
+func (g *GroupStore) Set(k, v []byte) error {
+	return g.b.Set(k, v)
+}
+
+// Get implements the raft.StableStore interface.
+func (g *GroupStore) Get(k []byte) ([]byte, error) {
+	return g.b.Get(k)
+}
+
+// SetUint64 implements the raft.StableStore interface.
+func (g *GroupStore) SetUint64(key []byte, val uint64) error {
+	return g.b.SetUint64(key, val)
+}
+
+// GetUint64 implements the raft.StableStore interface.
+func (g *GroupStore) GetUint64(key []byte) (uint64, error) {
+	return g.b.GetUint64(key)
+}
+
+// TrimRetained deletes the group's entries the retention options let go
+// of, up to the snapshot index Options.RetentionGroupSnapshotIndex returns
+// for the group, and returns how many there were.
+func (g *GroupStore) TrimRetained() (uint64, error) {
+	if g.b.retention.snapshotIndex == nil {
+		return 0, errors.New("TrimRetained needs Options.RetentionGroupSnapshotIndex")
+	}
+	return g.b.trimRetained()
+}
+
+// Backup writes the parts of the group selected by scope to w, as
+// BackupScoped does, with keys relative to the group, so Restore can load
+// the backup into any group, of this MultiStore or another. The whole
+// database, every group included, is backed up through the store's
+// Backup as usual.
+func (g *GroupStore) Backup(w io.Writer, scope BackupScope) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := g.b.BackupScoped(pw, scope)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	err := rebaseBackup(w, pr, g.b.keys.namespace, nil)
+	// Unblocks the backup if rebasing stopped early
+	pr.CloseWithError(err)
+	if backupErr := <-done; err == nil {
+		err = backupErr
+	}
+	return err
+}
+
+// Restore writes every entry of a backup made by Backup into the group, as
+// RestoreScoped does, leaving other groups alone.
+func (g *GroupStore) Restore(r io.Reader) error {
+	if g.b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := rebaseBackup(pw, r, nil, g.b.keys.namespace)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	err := g.b.RestoreScoped(pr)
+	// Unblocks the rebasing if restoring stopped early
+	pr.CloseWithError(err)
+	if rebaseErr := <-done; err == nil {
+		err = rebaseErr
+	}
+	return err
+}
+
+// rebaseBackup copies the backup in r to w, replacing the prefix from of
+// every key with to. Keys without the prefix are an error.
+func rebaseBackup(w io.Writer, r io.Reader, from, to []byte) error {
+	br, bw := bufio.NewReader(r), bufio.NewWriter(w)
+	var buf []byte
+	for {
+		kv, raw, err := readBackupEntry(br, buf)
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		buf = raw
+		if !bytes.HasPrefix(kv.Key, from) {
+			return fmt.Errorf("backup entry %q is outside the group", kv.Key)
+		}
+		kv.Key = append(append([]byte(nil), to...), kv.Key[len(from):]...)
+		if err := writeBackupEntry(bw, kv); err != nil {
+			return err
+		}
+	}
+}
+
+// Stats returns the group's current statistics. Counting the entries reads
+// every key of the group, but no values.
+func (g *GroupStore) Stats() (GroupStats, error) {
+	var s GroupStats
+	err := g.b.run(context.Background(), func(context.Context) (err error) {
+		if s.FirstIndex, s.LastIndex, err = g.b.logBounds(); err != nil {
+			return err
+		}
+		return g.b.readRetry.do(g.b.metrics, g.b.logger, retryRead, func() error {
+			return g.b.db.View(func(txn *badger.Txn) error {
+				s.LogEntries = countPrefix(txn, g.b.keys.logs)
+				s.StableKeys = countPrefix(txn, g.b.keys.conf)
+				return nil
+			})
+		})
+	})
+	return s, err
+}
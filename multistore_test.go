@@ -0,0 +1,403 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func testMultiStore(t *testing.T) *MultiStore {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	m, err := NewMultiStore(Options{Path: fh})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return m
+}
+
+func TestGroupStore_Implements(t *testing.T) {
+	var store interface{} = &GroupStore{}
+	if _, ok := store.(raft.StableStore); !ok {
+		t.Fatalf("GroupStore does not implement raft.StableStore")
+	}
+	if _, ok := store.(raft.MonotonicLogStore); !ok {
+		t.Fatalf("GroupStore does not implement raft.MonotonicLogStore")
+	}
+}
+
+func TestMultiStore_Groups(t *testing.T) {
+	m := testMultiStore(t)
+	path := m.root.path
+	defer os.RemoveAll(path)
+
+	a, err := m.Group("a")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	b, err := m.Group("b")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := a.StoreLogs([]*raft.Log{testRaftLog(1, "a1"), testRaftLog(2, "a2"), testRaftLog(3, "a3")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := b.StoreLogs([]*raft.Log{testRaftLog(7, "b7")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := a.SetUint64([]byte("CurrentTerm"), 4); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Each group only sees its own entries and keys
+	if first, _ := b.FirstIndex(); first != 7 {
+		t.Fatalf("bad: %d", first)
+	}
+	log := new(raft.Log)
+	if err := b.GetLog(2, log); err != raft.ErrLogNotFound {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := b.GetUint64([]byte("CurrentTerm")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// DeleteRange leaves other groups alone
+	if err := b.DeleteRange(1, 10); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := a.DeleteRange(1, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	stats, err := a.Stats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats != (GroupStats{LogEntries: 2, FirstIndex: 2, LastIndex: 3, StableKeys: 1}) {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if stats, _ := b.Stats(); stats != (GroupStats{}) {
+		t.Fatalf("bad: %#v", stats)
+	}
+
+	// Groups survive reopening
+	if err := m.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	m, err = NewMultiStore(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer m.Close()
+	ids, err := m.Groups()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Fatalf("bad: %v", ids)
+	}
+	a, err = m.Group("a")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := a.GetLog(3, log); err != nil || string(log.Data) != "a3" {
+		t.Fatalf("bad: %v %#v", err, log)
+	}
+	if term, err := a.GetUint64([]byte("CurrentTerm")); err != nil || term != 4 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+}
+
+func TestMultiStore_DropGroup(t *testing.T) {
+	m := testMultiStore(t)
+	defer m.Close()
+	defer os.RemoveAll(m.root.path)
+
+	a, err := m.Group("a")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	b, err := m.Group("b")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, g := range []*GroupStore{a, b} {
+		if err := g.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := g.Set([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if err := m.DropGroup("a"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if ids, _ := m.Groups(); !reflect.DeepEqual(ids, []string{"b"}) {
+		t.Fatalf("bad: %v", ids)
+	}
+	a, err = m.Group("a")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats, _ := a.Stats(); stats != (GroupStats{}) {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if stats, _ := b.Stats(); stats.LogEntries != 2 || stats.StableKeys != 1 {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
+
+func TestNewMultiStore_Invalid(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	if _, err := NewMultiStore(Options{Path: fh, AsyncDeleteRange: true}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	m, err := NewMultiStore(Options{Path: fh})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer m.Close()
+	for _, id := range []string{"", "a/b"} {
+		if _, err := m.Group(id); !errors.Is(err, ErrInvalidGroupID) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
+
+func TestMultiStore_Retention(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	for _, options := range []Options{
+		{Path: fh, RetentionInterval: time.Second},
+		{Path: fh, RetentionInterval: time.Second, RetentionSnapshotIndex: func() (uint64, error) { return 0, nil }},
+	} {
+		if _, err := NewMultiStore(options); err == nil {
+			t.Fatalf("expected an error")
+		}
+	}
+	if _, err := New(Options{Path: fh, RetentionGroupSnapshotIndex: func(string) (uint64, error) { return 0, nil }}); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	var mu sync.Mutex
+	snapshots := map[string]uint64{}
+	m, err := NewMultiStore(Options{
+		Path:              fh,
+		RetentionInterval: 10 * time.Millisecond,
+		RetentionGroupSnapshotIndex: func(group string) (uint64, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return snapshots[group], nil
+		},
+		RetentionTrailingLogs: 2,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer m.Close()
+	groups := map[string]*GroupStore{}
+	for _, id := range []string{"a", "b"} {
+		g, err := m.Group(id)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		for i := uint64(1); i <= 10; i++ {
+			if err := g.StoreLog(testRaftLog(i, "log")); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		}
+		groups[id] = g
+	}
+
+	// Each group is trimmed up to its own snapshot
+	mu.Lock()
+	snapshots["a"] = 8
+	mu.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		first, err := groups["a"].FirstIndex()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if first == 7 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bad: %d", first)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if first, _ := groups["b"].FirstIndex(); first != 1 {
+		t.Fatalf("bad: %d", first)
+	}
+
+	// And can be trimmed on demand
+	mu.Lock()
+	snapshots["b"] = 5
+	mu.Unlock()
+	if n, err := groups["b"].TrimRetained(); err != nil || n > 3 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if n, err := m.TrimRetained(); err != nil || n != 0 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if first, _ := groups["b"].FirstIndex(); first != 4 {
+		t.Fatalf("bad: %d", first)
+	}
+	if first, _ := groups["a"].FirstIndex(); first != 7 {
+		t.Fatalf("bad: %d", first)
+	}
+}
+
+func TestGroupStore_Backup(t *testing.T) {
+	m := testMultiStore(t)
+	defer m.Close()
+	defer os.RemoveAll(m.root.path)
+
+	a, err := m.Group("a")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	b, err := m.Group("b")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	logs := []*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}
+	if err := a.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := a.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := b.StoreLog(testRaftLog(1, "other")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := a.Backup(&buf, BackupScope{Logs: true, Stable: true}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if bytes.Contains(buf.Bytes(), m.groupNamespace("a")) {
+		t.Fatalf("backup keys should be relative to the group")
+	}
+
+	// The backup restores into another group, leaving the rest alone
+	c, err := m.Group("c")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, want := range logs {
+		got := new(raft.Log)
+		if err := c.GetLog(want.Index, got); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("bad: %#v", got)
+		}
+	}
+	if v, err := c.Get([]byte("foo")); err != nil || string(v) != "bar" {
+		t.Fatalf("bad: %q %v", v, err)
+	}
+	if stats, _ := b.Stats(); stats.LogEntries != 1 || stats.StableKeys != 0 {
+		t.Fatalf("bad: %#v", stats)
+	}
+
+	// A backup keyed as the database stores the group isn't one
+	buf.Reset()
+	if err := a.b.BackupScoped(&buf, BackupScope{Logs: true, Stable: true}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.Restore(&buf); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestMultiStore_GroupCommit(t *testing.T) {
+	sink := testMetricsSink(t)
+
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	var reports []CompactionReport
+	m, err := NewMultiStore(Options{
+		Path:              fh,
+		GroupCommitWindow: 50 * time.Millisecond,
+		OnCompaction:      func(r CompactionReport) { reports = append(reports, r) },
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer m.Close()
+
+	// Appends to different groups share a transaction
+	groups := make([]*GroupStore, 10)
+	for i := range groups {
+		if groups[i], err = m.Group(fmt.Sprintf("g%d", i)); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(groups))
+	for i, g := range groups {
+		wg.Add(1)
+		go func(i int, g *GroupStore) {
+			defer wg.Done()
+			errs <- g.StoreLogs([]*raft.Log{testRaftLog(1, fmt.Sprintf("g%d", i))})
+		}(i, g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	log := new(raft.Log)
+	for i, g := range groups {
+		if err := g.GetLog(1, log); err != nil || string(log.Data) != fmt.Sprintf("g%d", i) {
+			t.Fatalf("bad: %v, %#v", err, log)
+		}
+		if last, err := g.LastIndex(); err != nil || last != 1 {
+			t.Fatalf("bad: %d, %v", last, err)
+		}
+	}
+	sample, ok := sink.Data()[0].Samples["raft.badgerdb.store_logs.grouped_calls"]
+	if !ok {
+		t.Fatalf("missing sample")
+	}
+	if sample.Count >= len(groups) {
+		t.Fatalf("expected appends to groups to be grouped, got %d transactions", sample.Count)
+	}
+
+	// A group's DeleteRange reports its compaction
+	if err := groups[0].DeleteRange(1, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(reports) != 1 || reports[0].Trigger != "delete-range" || reports[0].EntriesRemoved != 1 {
+		t.Fatalf("bad: %#v", reports)
+	}
+}
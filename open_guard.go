@@ -0,0 +1,110 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// ErrAlreadyOpen is returned by New when a store for the same directory is
+// already open in this process. Badger would otherwise fail to acquire its
+// directory lock much later and far less clearly.
+var ErrAlreadyOpen = errors.New("store already open")
+
+// openPaths tracks the directories of stores open in this process.
+var openPaths = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+// claimPath marks the directory at path as open and returns its canonical
+// form, which must later be passed to releasePath.
+func claimPath(path string) (string, error) {
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		return "", err
+	}
+	openPaths.Lock()
+	defer openPaths.Unlock()
+	if openPaths.paths[canonical] {
+		return "", fmt.Errorf("%w: %s", ErrAlreadyOpen, canonical)
+	}
+	openPaths.paths[canonical] = true
+	return canonical, nil
+}
+
+// releasePath marks a claimed directory as closed.
+func releasePath(canonical string) {
+	openPaths.Lock()
+	defer openPaths.Unlock()
+	delete(openPaths.paths, canonical)
+}
+
+// sharedNamespaces tracks the key prefixes of the stores layered on each
+// database shared through NewWithDB.
+var sharedNamespaces = struct {
+	sync.Mutex
+	keys map[*badger.DB][]keyPrefixes
+}{keys: map[*badger.DB][]keyPrefixes{}}
+
+// claimNamespace registers a store using keys in db, failing with
+// ErrNamespaceOverlap if they overlap those of a store already there. The
+// claim must later be passed to releaseNamespace.
+func claimNamespace(db *badger.DB, keys keyPrefixes) error {
+	sharedNamespaces.Lock()
+	defer sharedNamespaces.Unlock()
+	for _, other := range sharedNamespaces.keys[db] {
+		if keys.overlaps(other) {
+			return fmt.Errorf("%w: %q", ErrNamespaceOverlap, keys.namespace)
+		}
+	}
+	sharedNamespaces.keys[db] = append(sharedNamespaces.keys[db], keys)
+	return nil
+}
+
+// releaseNamespace removes a store's claim on db.
+func releaseNamespace(db *badger.DB, keys keyPrefixes) {
+	sharedNamespaces.Lock()
+	defer sharedNamespaces.Unlock()
+	claims := sharedNamespaces.keys[db]
+	for i, other := range claims {
+		if bytes.Equal(other.namespace, keys.namespace) {
+			claims = append(claims[:i], claims[i+1:]...)
+			break
+		}
+	}
+	if len(claims) == 0 {
+		delete(sharedNamespaces.keys, db)
+	} else {
+		sharedNamespaces.keys[db] = claims
+	}
+}
+
+// canonicalPath resolves path to an absolute path with symlinks evaluated,
+// so different spellings of the same directory are recognized. A directory
+// that doesn't exist yet resolves through its deepest existing parent, so
+// it gets the same canonical form before and after it is created.
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for dir := abs; ; {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(dir)}, missing...)
+		dir = parent
+	}
+}
@@ -0,0 +1,75 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewBadgerStore_AlreadyOpen(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+
+	// Opening the same directory again fails fast
+	if _, err := NewBadgerStore(store.path); !errors.Is(err, ErrAlreadyOpen) {
+		t.Fatalf("expected already open error, got: %v", err)
+	}
+
+	// So does a different spelling of it
+	other := filepath.Join(store.path, "..", filepath.Base(store.path))
+	if _, err := NewBadgerStore(other); !errors.Is(err, ErrAlreadyOpen) {
+		t.Fatalf("expected already open error, got: %v", err)
+	}
+
+	// Once closed, the directory can be opened again
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	reopened, err := NewBadgerStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestNewBadgerStore_AlreadyOpenBeforeCreated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "real")
+	if err := os.Mkdir(target, 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A directory that doesn't exist yet resolves through its parents, to
+	// what it will resolve to once created
+	before, err := canonicalPath(filepath.Join(link, "a", "store"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store, err := NewBadgerStore(filepath.Join(link, "a", "store"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	after, err := canonicalPath(filepath.Join(target, "a", "store"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if before != after {
+		t.Fatalf("canonical path changed from %s to %s", before, after)
+	}
+	if _, err := NewBadgerStore(filepath.Join(target, "a", "store")); !errors.Is(err, ErrAlreadyOpen) {
+		t.Fatalf("expected already open error, got: %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// ErrMigrationBlocked is returned by MigrationPlan.Err when a migration
+// would fail or could not finish.
+var ErrMigrationBlocked = errors.New("migration blocked")
+
+// planPasses is how many passes over the store a migration makes for each
+// pass its plan makes: one to copy the store aside, one to rewrite entries
+// and one to verify them.
+const planPasses = 3
+
+// MigrationPlan describes what a migration would do, from a read-only scan
+// of the store. Nothing is modified while planning.
+type MigrationPlan struct {
+	// Migration names the migration, such as "migrate-codec"
+	Migration string
+	// Entries is the number of log entries scanned, and Rewrites how many
+	// entries and keys the migration would write
+	Entries  uint64
+	Rewrites uint64
+	// EstimatedDuration extrapolates the time the plan's own scan took to
+	// the passes the migration makes
+	EstimatedDuration time.Duration
+	// RequiredDiskSpace is how many bytes the migration needs on top of the
+	// store: a copy of its live data plus the rewritten entries.
+	// FreeDiskSpace is what is available, or zero if it can't be told on
+	// this platform
+	RequiredDiskSpace uint64
+	FreeDiskSpace     uint64
+	// Anomalies lists problems that would stop the migration, such as
+	// entries that don't decode, capped at a few entries
+	Anomalies []string
+}
+
+// Err returns an error wrapping ErrMigrationBlocked if the migration can't
+// go ahead as planned, and nil otherwise.
+func (p *MigrationPlan) Err() error {
+	var reasons []string
+	if p.FreeDiskSpace > 0 && p.FreeDiskSpace < p.RequiredDiskSpace {
+		reasons = append(reasons, fmt.Sprintf("needs %d bytes of disk space, %d free", p.RequiredDiskSpace, p.FreeDiskSpace))
+	}
+	reasons = append(reasons, p.Anomalies...)
+	if len(reasons) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrMigrationBlocked, reasons)
+}
+
+// addAnomaly records a problem, keeping only the first few.
+func (p *MigrationPlan) addAnomaly(format string, args ...interface{}) {
+	switch {
+	case len(p.Anomalies) < maxReportedCorruptions:
+		p.Anomalies = append(p.Anomalies, fmt.Sprintf(format, args...))
+	case len(p.Anomalies) == maxReportedCorruptions:
+		p.Anomalies = append(p.Anomalies, "and more")
+	}
+}
+
+// PlanCodecMigration reports what MigrateCodec would do to the store options
+// describe with the same codecs, without changing anything. The store is
+// opened read-only with options, so its encryption keys and namespace
+// apply, leaving out those that write in the background. A store in an
+// older key format fails with ErrUpgradeRequired; PlanUpgrade reports what
+// upgrading it takes. The store must not be open elsewhere.
+func PlanCodecMigration(options Options, from, to Codec) (*MigrationPlan, error) {
+	if err := validateCodec(to); err != nil {
+		return nil, err
+	}
+	options.Codec = to
+	store, err := New(planOptions(options))
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.planCodecMigration(from, to)
+}
+
+// PlanUpgrade reports what Upgrade, or opening the store without
+// Options.ManualUpgrade, would do to the store options describe, without
+// changing anything. The store is opened read-only, as for
+// PlanCodecMigration, and must not be open elsewhere.
+func PlanUpgrade(options Options) (*MigrationPlan, error) {
+	options = planOptions(options)
+	options.skipUpgrade = true
+	options.VerifyOnOpen = VerifyNone
+	options.CacheWarmEntries = 0
+	store, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.planUpgrade()
+}
+
+// CheckTarget completes the plan of a migration copying into the new store
+// options describe, for migrations planned outside this package such as
+// boltmigrate's. It records an anomaly if the store already holds log
+// entries or stable store values, and fills in FreeDiskSpace for its
+// filesystem, or that of its nearest existing parent directory if it
+// doesn't exist yet. The store is only opened read-only.
+func (p *MigrationPlan) CheckTarget(options Options) error {
+	store, err := New(planOptions(options))
+	if err == nil {
+		stats, err := store.Stats()
+		if closeErr := store.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if stats.LogEntries > 0 || stats.StableKeys > 0 {
+			p.addAnomaly("target store holds %d log entries and %d stable store values", stats.LogEntries, stats.StableKeys)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return p.setFreeDiskSpace(options.Path)
+}
+
+// planOptions returns options opening a store read-only for planning a
+// migration, without the options that would write in the background.
+func planOptions(options Options) Options {
+	options.ReadOnly = true
+	options.AsyncDeleteRange = false
+	options.ValueLogGCInterval = 0
+	options.StableValueLogGCInterval = 0
+	options.CompactOnClose = 0
+	options.MirrorPath = ""
+	options.KeyRefreshInterval = 0
+	options.RetentionInterval = 0
+	options.BackupInterval = 0
+	options.AllowAttach = false
+	if options.SyncPolicy == SyncInterval {
+		options.SyncPolicy = SyncDefault
+	}
+	return options
+}
+
+// setFreeDiskSpace fills in FreeDiskSpace for the filesystem holding path,
+// or its nearest existing parent directory.
+func (p *MigrationPlan) setFreeDiskSpace(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+	free, err := freeDiskSpace(path)
+	if err != nil && err != errDiskSpaceUnsupported {
+		return err
+	}
+	p.FreeDiskSpace = free
+	return nil
+}
+
+func (b *BadgerStore) planCodecMigration(from, to Codec) (*MigrationPlan, error) {
+	plan := &MigrationPlan{Migration: "migrate-codec"}
+	start := time.Now()
+	var liveBytes, rewriteBytes uint64
+	err := b.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			v, err := item.Value()
+			if err != nil {
+				plan.addAnomaly("key %q: %v", item.Key(), err)
+				continue
+			}
+			liveBytes += uint64(len(item.Key()) + len(v))
+			if !bytes.HasPrefix(item.Key(), b.keys.logs) {
+				continue
+			}
+			plan.Entries++
+			idx, err := b.keys.parseLogKey(item.Key())
+			if err != nil {
+				plan.addAnomaly("key %q: %v", item.Key(), err)
+				continue
+			}
+			plain, deduped, err := b.unwrapValue(idx, v)
+			if err != nil {
+				plan.addAnomaly("log %d: %v", idx, err)
+				continue
+			}
+			if len(plain) > 0 && plain[0] == to.ID() {
+				continue
+			}
+			c, data, err := lookupCodec(plain, from)
+			if err != nil {
+				plan.addAnomaly("log %d: %v", idx, err)
+				continue
+			}
+			log := new(raft.Log)
+			if err := c.Decode(data, log); err != nil {
+				plan.addAnomaly("log %d: %v", idx, err)
+				continue
+			}
+			val, err := b.encodeLogWith(to, log, deduped)
+			if err != nil {
+				plan.addAnomaly("log %d: %v", idx, err)
+				continue
+			}
+			plan.Rewrites++
+			rewriteBytes += uint64(len(item.Key()) + len(val))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan.EstimatedDuration = planPasses * time.Since(start)
+	plan.RequiredDiskSpace = liveBytes + rewriteBytes
+	if err := plan.setFreeDiskSpace(b.path); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// upgradePasses is how many passes over the keys to rewrite an upgrade
+// makes for each pass its plan makes: one reading them and one writing
+// them under their new keys.
+const upgradePasses = 2
+
+func (b *BadgerStore) planUpgrade() (*MigrationPlan, error) {
+	plan := &MigrationPlan{Migration: "upgrade-keys"}
+	start := time.Now()
+	format, err := b.keyFormat()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case format > currentKeyFormat:
+		plan.addAnomaly("store uses key format %d, this version only reads up to %d", format, currentKeyFormat)
+	case format == currentKeyFormat:
+		if err := b.checkKeyFormat(); errors.Is(err, ErrMixedKeyFormats) {
+			plan.addAnomaly("%v", err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if plan.Entries, err = b.countLogs(); err != nil {
+		return nil, err
+	}
+	if format < currentKeyFormat {
+		var rewriteBytes uint64
+		var scans []legacyScan
+		if format < binaryKeyFormat {
+			scans = append(scans,
+				legacyScan{b.db, b.keys.logs, b.legacyIndexKey(b.keys.logs)},
+				legacyScan{b.db, b.keys.trash, b.legacyIndexKey(b.keys.trash)})
+		}
+		scans = append(scans, legacyScan{b.db, b.keys.legacyConf, b.legacyConfKey})
+		if b.stableDB != nil {
+			scans = append(scans, legacyScan{b.stableDB, b.keys.legacyConf, b.legacyConfKey})
+		}
+		for _, scan := range scans {
+			n, size, err := scan.plan(plan)
+			if err != nil {
+				return nil, err
+			}
+			plan.Rewrites += n
+			rewriteBytes += size
+		}
+		plan.RequiredDiskSpace = rewriteBytes
+	}
+	plan.EstimatedDuration = upgradePasses * time.Since(start)
+	if err := plan.setFreeDiskSpace(b.path); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// legacyScan describes keys an upgrade rewrites: those under prefix in db
+// for which newKey returns a key, while an error marks a key the upgrade
+// can't handle.
+type legacyScan struct {
+	db     *badger.DB
+	prefix []byte
+	newKey func(key []byte) ([]byte, error)
+}
+
+// plan counts the keys the upgrade would rewrite and the bytes it would
+// write, recording anomalies in plan.
+func (s legacyScan) plan(plan *MigrationPlan) (n, size uint64, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(s.prefix); it.ValidForPrefix(s.prefix); it.Next() {
+			item := it.Item()
+			key, err := s.newKey(item.Key())
+			if err != nil {
+				plan.addAnomaly("key %q: %v", item.Key(), err)
+				continue
+			}
+			if key == nil {
+				continue
+			}
+			n++
+			size += uint64(len(key)) + uint64(item.EstimatedSize()) - uint64(len(item.Key()))
+		}
+		return nil
+	})
+	return n, size, err
+}
+
+// legacyIndexKey returns the function mapping a legacy key under prefix to
+// its binary key, and any other key that isn't a binary one to an error.
+func (b *BadgerStore) legacyIndexKey(prefix []byte) func(key []byte) ([]byte, error) {
+	return func(key []byte) ([]byte, error) {
+		if idx, ok := legacyIndex(prefix, key); ok {
+			return indexKey(prefix, idx), nil
+		}
+		if len(key) != len(prefix)+8 {
+			return nil, errors.New("neither a legacy nor a binary key")
+		}
+		return nil, nil
+	}
+}
+
+// legacyConfKey maps a legacy stable store key to its confKey.
+func (b *BadgerStore) legacyConfKey(key []byte) ([]byte, error) {
+	k, err := b.keys.parseLegacyConfKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return b.keys.confKey(k), nil
+}
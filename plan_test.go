@@ -0,0 +1,152 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestPlanCodecMigration(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	plan, err := PlanCodecMigration(Options{Path: store.path}, GobCodec{}, altCodec{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if plan.Entries != 5 || plan.Rewrites != 5 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+	if plan.RequiredDiskSpace == 0 || plan.EstimatedDuration <= 0 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+	if err := plan.Err(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Nothing was migrated
+	store = testReopen(t, store.path)
+	defer store.Close()
+	if err := store.verifyCodec(GobCodec{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	plan, err = store.planCodecMigration(GobCodec{}, GobCodec{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if plan.Entries != 5 || plan.Rewrites != 0 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+}
+
+func TestPlanCodecMigration_Anomalies(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+	if err := store.update(func(txn *writeTxn) error {
+		return txn.Set(defaultKeys.logKey(6), []byte{0x9d, 0x01})
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	plan, err := store.planCodecMigration(GobCodec{}, altCodec{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(plan.Anomalies) != 1 || plan.Rewrites != 5 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+	if err := plan.Err(); !errors.Is(err, ErrMigrationBlocked) {
+		t.Fatalf("expected ErrMigrationBlocked, got: %v", err)
+	}
+
+	plan.Anomalies = nil
+	plan.FreeDiskSpace = 1
+	if err := plan.Err(); !errors.Is(err, ErrMigrationBlocked) {
+		t.Fatalf("expected ErrMigrationBlocked for a full disk, got: %v", err)
+	}
+}
+
+func TestPlanCodecMigration_Encrypted(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	options := Options{Path: store.path, EncryptionKey: &EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}}
+	store, err := New(options)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testStoreFiveLogs(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	plan, err := PlanCodecMigration(options, GobCodec{}, altCodec{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if plan.Entries != 5 || plan.Rewrites != 5 || len(plan.Anomalies) != 0 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+}
+
+func TestPlanUpgrade(t *testing.T) {
+	fh := testLegacyStore(t, 12)
+	defer os.RemoveAll(fh)
+
+	// Planning a codec migration needs the store upgraded first
+	if _, err := PlanCodecMigration(Options{Path: fh}, GobCodec{}, altCodec{}); err != ErrUpgradeRequired {
+		t.Fatalf("expected ErrUpgradeRequired, got: %v", err)
+	}
+	plan, err := PlanUpgrade(Options{Path: fh})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if plan.Migration != "upgrade-keys" || plan.Entries != 12 || plan.Rewrites != 12 || plan.RequiredDiskSpace == 0 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+	if err := plan.Err(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Nothing was upgraded
+	badgerOpts := badger.DefaultOptions
+	badgerOpts.ReadOnly = true
+	if _, err := New(Options{Path: fh, BadgerOptions: &badgerOpts}); err != ErrUpgradeRequired {
+		t.Fatalf("expected ErrUpgradeRequired, got: %v", err)
+	}
+
+	if err := Upgrade(Options{Path: fh}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	plan, err = PlanUpgrade(Options{Path: fh})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if plan.Entries != 12 || plan.Rewrites != 0 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+}
+
+func TestPlanUpgrade_LegacyStableKeys(t *testing.T) {
+	fh := testLegacyStableStore(t, true)
+	defer os.RemoveAll(fh)
+
+	plan, err := PlanUpgrade(Options{Path: fh, SeparateStableStore: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if plan.Rewrites != 2 {
+		t.Fatalf("bad plan: %+v", plan)
+	}
+}
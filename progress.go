@@ -0,0 +1,91 @@
+package raftbadgerdb
+
+import (
+	"time"
+)
+
+// progressInterval is how often phases without measurable progress, such as
+// Badger replaying its value log, report that they are still running.
+const progressInterval = time.Second
+
+// Progress describes how far a long-running operation has come.
+type Progress struct {
+	// Phase names the operation, such as "open" or "migrate-codec"
+	Phase string
+	// Done and Total count processed and overall units of work, usually
+	// log entries
+	Done  uint64
+	Total uint64
+	// Elapsed is the time spent in the phase so far
+	Elapsed time.Duration
+}
+
+// Percent returns how much of the phase is complete, from 0 to 100.
+func (p Progress) Percent() float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return 100 * float64(p.Done) / float64(p.Total)
+}
+
+// ETA estimates the time left in the phase by extrapolating the pace so
+// far. It returns 0 while there is nothing to extrapolate from.
+func (p Progress) ETA() time.Duration {
+	if p.Done == 0 || p.Done >= p.Total {
+		return 0
+	}
+	perUnit := float64(p.Elapsed) / float64(p.Done)
+	return time.Duration(perUnit * float64(p.Total-p.Done))
+}
+
+// ProgressFunc receives progress updates. It may be nil.
+type ProgressFunc func(Progress)
+
+type progressReporter struct {
+	fn    ProgressFunc
+	phase string
+	total uint64
+	start time.Time
+}
+
+func newProgressReporter(fn ProgressFunc, phase string, total uint64) *progressReporter {
+	return &progressReporter{fn: fn, phase: phase, total: total, start: time.Now()}
+}
+
+func (r *progressReporter) report(done uint64) {
+	if r.fn == nil {
+		return
+	}
+	r.fn(Progress{Phase: r.phase, Done: done, Total: r.total, Elapsed: time.Since(r.start)})
+}
+
+// run reports progress every progressInterval while fn runs, for phases that
+// can't measure their own progress, and once more when fn returns.
+func (r *progressReporter) run(fn func() error) error {
+	if r.fn == nil {
+		return fn()
+	}
+	r.report(0)
+	stop := make(chan struct{})
+	ticking := make(chan struct{})
+	go func() {
+		defer close(ticking)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report(0)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	err := fn()
+	close(stop)
+	<-ticking
+	if err == nil {
+		r.report(r.total)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestProgress_PercentAndETA(t *testing.T) {
+	p := Progress{Done: 25, Total: 100, Elapsed: time.Second}
+	if pct := p.Percent(); pct != 25 {
+		t.Fatalf("bad percent: %v", pct)
+	}
+	if eta := p.ETA(); eta != 3*time.Second {
+		t.Fatalf("bad eta: %v", eta)
+	}
+	if eta := (Progress{Total: 100, Elapsed: time.Second}).ETA(); eta != 0 {
+		t.Fatalf("expected no eta before any progress, got %v", eta)
+	}
+	if pct := (Progress{}).Percent(); pct != 0 {
+		t.Fatalf("bad percent for empty phase: %v", pct)
+	}
+}
+
+func TestBadgerStore_OnProgress(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	var reports []Progress
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{
+		Path:          fh,
+		BadgerOptions: &badgerOpts,
+		OnProgress: func(p Progress) {
+			reports = append(reports, p)
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if len(reports) < 2 {
+		t.Fatalf("expected start and finish reports, got %+v", reports)
+	}
+	first, last := reports[0], reports[len(reports)-1]
+	if first.Phase != "open" || first.Done != 0 {
+		t.Fatalf("bad first report: %+v", first)
+	}
+	if last.Phase != "open" || last.Percent() != 100 {
+		t.Fatalf("bad last report: %+v", last)
+	}
+}
@@ -0,0 +1,108 @@
+package raftbadgerdb
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operation names of the raft_badgerdb_op_duration_seconds histogram.
+const (
+	opFirstIndex  = "first_index"
+	opLastIndex   = "last_index"
+	opGetLog      = "get_log"
+	opGetLogs     = "get_logs"
+	opStoreLogs   = "store_logs"
+	opDeleteRange = "delete_range"
+	opSet         = "set"
+	opGet         = "get"
+)
+
+// promMetrics is the store's Prometheus collector. A nil promMetrics, as
+// stores get without Options.PrometheusMetrics, records nothing.
+type promMetrics struct {
+	b *BadgerStore
+
+	opDuration   *prometheus.HistogramVec
+	bytesWritten prometheus.Counter
+	logsStored   prometheus.Counter
+	lsmSize      *prometheus.Desc
+	vlogSize     *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*promMetrics)(nil)
+
+// newPromMetrics returns a collector whose metric names start with prefix
+// joined by underscores and which carries labels on every metric.
+func newPromMetrics(b *BadgerStore, prefix []string, labels map[string]string) *promMetrics {
+	if prefix == nil {
+		prefix = defaultMetricsPrefix
+	}
+	namespace := strings.Join(prefix, "_")
+	constLabels := prometheus.Labels(labels)
+	return &promMetrics{
+		b: b,
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "op_duration_seconds",
+			Help:        "Latency of store operations.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.00001, 4, 12),
+		}, []string{"op"}),
+		bytesWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "bytes_written_total",
+			Help:        "Bytes of encoded log entries and stable store values written.",
+			ConstLabels: constLabels,
+		}),
+		logsStored: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "logs_stored_total",
+			Help:        "Log entries stored.",
+			ConstLabels: constLabels,
+		}),
+		lsmSize: prometheus.NewDesc(namespace+"_lsm_size_bytes",
+			"Size of Badger's LSM tree.", nil, constLabels),
+		vlogSize: prometheus.NewDesc(namespace+"_vlog_size_bytes",
+			"Size of Badger's value log.", nil, constLabels),
+	}
+}
+
+// PrometheusCollector returns a collector of the store's metrics to
+// register with Prometheus, or nil unless the store was opened with
+// Options.PrometheusMetrics.
+func (b *BadgerStore) PrometheusCollector() prometheus.Collector {
+	if b.prom == nil {
+		return nil
+	}
+	return b.prom
+}
+
+// Describe implements prometheus.Collector.
+func (m *promMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.opDuration.Describe(ch)
+	m.bytesWritten.Describe(ch)
+	m.logsStored.Describe(ch)
+	ch <- m.lsmSize
+	ch <- m.vlogSize
+}
+
+// Collect implements prometheus.Collector. Badger's sizes are read at
+// collection time; Badger itself refreshes them about once a minute.
+func (m *promMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.opDuration.Collect(ch)
+	m.bytesWritten.Collect(ch)
+	m.logsStored.Collect(ch)
+	lsm, vlog := m.b.db.Size()
+	ch <- prometheus.MustNewConstMetric(m.lsmSize, prometheus.GaugeValue, float64(lsm))
+	ch <- prometheus.MustNewConstMetric(m.vlogSize, prometheus.GaugeValue, float64(vlog))
+}
+
+// wrote counts entries log entries, and bytes bytes, written.
+func (m *promMetrics) wrote(entries, bytes int) {
+	if m == nil {
+		return
+	}
+	m.logsStored.Add(float64(entries))
+	m.bytesWritten.Add(float64(bytes))
+}
@@ -0,0 +1,93 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBadgerStore_PrometheusCollector(t *testing.T) {
+	store := testBadgerStore(t)
+	if store.PrometheusCollector() != nil {
+		t.Fatalf("should have no collector unless enabled")
+	}
+	store.Close()
+	os.RemoveAll(store.path)
+
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store, err = New(Options{Path: fh, PrometheusMetrics: true, MetricsLabels: map[string]string{"shard": "7"}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(store.PrometheusCollector()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testStoreFiveLogs(t, store)
+	if err := store.GetLog(3, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("k"), []byte("value")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	found := map[string]bool{}
+	for _, f := range families {
+		found[f.GetName()] = true
+		for _, m := range f.GetMetric() {
+			shard := false
+			for _, l := range m.GetLabel() {
+				shard = shard || l.GetName() == "shard" && l.GetValue() == "7"
+			}
+			if !shard {
+				t.Fatalf("%s: missing shard label: %v", f.GetName(), m.GetLabel())
+			}
+		}
+		switch f.GetName() {
+		case "raft_badgerdb_logs_stored_total":
+			if v := f.GetMetric()[0].GetCounter().GetValue(); v != 5 {
+				t.Fatalf("bad: %v entries", v)
+			}
+		case "raft_badgerdb_bytes_written_total":
+			if v := f.GetMetric()[0].GetCounter().GetValue(); v < 5 {
+				t.Fatalf("bad: %v bytes", v)
+			}
+		case "raft_badgerdb_op_duration_seconds":
+			ops := map[string]uint64{}
+			for _, m := range f.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "op" {
+						ops[l.GetValue()] = m.GetHistogram().GetSampleCount()
+					}
+				}
+			}
+			if ops[opStoreLogs] != 1 || ops[opGetLog] != 1 || ops[opSet] != 1 {
+				t.Fatalf("bad: %v", ops)
+			}
+		}
+	}
+	for _, name := range []string{
+		"raft_badgerdb_logs_stored_total",
+		"raft_badgerdb_bytes_written_total",
+		"raft_badgerdb_op_duration_seconds",
+		"raft_badgerdb_lsm_size_bytes",
+		"raft_badgerdb_vlog_size_bytes",
+	} {
+		if !found[name] {
+			t.Fatalf("missing %s", name)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package raftbadgerdb
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ReadLimits caps how fast ReadLogsLimited reads. A zero field is unlimited.
+type ReadLimits struct {
+	// BytesPerSecond limits the payload bytes read per second
+	BytesPerSecond float64
+	// EntriesPerSecond limits the entries read per second
+	EntriesPerSecond float64
+}
+
+// ReadLogsLimited calls fn for every entry from from to to, inclusively,
+// pacing the reads to stay within limits. It is meant for serving a
+// follower that is far behind from a large log without starving the
+// leader's own writes. A from or to of 0 stands for the first or last
+// stored index, as with ReplayLogs. The log passed to fn must not be
+// retained after fn returns. Returning ErrStopScan from fn ends the read
+// early. The achieved throughput is published as the catchup.bytes_per_sec
+// and catchup.entries_per_sec gauges.
+func (b *BadgerStore) ReadLogsLimited(from, to uint64, limits ReadLimits, fn func(log *raft.Log) error) error {
+	it, err := b.ReplayLogs(from, to)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	bytesLimit := newTokenBucket(limits.BytesPerSecond)
+	entriesLimit := newTokenBucket(limits.EntriesPerSecond)
+	throughput := newThroughputMeter(b.metrics)
+	for it.Next() {
+		log := it.Log()
+		size := float64(len(log.Data))
+		time.Sleep(maxDuration(bytesLimit.take(size), entriesLimit.take(1)))
+		if err := fn(log); err != nil {
+			if err == ErrStopScan {
+				return nil
+			}
+			return err
+		}
+		throughput.record(len(log.Data))
+	}
+	throughput.flush()
+	return it.Err()
+}
+
+// tokenBucket paces a stream of work to a rate. Taking more than is
+// available goes into debt, which later takes wait off, so entries larger
+// than a second's worth of budget still get through.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	// Allow up to one second's worth of work in a burst
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// take consumes n tokens and returns how long to wait before going ahead.
+func (t *tokenBucket) take(n float64) time.Duration {
+	if t.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.rate {
+		t.tokens = t.rate
+	}
+	t.last = now
+	t.tokens -= n
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// throughputMeter publishes read throughput about once a second.
+type throughputMeter struct {
+	m       *storeMetrics
+	start   time.Time
+	bytes   int
+	entries int
+}
+
+func newThroughputMeter(m *storeMetrics) *throughputMeter {
+	return &throughputMeter{m: m, start: time.Now()}
+}
+
+func (t *throughputMeter) record(bytes int) {
+	t.bytes += bytes
+	t.entries++
+	if time.Since(t.start) >= time.Second {
+		t.flush()
+	}
+}
+
+func (t *throughputMeter) flush() {
+	elapsed := time.Since(t.start).Seconds()
+	if elapsed <= 0 || t.entries == 0 {
+		return
+	}
+	t.m.setGauge([]string{"catchup", "bytes_per_sec"}, float32(float64(t.bytes)/elapsed))
+	t.m.setGauge([]string{"catchup", "entries_per_sec"}, float32(float64(t.entries)/elapsed))
+	t.start = time.Now()
+	t.bytes = 0
+	t.entries = 0
+}
@@ -0,0 +1,69 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(10)
+	// The first second's worth goes through without waiting
+	for i := 0; i < 10; i++ {
+		if wait := bucket.take(1); wait != 0 {
+			t.Fatalf("unexpected wait %v at %d", wait, i)
+		}
+	}
+	wait := bucket.take(5)
+	if wait < 400*time.Millisecond || wait > 500*time.Millisecond {
+		t.Fatalf("expected to wait about half a second, got %v", wait)
+	}
+	if wait := newTokenBucket(0).take(1e9); wait != 0 {
+		t.Fatalf("expected no limit, got a wait of %v", wait)
+	}
+}
+
+func TestBadgerStore_ReadLogsLimited(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+
+	var seen []uint64
+	start := time.Now()
+	err := store.ReadLogsLimited(1, 5, ReadLimits{EntriesPerSecond: 10}, func(log *raft.Log) error {
+		seen = append(seen, log.Index)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(seen) != 5 || seen[0] != 1 || seen[4] != 5 {
+		t.Fatalf("bad entries: %v", seen)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("entries within the burst were held back for %v", elapsed)
+	}
+
+	// Four payload bytes per entry at eight bytes a second
+	seen = nil
+	start = time.Now()
+	err = store.ReadLogsLimited(1, 5, ReadLimits{BytesPerSecond: 8}, func(log *raft.Log) error {
+		seen = append(seen, log.Index)
+		if log.Index == 4 {
+			return ErrStopScan
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(seen) != 4 {
+		t.Fatalf("bad entries: %v", seen)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("expected reads to be paced, took %v", elapsed)
+	}
+}
@@ -0,0 +1,64 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestRedaction_Apply(t *testing.T) {
+	log := &raft.Log{Index: 3, Term: 2, Type: raft.LogCommand, Data: []byte("alice@example.com")}
+
+	hashed := Redaction{Mode: RedactHash}.Apply(log)
+	if bytes.Contains(hashed.Data, []byte("alice")) {
+		t.Fatalf("payload leaked: %s", hashed.Data)
+	}
+	if !strings.HasPrefix(string(hashed.Data), "redacted sha256=") {
+		t.Fatalf("bad: %s", hashed.Data)
+	}
+	if hashed.Index != 3 || hashed.Term != 2 || hashed.Type != raft.LogCommand {
+		t.Fatalf("metadata not preserved: %#v", hashed)
+	}
+
+	truncated := Redaction{Mode: RedactTruncate, TruncateTo: 5}.Apply(log)
+	if string(truncated.Data) != "alice" {
+		t.Fatalf("bad: %s", truncated.Data)
+	}
+
+	// The original is untouched
+	if string(log.Data) != "alice@example.com" {
+		t.Fatalf("original modified: %s", log.Data)
+	}
+	if none := (Redaction{}).Apply(log); !bytes.Equal(none.Data, log.Data) {
+		t.Fatalf("bad: %s", none.Data)
+	}
+}
+
+func TestParseRedaction(t *testing.T) {
+	testCases := []struct {
+		in      string
+		want    Redaction
+		wantErr bool
+	}{
+		{in: "", want: Redaction{Mode: RedactNone}},
+		{in: "none", want: Redaction{Mode: RedactNone}},
+		{in: "hash", want: Redaction{Mode: RedactHash}},
+		{in: "truncate:16", want: Redaction{Mode: RedactTruncate, TruncateTo: 16}},
+		{in: "truncate:x", wantErr: true},
+		{in: "truncate:-1", wantErr: true},
+		{in: "shred", wantErr: true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.in, func(t *testing.T) {
+			got, err := ParseRedaction(tC.in)
+			if (err != nil) != tC.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if got != tC.want {
+				t.Fatalf("bad: %#v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNotRegistered is returned by Registry.Acquire for a name no store is
+// open under.
+var ErrNotRegistered = errors.New("no store registered under that name")
+
+// Registry shares open stores between the components of a process, such as
+// raft itself, an admin API and a metrics exporter, so they use one handle
+// instead of each trying to open the locked directory. Stores are reference
+// counted and closed when the last handle is.
+type Registry struct {
+	mu     sync.Mutex
+	stores map[string]*registered
+}
+
+type registered struct {
+	store *BadgerStore
+	refs  int
+}
+
+// DefaultRegistry is a process-wide Registry.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{stores: map[string]*registered{}}
+}
+
+// SharedStore is a handle on a store held by a Registry. Closing it
+// releases the handle; the store closes with its last handle.
+type SharedStore struct {
+	*BadgerStore
+
+	r     *Registry
+	name  string
+	close sync.Once
+}
+
+// Open returns a handle on the store registered under name, opening it with
+// options if it isn't open yet. An empty name registers the store under its
+// canonical path, so every spelling of a directory shares one store. options
+// are ignored when the store is already open.
+func (r *Registry) Open(name string, options Options) (*SharedStore, error) {
+	if name == "" {
+		canonical, err := canonicalPath(options.Path)
+		if err != nil {
+			return nil, err
+		}
+		name = canonical
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reg, ok := r.stores[name]; ok {
+		reg.refs++
+		return &SharedStore{BadgerStore: reg.store, r: r, name: name}, nil
+	}
+	store, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+	r.stores[name] = &registered{store: store, refs: 1}
+	return &SharedStore{BadgerStore: store, r: r, name: name}, nil
+}
+
+// Acquire returns another handle on a store that is already open under
+// name, for components that share a store but don't open it.
+func (r *Registry) Acquire(name string) (*SharedStore, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reg, ok := r.stores[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotRegistered, name)
+	}
+	reg.refs++
+	return &SharedStore{BadgerStore: reg.store, r: r, name: name}, nil
+}
+
+// Close releases the handle, closing the store if it was the last one.
+// Closing a handle more than once has no further effect.
+func (s *SharedStore) Close() error {
+	var err error
+	s.close.Do(func() {
+		err = s.r.release(s.name)
+	})
+	return err
+}
+
+func (r *Registry) release(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reg, ok := r.stores[name]
+	if !ok {
+		return nil
+	}
+	reg.refs--
+	if reg.refs > 0 {
+		return nil
+	}
+	delete(r.stores, name)
+	return reg.store.Close()
+}
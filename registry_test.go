@@ -0,0 +1,64 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestRegistry(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	r := NewRegistry()
+	badgerOpts := badger.DefaultOptions
+	first, err := r.Open("", Options{Path: fh, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// A different spelling of the same directory shares the store
+	second, err := r.Open("", Options{Path: fh + "/.", BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first.BadgerStore != second.BadgerStore {
+		t.Fatalf("expected both handles to share one store")
+	}
+	byName, err := r.Acquire(first.name)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := first.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Closing some handles leaves the store open for the others, closing a
+	// handle twice doesn't count twice
+	first.Close()
+	first.Close()
+	second.Close()
+	result := new(raft.Log)
+	if err := byName.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := byName.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := r.Acquire(first.name); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("expected the store to be gone, got: %v", err)
+	}
+	// The store was closed and its path released
+	store, err := NewBadgerStore(fh)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+}
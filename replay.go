@@ -0,0 +1,228 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// replayReadAhead is the number of entries a LogIterator decodes ahead.
+const replayReadAhead = 256
+
+// LogIterator walks a contiguous range of log entries in index order within
+// a single long-lived read transaction, decoding entries ahead of the caller
+// in batches. It sees the log as it was when the iterator was created.
+type LogIterator struct {
+	b    *BadgerStore
+	txn  *badger.Txn
+	next uint64
+	to   uint64
+
+	buf   []replayedEntry
+	pos   int
+	cur   replayedEntry
+	err   error
+	close sync.Once
+}
+
+type replayedEntry struct {
+	log  raft.Log
+	meta byte
+}
+
+// ReplayLogs returns an iterator over the entries from from to to,
+// inclusively. A from or to of 0 stands for the first or last stored index.
+// A missing entry inside the range ends the iteration with an error
+// wrapping raft.ErrLogNotFound. The iterator must be closed.
+func (b *BadgerStore) ReplayLogs(from, to uint64) (*LogIterator, error) {
+	var it *LogIterator
+	err := b.run(context.Background(), func(context.Context) error {
+		first, last, err := b.logBounds()
+		if err != nil {
+			return err
+		}
+		if from == 0 {
+			from = first
+		}
+		if to == 0 {
+			to = last
+		}
+		it = &LogIterator{
+			b:    b,
+			txn:  b.db.NewTransaction(false),
+			next: from,
+			to:   to,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// An empty log has no first index, there is nothing to replay
+	if from == 0 {
+		it.next = 1
+		it.to = 0
+	}
+	return it, nil
+}
+
+// Next advances to the next entry and reports whether there is one.
+func (it *LogIterator) Next() bool {
+	if it.pos >= len(it.buf) {
+		if it.err != nil || !it.fill() {
+			return false
+		}
+	}
+	it.cur = it.buf[it.pos]
+	it.pos++
+	return true
+}
+
+// fill decodes the next batch of entries. Once the store is closing it ends
+// the iteration with ErrStoreClosed.
+func (it *LogIterator) fill() bool {
+	it.buf = it.buf[:0]
+	it.pos = 0
+	if err := it.b.run(context.Background(), func(context.Context) error {
+		it.read()
+		return nil
+	}); err != nil {
+		it.err = err
+	}
+	return len(it.buf) > 0
+}
+
+// read decodes up to replayReadAhead entries into buf.
+func (it *LogIterator) read() {
+	for len(it.buf) < replayReadAhead && it.next <= it.to && it.next != 0 {
+		item, err := it.txn.Get(it.b.keys.logKey(it.next))
+		if err == nil && it.b.isPendingDelete(it.next) {
+			err = badger.ErrKeyNotFound
+		}
+		if err == badger.ErrKeyNotFound {
+			it.err = fmt.Errorf("log %d: %w", it.next, raft.ErrLogNotFound)
+			break
+		}
+		if err != nil {
+			it.err = err
+			break
+		}
+		v, err := item.Value()
+		if err != nil {
+			it.err = err
+			break
+		}
+		var e replayedEntry
+		if err := it.b.decodeLog(it.txn, it.next, v, &e.log); err != nil {
+			it.err = it.b.logDecodeError(it.next, err)
+			break
+		}
+		e.meta = item.UserMeta()
+		it.buf = append(it.buf, e)
+		it.next++
+	}
+}
+
+// Log returns the current entry. It is only valid until the next call to
+// Next.
+func (it *LogIterator) Log() *raft.Log {
+	return &it.cur.log
+}
+
+// Meta returns the user meta byte of the current entry.
+func (it *LogIterator) Meta() byte {
+	return it.cur.meta
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *LogIterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator's read transaction.
+func (it *LogIterator) Close() {
+	it.close.Do(it.txn.Discard)
+}
+
+// ReplayStore wraps a BadgerStore for raft.NewRaft. Raft replays the log
+// after the latest snapshot through GetLog on startup, one call per entry;
+// ReplayStore serves those sequential reads from a single LogIterator
+// instead of a transaction per entry. Anything else, including the first
+// write, falls back to the wrapped store and ends the replay.
+type ReplayStore struct {
+	*BadgerStore
+
+	mu   sync.Mutex
+	it   *LogIterator
+	done bool
+}
+
+// ForReplay returns a ReplayStore to hand to raft.NewRaft in place of the
+// store itself.
+func (b *BadgerStore) ForReplay() *ReplayStore {
+	return &ReplayStore{BadgerStore: b}
+}
+
+// GetLog implements raft.LogStore.
+func (r *ReplayStore) GetLog(idx uint64, log *raft.Log) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.done && r.it == nil {
+		it, err := r.BadgerStore.ReplayLogs(idx, 0)
+		if err != nil {
+			return err
+		}
+		r.it = it
+	}
+	if r.it != nil && r.it.nextIndex() == idx && r.it.Next() {
+		*log = *r.it.Log()
+		return nil
+	}
+	r.doneLocked()
+	return r.BadgerStore.GetLog(idx, log)
+}
+
+// nextIndex is the index Next would move to.
+func (it *LogIterator) nextIndex() uint64 {
+	if it.pos < len(it.buf) {
+		return it.buf[it.pos].log.Index
+	}
+	return it.next
+}
+
+// StoreLog implements raft.LogStore.
+func (r *ReplayStore) StoreLog(log *raft.Log) error {
+	r.Done()
+	return r.BadgerStore.StoreLog(log)
+}
+
+// StoreLogs implements raft.LogStore.
+func (r *ReplayStore) StoreLogs(logs []*raft.Log) error {
+	r.Done()
+	return r.BadgerStore.StoreLogs(logs)
+}
+
+// DeleteRange implements raft.LogStore.
+func (r *ReplayStore) DeleteRange(min, max uint64) error {
+	r.Done()
+	return r.BadgerStore.DeleteRange(min, max)
+}
+
+// Done ends the replay and releases its read transaction. Reads after Done
+// go straight to the store.
+func (r *ReplayStore) Done() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.doneLocked()
+}
+
+func (r *ReplayStore) doneLocked() {
+	if r.it != nil {
+		r.it.Close()
+		r.it = nil
+	}
+	r.done = true
+}
@@ -0,0 +1,133 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_ReplayLogs(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Nothing to replay in an empty log
+	it, err := store.ReplayLogs(0, 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if it.Next() {
+		t.Fatalf("expected no entries")
+	}
+	it.Close()
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 2*replayReadAhead+10; i++ {
+		logs = append(logs, testRaftLog(i, "log"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetLogMeta(3, 0x09); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	it, err = store.ReplayLogs(1, uint64(len(logs)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer it.Close()
+	var seen uint64
+	for it.Next() {
+		seen++
+		if !reflect.DeepEqual(it.Log(), logs[seen-1]) {
+			t.Fatalf("bad entry %d: %#v", seen, it.Log())
+		}
+		if seen == 3 && it.Meta() != 0x09 {
+			t.Fatalf("expected meta on entry 3, got %#x", it.Meta())
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if seen != uint64(len(logs)) {
+		t.Fatalf("expected %d entries, got %d", len(logs), seen)
+	}
+}
+
+func TestBadgerStore_ReplayLogs_Gap(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	store.allowOutOfOrder = true
+	logs := []*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2"), testRaftLog(4, "log4")}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	it, err := store.ReplayLogs(1, 4)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 entries before the gap, got %d", count)
+	}
+	if !errors.Is(it.Err(), raft.ErrLogNotFound) {
+		t.Fatalf("expected log not found, got: %v", it.Err())
+	}
+}
+
+func TestReplayStore(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	logs := []*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2"), testRaftLog(3, "log3")}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var logStore raft.LogStore = store.ForReplay()
+	replay := logStore.(*ReplayStore)
+
+	// Sequential reads are served by the replay iterator
+	for _, want := range logs[1:] {
+		got := new(raft.Log)
+		if err := logStore.GetLog(want.Index, got); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("bad: %#v", got)
+		}
+	}
+	if replay.it == nil {
+		t.Fatalf("expected the replay to be in progress")
+	}
+
+	// A write ends the replay, later reads see it
+	store.allowOutOfOrder = true
+	if err := logStore.StoreLog(testRaftLog(3, "rewritten")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if replay.it != nil || !replay.done {
+		t.Fatalf("expected the replay to be done")
+	}
+	got := new(raft.Log)
+	if err := logStore.GetLog(3, got); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(got.Data) != "rewritten" {
+		t.Fatalf("bad: %s", got.Data)
+	}
+	if err := logStore.GetLog(10, got); err != raft.ErrLogNotFound {
+		t.Fatalf("expected log not found, got: %v", err)
+	}
+}
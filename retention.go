@@ -0,0 +1,105 @@
+package raftbadgerdb
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// retentionPolicy holds the retention options of a store.
+type retentionPolicy struct {
+	snapshotIndex func() (uint64, error)
+	trailingLogs  uint64
+	maxAge        time.Duration
+}
+
+// trimRetained deletes the entries the retention options let go of and
+// returns how many there were. Trimming uses DeleteRange, so it honours
+// AsyncDeleteRange and SoftDeleteGracePeriod like any other truncation.
+func (b *BadgerStore) trimRetained() (uint64, error) {
+	snap, err := b.retention.snapshotIndex()
+	if err != nil || snap <= b.retention.trailingLogs {
+		return 0, err
+	}
+	max := snap - b.retention.trailingLogs
+	if b.retention.maxAge > 0 {
+		old, err := b.appendedBefore(time.Now().Add(-b.retention.maxAge))
+		if err != nil {
+			return 0, err
+		}
+		if old < max {
+			max = old
+		}
+	}
+	first, last, err := b.logBounds()
+	if err != nil || first == 0 || max < first {
+		return 0, err
+	}
+	if max > last {
+		max = last
+	}
+	if err := b.DeleteRange(first, max); err != nil {
+		return 0, err
+	}
+	return max - first + 1, nil
+}
+
+// appendedBefore returns the highest index known, from the append time
+// marks, to have been appended before t, or 0 if there is none. Entries
+// after a mark may have arrived up to a minute later, so they are only
+// counted once a later mark is old enough.
+func (b *BadgerStore) appendedBefore(t time.Time) (uint64, error) {
+	var idx uint64
+	err := b.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(b.keys.appendTimes); it.ValidForPrefix(b.keys.appendTimes); it.Next() {
+			v, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+			if time.Unix(0, int64(bytesToUint64(v))).After(t) {
+				break
+			}
+			idx = bytesToUint64(it.Item().Key()[len(b.keys.appendTimes):])
+		}
+		return nil
+	})
+	return idx, err
+}
+
+// startRetention trims old entries every interval until the store closes.
+func (b *BadgerStore) startRetention(interval time.Duration) {
+	b.retentionStop = make(chan struct{})
+	b.retentionDone = make(chan struct{})
+	go func() {
+		defer close(b.retentionDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-b.retentionStop:
+				return
+			}
+			n, err := b.trimRetained()
+			if err != nil {
+				b.metrics.incrCounter([]string{"retention", "failures"}, 1)
+				b.logger.Warn("trimming old log entries failed", "error", err)
+				continue
+			}
+			if n > 0 {
+				b.metrics.incrCounter([]string{"retention", "trimmed_entries"}, float32(n))
+				b.logger.Debug("trimmed old log entries", "entries", n)
+			}
+		}
+	}()
+}
+
+func (b *BadgerStore) stopRetention() {
+	if b.retentionStop == nil {
+		return
+	}
+	close(b.retentionStop)
+	<-b.retentionDone
+}
@@ -0,0 +1,109 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestBadgerStore_Retention(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	if _, err := New(Options{Path: fh, RetentionInterval: time.Second}); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	var snapshot uint64
+	store, err := New(Options{
+		Path:              fh,
+		RetentionInterval: 10 * time.Millisecond,
+		RetentionSnapshotIndex: func() (uint64, error) {
+			return atomic.LoadUint64(&snapshot), nil
+		},
+		RetentionTrailingLogs: 2,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, "log")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// Nothing goes before a snapshot covers it
+	time.Sleep(50 * time.Millisecond)
+	if first, _ := store.FirstIndex(); first != 1 {
+		t.Fatalf("bad: %d", first)
+	}
+	atomic.StoreUint64(&snapshot, 8)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		first, err := store.FirstIndex()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if first == 7 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bad: %d", first)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBadgerStore_RetentionMaxAge(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, "log")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	store.retention = retentionPolicy{
+		snapshotIndex: func() (uint64, error) { return 10, nil },
+		maxAge:        time.Hour,
+	}
+
+	// Every entry is younger than an hour
+	if n, err := store.trimRetained(); err != nil || n != 0 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+
+	// Mark entry 1 as appended two hours ago and entry 5 half an hour ago
+	err := store.db.Update(func(txn *badger.Txn) error {
+		for idx, age := range map[uint64]time.Duration{1: 2 * time.Hour, 5: time.Hour / 2} {
+			at := uint64(time.Now().Add(-age).UnixNano())
+			if err := txn.Set(defaultKeys.appendTimeKey(idx), uint64ToBytes(at)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Only the entry at the old mark is known to be older than an hour,
+	// the ones after it may have arrived up to the next mark
+	if n, err := store.trimRetained(); err != nil || n != 1 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	store.retention.maxAge = time.Hour / 4
+	if n, err := store.trimRetained(); err != nil || n != 4 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if first, _ := store.FirstIndex(); first != 6 {
+		t.Fatalf("bad: %d", first)
+	}
+}
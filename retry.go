@@ -0,0 +1,167 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// RetryPolicy retries operations failing with transient errors, such as
+// those network-attached storage returns while it fails over. The zero value
+// makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is how many times an operation is tried in total
+	MaxAttempts int
+	// Backoff is the wait before the first retry. It doubles with every
+	// further retry, up to MaxBackoff if that is set
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable reports whether an error is worth retrying. It defaults to
+	// IsTransient, except that writes and maintenance failing with EIO
+	// aren't retried: a failed fsync may have dropped the data it was
+	// syncing, which a retry that succeeds would never notice
+	Retryable func(err error) bool
+}
+
+// Operation classes a RetryPolicy applies to, also used as metric labels.
+const (
+	retryRead        = "read"
+	retryWrite       = "write"
+	retryMaintenance = "maintenance"
+	retryConflict    = "conflict"
+)
+
+// defaultConflictRetries is how often a write conflicting with a concurrent
+// transaction is retried unless Options.ConflictRetries says otherwise.
+const defaultConflictRetries = 3
+
+// conflictRetryPolicy returns the policy retrying writes that fail with
+// badger.ErrConflict up to retries times, quickly, as the transaction they
+// conflicted with has committed by then.
+func conflictRetryPolicy(retries int) RetryPolicy {
+	if retries == 0 {
+		retries = defaultConflictRetries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	return RetryPolicy{
+		MaxAttempts: retries + 1,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+		Retryable: func(err error) bool {
+			return err == badger.ErrConflict
+		},
+	}
+}
+
+// transientErrnos are the system errors IsTransient treats as passing.
+var transientErrnos = []syscall.Errno{
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.EIO,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+}
+
+// writeTransientErrnos are transientErrnos without EIO, which is fatal to
+// writes and maintenance.
+var writeTransientErrnos = []syscall.Errno{
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+}
+
+// IsTransient reports whether err is likely to go away on its own: an I/O
+// error, timeout or stale handle from the file system, or a transaction
+// conflict. Everything else, including not found errors, is permanent.
+func IsTransient(err error) bool {
+	return isTransient(err, transientErrnos)
+}
+
+// isTransient is IsTransient, treating errnos as the passing system errors.
+func isTransient(err error, errnos []syscall.Errno) bool {
+	for err != nil {
+		if err == badger.ErrConflict {
+			return true
+		}
+		var errno syscall.Errno
+		if errors.As(err, &errno) {
+			for _, e := range errnos {
+				if errno == e {
+					return true
+				}
+			}
+			return false
+		}
+		// Badger wraps errors with github.com/pkg/errors, which predates
+		// Unwrap
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = causer.Cause()
+	}
+	return false
+}
+
+// do runs fn until it succeeds, fails with an error that isn't retryable or
+// runs out of attempts, and returns its last error.
+func (p RetryPolicy) do(m *storeMetrics, logger hclog.Logger, class string, fn func() error) error {
+	return p.doContext(context.Background(), m, logger, class, fn)
+}
+
+// doWrite runs the write fn under Options.WriteRetry, retrying transaction
+// conflicts within each attempt first.
+func (b *BadgerStore) doWrite(ctx context.Context, fn func() error) error {
+	return b.writeRetry.doContext(ctx, b.metrics, b.logger, retryWrite, func() error {
+		return b.conflictRetry.doContext(ctx, b.metrics, b.logger, retryConflict, fn)
+	})
+}
+
+// doContext is do, but stops retrying once ctx is done, returning ctx's
+// error.
+func (p RetryPolicy) doContext(ctx context.Context, m *storeMetrics, logger hclog.Logger, class string, fn func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+		if class == retryWrite || class == retryMaintenance {
+			retryable = func(err error) bool { return isTransient(err, writeTransientErrnos) }
+		}
+	}
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			if attempt > 1 {
+				m.incrCounter([]string{"retry", class, "exhausted"}, 1)
+				logger.Warn("giving up after transient errors", "class", class, "attempts", attempt, "error", err)
+			}
+			return err
+		}
+		m.incrCounter([]string{"retry", class}, 1)
+		logger.Debug("retrying after transient error", "class", class, "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+// testCauser mimics an error wrapped with github.com/pkg/errors.
+type testCauser struct{ cause error }
+
+func (e testCauser) Error() string { return "wrapped: " + e.cause.Error() }
+func (e testCauser) Cause() error  { return e.cause }
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{raft.ErrLogNotFound, false},
+		{ErrKeyNotFound, false},
+		{badger.ErrConflict, true},
+		{syscall.EIO, true},
+		{syscall.ENOSPC, false},
+		{&os.PathError{Op: "write", Path: "000001.vlog", Err: syscall.ESTALE}, true},
+		{fmt.Errorf("sync: %w", syscall.ETIMEDOUT), true},
+		{testCauser{&os.PathError{Op: "read", Path: "MANIFEST", Err: syscall.EIO}}, true},
+		{testCauser{errors.New("checksum mismatch")}, false},
+	}
+	for _, c := range cases {
+		if got := IsTransient(c.err); got != c.transient {
+			t.Fatalf("IsTransient(%v) = %v, expected %v", c.err, got, c.transient)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	sink := testMetricsSink(t)
+	m := newStoreMetrics(nil, nil)
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	// Succeeds on the second attempt
+	attempts := 0
+	err := policy.do(m, hclog.NewNullLogger(), retryWrite, func() error {
+		attempts++
+		if attempts < 2 {
+			return syscall.ESTALE
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+
+	// Permanent errors are returned right away
+	attempts = 0
+	err = policy.do(m, hclog.NewNullLogger(), retryWrite, func() error {
+		attempts++
+		return raft.ErrLogNotFound
+	})
+	if err != raft.ErrLogNotFound || attempts != 1 {
+		t.Fatalf("expected a single attempt, got %d: %v", attempts, err)
+	}
+
+	// Writes and maintenance don't retry EIO, which may be a failed fsync
+	for _, class := range []string{retryWrite, retryMaintenance} {
+		attempts = 0
+		err = policy.do(m, hclog.NewNullLogger(), class, func() error {
+			attempts++
+			return &os.PathError{Op: "sync", Path: "000001.vlog", Err: syscall.EIO}
+		})
+		if !errors.Is(err, syscall.EIO) || attempts != 1 {
+			t.Fatalf("expected a single %s attempt, got %d: %v", class, attempts, err)
+		}
+	}
+
+	// Gives up after MaxAttempts
+	attempts = 0
+	err = policy.do(m, hclog.NewNullLogger(), retryRead, func() error {
+		attempts++
+		return syscall.EIO
+	})
+	if err != syscall.EIO || attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d: %v", attempts, err)
+	}
+
+	// A custom classification
+	attempts = 0
+	policy.Retryable = func(err error) bool { return err == raft.ErrLogNotFound }
+	policy.do(m, hclog.NewNullLogger(), retryMaintenance, func() error {
+		attempts++
+		return raft.ErrLogNotFound
+	})
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+
+	// The zero value tries once
+	attempts = 0
+	RetryPolicy{}.do(m, hclog.NewNullLogger(), retryRead, func() error {
+		attempts++
+		return syscall.EIO
+	})
+	if attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", attempts)
+	}
+
+	counters := sink.Data()[0].Counters
+	if c, ok := counters["raft.badgerdb.retry.write"]; !ok || c.Sum != 1 {
+		t.Fatalf("expected one write retry, have: %v", counters)
+	}
+	if c, ok := counters["raft.badgerdb.retry.read.exhausted"]; !ok || c.Sum != 1 {
+		t.Fatalf("expected one exhausted read, have: %v", counters)
+	}
+}
+
+func TestBadgerStore_ConflictRetries(t *testing.T) {
+	sink := testMetricsSink(t)
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	// Conflicts are retried by default
+	attempts := 0
+	err := store.doWrite(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return badger.ErrConflict
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("bad: %d attempts, %v", attempts, err)
+	}
+	counters := sink.Data()[0].Counters
+	if c, ok := counters["raft.badgerdb.retry.conflict"]; !ok || c.Sum != 2 {
+		t.Fatalf("expected two conflict retries, have: %v", counters)
+	}
+
+	// Up to ConflictRetries times, and only conflicts
+	attempts = 0
+	err = store.doWrite(context.Background(), func() error {
+		attempts++
+		return badger.ErrConflict
+	})
+	if err != badger.ErrConflict || attempts != defaultConflictRetries+1 {
+		t.Fatalf("bad: %d attempts, %v", attempts, err)
+	}
+	attempts = 0
+	store.doWrite(context.Background(), func() error {
+		attempts++
+		return syscall.EIO
+	})
+	if attempts != 1 {
+		t.Fatalf("bad: %d attempts", attempts)
+	}
+
+	// A negative count turns the retries off
+	store.conflictRetry = conflictRetryPolicy(-1)
+	attempts = 0
+	store.doWrite(context.Background(), func() error {
+		attempts++
+		return badger.ErrConflict
+	})
+	if attempts != 1 {
+		t.Fatalf("bad: %d attempts", attempts)
+	}
+}
+
+func TestBadgerStore_ReadRetryGet(t *testing.T) {
+	path, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(path)
+	store, err := New(Options{Path: path, ReadRetry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if err := store.StoreLog(&raft.Log{Index: 1, Term: 1, Data: []byte("one")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Reads fail with a transient error until failures runs out
+	failures := 0
+	defer func(orig func(*badger.Txn, []byte) (*badger.Item, error)) { txnGet = orig }(txnGet)
+	txnGet = func(txn *badger.Txn, key []byte) (*badger.Item, error) {
+		if failures > 0 {
+			failures--
+			return nil, syscall.EIO
+		}
+		return txn.Get(key)
+	}
+
+	failures = 2
+	log := new(raft.Log)
+	if err := store.GetLog(1, log); err != nil || string(log.Data) != "one" {
+		t.Fatalf("bad: %#v, %v", log, err)
+	}
+	failures = 2
+	if v, err := store.Get([]byte("k")); err != nil || string(v) != "v" {
+		t.Fatalf("bad: %q, %v", v, err)
+	}
+
+	// Once the retries run out the error is returned, not mistaken for a
+	// missing entry
+	failures = 3
+	if err := store.GetLog(1, log); err != syscall.EIO {
+		t.Fatalf("expected EIO, got: %v", err)
+	}
+	failures = 3
+	if _, err := store.Get([]byte("k")); err != syscall.EIO {
+		t.Fatalf("expected EIO, got: %v", err)
+	}
+	failures = 0
+	if err := store.GetLog(2, log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found, got: %v", err)
+	}
+	if _, err := store.Get([]byte("missing")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got: %v", err)
+	}
+}
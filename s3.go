@@ -0,0 +1,439 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+const (
+	// defaultS3PartSize is the part size of multipart uploads unless
+	// S3Config.PartSize is set.
+	defaultS3PartSize = 8 * mib
+	// minS3PartSize is the smallest part S3 accepts, apart from the last.
+	minS3PartSize = 5 * mib
+	// retryS3 is the retry class of S3 requests.
+	retryS3 = "s3"
+)
+
+// defaultS3Retry retries S3 requests unless S3Config.Retry is set.
+var defaultS3Retry = RetryPolicy{MaxAttempts: 4, Backoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Second}
+
+// S3Config configures an S3Sink.
+type S3Config struct {
+	// Endpoint is the URL of the S3 API, such as
+	// https://s3.eu-west-1.amazonaws.com or that of a MinIO server.
+	// Buckets are addressed path-style, as Endpoint/Bucket/key
+	Endpoint string
+	// Region the bucket is in, us-east-1 unless set
+	Region string
+	Bucket string
+	// Prefix is prepended to the key of every backup, such as "node1/"
+	Prefix string
+	// AccessKeyID and SecretAccessKey sign requests. SessionToken is sent
+	// along with temporary credentials
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PartSize is the size of the parts of multipart uploads, 8 MiB unless
+	// set. S3 needs at least 5 MiB. Backups that fit into one part are
+	// uploaded with a single request
+	PartSize int
+	// Retry retries requests failing with network errors, throttling or
+	// server errors. It defaults to four attempts, backing off from half a
+	// second; its Retryable defaults to those errors
+	Retry *RetryPolicy
+	// HTTPClient sends requests, http.DefaultClient unless set
+	HTTPClient *http.Client
+	// Logger, if set, receives logs of retried requests
+	Logger hclog.Logger
+}
+
+// S3Sink is a BackupSink uploading backups to S3 or S3-compatible object
+// storage, using multipart uploads for backups larger than a part.
+type S3Sink struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	retry    RetryPolicy
+	metrics  *storeMetrics
+	logger   hclog.Logger
+}
+
+var _ BackupSink = (*S3Sink)(nil)
+
+// S3Error is an error response from S3.
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// NewS3Sink returns a sink uploading backups as configured.
+func NewS3Sink(config S3Config) (*S3Sink, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("S3 bucket not set")
+	}
+	if config.PartSize == 0 {
+		config.PartSize = defaultS3PartSize
+	}
+	if config.PartSize < minS3PartSize {
+		return nil, fmt.Errorf("invalid S3 part size %d, needs at least %d", config.PartSize, minS3PartSize)
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	s := &S3Sink{
+		config:   config,
+		endpoint: endpoint,
+		client:   config.HTTPClient,
+		retry:    defaultS3Retry,
+		metrics:  newStoreMetrics(nil, nil),
+		logger:   config.Logger,
+	}
+	if config.Retry != nil {
+		s.retry = *config.Retry
+	}
+	if s.retry.Retryable == nil {
+		s.retry.Retryable = isS3Transient
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if s.logger == nil {
+		s.logger = hclog.NewNullLogger()
+	}
+	return s, nil
+}
+
+// isS3Transient reports whether a failed S3 request is worth retrying:
+// it failed in transit, was throttled or hit a server error.
+func isS3Transient(err error) bool {
+	var s3Err *S3Error
+	if errors.As(err, &s3Err) {
+		switch {
+		case s3Err.StatusCode >= 500, s3Err.StatusCode == http.StatusTooManyRequests, s3Err.StatusCode == http.StatusRequestTimeout:
+			return true
+		}
+		return s3Err.Code == "InternalError" || s3Err.Code == "SlowDown" || s3Err.Code == "RequestTimeout"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Create implements BackupSink. The backup is buffered a part at a time,
+// so uploading starts while it is being written.
+func (s *S3Sink) Create(name string) (BackupWriter, error) {
+	return &s3Writer{s: s, key: s.config.Prefix + name, buf: make([]byte, 0, s.config.PartSize)}, nil
+}
+
+// List implements BackupSink, returning the names of the objects under
+// Prefix.
+func (s *S3Sink) List() ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.config.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		_, body, err := s.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("malformed S3 listing: %w", err)
+		}
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, s.config.Prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Remove implements BackupSink.
+func (s *S3Sink) Remove(name string) error {
+	_, _, err := s.request(http.MethodDelete, s.config.Prefix+name, nil, nil)
+	return err
+}
+
+// request sends a signed request for key, the bucket itself if empty, with
+// retries, and returns the headers and body of the response.
+func (s *S3Sink) request(method, key string, query url.Values, body []byte) (http.Header, []byte, error) {
+	rawURL := s.endpoint.Scheme + "://" + s.endpoint.Host + strings.TrimSuffix(s.endpoint.EscapedPath(), "/") +
+		"/" + s3Escape(s.config.Bucket, false)
+	if key != "" {
+		rawURL += "/" + s3Escape(key, true)
+	}
+	if len(query) > 0 {
+		rawURL += "?" + s3Query(query)
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	var header http.Header
+	var respBody []byte
+	err := s.retry.do(s.metrics, s.logger, retryS3, func() error {
+		req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if s.config.SessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+		}
+		signV4(req, payloadHash, s.config.AccessKeyID, s.config.SecretAccessKey, s.config.Region, time.Now())
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		respBody, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		// Completing a multipart upload can fail after S3 has answered 200
+		if resp.StatusCode >= 300 || bytes.Contains(respBody, []byte("<Error>")) {
+			return parseS3Error(resp.StatusCode, respBody)
+		}
+		header = resp.Header
+		return nil
+	})
+	return header, respBody, err
+}
+
+func parseS3Error(status int, body []byte) error {
+	var parsed struct {
+		Code    string
+		Message string
+	}
+	if err := xml.Unmarshal(body, &parsed); err != nil || parsed.Code == "" {
+		parsed.Code = http.StatusText(status)
+	}
+	return &S3Error{StatusCode: status, Code: parsed.Code, Message: parsed.Message}
+}
+
+// s3Part is a part of a multipart upload.
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+// s3Writer uploads a backup, starting a multipart upload once it has a
+// full part.
+type s3Writer struct {
+	s        *S3Sink
+	key      string
+	buf      []byte
+	uploadID string
+	parts    []s3Part
+	err      error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if w.err = w.uploadPart(); w.err != nil {
+				return written, w.err
+			}
+		}
+	}
+	return written, nil
+}
+
+// uploadPart uploads the buffered data as the next part.
+func (w *s3Writer) uploadPart() error {
+	if w.uploadID == "" {
+		_, body, err := w.s.request(http.MethodPost, w.key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil || result.UploadID == "" {
+			return fmt.Errorf("malformed response to starting a multipart upload: %q", body)
+		}
+		w.uploadID = result.UploadID
+	}
+	part := s3Part{PartNumber: len(w.parts) + 1}
+	query := url.Values{"partNumber": {fmt.Sprint(part.PartNumber)}, "uploadId": {w.uploadID}}
+	header, _, err := w.s.request(http.MethodPut, w.key, query, w.buf)
+	if err != nil {
+		return err
+	}
+	part.ETag = header.Get("ETag")
+	w.parts = append(w.parts, part)
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close uploads what is left and completes the upload. A failed upload is
+// aborted.
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		w.Abort()
+		return w.err
+	}
+	w.err = errors.New("backup writer closed")
+	if w.uploadID == "" {
+		_, _, err := w.s.request(http.MethodPut, w.key, nil, w.buf)
+		return err
+	}
+	if len(w.buf) > 0 {
+		if err := w.uploadPart(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	if _, _, err := w.s.request(http.MethodPost, w.key, url.Values{"uploadId": {w.uploadID}}, body); err != nil {
+		w.Abort()
+		return err
+	}
+	return nil
+}
+
+// Abort aborts a multipart upload in progress. S3 keeps the parts of one
+// that is never completed or aborted, so failed backups clean up after
+// themselves.
+func (w *s3Writer) Abort() error {
+	w.err = errors.New("backup writer aborted")
+	if w.uploadID == "" {
+		return nil
+	}
+	uploadID := w.uploadID
+	w.uploadID = ""
+	_, _, err := w.s.request(http.MethodDelete, w.key, url.Values{"uploadId": {uploadID}}, nil)
+	return err
+}
+
+// signV4 signs req for S3 with AWS Signature Version 4, covering the host
+// and every header req already has.
+func signV4(req *http.Request, payloadHash, accessKeyID, secretAccessKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Query encodes query the way Signature Version 4 expects, sorted by
+// name and value.
+func s3Query(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes every byte of s but unreserved characters, and
+// slashes if keepSlash is set.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || keepSlash && c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,124 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// ErrStopScan can be returned by a ScanLogs callback to end the scan early
+// without ScanLogs reporting an error.
+var ErrStopScan = errors.New("stop scan")
+
+// LogFilter selects log entries by their metadata. The zero value matches
+// every entry.
+type LogFilter struct {
+	// MinIndex and MaxIndex bound the index range, inclusively. A MaxIndex
+	// of 0 means no upper bound
+	MinIndex uint64
+	MaxIndex uint64
+	// Terms, if not empty, restricts entries to these terms
+	Terms []uint64
+	// Types, if not empty, restricts entries to these log types
+	Types []raft.LogType
+	// MinSize skips entries whose payload is smaller than this many bytes
+	MinSize int
+}
+
+// matchesIndex reports whether idx lies within the filter's index range.
+func (f LogFilter) matchesIndex(idx uint64) bool {
+	return idx >= f.MinIndex && (f.MaxIndex == 0 || idx <= f.MaxIndex)
+}
+
+// Match reports whether log passes the filter.
+func (f LogFilter) Match(log *raft.Log) bool {
+	if !f.matchesIndex(log.Index) || len(log.Data) < f.MinSize {
+		return false
+	}
+	if len(f.Terms) > 0 && !containsUint64(f.Terms, log.Term) {
+		return false
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == log.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsUint64(list []uint64, v uint64) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanLogs calls fn for every stored log entry matching filter, within a
+// single read transaction. Entries outside the index range are skipped
+// without reading or decoding their values. The log passed to fn must not
+// be retained after fn returns. Returning ErrStopScan from fn ends the scan
+// early.
+func (b *BadgerStore) ScanLogs(filter LogFilter, fn func(log *raft.Log) error) error {
+	return b.run(context.Background(), func(context.Context) error {
+		return b.scanLogs(filter, func(log *raft.Log, _ scannedItem) error {
+			return fn(log)
+		})
+	})
+}
+
+// scannedItem carries what ScanLogs knows about an entry beyond the log.
+type scannedItem struct {
+	// storedSize is the size of the value as stored, after encoding
+	storedSize int
+	meta       byte
+}
+
+func (b *BadgerStore) scanLogs(filter LogFilter, fn func(log *raft.Log, item scannedItem) error) error {
+	err := b.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		log := new(raft.Log)
+		for it.Seek(b.keys.logs); it.ValidForPrefix(b.keys.logs); it.Next() {
+			item := it.Item()
+			idx, err := b.keys.parseLogKey(item.Key())
+			if err != nil {
+				return err
+			}
+			if !filter.matchesIndex(idx) || b.isPendingDelete(idx) {
+				continue
+			}
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			*log = raft.Log{}
+			if err := b.decodeLog(txn, idx, v, log); err != nil {
+				return b.logDecodeError(idx, err)
+			}
+			if !filter.Match(log) {
+				continue
+			}
+			if err := fn(log, scannedItem{storedSize: len(v), meta: item.UserMeta()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrStopScan {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_ScanLogs(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("a")},
+		{Index: 2, Term: 1, Type: raft.LogNoop},
+		{Index: 3, Term: 2, Type: raft.LogCommand, Data: []byte("bigger payload")},
+		{Index: 4, Term: 2, Type: raft.LogBarrier},
+		{Index: 5, Term: 3, Type: raft.LogCommand, Data: []byte("ccc")},
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	collect := func(filter LogFilter) []uint64 {
+		var seen []uint64
+		err := store.ScanLogs(filter, func(log *raft.Log) error {
+			seen = append(seen, log.Index)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return seen
+	}
+
+	testCases := []struct {
+		desc   string
+		filter LogFilter
+		want   []uint64
+	}{
+		{desc: "Everything", filter: LogFilter{}, want: []uint64{1, 2, 3, 4, 5}},
+		{desc: "Index range", filter: LogFilter{MinIndex: 2, MaxIndex: 4}, want: []uint64{2, 3, 4}},
+		{desc: "Terms", filter: LogFilter{Terms: []uint64{1, 3}}, want: []uint64{1, 2, 5}},
+		{desc: "Types", filter: LogFilter{Types: []raft.LogType{raft.LogNoop, raft.LogBarrier}}, want: []uint64{2, 4}},
+		{desc: "Min size", filter: LogFilter{MinSize: 3}, want: []uint64{3, 5}},
+		{desc: "Combined", filter: LogFilter{MinIndex: 2, Types: []raft.LogType{raft.LogCommand}}, want: []uint64{3, 5}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if got := collect(tC.filter); !reflect.DeepEqual(got, tC.want) {
+				t.Fatalf("wanted %v, got %v", tC.want, got)
+			}
+		})
+	}
+
+	// Stopping early is not an error
+	count := 0
+	err := store.ScanLogs(LogFilter{}, func(log *raft.Log) error {
+		count++
+		return ErrStopScan
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected the scan to stop after 1 entry, got %d", count)
+	}
+}
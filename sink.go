@@ -0,0 +1,199 @@
+package raftbadgerdb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sinkBackupPrefix and sinkBackupSuffix surround the time a backup sent to
+// a BackupSink was taken, so names sort in the order backups were taken
+// and pruning leaves everything else in the sink alone.
+const (
+	sinkBackupPrefix = "raft-badger-"
+	sinkBackupSuffix = ".bak"
+	sinkBackupTime   = "20060102T150405.000000000Z"
+)
+
+// BackupSink is somewhere BackupTo and scheduled backups send backups, such
+// as a local directory or S3-compatible object storage.
+type BackupSink interface {
+	// Create starts a backup called name. Everything written to it makes
+	// up the backup, which is only complete once Close returns nil;
+	// Abort discards it instead
+	Create(name string) (BackupWriter, error)
+	// List returns the names of the backups in the sink, in any order
+	List() ([]string, error)
+	// Remove deletes the backup called name
+	Remove(name string) error
+}
+
+// BackupWriter receives a backup for a BackupSink.
+type BackupWriter interface {
+	io.WriteCloser
+	// Abort discards what was written so far
+	Abort() error
+}
+
+// BackupTo sends a full backup of the store to sink and returns its name.
+// If retain is positive, the oldest backups the store sent to sink are
+// then removed until only retain are left. A backup that fails part way is
+// aborted and the older ones are kept.
+func (b *BadgerStore) BackupTo(sink BackupSink, retain int) (string, error) {
+	defer b.metrics.measureSince([]string{"backup"}, time.Now())
+	name := sinkBackupPrefix + time.Now().UTC().Format(sinkBackupTime) + sinkBackupSuffix
+	w, err := sink.Create(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := b.Backup(w, 0); err != nil {
+		w.Abort()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if retain > 0 {
+		if err := pruneBackups(sink, retain); err != nil {
+			return name, err
+		}
+	}
+	return name, nil
+}
+
+// pruneBackups removes the oldest backups sent by a store from sink until
+// retain are left.
+func pruneBackups(sink BackupSink, retain int) error {
+	names, err := sink.List()
+	if err != nil {
+		return err
+	}
+	var ours []string
+	for _, name := range names {
+		if strings.HasPrefix(name, sinkBackupPrefix) && strings.HasSuffix(name, sinkBackupSuffix) {
+			ours = append(ours, name)
+		}
+	}
+	sort.Strings(ours)
+	for len(ours) > retain {
+		if err := sink.Remove(ours[0]); err != nil {
+			return err
+		}
+		ours = ours[1:]
+	}
+	return nil
+}
+
+// startBackups sends a backup to sink every interval until the store
+// closes.
+func (b *BadgerStore) startBackups(sink BackupSink, interval time.Duration, retain int) {
+	b.backupStop = make(chan struct{})
+	b.backupDone = make(chan struct{})
+	go func() {
+		defer close(b.backupDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				name, err := b.BackupTo(sink, retain)
+				if err != nil {
+					b.metrics.incrCounter([]string{"backup", "failures"}, 1)
+					b.logger.Warn("scheduled backup failed", "error", err)
+					continue
+				}
+				b.logger.Debug("scheduled backup finished", "name", name)
+			case <-b.backupStop:
+				return
+			}
+		}
+	}()
+}
+
+func (b *BadgerStore) stopBackups() {
+	if b.backupStop == nil {
+		return
+	}
+	close(b.backupStop)
+	<-b.backupDone
+}
+
+// DirBackupSink is a BackupSink keeping backups as files in a directory,
+// for example one mounted from another machine.
+type DirBackupSink struct {
+	dir string
+}
+
+var _ BackupSink = (*DirBackupSink)(nil)
+
+// NewDirBackupSink returns a sink keeping backups in dir, which is created
+// if needed.
+func NewDirBackupSink(dir string) (*DirBackupSink, error) {
+	if err := createDirSynced(dir); err != nil {
+		return nil, err
+	}
+	return &DirBackupSink{dir: dir}, nil
+}
+
+// Create implements BackupSink. The backup is written to a temporary file
+// that Close syncs and renames into place.
+func (s *DirBackupSink) Create(name string) (BackupWriter, error) {
+	path := filepath.Join(s.dir, name)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &dirBackupWriter{File: f, path: path}, nil
+}
+
+// List implements BackupSink.
+func (s *DirBackupSink) List() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() && !strings.HasSuffix(info.Name(), ".tmp") {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// Remove implements BackupSink.
+func (s *DirBackupSink) Remove(name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+type dirBackupWriter struct {
+	*os.File
+	path string
+}
+
+func (w *dirBackupWriter) Close() error {
+	err := w.File.Sync()
+	if closeErr := w.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.File.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(w.path))
+}
+
+func (w *dirBackupWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
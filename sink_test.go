@@ -0,0 +1,155 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_BackupToDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	sink, err := NewDirBackupSink(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Files the store didn't send are never pruned
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	for i := 0; i < 3; i++ {
+		if _, err := store.BackupTo(sink, 1); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	names, err := sink.List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("bad: %v", names)
+	}
+
+	// An aborted backup leaves nothing behind
+	w, err := sink.Create("aborted.bak")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := w.Write([]byte("partial")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if after, err := sink.List(); err != nil || len(after) != 2 {
+		t.Fatalf("bad: %v, %v", after, err)
+	}
+}
+
+func TestNew_BackupInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	sink, err := NewDirBackupSink(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	path, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(path)
+	if _, err := New(Options{Path: path, BackupInterval: time.Second}); err == nil {
+		t.Fatalf("should require a sink")
+	}
+
+	store, err := New(Options{Path: path, BackupSink: sink, BackupInterval: 10 * time.Millisecond, BackupRetain: 2})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testStoreFiveLogs(t, store)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		names, err := sink.List()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if len(names) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bad: %v", names)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if names, err := sink.List(); err != nil || len(names) > 2 {
+		t.Fatalf("bad: %v, %v", names, err)
+	}
+}
+
+func TestBadgerStore_BackupToAfterDeleteRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	sink, err := NewDirBackupSink(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	if err := store.DeleteRange(1, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	name, err := store.BackupTo(sink, 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Compacted logs aren't uploaded as empty entries
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer f.Close()
+	fresh := testBadgerStore(t)
+	defer fresh.Close()
+	defer os.RemoveAll(fresh.path)
+	if err := fresh.Restore(f); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first, err := fresh.FirstIndex(); err != nil || first != 4 {
+		t.Fatalf("bad: %d, %v", first, err)
+	}
+	log := new(raft.Log)
+	for idx := uint64(1); idx <= 5; idx++ {
+		err := fresh.GetLog(idx, log)
+		if idx <= 3 && err != raft.ErrLogNotFound {
+			t.Fatalf("expected log %d not found, got: %v", idx, err)
+		}
+		if idx > 3 && (err != nil || log.Index != idx) {
+			t.Fatalf("bad: %#v, %v", log, err)
+		}
+	}
+}
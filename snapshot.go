@@ -0,0 +1,570 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+)
+
+// snapshotChunkSize is the largest value a snapshot is split into. Each
+// chunk is written in its own transaction, so snapshots aren't bounded by
+// Badger's transaction size.
+const snapshotChunkSize = 1 * mib
+
+// ErrSnapshotCorrupt is returned when reading a snapshot whose data doesn't
+// match the checksum recorded when it was written.
+var ErrSnapshotCorrupt = errors.New("snapshot corrupt")
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// plainChunkTag starts a snapshot chunk stored uncompressed, inside the
+// encryption envelope if there is one. Compressed chunks start with
+// compressedTag instead.
+const plainChunkTag byte = 0x00
+
+// snapshotRecord is what is stored under a snapshot's metadata key. It is
+// only written once every chunk is, so a snapshot without one is
+// incomplete.
+type snapshotRecord struct {
+	Meta   raft.SnapshotMeta
+	Chunks uint32
+	CRC    uint64
+	// Sealed is set for snapshots whose chunks are stored in the value
+	// envelope, see sealChunk. Chunks of snapshots written by earlier
+	// versions are stored as they are.
+	Sealed bool
+}
+
+// BadgerSnapshotStore implements raft.SnapshotStore on top of a
+// BadgerStore, keeping snapshots in the same database as the log. Only the
+// retain most recent snapshots are kept.
+type BadgerSnapshotStore struct {
+	b      *BadgerStore
+	retain int
+
+	// reapLock serializes removing old snapshots.
+	reapLock sync.Mutex
+}
+
+var _ raft.SnapshotStore = (*BadgerSnapshotStore)(nil)
+
+// NewSnapshotStore returns a snapshot store keeping its snapshots in store
+// and retaining the retain most recent ones. Data left behind by snapshots
+// that were never completed is removed.
+func NewSnapshotStore(store *BadgerStore, retain int) (*BadgerSnapshotStore, error) {
+	if retain < 1 {
+		return nil, fmt.Errorf("must retain at least one snapshot")
+	}
+	s := &BadgerSnapshotStore{b: store, retain: retain}
+	if err := store.run(context.Background(), func(context.Context) error {
+		return s.removeOrphans()
+	}); err != nil {
+		return nil, fmt.Errorf("failed to remove incomplete snapshots: %w", err)
+	}
+	return s, nil
+}
+
+// Create implements the raft.SnapshotStore interface.
+func (s *BadgerSnapshotStore) Create(version raft.SnapshotVersion, index, term uint64,
+	configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	peers, err := encodePeers(configuration, trans)
+	if err != nil {
+		return nil, err
+	}
+	id := fmt.Sprintf("%d-%d-%d", term, index, time.Now().UnixNano()/int64(time.Millisecond))
+	return &badgerSnapshotSink{
+		s: s,
+		meta: raft.SnapshotMeta{
+			Version:            version,
+			ID:                 id,
+			Index:              index,
+			Term:               term,
+			Peers:              peers,
+			Configuration:      configuration,
+			ConfigurationIndex: configurationIndex,
+		},
+		hash: crc64.New(crcTable),
+	}, nil
+}
+
+// List implements the raft.SnapshotStore interface. Snapshots are returned
+// newest first.
+func (s *BadgerSnapshotStore) List() ([]*raft.SnapshotMeta, error) {
+	var records []snapshotRecord
+	err := s.b.run(context.Background(), func(context.Context) (err error) {
+		records, err = s.records()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	metas := make([]*raft.SnapshotMeta, len(records))
+	for i := range records {
+		metas[i] = &records[i].Meta
+	}
+	return metas, nil
+}
+
+// Open implements the raft.SnapshotStore interface. The reader sees the
+// snapshot as of when it was opened and must be closed.
+func (s *BadgerSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	var txn *badger.Txn
+	var record snapshotRecord
+	err := s.b.run(context.Background(), func(context.Context) (err error) {
+		txn = s.b.db.NewTransaction(false)
+		if record, err = s.b.readSnapshotRecord(txn, id); err != nil {
+			txn.Discard()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &record.Meta, &badgerSnapshotReader{
+		b:      s.b,
+		txn:    txn,
+		keys:   s.b.keys,
+		record: record,
+		hash:   crc64.New(crcTable),
+		logger: s.b.logger,
+	}, nil
+}
+
+// records returns every complete snapshot, newest first.
+func (s *BadgerSnapshotStore) records() ([]snapshotRecord, error) {
+	var records []snapshotRecord
+	prefix := append(append([]byte(nil), s.b.keys.snap...), 'm')
+	err := s.b.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			val, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+			var record snapshotRecord
+			if err := json.Unmarshal(val, &record); err != nil {
+				return fmt.Errorf("snapshot %s: %w", it.Item().Key()[len(prefix):], err)
+			}
+			if record.Meta.Version != 1 {
+				continue
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i].Meta, records[j].Meta
+		if a.Term != b.Term {
+			return a.Term > b.Term
+		}
+		if a.Index != b.Index {
+			return a.Index > b.Index
+		}
+		return a.ID > b.ID
+	})
+	return records, nil
+}
+
+func (b *BadgerStore) readSnapshotRecord(txn *badger.Txn, id string) (snapshotRecord, error) {
+	var record snapshotRecord
+	item, err := txn.Get(b.keys.snapMetaKey(id))
+	if err == badger.ErrKeyNotFound {
+		return record, fmt.Errorf("snapshot %s: %w", id, ErrKeyNotFound)
+	}
+	if err != nil {
+		return record, err
+	}
+	val, err := item.Value()
+	if err != nil {
+		return record, err
+	}
+	if err := json.Unmarshal(val, &record); err != nil {
+		return record, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	return record, nil
+}
+
+// reap removes every snapshot beyond the retain most recent ones. The
+// metadata goes first, so a snapshot cut short while being removed is
+// never listed.
+func (s *BadgerSnapshotStore) reap() error {
+	s.reapLock.Lock()
+	defer s.reapLock.Unlock()
+	records, err := s.records()
+	if err != nil {
+		return err
+	}
+	for i := s.retain; i < len(records); i++ {
+		id := records[i].Meta.ID
+		err := s.b.update(func(txn *writeTxn) error {
+			return txn.Delete(s.b.keys.snapMetaKey(id))
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.b.deletePrefix(s.b.keys.snapChunkPrefix(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOrphans removes the chunks of snapshots that have no metadata,
+// left behind by a sink that was neither closed nor cancelled.
+func (s *BadgerSnapshotStore) removeOrphans() error {
+	orphans := make(map[string]bool)
+	prefix := append(append([]byte(nil), s.b.keys.snap...), 'c')
+	err := s.b.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().Key()
+			end := bytes.LastIndexByte(key, '/')
+			if end < len(prefix) {
+				continue
+			}
+			id := string(key[len(prefix):end])
+			if _, seen := orphans[id]; seen {
+				continue
+			}
+			_, err := txn.Get(s.b.keys.snapMetaKey(id))
+			switch err {
+			case nil:
+				orphans[id] = false
+			case badger.ErrKeyNotFound:
+				orphans[id] = true
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id, orphan := range orphans {
+		if !orphan {
+			continue
+		}
+		if err := s.b.deletePrefix(s.b.keys.snapChunkPrefix(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletePrefix deletes every key under prefix, splitting the deletes
+// across transactions if they don't fit into one.
+func (b *BadgerStore) deletePrefix(prefix []byte) error {
+	var keys [][]byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	txn := b.newWriteTxn()
+	defer func() { txn.Discard() }()
+	for _, key := range keys {
+		err := txn.Delete(key)
+		if err == badger.ErrTxnTooBig {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = b.newWriteTxn()
+			err = txn.Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// badgerSnapshotSink writes a snapshot chunk by chunk and records its
+// metadata on Close.
+type badgerSnapshotSink struct {
+	s      *BadgerSnapshotStore
+	meta   raft.SnapshotMeta
+	buf    []byte
+	chunks uint32
+	hash   hash.Hash64
+	closed bool
+}
+
+// ID implements the raft.SnapshotSink interface.
+func (sink *badgerSnapshotSink) ID() string {
+	return sink.meta.ID
+}
+
+// Write implements the io.Writer interface, writing out every chunk that
+// fills up.
+func (sink *badgerSnapshotSink) Write(p []byte) (int, error) {
+	if sink.closed {
+		return 0, fmt.Errorf("snapshot %s is closed", sink.meta.ID)
+	}
+	sink.buf = append(sink.buf, p...)
+	for len(sink.buf) >= snapshotChunkSize {
+		if err := sink.writeChunk(sink.buf[:snapshotChunkSize]); err != nil {
+			return 0, err
+		}
+		sink.buf = append(sink.buf[:0], sink.buf[snapshotChunkSize:]...)
+	}
+	sink.hash.Write(p)
+	sink.meta.Size += int64(len(p))
+	return len(p), nil
+}
+
+func (sink *badgerSnapshotSink) writeChunk(data []byte) error {
+	key := sink.s.b.keys.snapChunkKey(sink.meta.ID, sink.chunks)
+	val, err := sink.s.b.sealChunk(sink.meta.ID, sink.chunks, data)
+	if err != nil {
+		return fmt.Errorf("snapshot %s: failed to seal chunk %d: %w", sink.meta.ID, sink.chunks, err)
+	}
+	err = sink.s.b.run(context.Background(), func(context.Context) error {
+		return sink.s.b.update(func(txn *writeTxn) error {
+			return txn.Set(key, val)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot %s: failed to write chunk %d: %w", sink.meta.ID, sink.chunks, err)
+	}
+	sink.chunks++
+	return nil
+}
+
+// Close implements the io.Closer interface. It writes the last chunk and
+// the metadata, making the snapshot visible, and removes snapshots beyond
+// the retained ones. If it fails, the snapshot is removed.
+func (sink *badgerSnapshotSink) Close() error {
+	if sink.closed {
+		return nil
+	}
+	sink.closed = true
+	return sink.s.b.run(context.Background(), func(context.Context) error {
+		if err := sink.commit(); err != nil {
+			sink.s.b.deletePrefix(sink.s.b.keys.snapChunkPrefix(sink.meta.ID))
+			return err
+		}
+		if err := sink.s.reap(); err != nil {
+			return fmt.Errorf("failed to remove old snapshots: %w", err)
+		}
+		return nil
+	})
+}
+
+func (sink *badgerSnapshotSink) commit() error {
+	if len(sink.buf) > 0 {
+		if err := sink.writeChunk(sink.buf); err != nil {
+			return err
+		}
+		sink.buf = nil
+	}
+	val, err := json.Marshal(snapshotRecord{
+		Meta:   sink.meta,
+		Chunks: sink.chunks,
+		CRC:    sink.hash.Sum64(),
+		Sealed: true,
+	})
+	if err != nil {
+		return err
+	}
+	return sink.s.b.update(func(txn *writeTxn) error {
+		return txn.Set(sink.s.b.keys.snapMetaKey(sink.meta.ID), val)
+	})
+}
+
+// Cancel implements the raft.SnapshotSink interface, removing everything
+// written so far.
+func (sink *badgerSnapshotSink) Cancel() error {
+	if sink.closed {
+		return nil
+	}
+	sink.closed = true
+	sink.buf = nil
+	return sink.s.b.run(context.Background(), func(context.Context) error {
+		return sink.s.b.deletePrefix(sink.s.b.keys.snapChunkPrefix(sink.meta.ID))
+	})
+}
+
+// badgerSnapshotReader reads a snapshot's chunks in order from a single
+// read transaction, verifying the checksum once it reaches the end.
+type badgerSnapshotReader struct {
+	b      *BadgerStore
+	txn    *badger.Txn
+	keys   keyPrefixes
+	record snapshotRecord
+	next   uint32
+	cur    []byte
+	hash   hash.Hash64
+	logger hclog.Logger
+}
+
+// Read implements the io.Reader interface.
+func (r *badgerSnapshotReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if r.next == r.record.Chunks {
+			if r.hash.Sum64() != r.record.CRC {
+				r.logger.Error("snapshot checksum mismatch", "id", r.record.Meta.ID)
+				return 0, fmt.Errorf("snapshot %s: %w", r.record.Meta.ID, ErrSnapshotCorrupt)
+			}
+			return 0, io.EOF
+		}
+		err := r.b.run(context.Background(), func(context.Context) error {
+			item, err := r.txn.Get(r.keys.snapChunkKey(r.record.Meta.ID, r.next))
+			if err == badger.ErrKeyNotFound {
+				r.logger.Error("snapshot chunk missing", "id", r.record.Meta.ID, "chunk", r.next)
+				return fmt.Errorf("snapshot %s: chunk %d missing: %w", r.record.Meta.ID, r.next, ErrSnapshotCorrupt)
+			}
+			if err != nil {
+				return err
+			}
+			if r.cur, err = item.ValueCopy(nil); err != nil || !r.record.Sealed {
+				return err
+			}
+			r.cur, err = r.b.openChunk(r.record.Meta.ID, r.next, r.cur)
+			if errors.Is(err, ErrSnapshotCorrupt) {
+				r.logger.Error("snapshot chunk corrupt", "id", r.record.Meta.ID, "chunk", r.next)
+				return fmt.Errorf("snapshot %s: chunk %d: %w", r.record.Meta.ID, r.next, err)
+			}
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		r.hash.Write(r.cur)
+		r.next++
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+// Close implements the io.Closer interface.
+func (r *badgerSnapshotReader) Close() error {
+	r.txn.Discard()
+	return nil
+}
+
+// sealChunk wraps chunk n of snapshot id in the envelopes a log entry gets:
+// compression, encryption and the checksum. Chunks aren't encoded with a
+// codec, so one left uncompressed starts with plainChunkTag.
+func (b *BadgerStore) sealChunk(id string, n uint32, data []byte) ([]byte, error) {
+	v := append([]byte{plainChunkTag}, data...)
+	if len(data) >= b.minCompressSize {
+		// data itself comes back unless compressing it made it smaller
+		if compressed := compressValue(b.compression, data); len(compressed) < len(data) {
+			v = compressed
+		}
+	}
+	v, err := b.sealValue(v, chunkBinding(id, n))
+	if err != nil {
+		return nil, err
+	}
+	return checksumValue(v), nil
+}
+
+// openChunk strips the envelopes sealChunk wrapped chunk n of snapshot id
+// in. A chunk whose checksum doesn't match, or that isn't in the envelopes
+// at all, is reported as ErrSnapshotCorrupt.
+func (b *BadgerStore) openChunk(id string, n uint32, v []byte) ([]byte, error) {
+	if len(v) == 0 || v[0] != checksumTag {
+		return nil, fmt.Errorf("%w: missing checksum envelope", ErrSnapshotCorrupt)
+	}
+	v, err := b.openChecksum(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	if isEncrypted(v) {
+		if v, err = b.openValue(v, chunkBinding(id, n)); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case len(v) > 0 && v[0] == compressedTag:
+		if v, err = decompressValue(v); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+		}
+		return v, nil
+	case len(v) > 0 && v[0] == plainChunkTag:
+		return v[1:], nil
+	}
+	return nil, fmt.Errorf("%w: unknown chunk envelope", ErrSnapshotCorrupt)
+}
+
+// reencryptChunk returns a snapshot chunk sealed with the active key, or
+// nil if it already is or its snapshot was written before chunks were
+// sealed. Chunks of a snapshot still being written are sealed like any
+// other, but ones that don't unwrap are left over from a snapshot that was
+// never completed, and are left for NewSnapshotStore to remove.
+func (b *BadgerStore) reencryptChunk(txn *badger.Txn, key, v []byte) ([]byte, error) {
+	// The key is the prefix, 'c', the ID, '/' and the chunk number
+	if len(key) < len(b.keys.snap)+6 {
+		return nil, fmt.Errorf("not a snapshot chunk key: %q", key)
+	}
+	id := string(key[len(b.keys.snap)+1 : len(key)-5])
+	n := binary.BigEndian.Uint32(key[len(key)-4:])
+	record, err := b.readSnapshotRecord(txn, id)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+	complete := err == nil
+	if complete && !record.Sealed {
+		return nil, nil
+	}
+	// The chunk envelopes are those of a log entry that isn't deduplicated
+	val, err := b.reencryptLog(v, chunkBinding(id, n))
+	if err != nil && !complete {
+		return nil, nil
+	}
+	return val, err
+}
+
+// encodePeers encodes the voters in configuration the way raft records
+// them in SnapshotMeta.Peers for older versions.
+func encodePeers(configuration raft.Configuration, trans raft.Transport) ([]byte, error) {
+	if trans == nil {
+		return nil, nil
+	}
+	var peers [][]byte
+	for _, server := range configuration.Servers {
+		if server.Suffrage == raft.Voter {
+			peers = append(peers, trans.EncodePeer(server.ID, server.Address))
+		}
+	}
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf, &codec.MsgpackHandle{}).Encode(peers); err != nil {
+		return nil, fmt.Errorf("failed to encode peers: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,292 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"hash/crc64"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testSnapshotStore(t *testing.T, retain int) (*BadgerStore, *BadgerSnapshotStore) {
+	store := testBadgerStore(t)
+	snaps, err := NewSnapshotStore(store, retain)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store, snaps
+}
+
+func testCreateSnapshot(t *testing.T, snaps *BadgerSnapshotStore, index, term uint64, data []byte) string {
+	_, trans := raft.NewInmemTransport(raft.NewInmemAddr())
+	configuration := raft.Configuration{Servers: []raft.Server{
+		{Suffrage: raft.Voter, ID: "a", Address: "a"},
+	}}
+	sink, err := snaps.Create(1, index, term, configuration, 1, trans)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := sink.Write(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return sink.ID()
+}
+
+func TestBadgerSnapshotStore_CreateListOpen(t *testing.T) {
+	store, snaps := testSnapshotStore(t, 2)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	if _, err := NewSnapshotStore(store, 0); err == nil {
+		t.Fatalf("should reject retaining no snapshots")
+	}
+
+	// Spans several chunks and ends partway through one
+	data := bytes.Repeat([]byte("snapshot"), snapshotChunkSize/3)
+	id := testCreateSnapshot(t, snaps, 10, 3, data)
+
+	metas, err := snaps.List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(metas) != 1 {
+		t.Fatalf("bad: %d snapshots", len(metas))
+	}
+	if m := metas[0]; m.ID != id || m.Index != 10 || m.Term != 3 || m.Size != int64(len(data)) || len(m.Peers) == 0 {
+		t.Fatalf("bad: %#v", m)
+	}
+
+	meta, rc, err := snaps.Open(id)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(got, data) || meta.ConfigurationIndex != 1 || len(meta.Configuration.Servers) != 1 {
+		t.Fatalf("bad: %d bytes, %#v", len(got), meta)
+	}
+
+	if _, _, err := snaps.Open("nope"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("bad: %v", err)
+	}
+	if _, err := snaps.Create(2, 1, 1, raft.Configuration{}, 0, nil); err == nil {
+		t.Fatalf("should reject unsupported versions")
+	}
+}
+
+func TestBadgerSnapshotStore_Retain(t *testing.T) {
+	store, snaps := testSnapshotStore(t, 2)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	first := testCreateSnapshot(t, snaps, 10, 1, []byte("first"))
+	second := testCreateSnapshot(t, snaps, 20, 1, []byte("second"))
+	third := testCreateSnapshot(t, snaps, 15, 2, []byte("third"))
+
+	metas, err := snaps.List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(metas) != 2 || metas[0].ID != third || metas[1].ID != second {
+		t.Fatalf("bad: %v", metas)
+	}
+	if _, _, err := snaps.Open(first); err == nil {
+		t.Fatalf("should have removed the oldest snapshot")
+	}
+	if n := testCountPrefix(t, store, defaultKeys.snapChunkPrefix(first)); n != 0 {
+		t.Fatalf("bad: %d chunks left", n)
+	}
+}
+
+func TestBadgerSnapshotStore_Cancel(t *testing.T) {
+	store, snaps := testSnapshotStore(t, 1)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	sink, err := snaps.Create(1, 5, 1, raft.Configuration{}, 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := sink.Write(make([]byte, snapshotChunkSize+1)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := sink.Write([]byte("late")); err == nil {
+		t.Fatalf("should reject writes after cancelling")
+	}
+	metas, err := snaps.List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(metas) != 0 {
+		t.Fatalf("bad: %v", metas)
+	}
+	if n := testCountPrefix(t, store, defaultKeys.snap); n != 0 {
+		t.Fatalf("bad: %d keys left", n)
+	}
+}
+
+func TestNewSnapshotStore_RemovesOrphans(t *testing.T) {
+	store, snaps := testSnapshotStore(t, 1)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	kept := testCreateSnapshot(t, snaps, 5, 1, []byte("kept"))
+	sink, err := snaps.Create(1, 6, 1, raft.Configuration{}, 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := sink.Write(make([]byte, snapshotChunkSize)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The sink is abandoned, as if the process died
+	if _, err := NewSnapshotStore(store, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := testCountPrefix(t, store, defaultKeys.snapChunkPrefix(sink.ID())); n != 0 {
+		t.Fatalf("bad: %d chunks left", n)
+	}
+	if n := testCountPrefix(t, store, defaultKeys.snapChunkPrefix(kept)); n != 1 {
+		t.Fatalf("bad: %d chunks", n)
+	}
+}
+
+func TestBadgerSnapshotStore_Corrupt(t *testing.T) {
+	store, snaps := testSnapshotStore(t, 1)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	id := testCreateSnapshot(t, snaps, 5, 1, []byte("hello"))
+	err := store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(defaultKeys.snapChunkKey(id, 0), []byte("jello"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, rc, err := snaps.Open(id)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer rc.Close()
+	if _, err := ioutil.ReadAll(rc); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func testCountPrefix(t *testing.T, store *BadgerStore, prefix []byte) int {
+	n := 0
+	err := store.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return n
+}
+
+func TestBadgerSnapshotStore_Sealed(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	key1 := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	key2 := EncryptionKey{ID: 2, Key: bytes.Repeat([]byte{2}, 32)}
+	store, err := New(Options{Path: fh, EncryptionKey: &key1, Compression: CompressionSnappy})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	snaps, err := NewSnapshotStore(store, 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	data := bytes.Repeat([]byte("secret"), 1000)
+	id := testCreateSnapshot(t, snaps, 5, 1, data)
+
+	// Chunks are compressed, encrypted and checksummed like log entries
+	var chunk []byte
+	err = store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(defaultKeys.snapChunkKey(id, 0))
+		if err != nil {
+			return err
+		}
+		chunk, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if chunk[0] != checksumTag || chunk[checksumEnvelopeSize] != boundEncryptedTag || bytes.Contains(chunk, []byte("secret")) || len(chunk) >= len(data) {
+		t.Fatalf("bad: %d bytes, %x", len(chunk), chunk[:checksumEnvelopeSize+1])
+	}
+
+	// Chunks of snapshots written before they were sealed read as they are
+	legacy := []byte("legacy snapshot")
+	hash := crc64.New(crcTable)
+	hash.Write(legacy)
+	record, err := json.Marshal(snapshotRecord{
+		Meta:   raft.SnapshotMeta{Version: 1, ID: "1-1-1", Index: 1, Term: 1, Size: int64(len(legacy))},
+		Chunks: 1,
+		CRC:    hash.Sum64(),
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = store.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(defaultKeys.snapChunkKey("1-1-1", 0), legacy); err != nil {
+			return err
+		}
+		return txn.Set(defaultKeys.snapMetaKey("1-1-1"), record)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Rotating re-encrypts the sealed chunks only, so the old key can go
+	if n, err := store.RotateEncryptionKey(key2); err != nil || n != 1 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	store.Close()
+	store, err = New(Options{Path: fh, EncryptionKey: &key2})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	snaps, err = NewSnapshotStore(store, 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for snap, want := range map[string][]byte{id: data, "1-1-1": legacy} {
+		_, rc, err := snaps.Open(snap)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("bad: %q", got)
+		}
+	}
+}
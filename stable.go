@@ -0,0 +1,147 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger"
+)
+
+// ErrStableStoreLayout is returned by New when a store whose stable store
+// was kept separately is opened without Options.SeparateStableStore.
+var ErrStableStoreLayout = errors.New("stable store kept in a separate database")
+
+// stableDir returns the directory a separate stable store keeps its files
+// in. It lives inside Badger's directory, so copying, replacing or
+// destroying the store takes it along.
+func stableDir(path string) string {
+	return filepath.Join(badgerDir(path), "stable")
+}
+
+// openStableStore opens the separate stable store if options ask for one,
+// moving stable store values the log's database still holds into it.
+// Without one, it makes sure the store never had one.
+func (b *BadgerStore) openStableStore(options Options) error {
+	dir := stableDir(b.path)
+	if !options.SeparateStableStore {
+		if _, err := os.Stat(dir); err == nil {
+			return fmt.Errorf("%w: open %s with SeparateStableStore", ErrStableStoreLayout, b.path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	opts := badger.DefaultOptions
+	if options.StableBadgerOptions != nil {
+		opts = *options.StableBadgerOptions
+	}
+	opts.Dir = dir
+	opts.ValueDir = dir
+	fillBadgerDefaults(&opts)
+	if options.SyncPolicy != SyncDefault {
+		opts.SyncWrites = options.SyncPolicy == SyncAlways
+	}
+	opts.ReadOnly = b.badgerOpts.ReadOnly
+	if opts.ReadOnly {
+		if _, err := os.Stat(dir); err != nil {
+			return err
+		}
+	} else if err := createDirSynced(dir); err != nil {
+		return err
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return err
+	}
+	b.stableDB = db
+	b.stableBadgerOpts = opts
+	if options.skipUpgrade {
+		return nil
+	}
+	if err := b.moveStableKeys(); err != nil {
+		return err
+	}
+	// Upgrading the key format only rewrote the log's database
+	return b.rewriteLegacyConfKeys(db, b.newStableWriteTxn)
+}
+
+// moveStableKeys moves stable store values from the log's database into
+// the separate stable store. They are all copied before any is deleted, so
+// an interrupted move is finished by the next open. A read-only store
+// can't move them and fails with ErrUpgradeRequired.
+func (b *BadgerStore) moveStableKeys() error {
+	if b.badgerOpts.ReadOnly {
+		var left uint64
+		err := b.db.View(func(txn *badger.Txn) error {
+			left = countPrefix(txn, b.keys.conf)
+			return nil
+		})
+		if err == nil && left > 0 {
+			err = ErrUpgradeRequired
+		}
+		return err
+	}
+	moved := 0
+	txn := b.newStableWriteTxn()
+	defer func() { txn.Discard() }()
+	err := b.db.View(func(view *badger.Txn) error {
+		it := view.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(b.keys.conf); it.ValidForPrefix(b.keys.conf); it.Next() {
+			item := it.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			e := &badger.Entry{Key: item.KeyCopy(nil), Value: v}
+			err = txn.SetEntry(e)
+			if err == badger.ErrTxnTooBig {
+				if err := txn.Commit(); err != nil {
+					return err
+				}
+				txn = b.newStableWriteTxn()
+				err = txn.SetEntry(e)
+			}
+			if err != nil {
+				return err
+			}
+			moved++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	if moved == 0 {
+		return nil
+	}
+	b.logger.Debug("moved stable store values to their own database", "keys", moved)
+	return b.deletePrefix(b.keys.conf)
+}
+
+// newStableWriteTxn starts a read-write transaction on the database
+// holding the stable store.
+func (b *BadgerStore) newStableWriteTxn() *writeTxn {
+	return &writeTxn{Txn: b.stableDB.NewTransaction(true), b: b}
+}
+
+// updateStable is update for the database holding the stable store.
+func (b *BadgerStore) updateStable(fn func(txn *writeTxn) error) error {
+	txn := b.newStableWriteTxn()
+	defer txn.Discard()
+	if err := fn(txn); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// separateStable reports whether the stable store has a database of its
+// own.
+func (b *BadgerStore) separateStable() bool {
+	return b.stableDB != b.db
+}
@@ -0,0 +1,103 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testSeparateStore(t *testing.T, path string) *BadgerStore {
+	store, err := New(Options{Path: path, SeparateStableStore: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestBadgerStore_SeparateStableStore(t *testing.T) {
+	// Values written before the split move over on open
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+	if err := store.SetUint64([]byte("CurrentTerm"), 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store = testSeparateStore(t, store.path)
+	if !store.separateStable() {
+		t.Fatalf("should open a separate stable store")
+	}
+	if n := testCountPrefix(t, store, defaultKeys.conf); n != 0 {
+		t.Fatalf("bad: %d stable keys left in the log's database", n)
+	}
+	if v, err := store.GetUint64([]byte("CurrentTerm")); err != nil || v != 3 {
+		t.Fatalf("bad: %d, %v", v, err)
+	}
+	if err := store.Set([]byte("LastVoteCand"), []byte("node1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if ok, err := store.SetUint64IfGreater([]byte("CurrentTerm"), 4); err != nil || !ok {
+		t.Fatalf("bad: %v, %v", ok, err)
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.StableKeys != 2 || stats.LogEntries != 5 {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A stable-only backup comes from the stable store and restores into it
+	var buf bytes.Buffer
+	if err := store.BackupScoped(&buf, BackupScope{Stable: true}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fresh := testSeparateStore(t, dir)
+	defer fresh.Close()
+	if err := fresh.RestoreScoped(&buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v, err := fresh.GetUint64([]byte("CurrentTerm")); err != nil || v != 4 {
+		t.Fatalf("bad: %d, %v", v, err)
+	}
+
+	// Once split, the store can't be opened the old way
+	if _, err := NewBadgerStore(store.path); !errors.Is(err, ErrStableStoreLayout) {
+		t.Fatalf("bad: %v", err)
+	}
+	store = testSeparateStore(t, store.path)
+	defer store.Close()
+	if v, err := store.Get([]byte("LastVoteCand")); err != nil || string(v) != "node1" {
+		t.Fatalf("bad: %q, %v", v, err)
+	}
+}
+
+func TestNew_StableStoreOptions(t *testing.T) {
+	path, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(path)
+	if _, err := New(Options{Path: path, StableValueLogGCInterval: 1}); err == nil {
+		t.Fatalf("should require SeparateStableStore")
+	}
+	if _, err := New(Options{Path: path, SeparateStableStore: true, MirrorPath: path + "-mirror"}); err == nil {
+		t.Fatalf("should refuse to mirror a separate stable store")
+	}
+}
@@ -0,0 +1,90 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// KV is a stable store key and its value.
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// SetMany sets every key in kvs in a single transaction, so either all of
+// them are stored or, on error, none is. Raft can persist the current term
+// and the vote together this way, and never leave one without the other.
+// A key listed twice takes its last value.
+func (b *BadgerStore) SetMany(kvs []KV) error {
+	if b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(kvs) == 0 {
+		return nil
+	}
+	defer b.metrics.measureSince([]string{"set"}, time.Now())
+	defer b.finishOp(opSet, time.Now(), uint64(len(kvs)))
+	size := 0
+	for _, kv := range kvs {
+		size += len(kv.Value)
+	}
+	span := b.startSpan(context.Background(), "SetMany", attrKeys.Int(len(kvs)), attrBytes.Int(size))
+	err := b.run(context.Background(), func(ctx context.Context) error {
+		return b.doWrite(ctx, func() error {
+			return b.updateStable(func(txn *writeTxn) error {
+				for _, kv := range kvs {
+					if err := txn.Set(b.keys.confKey(kv.Key), kv.Value); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	})
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	b.prom.wrote(0, size)
+	for _, kv := range kvs {
+		b.stableKeySet(kv.Key)
+	}
+	return nil
+}
+
+// GetMany returns the values of keys, in the same order, as of a single
+// point in time. Unlike Get it doesn't fail for keys that aren't set; their
+// values are nil.
+func (b *BadgerStore) GetMany(keys [][]byte) ([][]byte, error) {
+	defer b.metrics.measureSince([]string{"get"}, time.Now())
+	defer b.finishOp(opGet, time.Now(), uint64(len(keys)))
+	span := b.startSpan(context.Background(), "GetMany", attrKeys.Int(len(keys)))
+	var vals [][]byte
+	err := b.run(context.Background(), func(context.Context) error {
+		return b.readRetry.do(b.metrics, b.logger, retryRead, func() error {
+			vals = make([][]byte, len(keys))
+			return b.stableDB.View(func(txn *badger.Txn) error {
+				for i, k := range keys {
+					item, err := txn.Get(b.keys.confKey(k))
+					if err == badger.ErrKeyNotFound {
+						continue
+					}
+					if err != nil {
+						return err
+					}
+					if vals[i], err = item.ValueCopy(nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	})
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
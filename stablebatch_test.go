@@ -0,0 +1,64 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBadgerStore_SetMany(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+
+	var set []string
+	store.hooks.StableKeySet = func(k []byte) {
+		set = append(set, string(k))
+	}
+	kvs := []KV{
+		{Key: []byte("CurrentTerm"), Value: uint64ToBytes(3)},
+		{Key: []byte("LastVoteTerm"), Value: uint64ToBytes(3)},
+		{Key: []byte("LastVoteCand"), Value: []byte("node1")},
+		{Key: []byte("LastVoteCand"), Value: []byte("node2")},
+	}
+	if err := store.SetMany(kvs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(set) != len(kvs) {
+		t.Fatalf("bad: %q", set)
+	}
+	if v, err := store.GetUint64([]byte("CurrentTerm")); err != nil || v != 3 {
+		t.Fatalf("bad: %d, %v", v, err)
+	}
+
+	vals, err := store.GetMany([][]byte{[]byte("LastVoteCand"), []byte("missing"), []byte("LastVoteTerm")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(vals) != 3 || string(vals[0]) != "node2" || vals[1] != nil || bytesToUint64(vals[2]) != 3 {
+		t.Fatalf("bad: %q", vals)
+	}
+}
+
+func TestBadgerStore_SetMany_SeparateStableStore(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store = testSeparateStore(t, store.path)
+	defer store.Close()
+
+	if err := store.SetMany([]KV{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("2")}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	vals, err := store.GetMany([][]byte{[]byte("b"), []byte("a")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(vals[0]) != "2" || string(vals[1]) != "1" {
+		t.Fatalf("bad: %q", vals)
+	}
+	if n := testCountPrefix(t, store, defaultKeys.conf); n != 0 {
+		t.Fatalf("bad: %d stable keys in the log's database", n)
+	}
+}
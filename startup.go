@@ -0,0 +1,119 @@
+package raftbadgerdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// maxReportedCorruptions caps how many bad entries a failed verification
+// lists.
+const maxReportedCorruptions = 10
+
+// verifyProgressEvery is how many entries a full verification checks
+// between progress reports.
+const verifyProgressEvery = 512
+
+// ErrStartupVerification is returned by New when Options.VerifyOnOpen is
+// VerifyFull and the store holds entries that can't be read back.
+var ErrStartupVerification = errors.New("startup verification failed")
+
+// VerifyMode selects how thoroughly New checks the store before returning.
+type VerifyMode int
+
+const (
+	// VerifyNone relies on Badger, which checksum-verifies the part of the
+	// value log it replays after an unclean shutdown
+	VerifyNone VerifyMode = iota
+	// VerifyFull additionally reads every value in the store and decodes
+	// every log entry, checking it is stored under its own index. This
+	// Badger version keeps no checksums for its tables, so reading
+	// everything back is the only way to find damage before raft does
+	VerifyFull
+)
+
+// StartupReport describes how the store was opened.
+type StartupReport struct {
+	// OpenDuration is how long opening Badger took, including value log
+	// replay
+	OpenDuration time.Duration
+	// Verify is the verification mode the store was opened with
+	Verify VerifyMode
+	// EntriesVerified and VerifyDuration describe a full verification
+	EntriesVerified uint64
+	VerifyDuration  time.Duration
+	// CacheWarmed and CacheWarmDuration describe loading the cache, see
+	// Options.CacheWarmEntries
+	CacheWarmed       int
+	CacheWarmDuration time.Duration
+}
+
+// StartupReport returns the report of opening the store.
+func (b *BadgerStore) StartupReport() StartupReport {
+	return b.startup
+}
+
+// verifyAll reads every value in the store and decodes every log entry. It
+// fails with ErrStartupVerification listing the first entries that don't.
+func (b *BadgerStore) verifyAll(progress ProgressFunc) (uint64, error) {
+	total, err := b.countLogs()
+	if err != nil {
+		return 0, err
+	}
+	reporter := newProgressReporter(progress, "verify", total)
+	reporter.report(0)
+
+	verified := uint64(0)
+	var corrupt []string
+	err = b.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		log := new(raft.Log)
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			v, err := item.Value()
+			if err != nil {
+				corrupt = append(corrupt, fmt.Sprintf("key %q: %v", item.Key(), err))
+			}
+			if err != nil || !bytes.HasPrefix(item.Key(), b.keys.logs) {
+				continue
+			}
+			verified++
+			if verified%verifyProgressEvery == 0 {
+				reporter.report(verified)
+			}
+			idx, err := b.keys.parseLogKey(item.Key())
+			if err == nil {
+				*log = raft.Log{}
+				err = b.decodeLog(txn, idx, v, log)
+			}
+			if err == nil && log.Index != idx {
+				err = fmt.Errorf("holds log %d", log.Index)
+			}
+			if errors.Is(err, errChecksumMismatch) {
+				b.corruptionDetected(idx)
+			}
+			if err != nil {
+				corrupt = append(corrupt, fmt.Sprintf("key %q: %v", item.Key(), err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return verified, err
+	}
+	reporter.report(verified)
+	if len(corrupt) > 0 {
+		b.metrics.incrCounter([]string{"verify_open", "corrupt_entries"}, float32(len(corrupt)))
+		b.logger.Error("corrupt entries found on open", "count", len(corrupt), "first", corrupt[0])
+		if len(corrupt) > maxReportedCorruptions {
+			corrupt = append(corrupt[:maxReportedCorruptions], fmt.Sprintf("and %d more", len(corrupt)-maxReportedCorruptions))
+		}
+		return verified, fmt.Errorf("%w: %v", ErrStartupVerification, corrupt)
+	}
+	return verified, nil
+}
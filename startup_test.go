@@ -0,0 +1,65 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestNew_VerifyOnOpen(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	store, err := NewBadgerStore(fh)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testStoreFiveLogs(t, store)
+	store.Close()
+
+	badgerOpts := badger.DefaultOptions
+	store, err = New(Options{Path: fh, BadgerOptions: &badgerOpts, VerifyOnOpen: VerifyFull})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	report := store.StartupReport()
+	if report.Verify != VerifyFull || report.EntriesVerified != 5 || report.OpenDuration == 0 {
+		t.Fatalf("bad report: %+v", report)
+	}
+
+	// Damage one entry: stored under the wrong index
+	wrong, err := store.encodeLog(testRaftLog(9, "misplaced"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(defaultKeys.logKey(3), wrong)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	badgerOpts = badger.DefaultOptions
+	_, err = New(Options{Path: fh, BadgerOptions: &badgerOpts, VerifyOnOpen: VerifyFull})
+	if !errors.Is(err, ErrStartupVerification) {
+		t.Fatalf("expected verification to fail, got: %v", err)
+	}
+
+	// Without verification the store still opens
+	badgerOpts = badger.DefaultOptions
+	store, err = New(Options{Path: fh, BadgerOptions: &badgerOpts})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if report := store.StartupReport(); report.EntriesVerified != 0 {
+		t.Fatalf("bad report: %+v", report)
+	}
+	store.Close()
+}
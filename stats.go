@@ -0,0 +1,116 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Stats describes the store's contents and on-disk state.
+type Stats struct {
+	// LogEntries is the number of stored log entries, FirstIndex and
+	// LastIndex their bounds, both zero for an empty log
+	LogEntries uint64
+	FirstIndex uint64
+	LastIndex  uint64
+	// StableKeys is the number of keys set through the StableStore
+	// interface
+	StableKeys uint64
+	// LSMSize and ValueLogSize are Badger's on-disk sizes, which Badger
+	// refreshes about once a minute
+	LSMSize      int64
+	ValueLogSize int64
+	// StableLSMSize and StableValueLogSize are the sizes of the separate
+	// stable store, zero without Options.SeparateStableStore
+	StableLSMSize      int64
+	StableValueLogSize int64
+	// LevelTables is the number of tables on each level of the LSM tree
+	LevelTables []int
+	// PendingCompactions is the number of level 0 tables waiting to be
+	// compacted into the deeper levels
+	PendingCompactions int
+	// LastGC is when value log garbage collection last ran to completion,
+	// zero if it hasn't since the store was opened
+	LastGC time.Time
+}
+
+// Stats returns the store's current statistics. Counting the entries reads
+// every key, but no values.
+func (b *BadgerStore) Stats() (s Stats, err error) {
+	err = b.run(context.Background(), func(context.Context) (err error) {
+		s, err = b.stats()
+		return err
+	})
+	return s, err
+}
+
+func (b *BadgerStore) stats() (Stats, error) {
+	var s Stats
+	var err error
+	if s.FirstIndex, s.LastIndex, err = b.logBounds(); err != nil {
+		return s, err
+	}
+	err = b.readRetry.do(b.metrics, b.logger, retryRead, func() error {
+		return b.db.View(func(txn *badger.Txn) error {
+			s.LogEntries = countPrefix(txn, b.keys.logs)
+			return nil
+		})
+	})
+	if err != nil {
+		return s, err
+	}
+	err = b.readRetry.do(b.metrics, b.logger, retryRead, func() error {
+		return b.stableDB.View(func(txn *badger.Txn) error {
+			s.StableKeys = countPrefix(txn, b.keys.conf)
+			return nil
+		})
+	})
+	if err != nil {
+		return s, err
+	}
+	s.LSMSize, s.ValueLogSize = b.db.Size()
+	if b.separateStable() {
+		s.StableLSMSize, s.StableValueLogSize = b.stableDB.Size()
+	}
+	s.LevelTables, s.PendingCompactions = levelTables(b.db)
+	b.statsMu.Lock()
+	s.LastGC = b.lastGC
+	b.statsMu.Unlock()
+	return s, nil
+}
+
+// levelTables returns the number of tables on each level of db's LSM tree
+// and how many of them, those on level 0, wait to be compacted.
+func levelTables(db *badger.DB) (levels []int, pending int) {
+	for _, table := range db.Tables() {
+		for len(levels) <= table.Level {
+			levels = append(levels, 0)
+		}
+		levels[table.Level]++
+	}
+	if len(levels) > 0 {
+		pending = levels[0]
+	}
+	return levels, pending
+}
+
+// countPrefix returns the number of keys starting with prefix.
+func countPrefix(txn *badger.Txn, prefix []byte) uint64 {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	var n uint64
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		n++
+	}
+	return n
+}
+
+// gcDone records that value log garbage collection ran to completion.
+func (b *BadgerStore) gcDone() {
+	b.statsMu.Lock()
+	b.lastGC = time.Now()
+	b.statsMu.Unlock()
+}
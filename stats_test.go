@@ -0,0 +1,44 @@
+package raftbadgerdb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBadgerStore_Stats(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	defer store.Close()
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.LogEntries != 0 || stats.FirstIndex != 0 || stats.LastIndex != 0 || !stats.LastGC.IsZero() {
+		t.Fatalf("bad: %#v", stats)
+	}
+
+	testStoreFiveLogs(t, store)
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.RunValueLogGC(0.5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	stats, err = store.Stats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.LogEntries != 3 || stats.FirstIndex != 3 || stats.LastIndex != 5 {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if stats.StableKeys != 1 {
+		t.Fatalf("bad: %d stable keys", stats.StableKeys)
+	}
+	if stats.LastGC.IsZero() {
+		t.Fatalf("should record the garbage collection run")
+	}
+}
@@ -0,0 +1,156 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// defaultSyncInterval is how often SyncInterval syncs unless
+// Options.SyncInterval is set.
+const defaultSyncInterval = time.Second
+
+// SyncPolicy decides when writes are synced to disk.
+type SyncPolicy int
+
+const (
+	// SyncDefault leaves syncing to BadgerOptions.SyncWrites, which
+	// defaults to syncing every write
+	SyncDefault SyncPolicy = iota
+	// SyncAlways syncs every write before it returns, so StoreLogs and Set
+	// are durable once they succeed
+	SyncAlways
+	// SyncInterval syncs in the background every Options.SyncInterval.
+	// Writes are much faster, and a crash of the machine loses at most the
+	// last interval's worth; a crash of the process alone loses nothing
+	SyncInterval
+	// SyncNever only syncs when Sync is called
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncDefault:
+		return "default"
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+// Sync makes every write that has returned durable. It is only needed
+// with SyncInterval or SyncNever, otherwise writes are durable already. It
+// does nothing on a read-only store.
+func (b *BadgerStore) Sync() error {
+	return b.run(context.Background(), func(context.Context) error {
+		return b.sync()
+	})
+}
+
+// sync is Sync for the background syncer, which Close stops itself.
+func (b *BadgerStore) sync() error {
+	defer b.metrics.measureSince([]string{"sync"}, time.Now())
+	if err := syncValueLog(b.badgerOpts); err != nil {
+		return err
+	}
+	if b.separateStable() {
+		return syncValueLog(b.stableBadgerOpts)
+	}
+	return nil
+}
+
+// syncValueLog syncs the value log of the database opened with opts,
+// unless it syncs every write anyway.
+func syncValueLog(opts badger.Options) error {
+	if opts.SyncWrites || opts.ReadOnly {
+		return nil
+	}
+	// Writes reach the newest value log file before the transaction
+	// commits. Badger syncs older files itself when it moves on from them
+	path, err := newestValueLog(opts.ValueDir)
+	if err != nil || path == "" {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(opts.ValueDir)
+}
+
+// newestValueLog returns the path of the value log file Badger is
+// appending to, or "" if there is none yet.
+func newestValueLog(dir string) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	newest, newestName := uint64(0), ""
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, ".vlog") {
+			continue
+		}
+		fid, err := strconv.ParseUint(strings.TrimSuffix(name, ".vlog"), 10, 32)
+		if err != nil {
+			continue
+		}
+		if newestName == "" || fid > newest {
+			newest, newestName = fid, name
+		}
+	}
+	if newestName == "" {
+		return "", nil
+	}
+	return filepath.Join(dir, newestName), nil
+}
+
+// startSyncer syncs every interval until the store closes.
+func (b *BadgerStore) startSyncer(interval time.Duration) {
+	b.syncStop = make(chan struct{})
+	b.syncDone = make(chan struct{})
+	go func() {
+		defer close(b.syncDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := b.sync(); err != nil {
+					b.metrics.incrCounter([]string{"sync", "failures"}, 1)
+					b.logger.Warn("background sync failed", "error", err)
+				}
+			case <-b.syncStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopSyncer stops the background syncer, syncing one last time.
+func (b *BadgerStore) stopSyncer() error {
+	if b.syncStop == nil {
+		return nil
+	}
+	close(b.syncStop)
+	<-b.syncDone
+	return b.sync()
+}
@@ -0,0 +1,73 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNew_SyncPolicy(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	for policy, want := range map[SyncPolicy]bool{
+		SyncDefault:  true,
+		SyncAlways:   true,
+		SyncInterval: false,
+		SyncNever:    false,
+	} {
+		store, err := New(Options{Path: fh, SyncPolicy: policy, AllowOutOfOrderAppends: true})
+		if err != nil {
+			t.Fatalf("%s: err: %s", policy, err)
+		}
+		if got := store.badgerOpts.SyncWrites; got != want {
+			t.Fatalf("%s: bad SyncWrites %v", policy, got)
+		}
+		if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+			t.Fatalf("%s: err: %s", policy, err)
+		}
+		if err := store.Sync(); err != nil {
+			t.Fatalf("%s: err: %s", policy, err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("%s: err: %s", policy, err)
+		}
+	}
+
+	if _, err := New(Options{Path: fh, SyncPolicy: SyncNever + 1}); err == nil {
+		t.Fatalf("should reject an unknown policy")
+	}
+	if _, err := New(Options{Path: fh, SyncPolicy: SyncInterval, SyncInterval: -time.Second}); err == nil {
+		t.Fatalf("should reject a negative interval")
+	}
+}
+
+func TestBadgerStore_SyncInterval(t *testing.T) {
+	sink := testMetricsSink(t)
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+	store, err := New(Options{Path: fh, SyncPolicy: SyncInterval, SyncInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := sink.Data()[0].Samples["raft.badgerdb.sync"]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("never synced")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
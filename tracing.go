@@ -0,0 +1,66 @@
+package raftbadgerdb
+
+import (
+	"context"
+
+	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the store's spans.
+const tracerName = "github.com/markthethomas/raft-badger"
+
+// Attributes of the store's spans.
+const (
+	attrIndex      = attribute.Key("raft.log.index")
+	attrFirstIndex = attribute.Key("raft.log.first_index")
+	attrLastIndex  = attribute.Key("raft.log.last_index")
+	attrEntries    = attribute.Key("raft.log.entries")
+	attrKey        = attribute.Key("raft.stable.key")
+	attrKeys       = attribute.Key("raft.stable.keys")
+	attrBytes      = attribute.Key("raft.badger.bytes")
+)
+
+// noopSpan is returned by startSpan when the store doesn't trace.
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// startSpan starts a span for the operation op, or returns a span that
+// does nothing unless Options.TracerProvider is set. raft's interfaces
+// carry no context, so their spans are the roots of their traces; those of
+// the Context variants are children of the span in ctx, if any.
+func (b *BadgerStore) startSpan(ctx context.Context, op string, attrs ...attribute.KeyValue) trace.Span {
+	if b.tracer == nil {
+		return noopSpan
+	}
+	_, span := b.tracer.Start(ctx, "raftbadger."+op,
+		trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(attrs...))
+	return span
+}
+
+// endSpan ends span, marking it failed with err if set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// logsAttributes describes a StoreLogs batch for its span.
+func (b *BadgerStore) logsAttributes(logs []*raft.Log) []attribute.KeyValue {
+	if b.tracer == nil || len(logs) == 0 {
+		return nil
+	}
+	size := 0
+	for _, log := range logs {
+		size += len(log.Data)
+	}
+	return []attribute.KeyValue{
+		attrFirstIndex.Int64(int64(logs[0].Index)),
+		attrLastIndex.Int64(int64(logs[len(logs)-1].Index)),
+		attrEntries.Int(len(logs)),
+		attrBytes.Int(size),
+	}
+}
@@ -0,0 +1,92 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestBadgerStore_Tracing(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store, err := New(Options{Path: fh, TracerProvider: provider})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	testStoreFiveLogs(t, store)
+	if err := store.GetLog(3, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(9, new(raft.Log)); err != raft.ErrLogNotFound {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.Get([]byte("key")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []struct {
+		name  string
+		attrs map[attribute.Key]int64
+		fail  bool
+	}{
+		{"raftbadger.StoreLogs", map[attribute.Key]int64{attrFirstIndex: 1, attrLastIndex: 5, attrEntries: 5, attrBytes: 20}, false},
+		{"raftbadger.GetLog", map[attribute.Key]int64{attrIndex: 3, attrBytes: 4}, false},
+		{"raftbadger.GetLog", map[attribute.Key]int64{attrIndex: 9}, true},
+		{"raftbadger.DeleteRange", map[attribute.Key]int64{attrFirstIndex: 1, attrLastIndex: 2}, false},
+		{"raftbadger.Set", map[attribute.Key]int64{attrBytes: 5}, false},
+		{"raftbadger.Get", map[attribute.Key]int64{attrBytes: 5}, false},
+	}
+	spans := recorder.Ended()
+	if len(spans) != len(expected) {
+		t.Fatalf("bad: %d spans", len(spans))
+	}
+	for i, span := range spans {
+		e := expected[i]
+		if span.Name() != e.name || (span.Status().Code == codes.Error) != e.fail {
+			t.Fatalf("span %d: bad: %s %v", i, span.Name(), span.Status())
+		}
+		got := map[attribute.Key]int64{}
+		for _, kv := range span.Attributes() {
+			if kv.Value.Type() == attribute.INT64 {
+				got[kv.Key] = kv.Value.AsInt64()
+			}
+		}
+		for k, v := range e.attrs {
+			if got[k] != v {
+				t.Fatalf("span %d: %s is %d, expected %d", i, k, got[k], v)
+			}
+		}
+	}
+
+	// The Context variants continue the caller's trace
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	if err := store.GetLogContext(ctx, 3, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	parent.End()
+	spans = recorder.Ended()
+	if got := spans[len(spans)-2].Parent().SpanID(); got != parent.SpanContext().SpanID() {
+		t.Fatalf("bad parent: %s", got)
+	}
+}
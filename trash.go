@@ -0,0 +1,77 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// trashEntry moves a log entry to the trash within txn. Badger expires the
+// copy once the grace period is over, and compaction reclaims it.
+func (b *BadgerStore) trashEntry(txn *writeTxn, idx uint64, item *badger.Item) error {
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	return txn.SetEntry(&badger.Entry{
+		Key:       b.keys.trashKey(idx),
+		Value:     v,
+		UserMeta:  item.UserMeta(),
+		ExpiresAt: uint64(time.Now().Add(b.trashGrace).Unix()),
+	})
+}
+
+// Undelete moves soft-deleted log entries with indexes from min to max,
+// inclusively, back into the log. It only has something to restore when the
+// store was opened with Options.SoftDeleteGracePeriod and the entries'
+// grace period hasn't run out. Entries whose index has been written again
+// since they were deleted are left in the trash rather than overwriting the
+// newer entry.
+func (b *BadgerStore) Undelete(min, max uint64) error {
+	return b.run(context.Background(), func(context.Context) error {
+		return b.undelete(min, max)
+	})
+}
+
+func (b *BadgerStore) undelete(min, max uint64) error {
+	// Entries of queued asynchronous deletions only reach the trash once
+	// they are physically removed
+	if err := b.flushDeletes(); err != nil {
+		return err
+	}
+	defer b.cache.invalidate()
+	defer b.bounds.invalidate()
+	return b.update(func(txn *writeTxn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(b.keys.trashKey(min)); it.ValidForPrefix(b.keys.trash); it.Next() {
+			item := it.Item()
+			idx, err := b.keys.parseTrashKey(item.Key())
+			if err != nil {
+				return err
+			}
+			if idx > max {
+				break
+			}
+			_, err = txn.Get(b.keys.logKey(idx))
+			if err == nil {
+				continue
+			}
+			if err != badger.ErrKeyNotFound {
+				return err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := txn.SetWithMeta(b.keys.logKey(idx), v, item.UserMeta()); err != nil {
+				return err
+			}
+			if err := txn.Delete(item.KeyCopy(nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
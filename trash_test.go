@@ -0,0 +1,93 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func testSoftDeleteStore(t *testing.T, grace time.Duration) (*BadgerStore, string) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, SoftDeleteGracePeriod: grace})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store, fh
+}
+
+func TestBadgerStore_Undelete(t *testing.T) {
+	store, dir := testSoftDeleteStore(t, time.Hour)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+	if err := store.SetLogMeta(2, 0x07); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := store.DeleteRange(1, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(2, new(raft.Log)); err != raft.ErrLogNotFound {
+		t.Fatalf("expected log not found, got: %v", err)
+	}
+
+	// Index 3 is written again after the deletion and must survive
+	store.allowOutOfOrder = true
+	if err := store.StoreLog(testRaftLog(3, "newer")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Undelete(2, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result := new(raft.Log)
+	if err := store.GetLog(2, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "log2" {
+		t.Fatalf("bad: %#v", result)
+	}
+	meta, err := store.GetLogMeta(2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if meta != 0x07 {
+		t.Fatalf("expected meta to be restored, got %#x", meta)
+	}
+	if err := store.GetLog(3, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(result.Data) != "newer" {
+		t.Fatalf("undelete overwrote a newer entry: %#v", result)
+	}
+	// Outside the undeleted range
+	if err := store.GetLog(1, result); err != raft.ErrLogNotFound {
+		t.Fatalf("expected log not found, got: %v", err)
+	}
+}
+
+func TestBadgerStore_SoftDeleteExpires(t *testing.T) {
+	store, dir := testSoftDeleteStore(t, time.Second)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+	testStoreFiveLogs(t, store)
+
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	time.Sleep(2 * time.Second)
+	if err := store.Undelete(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(1, new(raft.Log)); err != raft.ErrLogNotFound {
+		t.Fatalf("expected the grace period to have run out, got: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package raftbadgerdb
+
+import (
+	"errors"
+
+	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/options"
+)
+
+const (
+	kib = 1 << 10
+	mib = 1 << 20
+	gib = 1 << 30
+)
+
+// autoTuneShare is the fraction of system memory Options.AutoTuneMemory
+// budgets for the store, leaving the rest to the application.
+const autoTuneShare = 4
+
+// errMemoryUnsupported is returned by systemMemory on platforms it can't
+// query.
+var errMemoryUnsupported = errors.New("system memory is not available on this platform")
+
+// TuneForMemory sizes the memory-hungry parts of opts to fit the store into
+// roughly budget bytes: memtable count and size, how tables and the value
+// log are loaded, value log file size and compactor count. The rest of opts
+// is left alone. Badger's defaults assume a server with several gigabytes
+// to spare; a small budget trades some write throughput for staying within
+// it.
+func TuneForMemory(opts *badger.Options, budget uint64) {
+	// Memtables take about a quarter of the budget
+	opts.NumMemtables = 5
+	if budget < gib {
+		opts.NumMemtables = 2
+	}
+	tableSize := budget / 4 / uint64(opts.NumMemtables)
+	tableSize = tableSize / mib * mib
+	if tableSize < 4*mib {
+		tableSize = 4 * mib
+	}
+	if tableSize > 64*mib {
+		tableSize = 64 * mib
+	}
+	opts.MaxTableSize = int64(tableSize)
+	opts.LevelOneSize = 4 * opts.MaxTableSize
+
+	// Loading tables into RAM only pays off with memory to spare, mapping
+	// them leaves it to the page cache
+	opts.TableLoadingMode = options.MemoryMap
+	if budget >= 2*gib {
+		opts.TableLoadingMode = options.LoadToRAM
+	}
+	opts.ValueLogLoadingMode = options.MemoryMap
+
+	vlogSize := budget / 8
+	if vlogSize < 16*mib {
+		vlogSize = 16 * mib
+	}
+	if vlogSize > gib-1 {
+		vlogSize = gib - 1
+	}
+	opts.ValueLogFileSize = int64(vlogSize)
+
+	switch {
+	case budget < gib:
+		opts.NumCompactors = 1
+	case budget < 8*gib:
+		opts.NumCompactors = 2
+	default:
+		opts.NumCompactors = 3
+	}
+}
+
+// memoryBudget returns the budget New tunes Badger for, or 0 to leave its
+// options alone.
+func memoryBudget(o Options) (uint64, error) {
+	if o.MemoryBudget > 0 || !o.AutoTuneMemory {
+		return o.MemoryBudget, nil
+	}
+	total, err := systemMemory()
+	if err == errMemoryUnsupported {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return total / autoTuneShare, nil
+}
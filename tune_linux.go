@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package raftbadgerdb
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemMemory returns the memory available to the process: the machine's
+// total memory, or the cgroup limit if that is lower, as it is in most
+// containers.
+func systemMemory() (uint64, error) {
+	total, err := memTotal()
+	if err != nil {
+		return 0, err
+	}
+	for _, path := range []string{
+		"/sys/fs/cgroup/memory.max",                   // cgroup v2
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes", // cgroup v1
+	} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			// "max" means unlimited
+			continue
+		}
+		if limit < total {
+			total = limit
+		}
+		break
+	}
+	return total, nil
+}
+
+// memTotal reads MemTotal from /proc/meminfo.
+func memTotal() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * kib, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package raftbadgerdb
+
+// systemMemory is not implemented on this platform; set
+// Options.MemoryBudget instead.
+func systemMemory() (uint64, error) {
+	return 0, errMemoryUnsupported
+}
@@ -0,0 +1,72 @@
+package raftbadgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/options"
+)
+
+func TestTuneForMemory(t *testing.T) {
+	small := badger.DefaultOptions
+	TuneForMemory(&small, 512*mib)
+	if small.NumMemtables != 2 || small.MaxTableSize != 64*mib {
+		t.Fatalf("bad memtables for 512MB: %d x %d", small.NumMemtables, small.MaxTableSize)
+	}
+	if small.TableLoadingMode != options.MemoryMap || small.NumCompactors != 1 {
+		t.Fatalf("bad small options: %+v", small)
+	}
+	if small.ValueLogFileSize != 64*mib {
+		t.Fatalf("bad value log file size: %d", small.ValueLogFileSize)
+	}
+
+	tiny := badger.DefaultOptions
+	TuneForMemory(&tiny, 16*mib)
+	if tiny.MaxTableSize != 4*mib || tiny.ValueLogFileSize != 16*mib {
+		t.Fatalf("bad tiny options: %+v", tiny)
+	}
+
+	large := badger.DefaultOptions
+	TuneForMemory(&large, 64*gib)
+	if large.NumMemtables != 5 || large.MaxTableSize != 64*mib || large.TableLoadingMode != options.LoadToRAM {
+		t.Fatalf("bad large options: %+v", large)
+	}
+	if large.NumCompactors != 3 || large.ValueLogFileSize != gib-1 {
+		t.Fatalf("bad large options: %+v", large)
+	}
+}
+
+func TestNew_MemoryBudget(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, MemoryBudget: 64 * mib})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	// The caller's options are tuned in a copy
+	if badgerOpts.MaxTableSize != badger.DefaultOptions.MaxTableSize {
+		t.Fatalf("caller's options were modified")
+	}
+	testStoreFiveLogs(t, store)
+}
+
+func TestSystemMemory(t *testing.T) {
+	total, err := systemMemory()
+	if err == errMemoryUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if total < 16*mib {
+		t.Fatalf("implausible system memory: %d", total)
+	}
+}
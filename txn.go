@@ -0,0 +1,228 @@
+package raftbadgerdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+// ErrTxnStableStore is returned by the stable store methods of a StoreTxn
+// on a store opened with Options.SeparateStableStore, whose stable store
+// lives in a database of its own and can't be written in the same
+// transaction as the log.
+var ErrTxnStableStore = errors.New("stable store is in a separate database")
+
+// StoreTxn is a transaction over the log and stable store, passed to the
+// function given to Update. Everything done through it is committed
+// together, or not at all, and its reads see its own writes. It must not
+// be used once that function returns.
+type StoreTxn struct {
+	b   *BadgerStore
+	txn *writeTxn
+	ctx context.Context
+
+	// first and last are the bounds of the log as the transaction left it,
+	// for the out of order append check
+	first, last uint64
+	// committed runs after the commit, in order, to update the cache, the
+	// bounds and the hooks as the single operations would
+	committed []func() error
+}
+
+// Update runs fn in a transaction and commits what it did through tx in
+// one Badger commit: log appends, range deletes and stable store writes
+// alike. If fn returns an error, nothing is committed and Update returns
+// it. A transaction conflicting with a concurrent one is retried, calling
+// fn again, so fn must not have effects outside tx. Everything fn does
+// must fit into a single Badger transaction, or Update fails with
+// badger.ErrTxnTooBig; unlike StoreLogs and DeleteRange, it doesn't split
+// the work up.
+func (b *BadgerStore) Update(fn func(tx *StoreTxn) error) error {
+	if b.badgerOpts.ReadOnly {
+		return ErrReadOnly
+	}
+	defer b.metrics.measureSince([]string{"update"}, time.Now())
+	span := b.startSpan(context.Background(), "Update")
+	err := b.run(context.Background(), func(ctx context.Context) error {
+		return b.doWrite(ctx, func() error {
+			return b.tryUpdate(ctx, fn)
+		})
+	})
+	endSpan(span, err)
+	return err
+}
+
+// tryUpdate makes one attempt at Update. The bounds of the log are read in
+// the transaction rather than from the cache, so a concurrent write moving
+// them makes the commit conflict instead of fn working from stale ones; the
+// cache is only updated once the commit succeeded.
+func (b *BadgerStore) tryUpdate(ctx context.Context, fn func(tx *StoreTxn) error) error {
+	tx := &StoreTxn{b: b, txn: b.newWriteTxn(), ctx: ctx}
+	defer tx.txn.Discard()
+	if err := tx.readBounds(); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.txn.Commit(); err != nil {
+		return err
+	}
+	for _, f := range tx.committed {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBounds reads the first and last index in the transaction, along with
+// the key an append would go to next, so Badger's conflict detection
+// covers all three.
+func (tx *StoreTxn) readBounds() (err error) {
+	if tx.first, err = tx.b.firstIndexIn(tx.txn.Txn); err != nil {
+		return err
+	}
+	if tx.last, err = tx.b.lastIndexIn(tx.txn.Txn); err != nil {
+		return err
+	}
+	if _, err := tx.txn.Get(tx.b.keys.logKey(tx.last + 1)); err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// StoreLogs writes logs in the transaction. Unless the store was opened
+// with Options.AllowOutOfOrderAppends, they must follow the last entry as
+// the transaction left it, like for BadgerStore.StoreLogs.
+func (tx *StoreTxn) StoreLogs(logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	if err := tx.b.checkEntrySizes(logs); err != nil {
+		return err
+	}
+	if err := tx.b.checkAppendAfter(logs, tx.last); err != nil {
+		return err
+	}
+	if err := tx.b.awaitPendingDeletes(logs); err != nil {
+		return err
+	}
+	stored, err := tx.b.writeLogs(tx.txn, logs)
+	if err != nil {
+		return err
+	}
+	if tx.last == 0 {
+		tx.first = logs[0].Index
+	}
+	tx.last = logs[len(logs)-1].Index
+	tx.committed = append(tx.committed, stored)
+	return nil
+}
+
+// DeleteRange deletes the log entries from min to max, inclusively, in the
+// transaction.
+func (tx *StoreTxn) DeleteRange(min, max uint64) error {
+	b := tx.b
+	if _, _, _, err := b.deleteLogs(tx.ctx, tx.txn, min, max, 0); err != nil {
+		return err
+	}
+	if err := b.pruneAppendTimesTxn(tx.txn, min, max); err != nil {
+		return err
+	}
+	switch {
+	case tx.last == 0 || max < tx.first || min > tx.last:
+	case min <= tx.first && max >= tx.last:
+		tx.first, tx.last = 0, 0
+	case max >= tx.last:
+		tx.last = min - 1
+	case min <= tx.first:
+		tx.first = max + 1
+	}
+	tx.committed = append(tx.committed, func() error {
+		b.cache.removeRange(min, max)
+		b.bounds.deleted(min, max)
+		b.rangeDeleted(min, max)
+		return nil
+	})
+	return nil
+}
+
+// GetLog reads the log entry at idx as the transaction left it.
+func (tx *StoreTxn) GetLog(idx uint64, log *raft.Log) error {
+	if tx.b.isPendingDelete(idx) {
+		return raft.ErrLogNotFound
+	}
+	item, err := tx.txn.Get(tx.b.keys.logKey(idx))
+	if err == badger.ErrKeyNotFound {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	v, err := item.Value()
+	if err != nil {
+		return err
+	}
+	return tx.b.logDecodeError(idx, tx.b.decodeLog(tx.txn.Txn, idx, v, log))
+}
+
+// Set sets a stable store key in the transaction.
+func (tx *StoreTxn) Set(k, v []byte) error {
+	if tx.b.separateStable() {
+		return ErrTxnStableStore
+	}
+	if err := tx.txn.Set(tx.b.keys.confKey(k), v); err != nil {
+		return err
+	}
+	tx.committed = append(tx.committed, func() error {
+		tx.b.prom.wrote(0, len(v))
+		tx.b.stableKeySet(k)
+		return nil
+	})
+	return nil
+}
+
+// SetUint64 is like Set, but handles uint64 values. Keys listed in
+// Options.MonotonicKeys refuse to move backwards and return
+// ErrUint64Rollback.
+func (tx *StoreTxn) SetUint64(key []byte, val uint64) error {
+	if tx.b.monotonicKeys[string(key)] {
+		current, err := tx.GetUint64(key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if err == nil && val < current {
+			return fmt.Errorf("%w: %q is %d, refusing to set %d", ErrUint64Rollback, key, current, val)
+		}
+	}
+	return tx.Set(key, uint64ToBytes(val))
+}
+
+// Get reads a stable store key as the transaction left it.
+func (tx *StoreTxn) Get(k []byte) ([]byte, error) {
+	if tx.b.separateStable() {
+		return nil, ErrTxnStableStore
+	}
+	item, err := tx.txn.Get(tx.b.keys.confKey(k))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// GetUint64 is like Get, but handles uint64 values.
+func (tx *StoreTxn) GetUint64(key []byte) (uint64, error) {
+	val, err := tx.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return bytesToUint64(val), nil
+}
@@ -0,0 +1,155 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_Update(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+
+	// Truncate a conflicting suffix, rewrite it and move the term on, all
+	// at once
+	var deleted [2]uint64
+	store.hooks.RangeDeleted = func(min, max uint64) {
+		deleted = [2]uint64{min, max}
+	}
+	err := store.Update(func(tx *StoreTxn) error {
+		if err := tx.DeleteRange(4, 5); err != nil {
+			return err
+		}
+		if err := tx.StoreLogs([]*raft.Log{testRaftLog(4, "new4"), testRaftLog(5, "new5"), testRaftLog(6, "new6")}); err != nil {
+			return err
+		}
+		// Reads see the transaction's own writes
+		log := new(raft.Log)
+		if err := tx.GetLog(5, log); err != nil || string(log.Data) != "new5" {
+			t.Fatalf("bad: %q, %v", log.Data, err)
+		}
+		if err := tx.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+			return err
+		}
+		if v, err := tx.GetUint64([]byte("CurrentTerm")); err != nil || v != 2 {
+			t.Fatalf("bad: %d, %v", v, err)
+		}
+		return tx.Set([]byte("LastVoteCand"), []byte("node2"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first, last := testBounds(t, store); first != 1 || last != 6 {
+		t.Fatalf("bad: %d to %d", first, last)
+	}
+	log := new(raft.Log)
+	if err := store.GetLog(4, log); err != nil || string(log.Data) != "new4" {
+		t.Fatalf("bad: %q, %v", log.Data, err)
+	}
+	if v, err := store.GetUint64([]byte("CurrentTerm")); err != nil || v != 2 {
+		t.Fatalf("bad: %d, %v", v, err)
+	}
+	if deleted != [2]uint64{4, 5} {
+		t.Fatalf("bad: %v", deleted)
+	}
+
+	// Nothing is committed when fn fails
+	abort := errors.New("abort")
+	err = store.Update(func(tx *StoreTxn) error {
+		if err := tx.StoreLogs([]*raft.Log{testRaftLog(7, "log7")}); err != nil {
+			return err
+		}
+		if err := tx.Set([]byte("k"), []byte("v")); err != nil {
+			return err
+		}
+		return abort
+	})
+	if err != abort {
+		t.Fatalf("err: %v", err)
+	}
+	if first, last := testBounds(t, store); first != 1 || last != 6 {
+		t.Fatalf("bad: %d to %d", first, last)
+	}
+	if _, err := store.Get([]byte("k")); err != ErrKeyNotFound {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Appends must follow the log as the transaction left it
+	err = store.Update(func(tx *StoreTxn) error {
+		if err := tx.DeleteRange(3, 6); err != nil {
+			return err
+		}
+		return tx.StoreLogs([]*raft.Log{testRaftLog(5, "log5")})
+	})
+	if !errors.Is(err, ErrOutOfOrderAppend) {
+		t.Fatalf("expected out of order error, got: %v", err)
+	}
+	err = store.Update(func(tx *StoreTxn) error {
+		if err := tx.DeleteRange(3, 6); err != nil {
+			return err
+		}
+		return tx.StoreLogs([]*raft.Log{testRaftLog(3, "log3")})
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first, last := testBounds(t, store); first != 1 || last != 3 {
+		t.Fatalf("bad: %d to %d", first, last)
+	}
+}
+
+func TestBadgerStore_Update_SeparateStableStore(t *testing.T) {
+	store := testBadgerStore(t)
+	defer os.RemoveAll(store.path)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store = testSeparateStore(t, store.path)
+	defer store.Close()
+
+	err := store.Update(func(tx *StoreTxn) error {
+		return tx.Set([]byte("k"), []byte("v"))
+	})
+	if err != ErrTxnStableStore {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBadgerStore_UpdateConflictingAppend(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.RemoveAll(store.path)
+	testStoreFiveLogs(t, store)
+
+	attempts := 0
+	err := store.Update(func(tx *StoreTxn) error {
+		attempts++
+		if attempts == 1 {
+			// Another append lands after the transaction read the bounds
+			if err := store.StoreLogs([]*raft.Log{testRaftLog(6, "theirs")}); err != nil {
+				return err
+			}
+		}
+		return tx.StoreLogs([]*raft.Log{testRaftLog(tx.last+1, "mine")})
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// The first attempt conflicts rather than overwriting entry 6
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+	if first, last := testBounds(t, store); first != 1 || last != 7 {
+		t.Fatalf("bad: %d to %d", first, last)
+	}
+	for idx, data := range map[uint64]string{6: "theirs", 7: "mine"} {
+		log := new(raft.Log)
+		if err := store.GetLog(idx, log); err != nil || string(log.Data) != data {
+			t.Fatalf("bad: %q, %v", log.Data, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package raftbadgerdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+)
+
+func TestBadgerStore_VerifyWrites(t *testing.T) {
+	fh, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(fh)
+
+	badgerOpts := badger.DefaultOptions
+	store, err := New(Options{Path: fh, BadgerOptions: &badgerOpts, VerifyWrites: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBadgerStore_VerifyWritten_Mismatch(t *testing.T) {
+	store := testBadgerStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	key := defaultKeys.logKey(1)
+
+	// A checksum over different bytes must be reported
+	written := []writtenValue{newWrittenValue(1, key, []byte("something else"))}
+	if err := store.verifyWritten(written); !errors.Is(err, ErrWriteVerification) {
+		t.Fatalf("expected verification error, got: %v", err)
+	}
+
+	// As must a key that was never written
+	written = []writtenValue{newWrittenValue(2, defaultKeys.logKey(2), nil)}
+	if err := store.verifyWritten(written); !errors.Is(err, ErrWriteVerification) {
+		t.Fatalf("expected verification error, got: %v", err)
+	}
+}